@@ -0,0 +1,589 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"github.com/ant512/gobble/activitypub"
+	"github.com/ant512/gobble/webmention"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxActivitySize = 1024 * 1024
+
+var activityPubClient *activitypub.Client
+var activityPubKey *rsa.PrivateKey
+
+// activityPubFollowers maps each follower's actor ID to the inbox that posts
+// should be delivered to.
+var activityPubFollowers = make(map[string]string)
+
+// activityPubDelivered maps each delivered post's URL to a hash of the
+// article sent, so that posts are only redelivered, as updates, when they
+// change.
+var activityPubDelivered = make(map[string]string)
+var activityPubMutex sync.Mutex
+
+func startActivityPub() {
+	if !SharedConfig.ActivityPub {
+		return
+	}
+
+	os.MkdirAll(SharedConfig.ActivityPubPath, 0775)
+
+	var err error
+	activityPubKey, err = activitypub.LoadOrCreateKey(filepath.Join(SharedConfig.ActivityPubPath, "key.pem"))
+
+	if err != nil {
+		log.Fatal("Could not load ActivityPub key: ", err)
+	}
+
+	loadActivityPubState("followers.json", &activityPubFollowers)
+	loadActivityPubState("delivered.json", &activityPubDelivered)
+
+	activityPubClient = newActivityPubClient()
+
+	blog.OnPublish(deliverActivityPubPost)
+}
+
+// newActivityPubClient creates the client used to fetch actors and keys and
+// to deliver activities.  Like webmentions, the addresses it is given come
+// from other servers, so it refuses to connect to private addresses.
+func newActivityPubClient() *activitypub.Client {
+	client := activitypub.NewClient(webmention.NewHTTPClient(30*time.Second), activityPubKeyId(), activityPubKey)
+	client.UserAgent = "Gobble/" + version
+
+	return client
+}
+
+func activityPubActorId() string {
+	return SharedConfig.Address + "/activitypub/actor"
+}
+
+func activityPubKeyId() string {
+	return activityPubActorId() + "#main-key"
+}
+
+// webfinger lets other servers find the blog's actor from an address such as
+// blog@example.com.
+func webfinger(w http.ResponseWriter, req *http.Request) {
+
+	if !SharedConfig.ActivityPub {
+		http.NotFound(w, req)
+		return
+	}
+
+	address, _ := url.Parse(SharedConfig.Address)
+	resource := req.URL.Query().Get("resource")
+	account := "acct:" + SharedConfig.ActivityPubUsername + "@" + address.Host
+
+	if !strings.EqualFold(resource, account) && resource != activityPubActorId() && resource != SharedConfig.Address {
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/jrd+json")
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"subject": account,
+		"aliases": []string{activityPubActorId(), SharedConfig.Address},
+		"links": []map[string]string{
+			{"rel": "self", "type": activitypub.ContentType, "href": activityPubActorId()},
+			{"rel": "http://webfinger.net/rel/profile-page", "type": "text/html", "href": SharedConfig.Address},
+		},
+	})
+}
+
+func activityPubActor(w http.ResponseWriter, req *http.Request) {
+
+	if !SharedConfig.ActivityPub {
+		http.NotFound(w, req)
+		return
+	}
+
+	publicKey, err := activitypub.PublicKeyPEM(activityPubKey)
+
+	if err != nil {
+		log.Println("Could not encode ActivityPub key:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	writeActivityJSON(w, http.StatusOK, &activitypub.Actor{
+		Context:           []string{activitypub.ActivityStreamsContext, activitypub.SecurityContext},
+		Id:                activityPubActorId(),
+		Type:              "Person",
+		PreferredUsername: SharedConfig.ActivityPubUsername,
+		Name:              SharedConfig.Name,
+		Summary:           html.EscapeString(SharedConfig.Description),
+		Url:               SharedConfig.Address,
+		Inbox:             SharedConfig.Address + "/activitypub/inbox",
+		Outbox:            SharedConfig.Address + "/activitypub/outbox",
+		Followers:         SharedConfig.Address + "/activitypub/followers",
+		Endpoints:         &activitypub.Endpoints{SharedInbox: SharedConfig.Address + "/activitypub/inbox"},
+		PublicKey: activitypub.PublicKey{
+			Id:           activityPubKeyId(),
+			Owner:        activityPubActorId(),
+			PublicKeyPem: publicKey,
+		},
+	})
+}
+
+// activityPubOutbox lists a Create activity for every post, newest first.
+// Without a page parameter only the size of the collection and a link to its
+// first page are returned.
+func activityPubOutbox(w http.ResponseWriter, req *http.Request) {
+
+	if !SharedConfig.ActivityPub {
+		http.NotFound(w, req)
+		return
+	}
+
+	outbox := SharedConfig.Address + "/activitypub/outbox"
+
+	blog.mutex.RLock()
+	posts := blog.posts.Filter(func(post *BlogPost, index int, stop *bool) bool {
+		return !post.Metadata.Date.After(time.Now())
+	})
+	blog.mutex.RUnlock()
+
+	value := req.URL.Query().Get("page")
+
+	if len(value) == 0 {
+		writeActivityJSON(w, http.StatusOK, map[string]interface{}{
+			"@context":   activitypub.ActivityStreamsContext,
+			"id":         outbox,
+			"type":       "OrderedCollection",
+			"totalItems": len(posts),
+			"first":      outbox + "?page=1",
+		})
+		return
+	}
+
+	page, err := strconv.Atoi(value)
+
+	if err != nil || page < 1 {
+		http.Error(w, "Invalid page", http.StatusBadRequest)
+		return
+	}
+
+	perPage := SharedConfig.PostsPerPage
+	items := []interface{}{}
+
+	for i := (page - 1) * perPage; i < len(posts) && i < page*perPage; i++ {
+		items = append(items, newActivityPubActivity("Create", posts[i].Permalink()+"#create", newActivityPubArticle(posts[i])))
+	}
+
+	collection := map[string]interface{}{
+		"@context":     activitypub.ActivityStreamsContext,
+		"id":           outbox + "?page=" + strconv.Itoa(page),
+		"type":         "OrderedCollectionPage",
+		"partOf":       outbox,
+		"orderedItems": items,
+	}
+
+	if page*perPage < len(posts) {
+		collection["next"] = outbox + "?page=" + strconv.Itoa(page+1)
+	}
+
+	writeActivityJSON(w, http.StatusOK, collection)
+}
+
+// activityPubFollowersCollection reports how many followers the blog has
+// without revealing who they are.
+func activityPubFollowersCollection(w http.ResponseWriter, req *http.Request) {
+
+	if !SharedConfig.ActivityPub {
+		http.NotFound(w, req)
+		return
+	}
+
+	activityPubMutex.Lock()
+	count := len(activityPubFollowers)
+	activityPubMutex.Unlock()
+
+	writeActivityJSON(w, http.StatusOK, map[string]interface{}{
+		"@context":   activitypub.ActivityStreamsContext,
+		"id":         SharedConfig.Address + "/activitypub/followers",
+		"type":       "OrderedCollection",
+		"totalItems": count,
+	})
+}
+
+// activityPubInbox receives activities from other servers.  Every activity
+// must be signed by the actor that sent it.
+func activityPubInbox(w http.ResponseWriter, req *http.Request) {
+
+	if !SharedConfig.ActivityPub {
+		http.NotFound(w, req)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxActivitySize))
+
+	if err != nil {
+		http.Error(w, "Could not read activity", http.StatusBadRequest)
+		return
+	}
+
+	owner, err := activitypub.VerifyRequest(req, body, activityPubClient.LookupKey)
+
+	if err != nil {
+		log.Println("Rejected ActivityPub request:", err)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	activity := &activitypub.Activity{}
+	err = json.Unmarshal(body, activity)
+
+	if err != nil {
+		http.Error(w, "Invalid activity", http.StatusBadRequest)
+		return
+	}
+
+	if activity.Actor != owner {
+		http.Error(w, "Activity was not signed by its actor", http.StatusUnauthorized)
+		return
+	}
+
+	switch activity.Type {
+	case "Follow":
+		err = receiveActivityPubFollow(activity)
+	case "Undo":
+		receiveActivityPubUndo(activity)
+	case "Create", "Update":
+		err = receiveActivityPubReply(activity, getIpAddress(req))
+	case "Delete":
+		deleteActivityPubReply(activity)
+	}
+
+	if err != nil {
+		log.Println("Could not process", activity.Type, "activity from", owner, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func receiveActivityPubFollow(activity *activitypub.Activity) error {
+	if activity.ObjectId() != activityPubActorId() {
+		return fmt.Errorf("Cannot follow %v", activity.ObjectId())
+	}
+
+	actor, err := activityPubClient.FetchActor(activity.Actor)
+
+	if err != nil {
+		return err
+	}
+
+	activityPubMutex.Lock()
+	activityPubFollowers[actor.Id] = actor.DeliveryInbox()
+	saveActivityPubState("followers.json", activityPubFollowers)
+	activityPubMutex.Unlock()
+
+	log.Println("New ActivityPub follower", actor.Id)
+
+	follow, _ := json.Marshal(activity)
+	accept := newActivityPubActivity("Accept", fmt.Sprintf("%v#accept-%x", activityPubActorId(), sha1.Sum(follow)), json.RawMessage(follow))
+
+	go deliverActivity([]string{actor.Inbox}, accept)
+
+	return nil
+}
+
+func receiveActivityPubUndo(activity *activitypub.Activity) {
+	undone, err := activity.EmbeddedActivity()
+
+	if err != nil || undone.Type != "Follow" || undone.Actor != activity.Actor {
+		return
+	}
+
+	activityPubMutex.Lock()
+	delete(activityPubFollowers, activity.Actor)
+	saveActivityPubState("followers.json", activityPubFollowers)
+	activityPubMutex.Unlock()
+
+	log.Println("ActivityPub follower", activity.Actor, "unfollowed")
+}
+
+// receiveActivityPubReply stores replies to posts as comments.  Replies are
+// held for moderation, as there is no other way to tell whether they are
+// spam.
+func receiveActivityPubReply(activity *activitypub.Activity, remoteAddress string) error {
+	object, err := activity.EmbeddedObject()
+
+	if err != nil || object.Type != "Note" || len(object.InReplyTo) == 0 {
+		return nil
+	}
+
+	if object.AttributedTo != activity.Actor {
+		return fmt.Errorf("Note %v is not attributed to %v", object.Id, activity.Actor)
+	}
+
+	post := postWithPermalink(object.InReplyTo)
+
+	if post == nil || !post.AllowsComments() {
+		return nil
+	}
+
+	actor, err := activityPubClient.FetchActor(activity.Actor)
+
+	if err != nil {
+		return err
+	}
+
+	comment := newActivityPubComment(actor, object)
+	isNew, err := post.SaveWebmention(comment)
+
+	if err != nil {
+		return err
+	}
+
+	log.Println("Received ActivityPub reply", object.Id, "to", post.Url)
+
+	if isNew {
+		notifyNewComment(post, comment)
+	}
+
+	return nil
+}
+
+// deleteActivityPubReply removes a reply that has been deleted on its own
+// server.  Actors can only delete objects from their own server.
+func deleteActivityPubReply(activity *activitypub.Activity) {
+	id := activity.ObjectId()
+	objectUrl, err := url.Parse(id)
+	actorUrl, _ := url.Parse(activity.Actor)
+
+	if err != nil || !strings.EqualFold(objectUrl.Host, actorUrl.Host) {
+		return
+	}
+
+	filename := activityPubCommentFilename(id)
+
+	blog.mutex.RLock()
+	posts := blog.posts
+	blog.mutex.RUnlock()
+
+	for _, post := range posts {
+		if _, err := post.CommentWithFilename(filename); err == nil {
+			post.DeleteComment(filename)
+			log.Println("Deleted ActivityPub reply", id)
+		}
+	}
+}
+
+func newActivityPubComment(actor *activitypub.Actor, note *activitypub.Object) *Comment {
+	author := actor.Name
+
+	if len(author) == 0 {
+		author = actor.PreferredUsername
+	}
+
+	body := activitypub.PlainText(note.Content)
+
+	if len(body) == 0 {
+		body = "Replied to this post."
+	}
+
+	if runes := []rune(body); len(runes) > maxCommentBodyLength {
+		body = string(runes[:maxCommentBodyLength]) + "…"
+	}
+
+	c := NewComment(html.EscapeString(author), "", html.EscapeString(body), true)
+	c.Filename = activityPubCommentFilename(note.Id)
+
+	// The URLs are shown as links, so anything other than a web address,
+	// such as a javascript: URL, is dropped.
+	if isWebUrl(actor.Url) {
+		c.Metadata.AuthorUrl = actor.Url
+	}
+
+	if isWebUrl(note.Url) {
+		c.Metadata.Source = note.Url
+	} else if isWebUrl(note.Id) {
+		c.Metadata.Source = note.Id
+	}
+
+	if published, err := time.Parse(time.RFC3339, note.Published); err == nil {
+		c.Metadata.Date = published
+	}
+
+	return c
+}
+
+// deliverActivityPubPost sends a post to every follower, as a Create activity
+// the first time and as an Update if it changes later.
+func deliverActivityPubPost(post *BlogPost) {
+	if post.Metadata.Date.After(time.Now()) {
+		return
+	}
+
+	article := newActivityPubArticle(post)
+	content, _ := json.Marshal(article)
+	hash := fmt.Sprintf("%x", sha1.Sum(content))
+
+	activityPubMutex.Lock()
+	previous, delivered := activityPubDelivered[post.Url]
+
+	if previous == hash {
+		activityPubMutex.Unlock()
+		return
+	}
+
+	activityPubDelivered[post.Url] = hash
+	saveActivityPubState("delivered.json", activityPubDelivered)
+
+	inboxes := []string{}
+	seen := make(map[string]bool)
+
+	for _, inbox := range activityPubFollowers {
+		if !seen[inbox] {
+			seen[inbox] = true
+			inboxes = append(inboxes, inbox)
+		}
+	}
+	activityPubMutex.Unlock()
+
+	var activity interface{}
+
+	if delivered {
+		article.Updated = post.ModifiedDate.UTC().Format(time.RFC3339)
+		activity = newActivityPubActivity("Update", post.Permalink()+"#update-"+hash, article)
+	} else {
+		activity = newActivityPubActivity("Create", post.Permalink()+"#create", article)
+	}
+
+	go deliverActivity(inboxes, activity)
+}
+
+func deliverActivity(inboxes []string, activity interface{}) {
+	for _, inbox := range inboxes {
+		err := activityPubClient.Deliver(inbox, activity)
+
+		if err != nil {
+			log.Println("Could not deliver activity to", inbox, err)
+		}
+	}
+}
+
+func newActivityPubArticle(post *BlogPost) *activitypub.Object {
+	article := &activitypub.Object{
+		Id:           post.Permalink(),
+		Type:         "Article",
+		AttributedTo: activityPubActorId(),
+		Name:         post.Metadata.Title,
+		Content:      post.Body.HTML,
+		Url:          post.Permalink(),
+		Published:    post.Metadata.Date.UTC().Format(time.RFC3339),
+		To:           []string{activitypub.Public},
+		Cc:           []string{SharedConfig.Address + "/activitypub/followers"},
+		Tag:          []activitypub.Tag{},
+	}
+
+	for _, tag := range post.Metadata.Tags {
+		article.Tag = append(article.Tag, activitypub.Tag{
+			Type: "Hashtag",
+			Href: SharedConfig.Address + "/tags/" + url.PathEscape(tag),
+			Name: "#" + strings.Replace(tag, " ", "", -1),
+		})
+	}
+
+	return article
+}
+
+func newActivityPubActivity(activityType, id string, object interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"@context": activitypub.ActivityStreamsContext,
+		"id":       id,
+		"type":     activityType,
+		"actor":    activityPubActorId(),
+		"to":       []string{activitypub.Public},
+		"cc":       []string{SharedConfig.Address + "/activitypub/followers"},
+		"object":   object,
+	}
+}
+
+// showActivityPubArticle serves a post as an Article when it is requested
+// with an ActivityPub content type, which is how remote servers look up
+// links to posts.
+func showActivityPubArticle(post *BlogPost, w http.ResponseWriter, req *http.Request) {
+	if post == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	article := newActivityPubArticle(post)
+	article.Context = activitypub.ActivityStreamsContext
+
+	writeActivityJSON(w, http.StatusOK, article)
+}
+
+func wantsActivityJSON(req *http.Request) bool {
+	accept := req.Header.Get("Accept")
+
+	return SharedConfig.ActivityPub && (strings.Contains(accept, activitypub.ContentType) || strings.Contains(accept, "application/ld+json"))
+}
+
+func writeActivityJSON(w http.ResponseWriter, status int, value interface{}) {
+	content, err := json.MarshalIndent(value, "", "  ")
+
+	if err != nil {
+		log.Println("Could not encode activity:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", activitypub.ContentType)
+	w.WriteHeader(status)
+	w.Write(content)
+}
+
+func activityPubCommentFilename(id string) string {
+	return fmt.Sprintf("activitypub-%x.md", sha1.Sum([]byte(id)))
+}
+
+func loadActivityPubState(filename string, value interface{}) {
+	file, err := ioutil.ReadFile(filepath.Join(SharedConfig.ActivityPubPath, filename))
+
+	if os.IsNotExist(err) {
+		return
+	}
+
+	if err == nil {
+		err = json.Unmarshal(file, value)
+	}
+
+	if err != nil {
+		log.Println("Could not load ActivityPub state:", err)
+	}
+}
+
+// saveActivityPubState writes state to disk.  The caller must hold
+// activityPubMutex.
+func saveActivityPubState(filename string, value interface{}) {
+	data, err := json.MarshalIndent(value, "", "  ")
+
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(SharedConfig.ActivityPubPath, filename), data, 0644)
+	}
+
+	if err != nil {
+		log.Println("Could not save ActivityPub state:", err)
+	}
+}
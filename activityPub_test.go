@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"github.com/ant512/gobble/activitypub"
+	"github.com/ant512/gobble/webmention"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// remoteServer is a stand-in for another ActivityPub server.  It serves a
+// single actor and records the activities delivered to its inbox.
+type remoteServer struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	delivered chan map[string]interface{}
+}
+
+func newRemoteServer(t *testing.T) *remoteServer {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote := &remoteServer{key: key, delivered: make(chan map[string]interface{}, 10)}
+	remote.Server = httptest.NewServer(http.HandlerFunc(remote.serve))
+
+	return remote
+}
+
+func (r *remoteServer) actorId() string {
+	return r.URL + "/users/alice"
+}
+
+func (r *remoteServer) serve(w http.ResponseWriter, req *http.Request) {
+	if req.Method == "POST" {
+		body, _ := ioutil.ReadAll(req.Body)
+
+		_, err := activitypub.VerifyRequest(req, body, func(keyId string) (*rsa.PublicKey, string, error) {
+			return &activityPubKey.PublicKey, activityPubActorId(), nil
+		})
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		activity := make(map[string]interface{})
+		json.Unmarshal(body, &activity)
+
+		r.delivered <- activity
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	publicKey, _ := activitypub.PublicKeyPEM(r.key)
+
+	json.NewEncoder(w).Encode(&activitypub.Actor{
+		Id:                r.actorId(),
+		Type:              "Person",
+		PreferredUsername: "alice",
+		Name:              "Alice",
+		Url:               r.URL + "/@alice",
+		Inbox:             r.actorId() + "/inbox",
+		PublicKey:         activitypub.PublicKey{Id: r.actorId() + "#main-key", Owner: r.actorId(), PublicKeyPem: publicKey},
+	})
+}
+
+// send signs an activity with the remote actor's key and posts it to the
+// blog's inbox.
+func (r *remoteServer) send(t *testing.T, activity string) int {
+	body := []byte(strings.Replace(activity, "$actor", r.actorId(), -1))
+	req, _ := http.NewRequest("POST", "http://blog.example/activitypub/inbox", bytes.NewReader(body))
+	req.Header.Set("Content-Type", activitypub.ContentType)
+
+	err := activitypub.SignRequest(req, r.actorId()+"#main-key", r.key, body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	activityPubInbox(w, req)
+
+	return w.Code
+}
+
+func (r *remoteServer) nextDelivery(t *testing.T) map[string]interface{} {
+	select {
+	case activity := <-r.delivered:
+		return activity
+	case <-time.After(5 * time.Second):
+		t.Fatal("Nothing was delivered")
+	}
+
+	return nil
+}
+
+func createActivityPubTestBlog(t *testing.T) (*BlogPost, string) {
+	p, dir := createWebmentionTestBlog(t)
+
+	SharedConfig = &Config{
+		Address:             "http://blog.example",
+		Name:                "Test Blog",
+		PostsPerPage:        10,
+		ActivityPub:         true,
+		ActivityPubUsername: "blog",
+		ActivityPubPath:     dir,
+	}
+
+	var err error
+	activityPubKey, err = rsa.GenerateKey(rand.Reader, 1024)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	activityPubClient = activitypub.NewClient(http.DefaultClient, activityPubKeyId(), activityPubKey)
+	activityPubFollowers = make(map[string]string)
+	activityPubDelivered = make(map[string]string)
+
+	return p, dir
+}
+
+func TestWebfinger(t *testing.T) {
+	_, dir := createActivityPubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	tests := map[string]int{
+		"acct:blog@blog.example":  http.StatusOK,
+		"acct:other@blog.example": http.StatusNotFound,
+		"acct:blog@other.example": http.StatusNotFound,
+	}
+
+	for resource, status := range tests {
+		req, _ := http.NewRequest("GET", "/.well-known/webfinger?resource="+resource, nil)
+		w := httptest.NewRecorder()
+
+		webfinger(w, req)
+
+		if w.Code != status {
+			t.Errorf("%v: expected %v, got %v", resource, status, w.Code)
+		}
+
+		if status == http.StatusOK && !strings.Contains(w.Body.String(), `"href": "http://blog.example/activitypub/actor"`) {
+			t.Errorf("Actor link missing: %v", w.Body.String())
+		}
+	}
+}
+
+func TestActivityPubArticle(t *testing.T) {
+	_, dir := createActivityPubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	req, _ := http.NewRequest("GET", "/posts/2014/01/26/test-post?:year=2014&:month=01&:day=26&:title=test-post", nil)
+	req.Header.Set("Accept", activitypub.ContentType)
+	w := httptest.NewRecorder()
+
+	post(w, req)
+
+	article := activitypub.Object{}
+	json.Unmarshal(w.Body.Bytes(), &article)
+
+	if article.Type != "Article" || article.Id != "http://blog.example/posts/2014/01/26/test-post" || article.Name != "Test Post" {
+		t.Errorf("Unexpected article %v", w.Body.String())
+	}
+
+	if len(article.Tag) != 2 || article.Tag[0].Name != "#test" {
+		t.Errorf("Unexpected tags %v", article.Tag)
+	}
+}
+
+func TestActivityPubFollow(t *testing.T) {
+	_, dir := createActivityPubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	remote := newRemoteServer(t)
+	defer remote.Close()
+
+	status := remote.send(t, `{"id":"$actor#follow","type":"Follow","actor":"$actor","object":"http://blog.example/activitypub/actor"}`)
+
+	if status != http.StatusAccepted {
+		t.Fatalf("Follow returned %v", status)
+	}
+
+	if activityPubFollowers[remote.actorId()] != remote.actorId()+"/inbox" {
+		t.Errorf("Follower not stored: %v", activityPubFollowers)
+	}
+
+	accept := remote.nextDelivery(t)
+
+	if accept["type"] != "Accept" || accept["object"].(map[string]interface{})["id"] != remote.actorId()+"#follow" {
+		t.Errorf("Unexpected accept %v", accept)
+	}
+
+	saved, _ := ioutil.ReadFile(dir + "/followers.json")
+
+	if !strings.Contains(string(saved), remote.actorId()) {
+		t.Errorf("Followers not saved: %v", string(saved))
+	}
+
+	status = remote.send(t, `{"id":"$actor#undo","type":"Undo","actor":"$actor","object":{"id":"$actor#follow","type":"Follow","actor":"$actor","object":"http://blog.example/activitypub/actor"}}`)
+
+	if status != http.StatusAccepted || len(activityPubFollowers) != 0 {
+		t.Errorf("Follower not removed: %v %v", status, activityPubFollowers)
+	}
+}
+
+func TestActivityPubReply(t *testing.T) {
+	p, dir := createActivityPubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	p.Metadata.DisallowComments = false
+
+	remote := newRemoteServer(t)
+	defer remote.Close()
+
+	status := remote.send(t, `{"id":"$actor/notes/1/activity","type":"Create","actor":"$actor","object":{"id":"$actor/notes/1","type":"Note","attributedTo":"$actor","inReplyTo":"http://blog.example/posts/2014/01/26/test-post","content":"<p>Nice <b>post</b></p>"}}`)
+
+	if status != http.StatusAccepted {
+		t.Fatalf("Reply returned %v", status)
+	}
+
+	filename := activityPubCommentFilename(remote.actorId() + "/notes/1")
+	comment, err := p.CommentWithFilename(filename)
+
+	if err != nil {
+		t.Fatal("Reply not stored as a comment")
+	}
+
+	if comment.Metadata.Author != "Alice" || comment.Body.Markdown != "Nice post" || !comment.Metadata.IsSpam {
+		t.Errorf("Unexpected comment %v", comment)
+	}
+
+	if _, err := os.Stat(dir + "/test-post/" + filename); err != nil {
+		t.Errorf("Comment not written: %v", err)
+	}
+
+	status = remote.send(t, `{"id":"$actor/notes/1#delete","type":"Delete","actor":"$actor","object":"$actor/notes/1"}`)
+
+	if _, err := p.CommentWithFilename(filename); status != http.StatusAccepted || err == nil {
+		t.Errorf("Reply not deleted: %v", status)
+	}
+}
+
+func TestActivityPubInboxRejectsUnsignedActivities(t *testing.T) {
+	_, dir := createActivityPubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	body := `{"type":"Follow","actor":"http://a.example/actor","object":"http://blog.example/activitypub/actor"}`
+	req, _ := http.NewRequest("POST", "http://blog.example/activitypub/inbox", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	activityPubInbox(w, req)
+
+	if w.Code != http.StatusUnauthorized || len(activityPubFollowers) != 0 {
+		t.Errorf("Unsigned activity accepted: %v", w.Code)
+	}
+}
+
+func TestActivityPubRefusesPrivateAddresses(t *testing.T) {
+	_, dir := createActivityPubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	remote := newRemoteServer(t)
+	defer remote.Close()
+
+	activityPubClient = newActivityPubClient()
+
+	// The remote server's key is on 127.0.0.1, so it can't be fetched to
+	// check the signature.
+	status := remote.send(t, `{"id":"$actor#follow","type":"Follow","actor":"$actor","object":"http://blog.example/activitypub/actor"}`)
+
+	if status != http.StatusUnauthorized || len(activityPubFollowers) != 0 {
+		t.Errorf("Activity with a loopback key accepted: %v", status)
+	}
+
+	for _, inbox := range []string{remote.actorId() + "/inbox", "http://10.0.0.1/inbox"} {
+		err := activityPubClient.Deliver(inbox, newActivityPubActivity("Accept", "http://blog.example/accept", nil))
+
+		if err == nil || !strings.Contains(err.Error(), webmention.ErrPrivateAddress.Error()) {
+			t.Errorf("Delivered to %v: %v", inbox, err)
+		}
+	}
+
+	select {
+	case activity := <-remote.delivered:
+		t.Errorf("Unexpected delivery %v", activity)
+	default:
+	}
+}
+
+func TestActivityPubCommentDropsUnsafeUrls(t *testing.T) {
+	actor := &activitypub.Actor{Name: "Mallory", Url: "javascript:alert(1)"}
+	note := &activitypub.Object{Id: "javascript:alert(2)", Url: "data:text/html,hi", Content: "Hello"}
+
+	comment := newActivityPubComment(actor, note)
+
+	if len(comment.Metadata.AuthorUrl) > 0 || len(comment.Metadata.Source) > 0 {
+		t.Errorf("Unsafe URLs kept: %v, %v", comment.Metadata.AuthorUrl, comment.Metadata.Source)
+	}
+
+	note.Id = "https://social.example/notes/1"
+	comment = newActivityPubComment(actor, note)
+
+	if comment.Metadata.Source != note.Id {
+		t.Errorf("Note id not used as the source: %v", comment.Metadata.Source)
+	}
+}
+
+func TestDeliverActivityPubPost(t *testing.T) {
+	p, dir := createActivityPubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	remote := newRemoteServer(t)
+	defer remote.Close()
+
+	activityPubFollowers[remote.actorId()] = remote.actorId() + "/inbox"
+
+	deliverActivityPubPost(p)
+
+	create := remote.nextDelivery(t)
+	article := create["object"].(map[string]interface{})
+
+	if create["type"] != "Create" || article["type"] != "Article" || article["id"] != p.Permalink() {
+		t.Errorf("Unexpected activity %v", create)
+	}
+
+	// Unchanged posts aren't delivered again.
+	deliverActivityPubPost(p)
+
+	p.Body.HTML = "<p>Changed</p>"
+	deliverActivityPubPost(p)
+
+	update := remote.nextDelivery(t)
+
+	if update["type"] != "Update" || update["object"].(map[string]interface{})["content"] != "<p>Changed</p>" {
+		t.Errorf("Unexpected activity %v", update)
+	}
+}
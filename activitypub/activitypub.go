@@ -0,0 +1,305 @@
+package activitypub
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/net/html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const ContentType = "application/activity+json"
+const ActivityStreamsContext = "https://www.w3.org/ns/activitystreams"
+const SecurityContext = "https://w3id.org/security/v1"
+const Public = "https://www.w3.org/ns/activitystreams#Public"
+
+// maxDocumentSize limits how much of a remote document is read.
+const maxDocumentSize = 1024 * 1024
+
+// Fetcher performs HTTP requests.  *http.Client satisfies it; tests can
+// substitute a stand-in.
+type Fetcher interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+type PublicKey struct {
+	Id           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+type Endpoints struct {
+	SharedInbox string `json:"sharedInbox,omitempty"`
+}
+
+type Image struct {
+	Type      string `json:"type"`
+	MediaType string `json:"mediaType,omitempty"`
+	Url       string `json:"url"`
+}
+
+type Actor struct {
+	Context           interface{} `json:"@context,omitempty"`
+	Id                string      `json:"id"`
+	Type              string      `json:"type"`
+	PreferredUsername string      `json:"preferredUsername"`
+	Name              string      `json:"name,omitempty"`
+	Summary           string      `json:"summary,omitempty"`
+	Url               string      `json:"url,omitempty"`
+	Icon              *Image      `json:"icon,omitempty"`
+	Inbox             string      `json:"inbox"`
+	Outbox            string      `json:"outbox,omitempty"`
+	Followers         string      `json:"followers,omitempty"`
+	Endpoints         *Endpoints  `json:"endpoints,omitempty"`
+	PublicKey         PublicKey   `json:"publicKey"`
+}
+
+// DeliveryInbox returns the inbox that activities for the actor should be
+// sent to, preferring the shared inbox of the actor's server.
+func (a *Actor) DeliveryInbox() string {
+	if a.Endpoints != nil && len(a.Endpoints.SharedInbox) > 0 {
+		return a.Endpoints.SharedInbox
+	}
+
+	return a.Inbox
+}
+
+// Activity is an activity sent to or from an inbox.  The object is left
+// undecoded, as it may be a link or an embedded object.
+type Activity struct {
+	Context   interface{}     `json:"@context,omitempty"`
+	Id        string          `json:"id"`
+	Type      string          `json:"type"`
+	Actor     string          `json:"actor"`
+	Published string          `json:"published,omitempty"`
+	To        []string        `json:"to,omitempty"`
+	Cc        []string        `json:"cc,omitempty"`
+	Object    json.RawMessage `json:"object"`
+}
+
+// Object is an ActivityStreams object such as an Article or Note.
+type Object struct {
+	Context      interface{} `json:"@context,omitempty"`
+	Id           string      `json:"id"`
+	Type         string      `json:"type"`
+	AttributedTo string      `json:"attributedTo,omitempty"`
+	Name         string      `json:"name,omitempty"`
+	Summary      string      `json:"summary,omitempty"`
+	Content      string      `json:"content,omitempty"`
+	Url          string      `json:"url,omitempty"`
+	InReplyTo    string      `json:"inReplyTo,omitempty"`
+	Published    string      `json:"published,omitempty"`
+	Updated      string      `json:"updated,omitempty"`
+	To           []string    `json:"to,omitempty"`
+	Cc           []string    `json:"cc,omitempty"`
+	Tag          []Tag       `json:"tag,omitempty"`
+}
+
+type Tag struct {
+	Type string `json:"type"`
+	Href string `json:"href"`
+	Name string `json:"name"`
+}
+
+// ObjectId returns the ID of an activity's object, whether it is embedded or
+// a link.
+func (a *Activity) ObjectId() string {
+	var id string
+
+	if json.Unmarshal(a.Object, &id) == nil {
+		return id
+	}
+
+	object := struct {
+		Id string `json:"id"`
+	}{}
+
+	json.Unmarshal(a.Object, &object)
+
+	return object.Id
+}
+
+// EmbeddedActivity decodes an activity's object as another activity, as in
+// Undo or Accept activities.
+func (a *Activity) EmbeddedActivity() (*Activity, error) {
+	activity := &Activity{}
+	err := json.Unmarshal(a.Object, activity)
+
+	return activity, err
+}
+
+// EmbeddedObject decodes an activity's object, as in Create activities.
+func (a *Activity) EmbeddedObject() (*Object, error) {
+	object := &Object{}
+	err := json.Unmarshal(a.Object, object)
+
+	return object, err
+}
+
+// Client fetches actors and delivers activities, signing every request with
+// the local actor's key.
+type Client struct {
+	Fetcher   Fetcher
+	UserAgent string
+	KeyId     string
+	Key       *rsa.PrivateKey
+}
+
+func NewClient(fetcher Fetcher, keyId string, key *rsa.PrivateKey) *Client {
+	return &Client{Fetcher: fetcher, UserAgent: "Gobble", KeyId: keyId, Key: key}
+}
+
+// FetchActor retrieves a remote actor.
+func (c *Client) FetchActor(id string) (*Actor, error) {
+	u, err := url.Parse(id)
+
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("Invalid actor ID %v", id)
+	}
+
+	// Key IDs are usually the actor ID with a fragment.
+	u.Fragment = ""
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", ContentType)
+
+	resp, err := c.do(req, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("Actor %v returned %v", id, resp.Status)
+	}
+
+	actor := &Actor{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxDocumentSize)).Decode(actor)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(actor.Id) == 0 || len(actor.Inbox) == 0 {
+		return nil, fmt.Errorf("%v is not an actor", id)
+	}
+
+	return actor, nil
+}
+
+// LookupKey fetches the public key with the given ID from its owner's actor
+// document.  It can be passed to VerifyRequest.
+func (c *Client) LookupKey(keyId string) (*rsa.PublicKey, string, error) {
+	actor, err := c.FetchActor(keyId)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if actor.PublicKey.Id != keyId || actor.PublicKey.Owner != actor.Id {
+		return nil, "", errors.New("Actor does not own key " + keyId)
+	}
+
+	key, err := ParsePublicKeyPEM(actor.PublicKey.PublicKeyPem)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return key, actor.Id, nil
+}
+
+// Deliver posts an activity to an inbox.
+func (c *Client) Deliver(inbox string, activity interface{}) error {
+	body, err := json.Marshal(activity)
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", inbox, bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", ContentType)
+
+	resp, err := c.do(req, body)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDocumentSize))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Inbox %v returned %v", inbox, resp.Status)
+	}
+
+	return nil
+}
+
+func (c *Client) do(req *http.Request, body []byte) (*http.Response, error) {
+	req.Header.Set("User-Agent", c.UserAgent)
+
+	if c.Key != nil {
+		err := SignRequest(req, c.KeyId, c.Key, body)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.Fetcher.Do(req)
+}
+
+// PlainText converts the HTML content of a remote post into plain text,
+// keeping paragraph and line breaks.
+func PlainText(fragment string) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(fragment))
+	text := &bytes.Buffer{}
+
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			lines := []string{}
+
+			// Collapse runs of blank lines left by adjacent block elements.
+			for _, line := range strings.Split(text.String(), "\n") {
+				line = strings.Join(strings.Fields(line), " ")
+
+				if len(line) > 0 || (len(lines) > 0 && len(lines[len(lines)-1]) > 0) {
+					lines = append(lines, line)
+				}
+			}
+
+			return strings.TrimSpace(strings.Join(lines, "\n"))
+		case html.TextToken:
+			text.Write(tokenizer.Text())
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+
+			switch string(name) {
+			case "br":
+				text.WriteString("\n")
+			case "p", "div", "blockquote", "li":
+				text.WriteString("\n\n")
+			}
+		}
+	}
+}
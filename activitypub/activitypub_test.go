@@ -0,0 +1,224 @@
+package activitypub
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKey *rsa.PrivateKey
+
+func key(t *testing.T) *rsa.PrivateKey {
+	if testKey == nil {
+		var err error
+		testKey, err = rsa.GenerateKey(rand.Reader, 1024)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return testKey
+}
+
+func lookupTestKey(t *testing.T) KeyLookup {
+	return func(keyId string) (*rsa.PublicKey, string, error) {
+		if keyId != "http://a.example/actor#main-key" {
+			return nil, "", errors.New("Unknown key")
+		}
+
+		return &key(t).PublicKey, "http://a.example/actor", nil
+	}
+}
+
+func TestSignAndVerifyRequest(t *testing.T) {
+	body := []byte(`{"type":"Follow"}`)
+	req, _ := http.NewRequest("POST", "http://blog.example/inbox?x=1", nil)
+
+	err := SignRequest(req, "http://a.example/actor#main-key", key(t), body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner, err := VerifyRequest(req, body, lookupTestKey(t))
+
+	if err != nil || owner != "http://a.example/actor" {
+		t.Errorf("Signed request not verified: %v %v", owner, err)
+	}
+
+	_, err = VerifyRequest(req, []byte(`{"type":"Undo"}`), lookupTestKey(t))
+
+	if err == nil {
+		t.Error("Request with altered body verified")
+	}
+
+	req.URL.Path = "/other"
+
+	_, err = VerifyRequest(req, body, lookupTestKey(t))
+
+	if err != ErrInvalidSignature {
+		t.Errorf("Request with altered target verified: %v", err)
+	}
+}
+
+func TestVerifyRequestRejectsOldAndUnsignedRequests(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://blog.example/actor", nil)
+
+	if _, err := VerifyRequest(req, nil, lookupTestKey(t)); err != ErrNotSigned {
+		t.Errorf("Unsigned request gave %v", err)
+	}
+
+	req.Header.Set("Date", time.Now().Add(-24*time.Hour).UTC().Format(http.TimeFormat))
+	SignRequest(req, "http://a.example/actor#main-key", key(t), nil)
+
+	if _, err := VerifyRequest(req, nil, lookupTestKey(t)); err == nil {
+		t.Error("Old request verified")
+	}
+}
+
+func TestParseSignature(t *testing.T) {
+	params := parseSignature(`keyId="a,b", algorithm=rsa-sha256, headers="date", signature="c=="`)
+
+	expected := map[string]string{"keyId": "a,b", "algorithm": "rsa-sha256", "headers": "date", "signature": "c=="}
+
+	for name, value := range expected {
+		if params[name] != value {
+			t.Errorf("%v: expected %q, got %q", name, value, params[name])
+		}
+	}
+}
+
+func TestPublicKeyPEM(t *testing.T) {
+	data, err := PublicKeyPEM(key(t))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParsePublicKeyPEM(data)
+
+	if err != nil || parsed.N.Cmp(key(t).N) != 0 {
+		t.Errorf("Public key did not round trip: %v", err)
+	}
+}
+
+func TestLoadOrCreateKey(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "activitypub")
+	path := dir + "/key.pem"
+
+	created, err := LoadOrCreateKey(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadOrCreateKey(path)
+
+	if err != nil || loaded.N.Cmp(created.N) != 0 {
+		t.Errorf("Saved key not loaded: %v", err)
+	}
+}
+
+func TestClient(t *testing.T) {
+	var server *httptest.Server
+	delivered := make(chan *http.Request, 1)
+
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "POST" {
+			body, _ := ioutil.ReadAll(req.Body)
+
+			if _, err := VerifyRequest(req, body, lookupTestKey(t)); err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			delivered <- req
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		publicKey, _ := PublicKeyPEM(key(t))
+
+		json.NewEncoder(w).Encode(&Actor{
+			Id:        server.URL + "/actor",
+			Type:      "Person",
+			Inbox:     server.URL + "/inbox",
+			Endpoints: &Endpoints{SharedInbox: server.URL + "/shared"},
+			PublicKey: PublicKey{server.URL + "/actor#main-key", server.URL + "/actor", publicKey},
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient(http.DefaultClient, "http://a.example/actor#main-key", key(t))
+
+	actor, err := client.FetchActor(server.URL + "/actor")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actor.DeliveryInbox() != server.URL+"/shared" {
+		t.Errorf("Expected shared inbox, got %v", actor.DeliveryInbox())
+	}
+
+	publicKey, owner, err := client.LookupKey(server.URL + "/actor#main-key")
+
+	if err != nil || owner != actor.Id || publicKey.N.Cmp(key(t).N) != 0 {
+		t.Errorf("Key lookup failed: %v %v", owner, err)
+	}
+
+	if _, _, err := client.LookupKey(server.URL + "/actor#other-key"); err == nil {
+		t.Error("Key not owned by actor was returned")
+	}
+
+	err = client.Deliver(actor.DeliveryInbox(), map[string]string{"type": "Create"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-delivered
+
+	if req.Header.Get("Content-Type") != ContentType {
+		t.Errorf("Unexpected content type %v", req.Header.Get("Content-Type"))
+	}
+}
+
+func TestActivityObject(t *testing.T) {
+	activity := &Activity{}
+	json.Unmarshal([]byte(`{"type":"Undo","object":{"id":"http://a.example/follow","type":"Follow","object":"http://blog.example/actor"}}`), activity)
+
+	if activity.ObjectId() != "http://a.example/follow" {
+		t.Errorf("Unexpected object ID %v", activity.ObjectId())
+	}
+
+	embedded, err := activity.EmbeddedActivity()
+
+	if err != nil || embedded.Type != "Follow" || embedded.ObjectId() != "http://blog.example/actor" {
+		t.Errorf("Embedded activity not decoded: %v %v", embedded, err)
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	tests := map[string]string{
+		`<p>Hello <a href="http://a.example">@blog</a></p><p>Second&amp;last</p>`: "Hello @blog\n\nSecond&last",
+		"<p>One<br>two</p>":        "One\ntwo",
+		"plain   text":             "plain text",
+		strings.Repeat(" ", 10):    "",
+		"<p>  spaced  </p>\n\n\n ": "spaced",
+	}
+
+	for input, expected := range tests {
+		if actual := PlainText(input); actual != expected {
+			t.Errorf("%q: expected %q, got %q", input, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package activitypub
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const keySize = 2048
+
+// maxClockSkew is how far the Date header of a signed request may be from the
+// current time.
+const maxClockSkew = 12 * time.Hour
+
+var ErrNotSigned = errors.New("Request is not signed")
+var ErrInvalidSignature = errors.New("Request signature is not valid")
+
+// KeyLookup returns the public key with the given ID, and the ID of the actor
+// that owns it.
+type KeyLookup func(keyId string) (*rsa.PublicKey, string, error)
+
+// LoadOrCreateKey reads a PEM encoded RSA private key from path, creating and
+// saving a new key if the file doesn't exist.
+func LoadOrCreateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		key, err := rsa.GenerateKey(rand.Reader, keySize)
+
+		if err != nil {
+			return nil, err
+		}
+
+		block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+
+		return key, ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+
+	if block == nil {
+		return nil, fmt.Errorf("%v does not contain a PEM encoded key", path)
+	}
+
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// PublicKeyPEM encodes the public half of a key in the PKIX form that other
+// servers expect to find in an actor's publicKeyPem property.
+func PublicKeyPEM(key *rsa.PrivateKey) (string, error) {
+	data, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: data})), nil
+}
+
+// ParsePublicKeyPEM decodes a PKIX or PKCS#1 encoded RSA public key.
+func ParsePublicKeyPEM(data string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+
+	if block == nil {
+		return nil, errors.New("Public key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(*rsa.PublicKey)
+
+	if !ok {
+		return nil, errors.New("Public key is not an RSA key")
+	}
+
+	return key, nil
+}
+
+// SignRequest adds Date, Digest (for requests with a body) and Signature
+// headers to a request, following the HTTP Signatures draft used by Mastodon
+// and other ActivityPub servers.
+func SignRequest(req *http.Request, keyId string, key *rsa.PrivateKey, body []byte) error {
+	headers := []string{"(request-target)", "host", "date"}
+
+	if len(req.Header.Get("Date")) == 0 {
+		req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+
+	if body != nil {
+		req.Header.Set("Digest", digest(body))
+		headers = append(headers, "digest")
+	}
+
+	hash := sha256.Sum256([]byte(signingString(req, headers)))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%v",algorithm="rsa-sha256",headers="%v",signature="%v"`,
+		keyId, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(signature)))
+
+	return nil
+}
+
+// VerifyRequest checks the signature on a request and returns the ID of the
+// actor that signed it.  The signature must cover the request target, host
+// and date, and the digest if there is a body.
+func VerifyRequest(req *http.Request, body []byte, lookup KeyLookup) (string, error) {
+	params := parseSignature(req.Header.Get("Signature"))
+
+	if len(params["keyId"]) == 0 || len(params["signature"]) == 0 {
+		return "", ErrNotSigned
+	}
+
+	headers := strings.Fields(strings.ToLower(params["headers"]))
+
+	if len(headers) == 0 {
+		headers = []string{"date"}
+	}
+
+	required := []string{"(request-target)", "host", "date"}
+
+	if len(body) > 0 {
+		required = append(required, "digest")
+	}
+
+	for _, header := range required {
+		if !contains(headers, header) {
+			return "", fmt.Errorf("Signature does not cover %v", header)
+		}
+	}
+
+	date, err := http.ParseTime(req.Header.Get("Date"))
+
+	if err != nil || time.Since(date) > maxClockSkew || time.Until(date) > maxClockSkew {
+		return "", errors.New("Request date is missing or too far from the current time")
+	}
+
+	if len(body) > 0 && !digestMatches(req.Header.Get("Digest"), body) {
+		return "", errors.New("Request digest does not match its body")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(params["signature"])
+
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+
+	key, owner, err := lookup(params["keyId"])
+
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256([]byte(signingString(req, headers)))
+
+	if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) != nil {
+		return "", ErrInvalidSignature
+	}
+
+	return owner, nil
+}
+
+func signingString(req *http.Request, headers []string) string {
+	lines := []string{}
+
+	for _, header := range headers {
+		var value string
+
+		switch header {
+		case "(request-target)":
+			value = strings.ToLower(req.Method) + " " + req.URL.RequestURI()
+		case "host":
+			value = req.Host
+
+			if len(value) == 0 {
+				value = req.URL.Host
+			}
+		default:
+			value = strings.Join(req.Header[http.CanonicalHeaderKey(header)], ", ")
+		}
+
+		lines = append(lines, header+": "+value)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// parseSignature splits a Signature header into its parameters.
+func parseSignature(header string) map[string]string {
+	params := make(map[string]string)
+
+	for len(header) > 0 {
+		separator := strings.Index(header, "=")
+
+		if separator < 0 {
+			break
+		}
+
+		name := strings.TrimSpace(header[:separator])
+		header = header[separator+1:]
+
+		var value string
+
+		if strings.HasPrefix(header, `"`) {
+			end := strings.Index(header[1:], `"`)
+
+			if end < 0 {
+				break
+			}
+
+			value = header[1 : end+1]
+			header = header[end+2:]
+		} else {
+			end := strings.Index(header, ",")
+
+			if end < 0 {
+				end = len(header)
+			}
+
+			value = header[:end]
+			header = header[end:]
+		}
+
+		params[name] = value
+		header = strings.TrimPrefix(strings.TrimSpace(header), ",")
+	}
+
+	return params
+}
+
+func digest(body []byte) string {
+	hash := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func digestMatches(header string, body []byte) bool {
+	expected := []byte(digest(body))
+
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimSpace(value)
+
+		if len(value) > 8 && strings.EqualFold(value[:8], "SHA-256=") && bytes.Equal([]byte("SHA-256="+value[8:]), expected) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
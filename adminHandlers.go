@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// requireAdmin wraps a handler so that it can only be reached by a moderator
+// supplying the admin credentials from the config file via HTTP basic auth.
+// Admin pages do not exist at all if no password has been configured.
+func requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if len(SharedConfig.AdminPassword) == 0 {
+			http.NotFound(w, req)
+			return
+		}
+
+		username, password, ok := req.BasicAuth()
+
+		if !ok || !isAdmin(username, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Gobble"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler(w, req)
+	}
+}
+
+// requireAdminForm wraps a handler that changes or deletes data so that, as
+// well as the admin credentials, it needs the token from adminFormToken.
+// Browsers send basic auth credentials with forms posted from other sites, so
+// the credentials alone don't show that the request came from an admin page.
+func requireAdminForm(handler http.HandlerFunc) http.HandlerFunc {
+	return requireAdmin(func(w http.ResponseWriter, req *http.Request) {
+		if !hmac.Equal([]byte(req.FormValue("token")), []byte(adminFormToken())) {
+			http.Error(w, "Invalid form token", http.StatusForbidden)
+			return
+		}
+
+		handler(w, req)
+	})
+}
+
+// adminFormToken is included in the admin pages' forms.  It is keyed with the
+// admin credentials as well as the secret key, which need not be set, so that
+// it can't be worked out without them.
+func adminFormToken() string {
+	return sign("admin", SharedConfig.AdminUsername, SharedConfig.AdminPassword)
+}
+
+// isAdmin checks a username and password against the admin credentials from
+// the config file.  It always fails if no password has been configured.
+func isAdmin(username, password string) bool {
+	if len(SharedConfig.AdminPassword) == 0 {
+		return false
+	}
+
+	validUsername := subtle.ConstantTimeCompare([]byte(username), []byte(SharedConfig.AdminUsername)) == 1
+	validPassword := subtle.ConstantTimeCompare([]byte(password), []byte(SharedConfig.AdminPassword)) == 1
+
+	return validUsername && validPassword
+}
+
+// maxTokenRequestSize is the largest request accepted by any handler behind
+// requireToken, which is a Micropub media upload.
+const maxTokenRequestSize = maxMicropubMediaSize
+
+// requireToken wraps a handler so that it can only be reached by clients that
+// present one of the API tokens from the config file, either as a bearer token
+// in the Authorization header or as an access_token parameter.  The handler
+// does not exist at all if no tokens have been configured.  The size of the
+// request body is limited before the token is looked for, as an access_token
+// parameter means reading the form.
+func requireToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if len(SharedConfig.APITokens) == 0 {
+			http.NotFound(w, req)
+			return
+		}
+
+		req.Body = http.MaxBytesReader(w, req.Body, maxTokenRequestSize)
+
+		if !hasValidToken(req) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="Gobble"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler(w, req)
+	}
+}
+
+func hasValidToken(req *http.Request) bool {
+	token := ""
+	authorization := req.Header.Get("Authorization")
+
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		token = strings.TrimSpace(authorization[7:])
+	} else {
+		token = req.FormValue("access_token")
+	}
+
+	if len(token) == 0 {
+		return false
+	}
+
+	valid := false
+
+	for _, t := range SharedConfig.APITokens {
+		if len(t) > 0 && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			valid = true
+		}
+	}
+
+	return valid
+}
+
+// showAdminPage renders one of the theme's admin templates, or sends an error
+// if the template can't be loaded.
+func showAdminPage(w http.ResponseWriter, req *http.Request, name string, page interface{}) {
+	t, err := template.ParseFiles(SharedConfig.FullThemePath() + "/templates/" + name)
+
+	if err != nil {
+		log.Println("Could not load admin template:", err)
+		showError(w, req, http.StatusInternalServerError, "The page could not be shown.")
+		return
+	}
+
+	t.Execute(w, page)
+}
+
+func rateLimits(w http.ResponseWriter, req *http.Request) {
+
+	page := struct {
+		BlockedAddresses []RateLimitBlock
+		BlockedPosts     []RateLimitBlock
+		Token            string
+		Config           *Config
+	}{
+		ipRateLimiter.Blocked(),
+		postRateLimiter.Blocked(),
+		adminFormToken(),
+		SharedConfig,
+	}
+
+	showAdminPage(w, req, "ratelimits.html", page)
+}
+
+func clearRateLimit(w http.ResponseWriter, req *http.Request) {
+
+	key := req.FormValue("key")
+
+	switch req.FormValue("limiter") {
+	case "ip":
+		ipRateLimiter.Clear(key)
+	case "post":
+		postRateLimiter.Clear(key)
+	default:
+		http.Error(w, "Unknown rate limiter", http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, req, "/admin/ratelimits", http.StatusFound)
+}
+
+func redirectUses(w http.ResponseWriter, req *http.Request) {
+
+	page := struct {
+		Redirects []RedirectUse
+		Config    *Config
+	}{
+		redirects.Uses(),
+		SharedConfig,
+	}
+
+	showAdminPage(w, req, "redirects.html", page)
+}
+
+func privacy(w http.ResponseWriter, req *http.Request) {
+
+	email := req.FormValue("email")
+	erased, _ := strconv.Atoi(req.FormValue("erased"))
+
+	page := struct {
+		Email    string
+		Comments []PostComment
+		Erased   int
+		Token    string
+		Config   *Config
+	}{
+		html.EscapeString(email),
+		blog.CommentsWithEmail(email),
+		erased,
+		adminFormToken(),
+		SharedConfig,
+	}
+
+	showAdminPage(w, req, "privacy.html", page)
+}
+
+func exportPrivacy(w http.ResponseWriter, req *http.Request) {
+
+	type exportedComment struct {
+		Post   string
+		Url    string
+		Author string
+		Email  string
+		Date   time.Time
+		IsSpam bool
+		Body   string
+	}
+
+	email := req.FormValue("email")
+	comments := []exportedComment{}
+
+	for _, found := range blog.CommentsWithEmail(email) {
+		comments = append(comments, exportedComment{
+			found.Post.Metadata.Title,
+			SharedConfig.Address + "/posts/" + found.Post.Url,
+			html.UnescapeString(found.Comment.Metadata.Author),
+			html.UnescapeString(found.Comment.Metadata.Email),
+			found.Comment.Metadata.Date,
+			found.Comment.Metadata.IsSpam,
+			html.UnescapeString(found.Comment.Body.Markdown),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="comments.json"`)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	encoder.Encode(comments)
+}
+
+func erasePrivacy(w http.ResponseWriter, req *http.Request) {
+
+	email := req.FormValue("email")
+	deleteComments := req.FormValue("mode") == "delete"
+
+	count, err := blog.EraseCommentsWithEmail(email, deleteComments)
+
+	if err != nil {
+		log.Println("Could not erase comments:", err)
+		showError(w, req, http.StatusInternalServerError, fmt.Sprintf("Only %v comments could be erased: %v", count, err))
+		return
+	}
+
+	log.Println("Erased", count, "comments")
+
+	http.Redirect(w, req, "/admin/privacy?erased="+strconv.Itoa(count), http.StatusFound)
+}
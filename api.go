@@ -0,0 +1,371 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxAPIPostsPerPage = 100
+const apiDateLayout = "2006-01-02"
+
+type apiBody struct {
+	Markdown string `json:"markdown"`
+	HTML     string `json:"html"`
+}
+
+type apiComment struct {
+	Author    string    `json:"author"`
+	AuthorUrl string    `json:"authorUrl,omitempty"`
+	AvatarUrl string    `json:"avatarUrl,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	Date      time.Time `json:"date"`
+	Body      apiBody   `json:"body"`
+}
+
+type apiPost struct {
+	Metadata       BlogPostMetadata `json:"metadata"`
+	Url            string           `json:"url"`
+	Permalink      string           `json:"permalink"`
+	Body           apiBody          `json:"body"`
+	Summary        string           `json:"summary"`
+	CommentCount   int              `json:"commentCount"`
+	AllowsComments bool             `json:"allowsComments"`
+	ModifiedDate   time.Time        `json:"modifiedDate"`
+}
+
+// apiPostWithComments is a single post, which, unlike posts in lists, includes
+// its comments.
+type apiPostWithComments struct {
+	apiPost
+	Comments []apiComment `json:"comments"`
+}
+
+type apiPostList struct {
+	Posts     []apiPost `json:"posts"`
+	Page      int       `json:"page"`
+	PageCount int       `json:"pageCount"`
+	Total     int       `json:"total"`
+}
+
+type apiTag struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type apiArchiveMonth struct {
+	Year  int           `json:"year"`
+	Month int           `json:"month"`
+	Posts []apiPostLink `json:"posts"`
+}
+
+type apiPostLink struct {
+	Title string    `json:"title"`
+	Url   string    `json:"url"`
+	Date  time.Time `json:"date"`
+}
+
+// apiPosts lists posts, newest first.  The list can be filtered with the tag,
+// search, from and to parameters, and is split into pages with the page and
+// perPage parameters.
+func apiPosts(w http.ResponseWriter, req *http.Request) {
+
+	query := req.URL.Query()
+	perPage := SharedConfig.PostsPerPage
+
+	if value := query.Get("perPage"); len(value) > 0 {
+		n, err := strconv.Atoi(value)
+
+		if err != nil || n < 1 || n > maxAPIPostsPerPage {
+			writeAPIError(w, req, http.StatusBadRequest, fmt.Sprintf("perPage must be between 1 and %v", maxAPIPostsPerPage))
+			return
+		}
+
+		perPage = n
+	}
+
+	page := 1
+
+	if value := query.Get("page"); len(value) > 0 {
+		n, err := strconv.Atoi(value)
+
+		if err != nil || n < 1 {
+			writeAPIError(w, req, http.StatusBadRequest, "page must be a positive integer")
+			return
+		}
+
+		page = n
+	}
+
+	from, err := parseAPIDate(query.Get("from"))
+
+	if err != nil {
+		writeAPIError(w, req, http.StatusBadRequest, "from must be a date in the format YYYY-MM-DD")
+		return
+	}
+
+	to, err := parseAPIDate(query.Get("to"))
+
+	if err != nil {
+		writeAPIError(w, req, http.StatusBadRequest, "to must be a date in the format YYYY-MM-DD")
+		return
+	}
+
+	tag := query.Get("tag")
+	term := query.Get("search")
+
+	blog.mutex.RLock()
+	posts := blog.posts.Filter(func(post *BlogPost, index int, stop *bool) bool {
+		if len(tag) > 0 && !post.ContainsTag(tag) {
+			return false
+		}
+
+		if !from.IsZero() && post.Metadata.Date.Before(from) {
+			return false
+		}
+
+		// The to date is inclusive, so posts on that day are included.
+		if !to.IsZero() && !post.Metadata.Date.Before(to.AddDate(0, 0, 1)) {
+			return false
+		}
+
+		return len(term) == 0 || post.ContainsTerm(term)
+	})
+	blog.mutex.RUnlock()
+
+	list := apiPostList{
+		Posts:     []apiPost{},
+		Page:      page,
+		PageCount: (len(posts) + perPage - 1) / perPage,
+		Total:     len(posts),
+	}
+
+	for i := (page - 1) * perPage; i < len(posts) && i < page*perPage; i++ {
+		list.Posts = append(list.Posts, newAPIPost(posts[i]))
+	}
+
+	writeAPIJSON(w, req, http.StatusOK, list)
+}
+
+// apiPostResource routes a request below "/api/v1/posts/" to the handler for
+// a post, its comments or one of its comments.  Post URLs can have any number
+// of segments, so the route can't be written as a pattern.
+func apiPostResource(post, comments, comment http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		_, rest, _ := postWithPath(req.URL.Path)
+		id := strings.TrimPrefix(rest, "comments/")
+
+		switch {
+		case len(rest) == 0:
+			post(w, req)
+		case rest == "comments" && comments != nil:
+			comments(w, req)
+		case id != rest && len(id) > 0 && !strings.Contains(id, "/") && comment != nil:
+			comment(w, req)
+		default:
+			writeAPIError(w, req, http.StatusNotFound, "Not found")
+		}
+	}
+}
+
+func apiPostDetail(w http.ResponseWriter, req *http.Request) {
+
+	post, _, err := postWithPath(req.URL.Path)
+
+	if err != nil {
+		writeAPIError(w, req, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	writeAPIJSON(w, req, http.StatusOK, apiPostWithComments{newAPIPost(post), newAPIComments(post)})
+}
+
+func apiPostComments(w http.ResponseWriter, req *http.Request) {
+
+	post, _, err := postWithPath(req.URL.Path)
+
+	if err != nil {
+		writeAPIError(w, req, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	writeAPIJSON(w, req, http.StatusOK, newAPIComments(post))
+}
+
+func apiTags(w http.ResponseWriter, req *http.Request) {
+
+	tags := []apiTag{}
+
+	for name, count := range blog.AllTags() {
+		tags = append(tags, apiTag{name, count})
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+
+	writeAPIJSON(w, req, http.StatusOK, tags)
+}
+
+// apiArchive lists every post, grouped by month, newest first.
+func apiArchive(w http.ResponseWriter, req *http.Request) {
+
+	months := []apiArchiveMonth{}
+
+	blog.mutex.RLock()
+	for _, post := range blog.posts {
+		year := post.Metadata.Date.Year()
+		month := int(post.Metadata.Date.Month())
+
+		if len(months) == 0 || months[len(months)-1].Year != year || months[len(months)-1].Month != month {
+			months = append(months, apiArchiveMonth{year, month, []apiPostLink{}})
+		}
+
+		current := &months[len(months)-1]
+		current.Posts = append(current.Posts, apiPostLink{post.Metadata.Title, post.Url, post.Metadata.Date})
+	}
+	blog.mutex.RUnlock()
+
+	writeAPIJSON(w, req, http.StatusOK, months)
+}
+
+// apiOptions answers CORS preflight requests for every API URL.
+func apiOptions(w http.ResponseWriter, req *http.Request) {
+	setCORSHeaders(w, req)
+
+	w.Header().Set("Allow", "GET, HEAD, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-None-Match")
+	w.Header().Set("Access-Control-Max-Age", "86400")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newAPIPost(post *BlogPost) apiPost {
+	post.mutex.RLock()
+	commentCount := len(post.NonSpamComments())
+	post.mutex.RUnlock()
+
+	p := apiPost{
+		Metadata:       post.Metadata,
+		Url:            post.Url,
+		Permalink:      post.Permalink(),
+		Body:           apiBody{strings.TrimLeft(post.Body.Markdown, "\n"), post.Body.HTML},
+		Summary:        post.Summary,
+		CommentCount:   commentCount,
+		AllowsComments: post.AllowsComments(),
+		ModifiedDate:   post.ModifiedDate,
+	}
+
+	if p.Metadata.Tags == nil {
+		p.Metadata.Tags = []string{}
+	}
+
+	return p
+}
+
+// newAPIComments returns the post's visible comments.  Email addresses are
+// never included.
+func newAPIComments(post *BlogPost) []apiComment {
+	post.mutex.RLock()
+	defer post.mutex.RUnlock()
+
+	comments := []apiComment{}
+
+	for _, comment := range post.NonSpamComments() {
+		comments = append(comments, apiComment{
+			Author:    comment.Metadata.Author,
+			AuthorUrl: comment.Metadata.AuthorUrl,
+			AvatarUrl: comment.AvatarUrl(),
+			Source:    comment.Metadata.Source,
+			Date:      comment.Metadata.Date,
+			Body:      apiBody{strings.TrimLeft(comment.Body.Markdown, "\n"), comment.Body.HTML},
+		})
+	}
+
+	return comments
+}
+
+// writeAPIJSON writes a JSON response with an ETag.  If the client already has
+// the same representation, only a 304 status is sent.
+func writeAPIJSON(w http.ResponseWriter, req *http.Request, status int, value interface{}) {
+	setCORSHeaders(w, req)
+
+	content, err := json.MarshalIndent(value, "", "  ")
+
+	if err != nil {
+		log.Println("Could not encode API response:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%x"`, sha1.Sum(content))
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if status == http.StatusOK && etagMatches(req.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(content)
+	w.Write([]byte("\n"))
+}
+
+func writeAPIError(w http.ResponseWriter, req *http.Request, status int, message string) {
+	setCORSHeaders(w, req)
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// setCORSHeaders allows browsers on the origins listed in the apiOrigins
+// config setting to read API responses.
+func setCORSHeaders(w http.ResponseWriter, req *http.Request) {
+	origin := req.Header.Get("Origin")
+
+	w.Header().Add("Vary", "Origin")
+
+	if len(origin) == 0 {
+		return
+	}
+
+	for _, allowed := range SharedConfig.APIOrigins {
+		if allowed == "*" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else if strings.EqualFold(allowed, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		} else {
+			continue
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Location")
+		return
+	}
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseAPIDate(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(apiDateLayout, value)
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const maxAPIRequestSize = 1024 * 1024
+
+// apiPostRequest is the body of a request to create or replace a post.
+type apiPostRequest struct {
+	Filename string           `json:"filename"`
+	Metadata BlogPostMetadata `json:"metadata"`
+	Body     string           `json:"body"`
+}
+
+type apiCommentRequest struct {
+	Spam *bool `json:"spam"`
+}
+
+// apiModerationComment is a comment as seen by a moderator, including spam
+// status and email address.
+type apiModerationComment struct {
+	Id      string    `json:"id"`
+	PostUrl string    `json:"postUrl"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Date    time.Time `json:"date"`
+	Spam    bool      `json:"spam"`
+	Source  string    `json:"source,omitempty"`
+	Body    apiBody   `json:"body"`
+}
+
+func apiCreatePost(w http.ResponseWriter, req *http.Request) {
+
+	request, err := decodeAPIPostRequest(req)
+
+	if err != nil {
+		writeAPIError(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if request.Metadata.Date.IsZero() {
+		request.Metadata.Date = time.Now()
+	}
+
+	err = validateAPIPost(&request.Metadata, "", request.Body)
+
+	if err != nil {
+		writeAPIError(w, req, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	filename := request.Filename
+
+	if len(filename) == 0 {
+		filename = blog.NewPostFilename(request.Metadata.Title, request.Metadata.Date)
+	} else if !isValidBlogPostFilename(filename) {
+		writeAPIError(w, req, http.StatusUnprocessableEntity, "filename must be a plain filename ending in "+validFilenameExtension)
+		return
+	} else if blog.postFileExists(filename) {
+		writeAPIError(w, req, http.StatusConflict, "A post with that filename already exists")
+		return
+	}
+
+	post, err := blog.WritePost(filename, request.Metadata, request.Body)
+
+	if err != nil {
+		log.Println("Could not create post:", err)
+		writeAPIError(w, req, http.StatusInternalServerError, "Could not create post")
+		return
+	}
+
+	log.Println("Created post", filename, "via API")
+
+	w.Header().Set("Location", "/api/v1/posts/"+post.Url)
+	writeAPIJSON(w, req, http.StatusCreated, apiPostWithComments{newAPIPost(post), newAPIComments(post)})
+}
+
+// apiReplacePost replaces a post's metadata and body.  A post's date is kept
+// if the request doesn't include one.
+func apiReplacePost(w http.ResponseWriter, req *http.Request) {
+
+	post, _, err := postWithPath(req.URL.Path)
+
+	if err != nil {
+		writeAPIError(w, req, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	request, err := decodeAPIPostRequest(req)
+
+	if err != nil {
+		writeAPIError(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if len(request.Filename) > 0 && request.Filename != post.Filename {
+		writeAPIError(w, req, http.StatusUnprocessableEntity, "A post's filename cannot be changed")
+		return
+	}
+
+	if request.Metadata.Date.IsZero() {
+		request.Metadata.Date = post.Metadata.Date
+	}
+
+	err = validateAPIPost(&request.Metadata, post.Filename, request.Body)
+
+	if err != nil {
+		writeAPIError(w, req, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	post, err = blog.WritePost(post.Filename, request.Metadata, request.Body)
+
+	if err != nil {
+		log.Println("Could not update post:", err)
+		writeAPIError(w, req, http.StatusInternalServerError, "Could not update post")
+		return
+	}
+
+	log.Println("Updated post", post.Filename, "via API")
+
+	w.Header().Set("Content-Location", "/api/v1/posts/"+post.Url)
+	writeAPIJSON(w, req, http.StatusOK, apiPostWithComments{newAPIPost(post), newAPIComments(post)})
+}
+
+func apiDeletePost(w http.ResponseWriter, req *http.Request) {
+
+	post, _, err := postWithPath(req.URL.Path)
+
+	if err != nil {
+		writeAPIError(w, req, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	err = blog.DeletePost(post.Filename)
+
+	if err != nil {
+		log.Println("Could not delete post:", err)
+		writeAPIError(w, req, http.StatusInternalServerError, "Could not delete post")
+		return
+	}
+
+	log.Println("Deleted post", post.Filename, "via API")
+
+	setCORSHeaders(w, req)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiModerationComments lists comments for moderators.  The spam parameter
+// limits the list to spam ("true") or visible ("false") comments.
+func apiModerationComments(w http.ResponseWriter, req *http.Request) {
+
+	spam := req.URL.Query().Get("spam")
+
+	if len(spam) > 0 && spam != "true" && spam != "false" {
+		writeAPIError(w, req, http.StatusBadRequest, "spam must be true or false")
+		return
+	}
+
+	comments := []apiModerationComment{}
+
+	blog.mutex.RLock()
+	for _, post := range blog.posts {
+		post.mutex.RLock()
+		for _, comment := range post.Comments {
+			if len(spam) > 0 && comment.Metadata.IsSpam != (spam == "true") {
+				continue
+			}
+
+			comments = append(comments, newAPIModerationComment(post, comment))
+		}
+		post.mutex.RUnlock()
+	}
+	blog.mutex.RUnlock()
+
+	writeAPIJSON(w, req, http.StatusOK, comments)
+}
+
+// apiModerateComment marks a comment as spam or not spam.
+func apiModerateComment(w http.ResponseWriter, req *http.Request) {
+
+	post, filename, ok := apiCommentFromPath(w, req)
+
+	if !ok {
+		return
+	}
+
+	request := apiCommentRequest{}
+	err := decodeAPIRequest(req, &request)
+
+	if err == nil && request.Spam == nil {
+		err = errors.New("spam is required")
+	}
+
+	if err != nil {
+		writeAPIError(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	comment, wasSpam, err := post.SetCommentIsSpam(filename, *request.Spam)
+
+	// The comment may have been deleted since apiCommentFromPath found it.
+	if err != nil && err.Error() == couldNotFindCommentErrorMessage {
+		writeAPIError(w, req, http.StatusNotFound, "Comment not found")
+		return
+	}
+
+	if err != nil {
+		log.Println("Could not moderate comment:", err)
+		writeAPIError(w, req, http.StatusInternalServerError, "Could not update comment")
+		return
+	}
+
+	log.Println("Comment", filename, "on", post.Url, "moderated via API, spam:", comment.Metadata.IsSpam)
+
+	if wasSpam && !comment.Metadata.IsSpam {
+		notifyReplySubscribers(post, comment)
+	}
+
+	post.mutex.RLock()
+	moderated := newAPIModerationComment(post, comment)
+	post.mutex.RUnlock()
+
+	writeAPIJSON(w, req, http.StatusOK, moderated)
+}
+
+func apiDeleteComment(w http.ResponseWriter, req *http.Request) {
+
+	post, filename, ok := apiCommentFromPath(w, req)
+
+	if !ok {
+		return
+	}
+
+	err := post.DeleteComment(filename)
+
+	if err != nil {
+		log.Println("Could not delete comment:", err)
+		writeAPIError(w, req, http.StatusInternalServerError, "Could not delete comment")
+		return
+	}
+
+	log.Println("Comment", filename, "on", post.Url, "deleted via API")
+
+	setCORSHeaders(w, req)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiCommentFromPath finds the post and comment filename identified by the
+// URL, writing an error response if either can't be found.
+func apiCommentFromPath(w http.ResponseWriter, req *http.Request) (*BlogPost, string, bool) {
+	post, rest, err := postWithPath(req.URL.Path)
+
+	if err != nil {
+		writeAPIError(w, req, http.StatusNotFound, "Post not found")
+		return nil, "", false
+	}
+
+	filename := strings.TrimPrefix(rest, "comments/") + validFilenameExtension
+
+	if _, err := post.CommentWithFilename(filename); err != nil {
+		writeAPIError(w, req, http.StatusNotFound, "Comment not found")
+		return nil, "", false
+	}
+
+	return post, filename, true
+}
+
+func newAPIModerationComment(post *BlogPost, comment *Comment) apiModerationComment {
+	return apiModerationComment{
+		Id:      strings.TrimSuffix(comment.Filename, validFilenameExtension),
+		PostUrl: post.Url,
+		Author:  comment.Metadata.Author,
+		Email:   comment.Metadata.Email,
+		Date:    comment.Metadata.Date,
+		Spam:    comment.Metadata.IsSpam,
+		Source:  comment.Metadata.Source,
+		Body:    apiBody{strings.TrimLeft(comment.Body.Markdown, "\n"), comment.Body.HTML},
+	}
+}
+
+func decodeAPIPostRequest(req *http.Request) (*apiPostRequest, error) {
+	request := &apiPostRequest{}
+	err := decodeAPIRequest(req, request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// decodeAPIRequest decodes a JSON request body.  Unknown fields are rejected
+// so that misspelt metadata isn't silently ignored.
+func decodeAPIRequest(req *http.Request, value interface{}) error {
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		return errors.New("Content-Type must be application/json")
+	}
+
+	decoder := json.NewDecoder(io.LimitReader(req.Body, maxAPIRequestSize))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(value)
+
+	if err != nil {
+		return fmt.Errorf("Invalid JSON: %v", err)
+	}
+
+	return nil
+}
+
+// validateAPIPost checks that metadata can be written to a post file and read
+// back unchanged, that the post's URL isn't used by another post and that the
+// shortcodes in its body can be expanded.  filename is the name of the post
+// being replaced, if any.
+func validateAPIPost(metadata *BlogPostMetadata, filename, body string) error {
+	if len(strings.TrimSpace(metadata.Title)) == 0 {
+		return errors.New("metadata.title is required")
+	}
+
+	if strings.ContainsAny(metadata.Title, "\r\n") {
+		return errors.New("metadata.title must be a single line")
+	}
+
+	if metadata.Id < 0 {
+		return errors.New("metadata.id must not be negative")
+	}
+
+	if metadata.Date.Year() < 1 || metadata.Date.Year() > 9999 {
+		return errors.New("metadata.date is out of range")
+	}
+
+	for _, tag := range metadata.Tags {
+		if len(strings.TrimSpace(tag)) == 0 || strings.ContainsAny(tag, ",\r\n") {
+			return fmt.Errorf("Invalid tag %q", tag)
+		}
+	}
+
+	metadata.Markdown = strings.ToLower(metadata.Markdown)
+	options := markdownOptions{renderer: legacyMarkdown, extensions: metadata.Extensions}
+
+	if len(metadata.Markdown) > 0 {
+		options.renderer = metadata.Markdown
+	}
+
+	if err := validateMarkdownOptions(options); err != nil {
+		return fmt.Errorf("Invalid Markdown settings: %v", err)
+	}
+
+	// Dates are stored to the second, without a time zone.
+	metadata.Date = stringToTime(timeToString(metadata.Date))
+
+	post := &BlogPost{Metadata: *metadata, Filename: filename}
+	url := post.urlFromBlogPostProperties()
+
+	if len(post.slug()) == 0 {
+		return errors.New("metadata.title must contain letters or numbers")
+	}
+
+	if existing, err := blog.PostWithUrl(url); err == nil && existing.Filename != filename {
+		return fmt.Errorf("The URL %v is already used by %v", url, existing.Filename)
+	}
+
+	if metadata.Id != 0 {
+		if existing, err := blog.PostWithId(metadata.Id); err == nil && existing.Filename != filename {
+			return fmt.Errorf("The ID %v is already used by %v", metadata.Id, existing.Filename)
+		}
+	}
+
+	post.PostPath = blog.postPath
+
+	if _, problems := post.renderBody(body, 1, plainHeadings); len(problems) > 0 {
+		return fmt.Errorf("Shortcode error on %v", problems[0])
+	}
+
+	return nil
+}
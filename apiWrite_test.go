@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sendAPI(handler http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+
+	w := httptest.NewRecorder()
+	requireToken(handler)(w, req)
+
+	return w
+}
+
+func TestAPICreatePost(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	w := sendAPI(apiCreatePost, "POST", "/api/v1/posts", `{"metadata": {"title": "Release 1.0", "date": "2014-01-26T10:30:00Z", "tags": ["Releases"]}, "body": "Notes."}`)
+
+	if w.Code != http.StatusCreated {
+		t.Fatal("Incorrect status:", w.Code, w.Body.String())
+	}
+
+	post := apiPostWithComments{}
+	json.Unmarshal(w.Body.Bytes(), &post)
+
+	if post.Url != "2014/01/26/release-1-0" || w.Header().Get("Location") != "/api/v1/posts/2014/01/26/release-1-0" {
+		t.Error("Incorrect URL:", post.Url, w.Header().Get("Location"))
+	}
+
+	if _, err := blog.PostWithUrl(post.Url); err != nil {
+		t.Error("Post not added to blog:", err)
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(blog.postPath, "release-1-0.md"))
+	expected := "Title: Release 1.0\nDate: 2014-01-26 10:30:00\nTags: Releases\n\nNotes."
+
+	if string(content) != expected {
+		t.Errorf("Incorrect file:\n%q\nexpected:\n%q", content, expected)
+	}
+
+	// Reading the file back must give the same metadata.
+	parsed := map[string]string{}
+	parseBlogFileHeader(string(content), func(key, value string, raw interface{}) { parsed[key] = value })
+
+	if parsed["title"] != "Release 1.0" || parsed["date"] != "2014-01-26 10:30:00" || parsed["tags"] != "Releases" {
+		t.Error("Incorrect header:", parsed)
+	}
+
+	w = sendAPI(apiCreatePost, "POST", "/api/v1/posts", `{"metadata": {"title": "Release 1.0", "date": "2014-01-26T11:00:00Z"}, "body": "Again."}`)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Error("Duplicate URL accepted:", w.Code, w.Body.String())
+	}
+}
+
+func TestAPICreateInvalidPost(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	tests := map[string]int{
+		`{"metadata": {"title": ""}}`:                                     http.StatusUnprocessableEntity,
+		`{"metadata": {"title": "Two\nLines"}}`:                           http.StatusUnprocessableEntity,
+		`{"metadata": {"title": "???"}}`:                                  http.StatusUnprocessableEntity,
+		`{"metadata": {"title": "Tags", "tags": ["a,b"]}}`:                http.StatusUnprocessableEntity,
+		`{"metadata": {"title": "Id", "id": -1}}`:                         http.StatusUnprocessableEntity,
+		`{"metadata": {"title": "Name"}, "filename": "../x.md"}`:          http.StatusUnprocessableEntity,
+		`{"metadata": {"title": "Unknown", "excerpt": "x"}}`:              http.StatusBadRequest,
+		`{"metadata": {"title": "Date", "date": "yesterday"}}`:            http.StatusBadRequest,
+		`{"metadata": {"title": "Shortcode"}, "body": "{{< tweet 1 >}}"}`: http.StatusUnprocessableEntity,
+		`not json`: http.StatusBadRequest,
+	}
+
+	for body, status := range tests {
+		w := sendAPI(apiCreatePost, "POST", "/api/v1/posts", body)
+
+		if w.Code != status {
+			t.Error("Incorrect status for", body, w.Code, w.Body.String())
+		}
+	}
+
+	if len(blog.AllPosts()) != 0 {
+		t.Error("Invalid post created")
+	}
+}
+
+func TestAPIReplaceAndDeletePost(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	sendAPI(apiCreatePost, "POST", "/api/v1/posts", `{"filename": "release.md", "metadata": {"title": "Release 1.0", "date": "2014-01-26T10:30:00Z"}, "body": "Notes."}`)
+
+	w := sendAPI(apiReplacePost, "PUT", "/api/v1/posts/2014/01/26/release-1-0", `{"metadata": {"title": "Release 1.0.1"}, "body": "Fixed."}`)
+
+	if w.Code != http.StatusOK {
+		t.Fatal("Incorrect status:", w.Code, w.Body.String())
+	}
+
+	post, _ := blog.PostWithFilename("release.md")
+
+	if post.Url != "2014/01/26/release-1-0-1" || strings.TrimSpace(post.Body.Markdown) != "Fixed." {
+		t.Error("Post not replaced:", post.Url, post.Body.Markdown)
+	}
+
+	if !post.Metadata.Date.Equal(time.Date(2014, 1, 26, 10, 30, 0, 0, time.UTC)) {
+		t.Error("Date not kept:", post.Metadata.Date)
+	}
+
+	w = sendAPI(apiDeletePost, "DELETE", "/api/v1/posts/2014/01/26/release-1-0", "")
+
+	if w.Code != http.StatusNotFound {
+		t.Error("Deleted post at its old URL:", w.Code)
+	}
+
+	w = sendAPI(apiDeletePost, "DELETE", "/api/v1/posts/2014/01/26/release-1-0-1", "")
+
+	if w.Code != http.StatusNoContent || len(blog.AllPosts()) != 0 {
+		t.Error("Post not deleted:", w.Code)
+	}
+}
+
+func TestAPIModerateComments(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	sendAPI(apiCreatePost, "POST", "/api/v1/posts", `{"metadata": {"title": "Release 1.0", "date": "2014-01-26T10:30:00Z"}, "body": "Notes."}`)
+
+	post, _ := blog.PostWithUrl("2014/01/26/release-1-0")
+	comment := post.SaveComment("", "", "", "", "", "Joe", "joe@example.com", "Spam!", false)
+	id := strings.TrimSuffix(comment.Filename, ".md")
+
+	w := sendAPI(apiModerateComment, "PUT", "/api/v1/posts/2014/01/26/release-1-0/comments/"+id, `{"spam": true}`)
+
+	if w.Code != http.StatusOK || !comment.Metadata.IsSpam {
+		t.Error("Comment not marked as spam:", w.Code, w.Body.String())
+	}
+
+	comments := []apiModerationComment{}
+	w = sendAPI(apiModerationComments, "GET", "/api/v1/comments?spam=true", "")
+	json.Unmarshal(w.Body.Bytes(), &comments)
+
+	if len(comments) != 1 || comments[0].Id != id || comments[0].Email != "joe@example.com" {
+		t.Error("Incorrect spam comments:", comments)
+	}
+
+	w = sendAPI(apiDeleteComment, "DELETE", "/api/v1/posts/2014/01/26/release-1-0/comments/"+id, "")
+
+	if w.Code != http.StatusNoContent || len(post.Comments) != 0 {
+		t.Error("Comment not deleted:", w.Code, w.Body.String())
+	}
+
+	w = sendAPI(apiDeleteComment, "DELETE", "/api/v1/posts/2014/01/26/release-1-0/comments/"+id, "")
+
+	if w.Code != http.StatusNotFound {
+		t.Error("Missing comment found:", w.Code)
+	}
+
+	w = sendAPI(apiModerateComment, "PUT", "/api/v1/posts/2014/01/26/release-1-0/comments/"+id, `{"spam": false}`)
+
+	if w.Code != http.StatusNotFound {
+		t.Error("Missing comment moderated:", w.Code)
+	}
+
+	if _, err := os.Stat(filepath.Join(post.commentDirectory(), comment.Filename)); !os.IsNotExist(err) {
+		t.Error("Deleted comment was written back:", err)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func createAPITestBlog() {
+	SharedConfig = &Config{Address: "http://blog.example", PostsPerPage: 2, APIOrigins: []string{"http://embed.example"}}
+
+	posts := BlogPosts{}
+
+	for i, title := range []string{"Fourth", "Third", "Second", "First"} {
+		p := createPost()
+		p.Metadata.Title = title
+		p.Metadata.Date = time.Date(2014, 1, 4-i, 12, 0, 0, 0, time.UTC)
+		p.Url = p.urlFromBlogPostProperties()
+
+		if i%2 == 0 {
+			p.Metadata.Tags = []string{"even"}
+		}
+
+		posts = append(posts, p)
+	}
+
+	blog = &Blog{posts: posts, tags: NewTags()}
+	blog.tags.AddTags([]string{"even", "even"})
+}
+
+func getAPI(t *testing.T, handler http.HandlerFunc, target string, headers map[string]string, value interface{}) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", target, nil)
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	if value != nil && w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), value); err != nil {
+			t.Fatal("Invalid JSON:", err, w.Body.String())
+		}
+	}
+
+	return w
+}
+
+func TestAPIPosts(t *testing.T) {
+	createAPITestBlog()
+
+	list := apiPostList{}
+	getAPI(t, apiPosts, "/api/v1/posts", nil, &list)
+
+	if list.Total != 4 || list.PageCount != 2 || len(list.Posts) != 2 || list.Posts[0].Metadata.Title != "Fourth" {
+		t.Error("Incorrect first page:", list)
+	}
+
+	list = apiPostList{}
+	getAPI(t, apiPosts, "/api/v1/posts?page=2", nil, &list)
+
+	if len(list.Posts) != 2 || list.Posts[1].Metadata.Title != "First" || list.Posts[1].Url != "2014/01/01/first" {
+		t.Error("Incorrect second page:", list)
+	}
+
+	if list.Posts[1].CommentCount != 1 || list.Posts[1].Body.Markdown != "Test" {
+		t.Error("Incorrect post:", list.Posts[1])
+	}
+
+	list = apiPostList{}
+	getAPI(t, apiPosts, "/api/v1/posts?tag=even&from=2014-01-03&to=2014-01-04", nil, &list)
+
+	if list.Total != 1 || list.Posts[0].Metadata.Title != "Fourth" {
+		t.Error("Incorrect filtered posts:", list)
+	}
+
+	list = apiPostList{}
+	getAPI(t, apiPosts, "/api/v1/posts?search=second", nil, &list)
+
+	if list.Total != 1 || list.Posts[0].Metadata.Title != "Second" {
+		t.Error("Incorrect search results:", list)
+	}
+
+	for _, query := range []string{"page=0", "perPage=1000", "from=yesterday"} {
+		if w := getAPI(t, apiPosts, "/api/v1/posts?"+query, nil, nil); w.Code != http.StatusBadRequest {
+			t.Error("Invalid query accepted:", query, w.Code)
+		}
+	}
+}
+
+func TestAPIPostDetail(t *testing.T) {
+	createAPITestBlog()
+
+	post := apiPostWithComments{}
+	getAPI(t, apiPostDetail, "/api/v1/posts/2014/01/03/third?:year=2014&:month=01&:day=03&:title=third", nil, &post)
+
+	if post.Metadata.Title != "Third" || post.Permalink != "http://blog.example/posts/2014/01/03/third" {
+		t.Error("Incorrect post:", post)
+	}
+
+	if len(post.Comments) != 1 || post.Comments[0].Author != "Joe" {
+		t.Error("Incorrect comments:", post.Comments)
+	}
+
+	w := getAPI(t, apiPostDetail, "/api/v1/posts/2014/01/02/missing?:year=2014&:month=01&:day=02&:title=missing", nil, nil)
+
+	if w.Code != http.StatusNotFound {
+		t.Error("Missing post found:", w.Code)
+	}
+}
+
+func TestAPIETag(t *testing.T) {
+	createAPITestBlog()
+
+	w := getAPI(t, apiTags, "/api/v1/tags", nil, nil)
+	etag := w.Header().Get("ETag")
+
+	if len(etag) == 0 {
+		t.Fatal("No ETag")
+	}
+
+	w = getAPI(t, apiTags, "/api/v1/tags", map[string]string{"If-None-Match": etag}, nil)
+
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Error("Unchanged response was resent:", w.Code)
+	}
+
+	blog.tags.AddTags([]string{"new"})
+
+	w = getAPI(t, apiTags, "/api/v1/tags", map[string]string{"If-None-Match": etag}, nil)
+
+	if w.Code != http.StatusOK {
+		t.Error("Changed response was not sent:", w.Code)
+	}
+}
+
+func TestAPICORS(t *testing.T) {
+	createAPITestBlog()
+
+	w := getAPI(t, apiArchive, "/api/v1/archive", map[string]string{"Origin": "http://embed.example"}, nil)
+
+	if w.Header().Get("Access-Control-Allow-Origin") != "http://embed.example" {
+		t.Error("Allowed origin rejected:", w.Header())
+	}
+
+	w = getAPI(t, apiArchive, "/api/v1/archive", map[string]string{"Origin": "http://other.example"}, nil)
+
+	if len(w.Header().Get("Access-Control-Allow-Origin")) > 0 {
+		t.Error("Unknown origin allowed:", w.Header())
+	}
+
+	months := []apiArchiveMonth{}
+	json.Unmarshal(w.Body.Bytes(), &months)
+
+	if len(months) != 1 || len(months[0].Posts) != 4 {
+		t.Error("Incorrect archive:", months)
+	}
+}
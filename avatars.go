@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"github.com/ant512/gobble/identicon"
+	"html"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const gravatarAvatars = "gravatar"
+const identiconAvatars = "identicon"
+const avatarSize = 80
+
+var avatarFilenamePattern = regexp.MustCompile(`^[0-9a-f]{32}\.(png|svg)$`)
+
+// AvatarUrl returns the URL of the commenter's avatar, or an empty string if
+// avatars are disabled.
+func (c *Comment) AvatarUrl() string {
+	return avatarUrl(html.UnescapeString(c.Metadata.Email))
+}
+
+func avatarUrl(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	switch SharedConfig.Avatars {
+	case gravatarAvatars:
+		return fmt.Sprintf("https://www.gravatar.com/avatar/%x?s=%d&d=identicon", md5.Sum([]byte(email)), avatarSize)
+	case identiconAvatars:
+		return "/avatars/" + avatarHash(email) + "." + SharedConfig.AvatarFormat
+	default:
+		return ""
+	}
+}
+
+// avatarHash identifies a commenter's identicon.  It is keyed with the secret
+// key so that the email address can't be recovered by hashing guesses.
+func avatarHash(email string) string {
+	return sign("avatar", strings.ToLower(strings.TrimSpace(email)))[:32]
+}
+
+func isKnownAvatarHash(hash string) bool {
+	for _, post := range blog.AllPosts() {
+		if post.hasCommentWithAvatarHash(hash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *BlogPost) hasCommentWithAvatarHash(hash string) bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for _, comment := range b.Comments {
+		if avatarHash(html.UnescapeString(comment.Metadata.Email)) == hash {
+			return true
+		}
+	}
+
+	return false
+}
+
+func avatar(w http.ResponseWriter, req *http.Request) {
+
+	filename := req.URL.Query().Get(":hash")
+
+	if SharedConfig.Avatars != identiconAvatars || !avatarFilenamePattern.MatchString(filename) {
+		http.NotFound(w, req)
+		return
+	}
+
+	path := filepath.Join(SharedConfig.AvatarPath, filename)
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		hash := filename[:32]
+
+		// Only commenters get avatars; otherwise anyone could fill the disk
+		// with identicons for made-up hashes.
+		if !isKnownAvatarHash(hash) {
+			http.NotFound(w, req)
+			return
+		}
+
+		err = createIdenticon(path, hash, filepath.Ext(filename))
+
+		if err != nil {
+			log.Println("Could not create avatar:", err)
+			http.Error(w, "Could not create avatar", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=604800")
+	http.ServeFile(w, req, path)
+}
+
+func createIdenticon(path, hash, extension string) error {
+	bytes, err := hex.DecodeString(hash)
+
+	if err != nil {
+		return err
+	}
+
+	icon, err := identicon.New(bytes)
+
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+
+	if extension == ".png" {
+		data, err = icon.PNG(avatarSize)
+
+		if err != nil {
+			return err
+		}
+	} else {
+		data = icon.SVG(avatarSize)
+	}
+
+	os.MkdirAll(filepath.Dir(path), 0775)
+
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAvatarUrl(t *testing.T) {
+	SharedConfig = &Config{SecretKey: "secret", AvatarFormat: "svg"}
+
+	if url := avatarUrl("joe@example.com"); url != "" {
+		t.Error("Avatar URL returned when avatars are disabled:", url)
+	}
+
+	SharedConfig.Avatars = gravatarAvatars
+
+	if url := avatarUrl(" Joe@Example.com "); !strings.Contains(url, "/avatar/f5b8fb60c6116331da07c65b96a8a1d1?") {
+		t.Error("Incorrect Gravatar URL:", url)
+	}
+
+	SharedConfig.Avatars = identiconAvatars
+
+	url := avatarUrl("joe@example.com")
+
+	if !avatarFilenamePattern.MatchString(strings.TrimPrefix(url, "/avatars/")) {
+		t.Error("Incorrect identicon URL:", url)
+	}
+
+	if url != avatarUrl("JOE@example.com") {
+		t.Error("Identicon URL is case sensitive")
+	}
+
+	SharedConfig.SecretKey = "other"
+
+	if url == avatarUrl("joe@example.com") {
+		t.Error("Identicon URL is not keyed")
+	}
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const backupManifestName = "manifest.json"
+const backupConfigName = "gobble.conf"
+const backupVersion = 1
+
+// backupManifest is written to every backup.  It lists the files in the
+// archive with their checksums, so that restore can tell whether the archive
+// is complete and undamaged before unpacking anything.
+type backupManifest struct {
+	Version int          `json:"version"`
+	Created time.Time    `json:"created"`
+	Name    string       `json:"name"`
+	Files   []backupFile `json:"files"`
+}
+
+type backupFile struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256"`
+	Modified time.Time `json:"modified"`
+}
+
+// backupRoot is a directory or file included in backups, and the name it is
+// stored under in the archive.
+type backupRoot struct {
+	name string
+	path string
+}
+
+// archiveWriter hides the differences between tar.gz and zip archives.
+type archiveWriter interface {
+	Create(name string, size int64, modified time.Time) (io.Writer, error)
+	Close() error
+}
+
+type tarArchiveWriter struct {
+	gzip *gzip.Writer
+	tar  *tar.Writer
+}
+
+type zipArchiveWriter struct {
+	zip *zip.Writer
+}
+
+func backupRoots(config *Config) []backupRoot {
+	return []backupRoot{
+		{"posts", config.PostPath},
+		{"comments", config.CommentPath},
+		{"media", config.MediaPath},
+		{"files", config.StaticFilePath},
+		{path.Join("themes", config.Theme), config.FullThemePath()},
+		{"activitypub", config.ActivityPubPath},
+		{urlHistoryBackupName, config.URLHistoryFile},
+		{redirectFileBackupName, config.RedirectFile},
+		{redirectHitsBackupName, config.RedirectHitsFile},
+	}
+}
+
+func backupCommand(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := flags.String("out", "", "archive to create, ending in .tar.gz or .zip (defaults to a dated .tar.gz)")
+	parseCommandFlags(flags, args)
+
+	if len(*out) == 0 {
+		*out = "gobble-backup-" + strings.TrimSuffix(timeToFilename(time.Now()), validFilenameExtension) + ".tar.gz"
+	}
+
+	manifest, err := writeBackup(*out)
+
+	if err != nil {
+		os.Remove(*out)
+		return err
+	}
+
+	fmt.Fprintf(commandOutput, "Backed up %v files to %v\n", len(manifest.Files), *out)
+
+	return nil
+}
+
+// writeBackup archives the config file and every file in the backup roots.
+// The archive is a zip file if the filename ends in ".zip", and a tar.gz file
+// otherwise.
+func writeBackup(filename string) (*backupManifest, error) {
+	file, err := os.Create(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var archive archiveWriter
+
+	if strings.HasSuffix(strings.ToLower(filename), ".zip") {
+		archive = &zipArchiveWriter{zip.NewWriter(file)}
+	} else {
+		gz := gzip.NewWriter(file)
+		archive = &tarArchiveWriter{gz, tar.NewWriter(gz)}
+	}
+
+	manifest := &backupManifest{Version: backupVersion, Created: time.Now().UTC(), Name: SharedConfig.Name}
+
+	err = addBackupFile(archive, manifest, configFilename, backupConfigName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	output, _ := filepath.Abs(filename)
+
+	for _, root := range backupRoots(SharedConfig) {
+		if _, err := os.Stat(root.path); os.IsNotExist(err) {
+			continue
+		}
+
+		err = filepath.Walk(root.path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// Don't back up the backup if it is being written inside the blog.
+			if abs, _ := filepath.Abs(file); !info.Mode().IsRegular() || abs == output {
+				return nil
+			}
+
+			relative, err := filepath.Rel(root.path, file)
+
+			if err != nil {
+				return err
+			}
+
+			return addBackupFile(archive, manifest, file, path.Join(root.name, filepath.ToSlash(relative)))
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := archive.Create(backupManifestName, int64(len(content)), manifest.Created)
+
+	if err == nil {
+		_, err = w.Write(content)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, archive.Close()
+}
+
+func addBackupFile(archive archiveWriter, manifest *backupManifest, source, name string) error {
+	info, err := os.Stat(source)
+
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(source)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	w, err := archive.Create(name, info.Size(), info.ModTime())
+
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(w, hash), in)
+
+	if err != nil {
+		return err
+	}
+
+	if size != info.Size() {
+		return fmt.Errorf("%v changed while it was being backed up", source)
+	}
+
+	manifest.Files = append(manifest.Files, backupFile{
+		Path:     name,
+		Size:     size,
+		SHA256:   hex.EncodeToString(hash.Sum(nil)),
+		Modified: info.ModTime().UTC(),
+	})
+
+	return nil
+}
+
+func restoreCommand(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	force := flags.Bool("force", false, "overwrite existing files")
+	positional := parseCommandFlags(flags, args)
+
+	if len(positional) != 1 {
+		return errors.New("A single archive is required, for example: gobble restore gobble-backup.tar.gz")
+	}
+
+	manifest, config, err := verifyBackup(positional[0])
+
+	if err != nil {
+		return fmt.Errorf("%v is not a valid backup: %v", positional[0], err)
+	}
+
+	fmt.Fprintf(commandOutput, "Verified %v files backed up from %v on %v\n", len(manifest.Files), manifest.Name, timeToString(manifest.Created))
+
+	roots := backupRoots(config)
+
+	modified := map[string]time.Time{}
+	targets := map[string]bool{configFilename: true}
+
+	for _, file := range manifest.Files {
+		modified[file.Path] = file.Modified
+
+		for _, root := range roots {
+			if strings.HasPrefix(file.Path, root.name+"/") || file.Path == root.name {
+				targets[root.path] = true
+			}
+		}
+	}
+
+	if !*force {
+		for _, existing := range sortedKeys(targets) {
+			if !isEmptyPath(existing) {
+				return fmt.Errorf("%v already exists; use -force to overwrite it", existing)
+			}
+		}
+	}
+
+	err = readArchive(positional[0], func(name string, r io.Reader) error {
+		if name == backupManifestName {
+			return nil
+		}
+
+		target := configFilename
+
+		if name != backupConfigName {
+			target = restoredPath(roots, name)
+		}
+
+		return restoreFile(target, r, modified[name])
+	})
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(commandOutput, "Restored %v files\n", len(manifest.Files))
+
+	return nil
+}
+
+// verifyBackup checks that every file in an archive is listed in its manifest
+// with the right checksum, and that every file in the manifest is present.  It
+// returns the manifest and the config stored in the archive.
+func verifyBackup(filename string) (*backupManifest, *Config, error) {
+	var manifest *backupManifest
+	var configContent []byte
+
+	checksums := map[string]string{}
+	sizes := map[string]int64{}
+
+	err := readArchive(filename, func(name string, r io.Reader) error {
+		if _, exists := checksums[name]; exists {
+			return fmt.Errorf("%v appears more than once", name)
+		}
+
+		if name == backupManifestName {
+			manifest = &backupManifest{}
+			return json.NewDecoder(r).Decode(manifest)
+		}
+
+		hash := sha256.New()
+		w := io.Writer(hash)
+		buffer := &bytes.Buffer{}
+
+		if name == backupConfigName {
+			w = io.MultiWriter(hash, buffer)
+		}
+
+		size, err := io.Copy(w, r)
+
+		if err != nil {
+			return err
+		}
+
+		checksums[name] = hex.EncodeToString(hash.Sum(nil))
+		sizes[name] = size
+
+		if name == backupConfigName {
+			configContent = buffer.Bytes()
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if manifest == nil {
+		return nil, nil, errors.New("the manifest is missing")
+	}
+
+	if manifest.Version > backupVersion {
+		return nil, nil, fmt.Errorf("it was made by a newer version of Gobble (backup version %v)", manifest.Version)
+	}
+
+	listed := map[string]bool{}
+
+	for _, file := range manifest.Files {
+		listed[file.Path] = true
+
+		checksum, exists := checksums[file.Path]
+
+		if !exists {
+			return nil, nil, fmt.Errorf("%v is missing", file.Path)
+		}
+
+		if checksum != file.SHA256 || sizes[file.Path] != file.Size {
+			return nil, nil, fmt.Errorf("%v is damaged (checksum does not match)", file.Path)
+		}
+	}
+
+	for name := range checksums {
+		if !listed[name] {
+			return nil, nil, fmt.Errorf("%v is not listed in the manifest", name)
+		}
+	}
+
+	if configContent == nil {
+		return nil, nil, errors.New("the config file is missing")
+	}
+
+	config := new(Config)
+	config.setDefaults()
+
+	err = json.Unmarshal(configContent, config)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("the config file could not be parsed: %v", err)
+	}
+
+	roots := backupRoots(config)
+
+	for name := range checksums {
+		if name != backupConfigName && len(restoredPath(roots, name)) == 0 {
+			return nil, nil, fmt.Errorf("%v is outside of the blog's directories", name)
+		}
+	}
+
+	return manifest, config, nil
+}
+
+// readArchive calls read with the name and content of each file in a tar.gz
+// or zip archive.  Names that could escape the directory being restored to
+// are rejected.
+func readArchive(filename string, read func(name string, r io.Reader) error) error {
+	file, err := os.Open(filename)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	magic, _ := bufio.NewReader(file).Peek(4)
+	file.Seek(0, io.SeekStart)
+
+	if bytes.Equal(magic, []byte("PK\x03\x04")) {
+		info, err := file.Stat()
+
+		if err != nil {
+			return err
+		}
+
+		archive, err := zip.NewReader(file, info.Size())
+
+		if err != nil {
+			return err
+		}
+
+		for _, f := range archive.File {
+			if strings.HasSuffix(f.Name, "/") {
+				continue
+			}
+
+			err = readArchiveFile(f.Name, f.Open, read)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	gz, err := gzip.NewReader(file)
+
+	if err != nil {
+		return err
+	}
+
+	archive := tar.NewReader(gz)
+
+	for {
+		header, err := archive.Next()
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if header.Typeflag == tar.TypeDir {
+			continue
+		} else if header.Typeflag != tar.TypeReg {
+			return fmt.Errorf("%v is not a regular file", header.Name)
+		}
+
+		err = readArchiveFile(header.Name, func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(archive), nil
+		}, read)
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func readArchiveFile(name string, open func() (io.ReadCloser, error), read func(name string, r io.Reader) error) error {
+	if path.IsAbs(name) || path.Clean(name) != name || name == ".." || strings.HasPrefix(name, "../") || strings.Contains(name, "\\") {
+		return fmt.Errorf("%v is not a valid filename", name)
+	}
+
+	r, err := open()
+
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	return read(name, r)
+}
+
+// restoredPath returns the location that a file in an archive is restored to,
+// or an empty string if it isn't in any of the backup roots.
+func restoredPath(roots []backupRoot, name string) string {
+	for _, root := range roots {
+		if name == root.name {
+			return root.path
+		}
+
+		if strings.HasPrefix(name, root.name+"/") {
+			return filepath.Join(root.path, filepath.FromSlash(strings.TrimPrefix(name, root.name+"/")))
+		}
+	}
+
+	return ""
+}
+
+func restoreFile(target string, r io.Reader, modified time.Time) error {
+	err := os.MkdirAll(filepath.Dir(target), 0775)
+
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(target)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, r)
+	out.Close()
+
+	if err != nil || modified.IsZero() {
+		return err
+	}
+
+	return os.Chtimes(target, modified, modified)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := []string{}
+
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// isEmptyPath returns true if nothing exists at a path, or if it is an empty
+// directory.
+func isEmptyPath(name string) bool {
+	info, err := os.Stat(name)
+
+	if os.IsNotExist(err) {
+		return true
+	} else if err != nil || !info.IsDir() {
+		return false
+	}
+
+	files, err := ioutil.ReadDir(name)
+
+	return err == nil && len(files) == 0
+}
+
+func (a *tarArchiveWriter) Create(name string, size int64, modified time.Time) (io.Writer, error) {
+	header := &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  modified,
+		Typeflag: tar.TypeReg,
+	}
+
+	return a.tar, a.tar.WriteHeader(header)
+}
+
+func (a *tarArchiveWriter) Close() error {
+	err := a.tar.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return a.gzip.Close()
+}
+
+func (a *zipArchiveWriter) Create(name string, size int64, modified time.Time) (io.Writer, error) {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified}
+
+	return a.zip.CreateHeader(header)
+}
+
+func (a *zipArchiveWriter) Close() error {
+	return a.zip.Close()
+}
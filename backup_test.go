@@ -0,0 +1,212 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createBackupTestBlog(t *testing.T) string {
+	dir, _ := createCommandTestBlog(t)
+
+	SharedConfig.Name = "Test Blog"
+	SharedConfig.StaticFilePath = filepath.Join(dir, "files")
+	SharedConfig.ThemePath = filepath.Join(dir, "themes")
+	SharedConfig.Theme = "grump"
+	SharedConfig.ActivityPubPath = filepath.Join(dir, "activitypub")
+	configFilename = filepath.Join(dir, "gobble.conf")
+
+	config, _ := json.Marshal(map[string]string{
+		"name":           SharedConfig.Name,
+		"postPath":       SharedConfig.PostPath,
+		"commentPath":    SharedConfig.CommentPath,
+		"mediaPath":      SharedConfig.MediaPath,
+		"staticFilePath": SharedConfig.StaticFilePath,
+		"themePath":      SharedConfig.ThemePath,
+		"theme":          SharedConfig.Theme,
+	})
+
+	writeTestFiles(t, dir, map[string]string{
+		"gobble.conf":                   string(config),
+		"posts/hello.md":                "Title: Hello\nDate: 2014-01-26 10:30:00\n\nHello",
+		"comments/hello/1.md":           "Author: Ann\nEmail: ann@example.com\nDate: 2014-01-27 10:30:00\n\nHi",
+		"media/2014/01/photo.jpg":       "jpeg",
+		"files/robots.txt":              "User-agent: *",
+		"themes/grump/templates/x.html": "{{.}}",
+	})
+
+	return dir
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	for _, name := range []string{"backup.tar.gz", "backup.zip"} {
+		dir := createBackupTestBlog(t)
+		archive := filepath.Join(dir, name)
+
+		err := backupCommand([]string{"-out", archive})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		manifest, _, err := verifyBackup(archive)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The config, five blog files and nothing else.
+		if len(manifest.Files) != 6 || manifest.Name != "Test Blog" {
+			t.Errorf("Unexpected manifest for %v: %+v", name, manifest)
+		}
+
+		if err := restoreCommand([]string{archive}); err == nil || !strings.Contains(err.Error(), "-force") {
+			t.Errorf("Restoring over an existing blog was allowed: %v", err)
+		}
+
+		for _, path := range []string{"gobble.conf", "posts", "comments", "media", "files", "themes"} {
+			os.RemoveAll(filepath.Join(dir, path))
+		}
+
+		err = restoreCommand([]string{archive})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for file, expected := range map[string]string{
+			"posts/hello.md":                "Title: Hello\nDate: 2014-01-26 10:30:00\n\nHello",
+			"comments/hello/1.md":           "Author: Ann\nEmail: ann@example.com\nDate: 2014-01-27 10:30:00\n\nHi",
+			"media/2014/01/photo.jpg":       "jpeg",
+			"themes/grump/templates/x.html": "{{.}}",
+		} {
+			content, _ := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+
+			if string(content) != expected {
+				t.Errorf("%v restored as %q from %v", file, string(content), name)
+			}
+		}
+
+		if _, err := os.Stat(configFilename); err != nil {
+			t.Errorf("Config was not restored from %v", name)
+		}
+
+		if err := restoreCommand([]string{archive, "-force"}); err != nil {
+			t.Errorf("Could not force restore from %v: %v", name, err)
+		}
+
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRestoreRejectsDamagedBackups(t *testing.T) {
+	dir := createBackupTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "backup.tar.gz")
+
+	if _, err := writeBackup(archive); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]func(name string, content string) (string, string){
+		"damaged": func(name, content string) (string, string) {
+			if name == "posts/hello.md" {
+				content = strings.Replace(content, "Hello", "Jello", -1)
+			}
+
+			return name, content
+		},
+		"missing": func(name, content string) (string, string) {
+			if name == "media/2014/01/photo.jpg" {
+				return "", ""
+			}
+
+			return name, content
+		},
+		"outside of the blog": func(name, content string) (string, string) {
+			if name == "posts/hello.md" {
+				name = "../hello.md"
+			}
+
+			return name, content
+		},
+	}
+
+	for expected, change := range tests {
+		damaged := filepath.Join(dir, "damaged.tar.gz")
+		file, _ := os.Create(damaged)
+		gz := gzip.NewWriter(file)
+		out := &tarArchiveWriter{gz, tar.NewWriter(gz)}
+
+		readArchive(archive, func(name string, r io.Reader) error {
+			content, _ := ioutil.ReadAll(r)
+
+			if name, text := change(name, string(content)); len(name) > 0 {
+				w, _ := out.Create(name, int64(len(text)), time.Now())
+				io.WriteString(w, text)
+			}
+
+			return nil
+		})
+
+		out.Close()
+		file.Close()
+
+		err := restoreCommand([]string{damaged, "-force"})
+
+		if err == nil {
+			t.Errorf("Backup that was %v was restored", expected)
+		} else if expected != "outside of the blog" && !strings.Contains(err.Error(), expected) {
+			t.Errorf("Unexpected error for a backup that was %v: %v", expected, err)
+		}
+	}
+}
+
+func TestJSONExport(t *testing.T) {
+	dir := createBackupTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	writeTestFiles(t, dir, map[string]string{
+		"posts/later.md": "Title: Later\nDate: 2014-02-01 10:30:00\nDraft: true\n\nLater",
+	})
+
+	output := filepath.Join(dir, "export.json")
+	err := jsonExportCommand([]string{"-out", output})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := ioutil.ReadFile(output)
+	var export jsonExport
+
+	if err := json.Unmarshal(content, &export); err != nil {
+		t.Fatal(err)
+	}
+
+	if export.Version != jsonExportVersion || export.Blog.Name != "Test Blog" || len(export.Posts) != 2 {
+		t.Fatalf("Unexpected export %s", content)
+	}
+
+	if post := export.Posts[0]; post.Filename != "later.md" || !post.Metadata.Draft {
+		t.Errorf("Drafts were not exported first: %+v", post)
+	}
+
+	post := export.Posts[1]
+
+	if post.Url != "2014/01/26/hello" || post.Body.Markdown != "Hello" || !strings.Contains(post.Body.HTML, "<p>Hello</p>") {
+		t.Errorf("Unexpected post %+v", post)
+	}
+
+	if len(post.Comments) != 1 || post.Comments[0].Email != "ann@example.com" || post.Comments[0].Body.Markdown != "Hi" {
+		t.Errorf("Unexpected comments %+v", post.Comments)
+	}
+}
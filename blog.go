@@ -2,13 +2,17 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"gopkg.in/fsnotify.v1"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
+	"time"
+	"unicode"
 )
 
 type Blog struct {
@@ -17,6 +21,16 @@ type Blog struct {
 	posts       BlogPosts
 	tags        Tags
 	mutex       sync.RWMutex
+
+	// urlHistory maps every URL that a post has had, as a path such as
+	// "/posts/2014/01/26/my-post", to the post's filename.  It is only kept
+	// once LoadUrlHistory has been called.
+	urlHistory     map[string]string
+	urlHistoryFile string
+
+	// publishHandlers are called whenever a post is added or changed after
+	// the blog has loaded.
+	publishHandlers []func(post *BlogPost)
 }
 
 func LoadBlog(postPath, commentPath string, disableWatcher bool) (*Blog, error) {
@@ -44,6 +58,19 @@ func (b *Blog) AllTags() map[string]int {
 	return b.tags.AllTags()
 }
 
+// AllTagNames returns the names of all tags in alphabetical order.
+func (b *Blog) AllTagNames() []string {
+	names := []string{}
+
+	for tag := range b.AllTags() {
+		names = append(names, tag)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
 func (b *Blog) PostWithUrl(url string) (*BlogPost, error) {
 	b.mutex.RLock()
 	defer b.mutex.RUnlock()
@@ -51,6 +78,13 @@ func (b *Blog) PostWithUrl(url string) (*BlogPost, error) {
 	return b.posts.PostWithUrl(url)
 }
 
+func (b *Blog) PostWithFilename(filename string) (*BlogPost, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return b.posts.PostWithFilename(filename)
+}
+
 func (b *Blog) PostWithId(id int) (*BlogPost, error) {
 	b.mutex.RLock()
 	defer b.mutex.RUnlock()
@@ -72,6 +106,91 @@ func (b *Blog) SearchPosts(term string, start int, count int) (BlogPosts, int) {
 	return b.posts.FilteredPosts(term, start, count)
 }
 
+// WritePost saves a post to the post directory, replacing any existing post
+// with the same filename.  The post is added to the blog immediately rather
+// than waiting for the filesystem watcher to notice it.
+func (b *Blog) WritePost(filename string, metadata BlogPostMetadata, body string) (*BlogPost, error) {
+	if !isValidBlogPostFilename(filename) {
+		return nil, errors.New("Invalid post filename: " + filename)
+	}
+
+	post := &BlogPost{Metadata: metadata, Body: BlogItemBody{Markdown: body}}
+
+	err := ioutil.WriteFile(filepath.Join(b.postPath, filename), []byte(post.String()), 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Drafts aren't part of the blog, so are loaded straight from the file.
+	if metadata.Draft {
+		if _, err := b.PostWithFilename(filename); err == nil {
+			b.removeBlogPost(filename)
+		}
+
+		return LoadPost(filename, b.postPath, b.commentPath)
+	}
+
+	if _, err := b.PostWithFilename(filename); err == nil {
+		err = b.reloadBlogPost(filename)
+	} else {
+		err = b.addBlogPost(filename)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return b.PostWithFilename(filename)
+}
+
+// DeletePost removes a post's file and removes it from the blog.  Its comments
+// are left on disk.
+func (b *Blog) DeletePost(filename string) error {
+	if _, err := b.PostWithFilename(filename); err != nil {
+		return err
+	}
+
+	err := os.Remove(filepath.Join(b.postPath, filename))
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// The watcher may already have removed the post.
+	b.removeBlogPost(filename)
+
+	return nil
+}
+
+// NewPostFilename returns an unused filename for a new post, based on its
+// title.
+func (b *Blog) NewPostFilename(title string, date time.Time) string {
+	name := filenameFromTitle(title)
+
+	if len(name) == 0 {
+		name = strings.TrimSuffix(timeToFilename(date), validFilenameExtension)
+	}
+
+	filename := name + validFilenameExtension
+
+	for i := 2; b.postFileExists(filename); i++ {
+		filename = fmt.Sprintf("%s-%d%s", name, i, validFilenameExtension)
+	}
+
+	return filename
+}
+
+func (b *Blog) postFileExists(filename string) bool {
+	if _, err := b.PostWithFilename(filename); err == nil {
+		return true
+	}
+
+	_, err := os.Stat(filepath.Join(b.postPath, filename))
+
+	return err == nil
+}
+
 func (b *Blog) loadBlogPosts() error {
 	files, err := ioutil.ReadDir(b.postPath)
 
@@ -93,12 +212,20 @@ func (b *Blog) loadBlogPosts() error {
 			return err
 		}
 
+		if post.Metadata.Draft {
+			continue
+		}
+
 		posts = append(posts, post)
 		tags = append(tags, post.Metadata.Tags[:]...)
 	}
 
 	sort.Sort(posts)
 
+	for _, problem := range duplicateUrls(posts) {
+		log.Println(problem)
+	}
+
 	b.mutex.Lock()
 	b.posts = posts
 	b.tags.AddTags(tags)
@@ -194,7 +321,20 @@ func (b *Blog) addBlogPost(filename string) error {
 		return err
 	}
 
+	if post.Metadata.Draft {
+		log.Println("Post is a draft")
+		return nil
+	}
+
 	b.mutex.Lock()
+
+	// The post may already have been loaded by WritePost, in which case the
+	// filesystem watcher's create event is just a reload.
+	if _, err := b.posts.PostWithFilename(filename); err == nil {
+		b.mutex.Unlock()
+		return b.reloadBlogPost(filename)
+	}
+
 	posts := b.posts
 	posts = append(posts, post)
 	sort.Sort(posts)
@@ -205,6 +345,9 @@ func (b *Blog) addBlogPost(filename string) error {
 
 	log.Println("Post added")
 
+	b.postUrlsChanged(post)
+	b.postPublished(post)
+
 	return nil
 }
 
@@ -227,6 +370,18 @@ func (b *Blog) reloadBlogPost(filename string) error {
 		return err
 	}
 
+	// A post that has become a draft is unpublished, and a draft that has
+	// been published is new to the blog.
+	if post.Metadata.Draft {
+		if _, err := b.PostWithFilename(filename); err == nil {
+			return b.removeBlogPost(filename)
+		}
+
+		return nil
+	} else if _, err := b.PostWithFilename(filename); err != nil {
+		return b.addBlogPost(filename)
+	}
+
 	var removed *BlogPost = nil
 
 	b.mutex.Lock()
@@ -250,9 +405,44 @@ func (b *Blog) reloadBlogPost(filename string) error {
 
 	b.mutex.Unlock()
 
+	if err == nil {
+		b.postUrlsChanged(post)
+		b.postPublished(post)
+	}
+
 	return err
 }
 
+// OnPublish adds a function to be called whenever a post is added or changed
+// after the blog has loaded.
+func (b *Blog) OnPublish(handler func(post *BlogPost)) {
+	b.publishHandlers = append(b.publishHandlers, handler)
+}
+
+func (b *Blog) postPublished(post *BlogPost) {
+	for _, handler := range b.publishHandlers {
+		handler(post)
+	}
+}
+
+func isValidBlogPostFilename(filename string) bool {
+	return filename == filepath.Base(filename) && filepath.Ext(filename) == validFilenameExtension && filename != validFilenameExtension
+}
+
+// filenameFromTitle converts a title into a filename-safe lowercase string of
+// letters, digits and hyphens.
+func filenameFromTitle(title string) string {
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	if len(words) > 8 {
+		words = words[:8]
+	}
+
+	return strings.Join(words, "-")
+}
+
 func isValidBlogPostFile(fileInfo os.FileInfo) bool {
 	if fileInfo.IsDir() {
 		return false
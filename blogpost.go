@@ -1,13 +1,15 @@
 package main
 
 import (
-	"fmt"
+	"errors"
 	"github.com/ant512/gobble/akismet"
+	"gopkg.in/yaml.v3"
 	"html"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,16 +17,30 @@ import (
 )
 
 type BlogPostMetadata struct {
-	Title            string
-	Id               int
-	Date             time.Time
-	Tags             []string
-	DisallowComments bool
+	Title            string    `json:"title"`
+	Id               int       `json:"id,omitempty"`
+	Date             time.Time `json:"date"`
+	Tags             []string  `json:"tags"`
+	DisallowComments bool      `json:"disallowComments"`
+	Draft            bool      `json:"draft,omitempty"`
+	Slug             string    `json:"slug,omitempty"`
+	Aliases          []string  `json:"aliases,omitempty"`
+	Summary          string    `json:"summary,omitempty"`
+	TOC              *bool     `json:"toc,omitempty"`
+	Markdown         string    `json:"markdown,omitempty"`
+	Extensions       []string  `json:"extensions,omitempty"`
+
+	// Params holds any other keys from a post's header or front matter, so
+	// that themes can use values that Gobble itself doesn't know about.
+	Params map[string]interface{} `json:"params,omitempty"`
 }
 
 type BlogPost struct {
 	Metadata     BlogPostMetadata
 	Body         BlogItemBody
+	Summary      string
+	HasMore      bool
+	TOC          []*TOCEntry
 	Comments     Comments
 	PostPath     string
 	CommentPath  string
@@ -45,7 +61,7 @@ func LoadPost(filename, postPath, commentPath string) (*BlogPost, error) {
 
 	err := loadBlogFile(fullPath, func(fileInfo os.FileInfo) {
 		b.ModifiedDate = fileInfo.ModTime()
-	}, func(key, value string) {
+	}, func(key, value string, raw interface{}) {
 		switch key {
 		case "title":
 			b.Metadata.Title = value
@@ -74,17 +90,54 @@ func LoadPost(filename, postPath, commentPath string) (*BlogPost, error) {
 			b.Metadata.Date = stringToTime(value)
 		case "disallowcomments":
 			b.Metadata.DisallowComments = value == "true"
+		case "draft":
+			b.Metadata.Draft = value == "true"
+		case "slug":
+			b.Metadata.Slug = value
+		case "summary":
+			b.Metadata.Summary = value
+		case "toc":
+			toc := value == "true"
+			b.Metadata.TOC = &toc
+		case "markdown":
+			b.Metadata.Markdown = strings.ToLower(value)
+		case "extensions":
+			b.Metadata.Extensions = parseMarkdownExtensions(value)
+		case "aliases":
+			b.Metadata.Aliases = []string{}
+
+			for _, alias := range strings.Split(value, ",") {
+				if alias = strings.TrimSpace(alias); len(alias) > 0 {
+					b.Metadata.Aliases = append(b.Metadata.Aliases, alias)
+				}
+			}
 		default:
+			if b.Metadata.Params == nil {
+				b.Metadata.Params = map[string]interface{}{}
+			}
+
+			// Keys from the "Key: value" header are kept as strings.
+			if raw == nil {
+				b.Metadata.Params[key] = value
+			} else {
+				b.Metadata.Params[key] = raw
+			}
 		}
-	}, func(value string) {
-		bytes := []byte(value)
+	}, func(value string, line int) {
+		var problems []*ShortcodeError
 
 		b.Body.Markdown = value
-		b.Body.HTML = convertMarkdownToHtml(&bytes)
+		b.Body.HTML, problems = b.renderBody(value, line, b.headingStyle())
+		b.TOC = tableOfContents(b.Body.HTML)
+
+		for _, err := range problems {
+			log.Printf("%v:%v: %v", fullPath, err.Line, err.Message)
+		}
 	})
 
 	if err == nil {
 		b.Url = b.urlFromBlogPostProperties()
+		b.summarise()
 		b.loadComments()
 	} else {
 		log.Println(err)
@@ -143,6 +196,11 @@ func (b *BlogPost) ContainsTerm(term string) bool {
 	return true
 }
 
+// Permalink returns the absolute URL of the post.
+func (b *BlogPost) Permalink() string {
+	return SharedConfig.Address + "/posts/" + b.Url
+}
+
 func (b *BlogPost) AllowsComments() bool {
 	if b.Metadata.DisallowComments {
 		return false
@@ -157,49 +215,312 @@ func (b *BlogPost) AllowsComments() bool {
 	return time.Now().Before(closeDate)
 }
 
-func (b *BlogPost) SaveComment(akismetAPIKey, serverAddress, remoteAddress, userAgent, referrer, author, email, body string) {
+func (b *BlogPost) SaveComment(akismetAPIKey, serverAddress, remoteAddress, userAgent, referrer, author, email, body string, notify bool) *Comment {
 
 	// TODO: Ensure file name is unique
 	isSpam, _ := akismet.IsSpamComment(body, serverAddress, remoteAddress, userAgent, referrer, author, email, akismetAPIKey)
 	comment := NewComment(html.EscapeString(author), html.EscapeString(email), html.EscapeString(body), isSpam)
+	comment.Metadata.Notify = notify
 
 	b.mutex.Lock()
 	b.Comments = append(b.Comments, comment)
 	b.mutex.Unlock()
 
-	commentPath := filepath.Join(b.CommentPath, b.Filename[:len(b.Filename)-3])
-	filename := timeToFilename(comment.Metadata.Date)
+	err := b.writeComment(comment)
+
+	if err != nil {
+		log.Println(err)
+	}
+
+	return comment
+}
+
+func (b *BlogPost) CommentWithFilename(filename string) (*Comment, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for _, comment := range b.Comments {
+		if comment.Filename == filename {
+			return comment, nil
+		}
+	}
+
+	return nil, errors.New(couldNotFindCommentErrorMessage)
+}
+
+// SetCommentIsSpam marks a comment as spam or not spam and rewrites its file,
+// returning whether it was spam before.  The post stays locked throughout so
+// that a comment deleted at the same time isn't written back to disk.
+func (b *BlogPost) SetCommentIsSpam(filename string, isSpam bool) (*Comment, bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, comment := range b.Comments {
+		if comment.Filename == filename {
+			wasSpam := comment.Metadata.IsSpam
+			comment.Metadata.IsSpam = isSpam
+
+			return comment, wasSpam, b.saveCommentFile(comment.Filename, comment.String())
+		}
+	}
+
+	return nil, false, errors.New(couldNotFindCommentErrorMessage)
+}
+
+// ReplySubscribers returns the addresses of commenters who asked to be told
+// about new comments on the post, excluding the given address.
+func (b *BlogPost) ReplySubscribers(exclude string) []string {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	found := make(map[string]bool)
+	emails := []string{}
+
+	for _, comment := range b.Comments {
+		email := html.UnescapeString(comment.Metadata.Email)
+
+		if !comment.Metadata.Notify || comment.Metadata.IsSpam {
+			continue
+		}
+
+		if strings.EqualFold(email, exclude) || found[strings.ToLower(email)] {
+			continue
+		}
+
+		found[strings.ToLower(email)] = true
+		emails = append(emails, email)
+	}
+
+	return emails
+}
+
+// Unsubscribe stops replies to the post being sent to the given address.
+func (b *BlogPost) Unsubscribe(email string) error {
+	changed := Comments{}
+
+	b.mutex.Lock()
+	for _, comment := range b.Comments {
+		if comment.Metadata.Notify && strings.EqualFold(html.UnescapeString(comment.Metadata.Email), email) {
+			comment.Metadata.Notify = false
+			changed = append(changed, comment)
+		}
+	}
+	b.mutex.Unlock()
+
+	for _, comment := range changed {
+		err := b.writeComment(comment)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *BlogPost) commentDirectory() string {
+	return filepath.Join(b.CommentPath, b.Filename[:len(b.Filename)-3])
+}
+
+func (b *BlogPost) writeComment(comment *Comment) error {
+	b.mutex.RLock()
+	content := comment.String()
+	b.mutex.RUnlock()
+
+	return b.saveCommentFile(comment.Filename, content)
+}
+
+func (b *BlogPost) saveCommentFile(filename, content string) error {
+	commentPath := b.commentDirectory()
 	fullPath := filepath.Join(commentPath, filename)
 
 	log.Println(commentPath)
 	os.MkdirAll(commentPath, 0775)
 
-	content := comment.String()
+	return ioutil.WriteFile(fullPath, []byte(content), 0644)
+}
 
-	err := ioutil.WriteFile(fullPath, []byte(content), 0644)
+func (b *BlogPost) String() string {
+	content := b.Metadata.String()
+	content += "\n"
+	content += strings.TrimLeft(b.Body.String(), "\n")
 
-	if err != nil {
-		log.Println(err)
+	return content
+}
+
+func (m *BlogPostMetadata) String() string {
+	if !m.fitsHeader() {
+		return m.frontMatter()
+	}
+
+	content := "Title: " + singleLine(m.Title) + "\n"
+
+	if m.Id != 0 {
+		content += "Id: " + strconv.Itoa(m.Id) + "\n"
 	}
+
+	content += "Date: " + timeToString(m.Date) + "\n"
+
+	if len(m.Tags) > 0 {
+		content += "Tags: " + singleLine(strings.Join(m.Tags, ", ")) + "\n"
+	}
+
+	if m.DisallowComments {
+		content += "DisallowComments: true\n"
+	}
+
+	if m.Draft {
+		content += "Draft: true\n"
+	}
+
+	if len(m.Slug) > 0 {
+		content += "Slug: " + singleLine(m.Slug) + "\n"
+	}
+
+	if len(m.Aliases) > 0 {
+		content += "Aliases: " + singleLine(strings.Join(m.Aliases, ", ")) + "\n"
+	}
+
+	if len(m.Summary) > 0 {
+		content += "Summary: " + m.Summary + "\n"
+	}
+
+	if m.TOC != nil {
+		content += "TOC: " + strconv.FormatBool(*m.TOC) + "\n"
+	}
+
+	if len(m.Markdown) > 0 {
+		content += "Markdown: " + m.Markdown + "\n"
+	}
+
+	if m.Extensions != nil {
+		content += "Extensions: " + strings.Join(m.Extensions, ", ") + "\n"
+	}
+
+	keys := []string{}
+
+	for key := range m.Params {
+		if !contains(knownPostKeys, strings.ToLower(key)) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		content += strings.ToLower(key) + ": " + m.Params[key].(string) + "\n"
+	}
+
+	return content
 }
 
-func (b *BlogPost) urlFromBlogPostProperties() string {
-	title := strings.ToLower(b.Metadata.Title)
-	title = strings.Replace(title, " ", "-", -1)
-	title = strings.Replace(title, ",", "", -1)
-	title = strings.Replace(title, "#", "", -1)
-	title = strings.Replace(title, ":", "", -1)
-	title = strings.Replace(title, "\\", "", -1)
-	title = strings.Replace(title, "?", "", -1)
-	title = strings.Replace(title, "/", "", -1)
+// fitsHeader reports whether the metadata can be written as a "Key: value"
+// header.  Extra keys fit as long as they are single line strings, as they
+// are when they were read from such a header.
+func (m *BlogPostMetadata) fitsHeader() bool {
+	if strings.Contains(m.Summary, "\n") {
+		return false
+	}
+
+	for key, value := range m.Params {
+		text, isString := value.(string)
 
-	return fmt.Sprintf("%04d/%02d/%02d/%s", b.Metadata.Date.Year(), b.Metadata.Date.Month(), b.Metadata.Date.Day(), title)
+		if !isString || strings.ContainsAny(key, ":\n") || strings.TrimSpace(key) != key || len(key) == 0 {
+			return false
+		}
+
+		if strings.Contains(text, "\n") || strings.TrimSpace(text) != text {
+			return false
+		}
+	}
+
+	return true
 }
 
-func (b *BlogPost) loadComments() {
+// frontMatter writes the metadata as YAML front matter.  It is only used for
+// posts with extra keys that aren't single line strings, or summaries of more
+// than one line, which the "Key: value" header can't hold.
+func (m *BlogPostMetadata) frontMatter() string {
+	content := yamlFrontMatterDelimiter + "\n"
 
-	filename := b.Filename[:len(b.Filename)-3]
-	dirname := b.CommentPath + string(filepath.Separator) + filename + string(filepath.Separator)
+	add := func(key string, value interface{}) {
+		line, err := yaml.Marshal(map[string]interface{}{key: value})
+
+		if err == nil {
+			content += string(line)
+		}
+	}
+
+	add("title", m.Title)
+
+	if m.Id != 0 {
+		add("id", m.Id)
+	}
+
+	add("date", timeToString(m.Date))
+
+	if len(m.Tags) > 0 {
+		add("tags", m.Tags)
+	}
+
+	if m.DisallowComments {
+		add("disallowcomments", true)
+	}
+
+	if m.Draft {
+		add("draft", true)
+	}
+
+	if len(m.Slug) > 0 {
+		add("slug", m.Slug)
+	}
+
+	if len(m.Aliases) > 0 {
+		add("aliases", m.Aliases)
+	}
+
+	if len(m.Summary) > 0 {
+		add("summary", m.Summary)
+	}
+
+	if m.TOC != nil {
+		add("toc", *m.TOC)
+	}
+
+	if len(m.Markdown) > 0 {
+		add("markdown", m.Markdown)
+	}
+
+	if m.Extensions != nil {
+		add("extensions", m.Extensions)
+	}
+
+	params := map[string]interface{}{}
+
+	for key, value := range m.Params {
+		if !contains(knownPostKeys, strings.ToLower(key)) {
+			params[strings.ToLower(key)] = value
+		}
+	}
+
+	if len(params) > 0 {
+		lines, err := yaml.Marshal(params)
+
+		if err == nil {
+			content += string(lines)
+		}
+	}
+
+	return content + yamlFrontMatterDelimiter + "\n"
+}
+
+// singleLine stops metadata values from spilling onto following lines, where
+// they would be read as extra metadata or as the body.
+func singleLine(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+func (b *BlogPost) loadComments() {
 
-	b.Comments, _ = LoadComments(dirname)
+	b.Comments, _ = LoadComments(b.commentDirectory())
 }
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -50,3 +55,216 @@ func TestContainsTag(t *testing.T) {
 		t.Error("Found missing tag")
 	}
 }
+
+func TestReplySubscribers(t *testing.T) {
+	p := createPost()
+
+	c := NewComment("Sue", "sue@example.com", "Notify me", false)
+	c.Metadata.Notify = true
+	p.Comments = append(p.Comments, c)
+
+	c = NewComment("Sue", "SUE@example.com", "Notify me again", false)
+	c.Metadata.Notify = true
+	p.Comments = append(p.Comments, c)
+
+	c = NewComment("Spammer", "spam@example.com", "Buy now", true)
+	c.Metadata.Notify = true
+	p.Comments = append(p.Comments, c)
+
+	subscribers := p.ReplySubscribers("joe@example.com")
+
+	if len(subscribers) != 1 || subscribers[0] != "sue@example.com" {
+		t.Error("Incorrect reply subscribers:", subscribers)
+	}
+
+	subscribers = p.ReplySubscribers("Sue@Example.com")
+
+	if len(subscribers) != 0 {
+		t.Error("Commenter was notified of their own comment:", subscribers)
+	}
+}
+
+func TestDraftsAreNotPublished(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	metadata := BlogPostMetadata{Title: "Draft", Date: time.Now(), Draft: true}
+	post, err := blog.WritePost("draft.md", metadata, "Body")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(post.String(), "Draft: true\n") || !post.Metadata.Draft {
+		t.Errorf("Unexpected draft %q", post.String())
+	}
+
+	if _, err := blog.PostWithFilename("draft.md"); err == nil {
+		t.Error("Draft was published")
+	}
+
+	metadata.Draft = false
+	blog.WritePost("draft.md", metadata, "Body")
+
+	if _, err := blog.PostWithFilename("draft.md"); err != nil {
+		t.Error("Post was not published")
+	}
+
+	metadata.Draft = true
+	blog.WritePost("draft.md", metadata, "Body")
+
+	if _, err := blog.PostWithFilename("draft.md"); err == nil {
+		t.Error("Post was not unpublished")
+	}
+}
+
+func TestFrontMatterPosts(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	writeTestFiles(t, dir, map[string]string{
+		"posts/yaml.md":   "---\ntitle: \"YAML: A Post\"\ndate: 2014-01-26 10:30:00 +0100\ntags:\n  - Go\n  - News\nsubtitle: \"Colons: fine\"\nseries: {name: Intro, part: 2}\n---\n\nNote: this is the body.\n",
+		"posts/toml.md":   "+++\ntitle = \"TOML Post\"\ndate = 2014-01-27T10:30:00Z\ndisallowComments = true\n\n[params]\nimage = \"/media/a.jpg\"\n+++\nBody\n",
+		"posts/legacy.md": "Title: Legacy\nDate: 2014-01-28 10:30:00\nSubtitle: Kept too\n\nBody\n",
+	})
+
+	post, err := LoadPost("yaml.md", filepath.Join(dir, "posts"), filepath.Join(dir, "comments"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if post.Metadata.Title != "YAML: A Post" || timeToString(post.Metadata.Date) != "2014-01-26 10:30:00" || strings.Join(post.Metadata.Tags, ",") != "go,news" {
+		t.Errorf("Unexpected metadata %+v", post.Metadata)
+	}
+
+	if post.Body.Markdown != "\nNote: this is the body.\n" {
+		t.Errorf("Unexpected body %q", post.Body.Markdown)
+	}
+
+	if post.Metadata.Params["subtitle"] != "Colons: fine" || len(post.Metadata.Params) != 2 {
+		t.Errorf("Unexpected params %v", post.Metadata.Params)
+	}
+
+	if series, ok := post.Metadata.Params["series"].(map[string]interface{}); !ok || series["part"] != 2 {
+		t.Errorf("Structured param was not kept: %v", post.Metadata.Params["series"])
+	}
+
+	// Posts with params are written back as YAML so the params are kept.
+	post.Metadata.Date = stringToTime("2014-01-26 10:30:00")
+	reloaded, err := blog.WritePost("yaml.md", post.Metadata, post.Body.Markdown)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(reloaded.String(), "---\ntitle: 'YAML: A Post'\n") || reloaded.Metadata.Title != "YAML: A Post" || reloaded.Metadata.Params["subtitle"] != "Colons: fine" {
+		t.Errorf("Params were not written back: %q", reloaded.String())
+	}
+
+	post, _ = LoadPost("toml.md", filepath.Join(dir, "posts"), filepath.Join(dir, "comments"))
+
+	if post.Metadata.Title != "TOML Post" || !post.Metadata.DisallowComments || post.Body.Markdown != "Body\n" {
+		t.Errorf("Unexpected TOML post %+v %q", post.Metadata, post.Body.Markdown)
+	}
+
+	if params, ok := post.Metadata.Params["params"].(map[string]interface{}); !ok || params["image"] != "/media/a.jpg" {
+		t.Errorf("Unexpected TOML params %v", post.Metadata.Params)
+	}
+
+	post, _ = LoadPost("legacy.md", filepath.Join(dir, "posts"), filepath.Join(dir, "comments"))
+
+	if post.Metadata.Title != "Legacy" || post.Metadata.Params["subtitle"] != "Kept too" || post.Body.Markdown != "\nBody\n" {
+		t.Errorf("Unexpected legacy post %+v %q", post.Metadata, post.Body.Markdown)
+	}
+
+	if !strings.HasSuffix(post.Metadata.String(), "Date: 2014-01-28 10:30:00\nsubtitle: Kept too\n") {
+		t.Errorf("Legacy post not written back in the same format: %q", post.Metadata.String())
+	}
+}
+
+func TestPostSummaries(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	SharedConfig.SummaryWords = 5
+	SharedConfig.PostsPerPage = 10
+	SharedConfig.ThemePath = "themes"
+	SharedConfig.Theme = "grump"
+
+	writeTestFiles(t, dir, map[string]string{
+		"posts/marker.md":  "Title: Marker\nDate: 2014-01-26 10:30:00\n\nThe *introduction*.\n\n<!-- more -->\n\nThe rest.\n",
+		"posts/key.md":     "Title: Key\nDate: 2014-01-27 10:30:00\nSummary: A **short** summary.\n\nThe whole post.\n",
+		"posts/long.md":    "Title: Long\nDate: 2014-01-28 10:30:00\n\nOne [two three four five](/x) six seven.\n",
+		"posts/short.md":   "Title: Short\nDate: 2014-01-29 10:30:00\n\nOnly four short words.\n",
+		"posts/trailer.md": "Title: Trailer\nDate: 2014-01-30 10:30:00\n\nEverything.\n\n<!--more-->\n",
+	})
+
+	tests := map[string]struct {
+		summary string
+		hasMore bool
+	}{
+		"marker.md":  {"<p>The <em>introduction</em>.</p>\n", true},
+		"key.md":     {"<p>A <strong>short</strong> summary.</p>\n", true},
+		"long.md":    {"<p>One <a href=\"/x\">two three four five…</a></p>", true},
+		"short.md":   {"<p>Only four short words.</p>\n", false},
+		"trailer.md": {"<p>Everything.</p>\n", false},
+	}
+
+	for filename, expected := range tests {
+		post, err := LoadPost(filename, filepath.Join(dir, "posts"), filepath.Join(dir, "comments"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if post.Summary != expected.summary || post.HasMore != expected.hasMore {
+			t.Errorf("%v: expected summary %q (%v), got %q (%v)", filename, expected.summary, expected.hasMore, post.Summary, post.HasMore)
+		}
+	}
+
+	// The summary key survives the post being written back.
+	post, _ := LoadPost("key.md", filepath.Join(dir, "posts"), filepath.Join(dir, "comments"))
+
+	if !strings.Contains(post.Metadata.String(), "Summary: A **short** summary.\n") {
+		t.Errorf("Summary was not written: %q", post.Metadata.String())
+	}
+
+	// Listing pages and the feed show summaries only when asked to.
+	blog, _ = LoadBlog(filepath.Join(dir, "posts"), filepath.Join(dir, "comments"), true)
+	router := newRouter()
+
+	for _, mode := range []string{fullContent, summaryContent} {
+		SharedConfig.ListContent = mode
+		SharedConfig.FeedContent = mode
+
+		for _, path := range []string{"/", "/?search=rest", "/rss"} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", path, nil)
+			router.ServeHTTP(w, req)
+
+			if full := strings.Contains(w.Body.String(), "The rest."); full != (mode == fullContent) {
+				t.Errorf("%v in %v mode included the full post: %v", path, mode, full)
+			}
+		}
+	}
+}
+
+func TestTruncateHTML(t *testing.T) {
+	tests := map[string]string{
+		"<p>one two three</p>":                           "<p>one two…</p>",
+		"<p>one</p>\n<ul><li>two <b>three</b></li></ul>": "<p>one</p>\n<ul><li>two…</li></ul>",
+		"<p>one <img src=\"a.png\"> two</p><p>x</p>":     "<p>one <img src=\"a.png\"> two…</p>",
+		"<p>one &amp; two</p>":                           "<p>one &amp;…</p>",
+	}
+
+	for text, expected := range tests {
+		if summary, more := truncateHTML(text, 2); summary != expected || !more {
+			t.Errorf("Truncating %q: expected %q, got %q", text, expected, summary)
+		}
+	}
+
+	if summary, more := truncateHTML("<p>one two</p>", 2); summary != "<p>one two</p>" || more {
+		t.Errorf("Short HTML was truncated to %q", summary)
+	}
+}
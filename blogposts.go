@@ -104,3 +104,15 @@ func (b BlogPosts) PostWithId(id int) (*BlogPost, error) {
 
 	return nil, err
 }
+
+func (b BlogPosts) PostWithFilename(filename string) (*BlogPost, error) {
+	for _, post := range b {
+		if post.Filename == filename {
+			return post, nil
+		}
+	}
+
+	err := errors.New(couldNotFindPostErrorMessage)
+
+	return nil, err
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var knownPostKeys = []string{"title", "id", "date", "tags", "disallowcomments", "draft", "slug", "aliases", "summary", "toc", "markdown", "extensions"}
+var knownCommentKeys = []string{"author", "email", "date", "spam", "notify", "source", "authorurl"}
+
+// internalLinkPattern finds links to posts and media, either relative to the
+// site root or absolute.  Absolute links are only checked if they point at the
+// blog's own address.
+var internalLinkPattern = regexp.MustCompile(`(?:^|[\s("'<=\[])((?:https?://[^/\s"'<>()]+)?/(?:posts|media)/[^\s"'<>()#?]*)`)
+
+type checkProblem struct {
+	path    string
+	line    int
+	message string
+}
+
+type checkedLink struct {
+	path   string
+	line   int
+	target string
+}
+
+// checker validates post and comment files without loading them into a
+// blog, so that every problem can be reported with its location.
+type checker struct {
+	problems []checkProblem
+	links    []checkedLink
+	urls     map[string]string
+	ids      map[int]string
+	posts    int
+	comments int
+}
+
+func newChecker() *checker {
+	return &checker{
+		urls: make(map[string]string),
+		ids:  make(map[int]string),
+	}
+}
+
+func checkCommand(args []string) error {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	parseCommandFlags(flags, args)
+
+	c := newChecker()
+	c.checkPosts(SharedConfig.PostPath)
+	c.checkComments(SharedConfig.CommentPath)
+	c.checkLinks()
+
+	for _, problem := range c.sortedProblems() {
+		fmt.Fprintf(commandOutput, "%v:%v: %v\n", problem.path, problem.line, problem.message)
+	}
+
+	if len(c.problems) > 0 {
+		return fmt.Errorf("Found %v problems in %v posts and %v comments", len(c.problems), c.posts, c.comments)
+	}
+
+	fmt.Fprintf(commandOutput, "Checked %v posts and %v comments: no problems found\n", c.posts, c.comments)
+
+	return nil
+}
+
+func (c *checker) report(path string, line int, format string, args ...interface{}) {
+	c.problems = append(c.problems, checkProblem{path, line, fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) sortedProblems() []checkProblem {
+	sort.SliceStable(c.problems, func(i, j int) bool {
+		if c.problems[i].path != c.problems[j].path {
+			return c.problems[i].path < c.problems[j].path
+		}
+
+		return c.problems[i].line < c.problems[j].line
+	})
+
+	return c.problems
+}
+
+func (c *checker) checkPosts(postPath string) {
+	files, err := ioutil.ReadDir(postPath)
+
+	if err != nil {
+		c.report(postPath, 0, "could not read posts: %v", err)
+		return
+	}
+
+	for _, file := range files {
+		if isValidBlogPostFile(file) {
+			c.checkPost(filepath.Join(postPath, file.Name()))
+		}
+	}
+}
+
+func (c *checker) checkPost(path string) {
+	c.posts++
+
+	headers, bodyLine, body, ok := c.readBlogFile(path, knownPostKeys, true)
+
+	if !ok {
+		return
+	}
+
+	post := &BlogPost{Filename: filepath.Base(path)}
+	dateFound := false
+
+	for _, header := range headers {
+		switch header.key {
+		case "title":
+			post.Metadata.Title = header.value
+
+			if len(header.value) == 0 {
+				c.report(path, header.line, "title is empty")
+			}
+		case "id":
+			id, err := strconv.Atoi(header.value)
+
+			if err != nil || id < 0 {
+				c.report(path, header.line, "invalid id %q", header.value)
+			} else if other, exists := c.ids[id]; exists && id != 0 {
+				c.report(path, header.line, "id %v is also used by %v", id, other)
+			} else if id != 0 {
+				c.ids[id] = path
+			}
+
+			post.Metadata.Id = id
+		case "date":
+			dateFound = true
+			date, err := parseTimeString(header.value)
+
+			if err != nil {
+				c.report(path, header.line, "invalid date %q (expected YYYY-MM-DD HH:MM:SS)", header.value)
+			}
+
+			post.Metadata.Date = date
+		case "slug":
+			post.Metadata.Slug = header.value
+
+			if len(slugify(header.value)) == 0 {
+				c.report(path, header.line, "slug %q has no letters or numbers", header.value)
+			}
+		case "disallowcomments", "draft", "toc":
+			if header.value != "true" && header.value != "false" {
+				c.report(path, header.line, "%v must be true or false, not %q", header.key, header.value)
+			}
+		case "markdown":
+			post.Metadata.Markdown = strings.ToLower(header.value)
+
+			if err := validateMarkdownOptions(markdownOptions{renderer: post.Metadata.Markdown}); err != nil {
+				c.report(path, header.line, "%v", err)
+			}
+		case "extensions":
+			post.Metadata.Extensions = parseMarkdownExtensions(header.value)
+
+			if err := validateMarkdownOptions(markdownOptions{renderer: legacyMarkdown, extensions: post.Metadata.Extensions}); err != nil {
+				c.report(path, header.line, "%v", err)
+			}
+		}
+	}
+
+	if extensions, exists := headers.find("extensions"); exists && post.Metadata.markdownOptions().renderer != commonMarkMarkdown {
+		c.report(path, extensions.line, "extensions only apply to the %v renderer", commonMarkMarkdown)
+	}
+
+	if _, exists := headers.find("title"); !exists {
+		c.report(path, 1, "missing title")
+	}
+
+	if !dateFound {
+		c.report(path, 1, "missing date")
+	}
+
+	if len(strings.TrimSpace(body)) == 0 {
+		c.report(path, bodyLine, "empty body")
+	}
+
+	if len(post.Metadata.Title) > 0 {
+		url := post.urlFromBlogPostProperties()
+
+		if other, exists := c.urls[url]; exists {
+			c.report(path, 1, "URL /posts/%v is also used by %v", url, other)
+		} else {
+			c.urls[url] = path
+		}
+	}
+
+	c.findLinks(path, bodyLine, body)
+
+	post.PostPath = filepath.Dir(path)
+	_, problems := post.renderBody(body, bodyLine, plainHeadings)
+
+	for _, problem := range problems {
+		c.report(path, problem.Line, "%v", problem.Message)
+	}
+}
+
+func (c *checker) checkComments(commentPath string) {
+	directories, err := ioutil.ReadDir(commentPath)
+
+	if err != nil {
+		c.report(commentPath, 0, "could not read comments: %v", err)
+		return
+	}
+
+	for _, directory := range directories {
+		if !directory.IsDir() {
+			continue
+		}
+
+		files, err := ioutil.ReadDir(filepath.Join(commentPath, directory.Name()))
+
+		if err != nil {
+			c.report(filepath.Join(commentPath, directory.Name()), 0, "could not read comments: %v", err)
+			continue
+		}
+
+		for _, file := range files {
+			if isValidBlogPostFile(file) {
+				c.checkComment(filepath.Join(commentPath, directory.Name(), file.Name()))
+			}
+		}
+	}
+}
+
+func (c *checker) checkComment(path string) {
+	c.comments++
+
+	headers, bodyLine, body, ok := c.readBlogFile(path, knownCommentKeys, false)
+
+	if !ok {
+		return
+	}
+
+	for _, header := range headers {
+		switch header.key {
+		case "date":
+			if _, err := parseTimeString(header.value); err != nil {
+				c.report(path, header.line, "invalid date %q (expected YYYY-MM-DD HH:MM:SS)", header.value)
+			}
+		case "spam", "notify":
+			if header.value != "true" && header.value != "false" {
+				c.report(path, header.line, "%v must be true or false, not %q", header.key, header.value)
+			}
+		}
+	}
+
+	if author, exists := headers.find("author"); !exists || len(author.value) == 0 {
+		c.report(path, 1, "missing author")
+	}
+
+	if _, exists := headers.find("date"); !exists {
+		c.report(path, 1, "missing date")
+	}
+
+	if len(strings.TrimSpace(body)) == 0 {
+		c.report(path, bodyLine, "empty body")
+	}
+}
+
+type checkedHeader struct {
+	line  int
+	key   string
+	value string
+}
+
+type checkedHeaders []checkedHeader
+
+func (h checkedHeaders) find(key string) (checkedHeader, bool) {
+	for _, header := range h {
+		if header.key == key {
+			return header, true
+		}
+	}
+
+	return checkedHeader{}, false
+}
+
+// readBlogFile reads a post or comment file, reporting missing headers and
+// repeated keys, and unknown keys unless extraKeys is set.  It returns the
+// headers, the line on which the body starts and the body.
+func (c *checker) readBlogFile(path string, known []string, extraKeys bool) (checkedHeaders, int, string, bool) {
+	content, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		c.report(path, 0, "could not read file: %v", err)
+		return nil, 0, "", false
+	}
+
+	text := strings.Replace(string(content), "\r", "", -1)
+	headers := checkedHeaders{}
+
+	headerSize, headerLines, err := scanBlogFileHeader(text, func(line int, key, value string, raw interface{}) {
+		// Posts can hold any extra keys that a theme might use.
+		if !contains(known, key) && !extraKeys {
+			c.report(path, line, "unknown metadata key %q", key)
+		} else if previous, exists := headers.find(key); exists {
+			c.report(path, line, "%q is repeated from line %v", key, previous.line)
+		}
+
+		headers = append(headers, checkedHeader{line, key, value})
+	})
+
+	if err != nil {
+		c.report(path, 1, "%v", err)
+	} else if headerLines == 0 {
+		c.report(path, 1, "missing metadata header")
+	}
+
+	if headerSize > len(text) {
+		headerSize = len(text)
+	}
+
+	return headers, headerLines + 1, text[headerSize:], true
+}
+
+// findLinks records the internal links in a post's body so that they can be
+// checked once every post is known.  Fenced code blocks are skipped, as they
+// often contain example URLs.
+func (c *checker) findLinks(path string, firstLine int, body string) {
+	address, _ := url.Parse(SharedConfig.Address)
+	inCode := false
+
+	for i, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") || strings.HasPrefix(strings.TrimSpace(line), "~~~") {
+			inCode = !inCode
+			continue
+		}
+
+		if inCode {
+			continue
+		}
+
+		for _, match := range internalLinkPattern.FindAllStringSubmatch(line, -1) {
+			link, err := url.Parse(match[1])
+
+			if err != nil {
+				c.report(path, firstLine+i, "invalid link %v", match[1])
+				continue
+			}
+
+			if len(link.Host) > 0 && (address == nil || !strings.EqualFold(link.Host, address.Host)) {
+				continue
+			}
+
+			c.links = append(c.links, checkedLink{path, firstLine + i, link.Path})
+		}
+	}
+}
+
+func (c *checker) checkLinks() {
+	for _, link := range c.links {
+		if strings.HasPrefix(link.target, "/posts/") {
+			url := strings.Trim(strings.TrimPrefix(link.target, "/posts/"), "/")
+			url = strings.TrimSuffix(url, "/comments")
+
+			if _, exists := c.urls[url]; !exists {
+				c.report(link.path, link.line, "broken link to %v", link.target)
+			}
+		} else {
+			media := filepath.Join(SharedConfig.MediaPath, filepath.FromSlash(strings.TrimPrefix(link.target, "/media/")))
+
+			if info, err := os.Stat(media); err != nil || info.IsDir() {
+				c.report(link.path, link.line, "broken link to %v", link.target)
+			}
+		}
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckCommand(t *testing.T) {
+	dir, output := createCommandTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "media", "photo.jpg"), []byte("jpeg"), 0644)
+
+	files := map[string]string{
+		"posts/good.md":       "Title: Good\nId: 1\nDate: 2014-01-26 10:00:00\nTags: a\n\n![Photo](/media/photo.jpg) and [other](/posts/2014/01/27/other/).\n",
+		"posts/other.md":      "Title: Other\nId: 1\nDate: 2014-01-27 10:00:00\n\nSee [missing](/posts/2014/01/01/missing) and\n![Missing](http://blog.example/media/missing.jpg)\n\n```\n/posts/in/code\n```\n[External](http://other.example/posts/x)\n",
+		"posts/broken.md":     "Subtitle: Allowed\nDate: 26/01/2014\nId: x\n\n",
+		"posts/duplicate.md":  "Title: Good\nDate: 2014-01-26 12:00:00\n\nSame URL as good.md\n",
+		"posts/yaml.md":       "---\ntitle: YAML\nsubtitle: Allowed\ndate: 2014-02-30\n---\n\nBody\n",
+		"posts/toml.md":       "+++\ntitle = \"Unclosed\n+++\n\nBody\n",
+		"posts/markdown.md":   "Title: Markdown\nDate: 2014-01-28 10:00:00\nMarkdown: kramdown\nExtensions: table, emoji\n\n{{< tweet 1 >}}\n",
+		"comments/good/1.md":  "Author: Joe\nEmail: joe@example.com\nDate: 2014-01-26 11:00:00\nSpam: maybe\nMood: happy\n\nHello\n",
+		"comments/good/2.md":  "Just some text\n",
+		"comments/good/3.txt": "Ignored",
+	}
+
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0775)
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+
+	err := checkCommand([]string{})
+
+	if err == nil {
+		t.Error("Problems were not reported as an error")
+	}
+
+	expected := []string{
+		"posts/broken.md:1: missing title",
+		"posts/broken.md:2: invalid date \"26/01/2014\"",
+		"posts/broken.md:3: invalid id \"x\"",
+		"posts/broken.md:4: empty body",
+		"posts/yaml.md:4: invalid date \"2014-02-30\"",
+		"posts/toml.md:1: invalid front matter",
+		"posts/markdown.md:3: unknown Markdown renderer \"kramdown\"",
+		"posts/markdown.md:4: unknown Markdown extension \"emoji\"",
+		"posts/markdown.md:4: extensions only apply to the commonmark renderer",
+		"posts/markdown.md:6: unknown shortcode \"tweet\"",
+		"URL /posts/2014/01/26/good is also used by",
+		"posts/other.md:2: id 1 is also used by",
+		"posts/other.md:5: broken link to /posts/2014/01/01/missing",
+		"posts/other.md:6: broken link to /media/missing.jpg",
+		"comments/good/1.md:4: spam must be true or false",
+		"comments/good/1.md:5: unknown metadata key \"mood\"",
+		"comments/good/2.md:1: missing metadata header",
+		"comments/good/2.md:1: missing author",
+	}
+
+	for _, problem := range expected {
+		if !strings.Contains(output.String(), problem) {
+			t.Errorf("Expected problem %q", problem)
+		}
+	}
+
+	for _, unexpected := range []string{"/posts/in/code", "other.example", "3.txt", "photo.jpg", "subtitle"} {
+		if strings.Contains(output.String(), unexpected) {
+			t.Errorf("Unexpected problem with %v:\n%v", unexpected, output.String())
+		}
+	}
+
+	if lines := strings.Count(output.String(), "\n"); lines != 21 {
+		t.Errorf("Expected 21 problems, got %v:\n%v", lines, output.String())
+	}
+}
+
+func TestCheckCommandWithNoProblems(t *testing.T) {
+	dir, output := createCommandTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "posts", "good.md"), []byte("Title: Good\nDate: 2014-01-26 10:00:00\n\nBody\n"), 0644)
+
+	err := checkCommand([]string{})
+
+	if err != nil || !strings.Contains(output.String(), "no problems found") {
+		t.Errorf("Unexpected result %v: %v", err, output.String())
+	}
+}
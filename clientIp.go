@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const forwardedForHeader = "X-Forwarded-For"
+const forwardedHeader = "Forwarded"
+const realIpHeader = "X-Real-Ip"
+
+// getIpAddress returns the address of the client that made the request.
+// Proxy headers are only believed if the request arrived from one of the
+// trusted proxies in the config file, and the forwarding chain is walked from
+// right to left so that addresses prepended by the client itself are ignored.
+func getIpAddress(r *http.Request) string {
+	return clientIpAddress(r, SharedConfig.trustedProxies, SharedConfig.TrustedProxyHeader)
+}
+
+func clientIpAddress(r *http.Request, trustedProxies []*net.IPNet, header string) string {
+	remote := parseIpAddress(r.RemoteAddr)
+
+	if remote == nil {
+		return r.RemoteAddr
+	}
+
+	if !isTrustedProxy(remote, trustedProxies) {
+		return remote.String()
+	}
+
+	var chain []string
+
+	switch http.CanonicalHeaderKey(header) {
+	case forwardedHeader:
+		chain = forwardedForAddresses(r.Header[forwardedHeader])
+	case realIpHeader:
+		chain = r.Header[realIpHeader]
+	default:
+		chain = splitHeaderList(r.Header[forwardedForHeader])
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := parseIpAddress(chain[i])
+
+		// A trusted proxy has sent us something that isn't an address (such
+		// as "unknown" or an obfuscated identifier), so we can't tell who
+		// the client is.  The proxy is the best we can do.
+		if ip == nil {
+			return remote.String()
+		}
+
+		if !isTrustedProxy(ip, trustedProxies) {
+			return ip.String()
+		}
+
+		remote = ip
+	}
+
+	return remote.String()
+}
+
+// parseIpAddress extracts an IP address from strings of the forms "1.2.3.4",
+// "1.2.3.4:80", "::1", "[::1]" and "[::1]:80".  IPv6 zones are discarded.
+func parseIpAddress(s string) net.IP {
+	s = strings.TrimSpace(s)
+
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+
+	s = strings.TrimPrefix(s, "[")
+	s = strings.TrimSuffix(s, "]")
+
+	if idx := strings.Index(s, "%"); idx != -1 {
+		s = s[:idx]
+	}
+
+	ip := net.ParseIP(s)
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+
+	return ip
+}
+
+// parseTrustedProxyHeader checks that header is one of the headers that
+// clientIpAddress understands and returns its canonical form.  Anything else
+// would silently fall back to X-Forwarded-For, which the proxy might not set.
+func parseTrustedProxyHeader(header string) (string, error) {
+	canonical := http.CanonicalHeaderKey(strings.TrimSpace(header))
+
+	if canonical != forwardedForHeader && canonical != forwardedHeader && canonical != realIpHeader {
+		msg := fmt.Sprintf("Unknown trusted proxy header %v", header)
+		return "", errors.New(msg)
+	}
+
+	return canonical, nil
+}
+
+func isTrustedProxy(ip net.IP, trustedProxies []*net.IPNet) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseTrustedProxies converts a list of CIDR ranges into networks.  Plain
+// addresses are treated as single-address ranges.
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+
+		if !strings.Contains(proxy, "/") {
+			ip := parseIpAddress(proxy)
+
+			if ip == nil {
+				msg := fmt.Sprintf("Invalid trusted proxy %v", proxy)
+				return nil, errors.New(msg)
+			}
+
+			bits := len(ip) * 8
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+
+		if err != nil {
+			msg := fmt.Sprintf("Invalid trusted proxy %v: %v", proxy, err)
+			return nil, errors.New(msg)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func splitHeaderList(values []string) []string {
+	items := []string{}
+
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			items = append(items, strings.TrimSpace(item))
+		}
+	}
+
+	return items
+}
+
+// forwardedForAddresses returns the "for" parameter of each element of an
+// RFC 7239 Forwarded header, in order.  Elements without a "for" parameter
+// are returned as empty strings so that they break the chain of trust.
+func forwardedForAddresses(values []string) []string {
+	addresses := []string{}
+
+	for _, element := range splitHeaderList(values) {
+		address := ""
+
+		for _, pair := range strings.Split(element, ";") {
+			separatorIndex := strings.Index(pair, "=")
+
+			if separatorIndex == -1 {
+				continue
+			}
+
+			key := strings.ToLower(strings.TrimSpace(pair[:separatorIndex]))
+
+			if key == "for" {
+				address = strings.Trim(strings.TrimSpace(pair[separatorIndex+1:]), "\"")
+			}
+		}
+
+		addresses = append(addresses, address)
+	}
+
+	return addresses
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func createIpRequest(remoteAddr string, headers map[string]string) *http.Request {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = remoteAddr
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	return req
+}
+
+func TestClientIpAddressUntrustedRemote(t *testing.T) {
+	proxies, _ := parseTrustedProxies([]string{"10.0.0.0/8"})
+
+	req := createIpRequest("203.0.113.7:5123", map[string]string{"X-Forwarded-For": "1.2.3.4"})
+
+	if ip := clientIpAddress(req, proxies, forwardedForHeader); ip != "203.0.113.7" {
+		t.Error("Trusted header from untrusted remote:", ip)
+	}
+
+	req = createIpRequest("[2001:db8::1]:5123", nil)
+
+	if ip := clientIpAddress(req, proxies, forwardedForHeader); ip != "2001:db8::1" {
+		t.Error("Incorrect IPv6 remote address:", ip)
+	}
+}
+
+func TestClientIpAddressForwardedFor(t *testing.T) {
+	proxies, _ := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+
+	req := createIpRequest("10.0.0.1:5123", map[string]string{"X-Forwarded-For": "6.6.6.6, 203.0.113.7, 192.168.1.1"})
+
+	if ip := clientIpAddress(req, proxies, forwardedForHeader); ip != "203.0.113.7" {
+		t.Error("Spoofed address accepted:", ip)
+	}
+
+	req = createIpRequest("10.0.0.1:5123", map[string]string{"X-Forwarded-For": "10.0.0.5, 10.0.0.3"})
+
+	if ip := clientIpAddress(req, proxies, forwardedForHeader); ip != "10.0.0.5" {
+		t.Error("Incorrect address for all-trusted chain:", ip)
+	}
+
+	req = createIpRequest("10.0.0.1:5123", map[string]string{"X-Forwarded-For": "203.0.113.7, unknown"})
+
+	if ip := clientIpAddress(req, proxies, forwardedForHeader); ip != "10.0.0.1" {
+		t.Error("Accepted address beyond invalid entry:", ip)
+	}
+}
+
+func TestClientIpAddressForwarded(t *testing.T) {
+	proxies, _ := parseTrustedProxies([]string{"10.0.0.0/8"})
+
+	req := createIpRequest("10.0.0.1:5123", map[string]string{"Forwarded": `for=6.6.6.6, for="[2001:db8:cafe::17]:4711";proto=https, for=10.0.0.2;by=10.0.0.1`})
+
+	if ip := clientIpAddress(req, proxies, forwardedHeader); ip != "2001:db8:cafe::17" {
+		t.Error("Incorrect Forwarded address:", ip)
+	}
+}
+
+func TestClientIpAddressRealIp(t *testing.T) {
+	proxies, _ := parseTrustedProxies([]string{"127.0.0.1", "::1"})
+
+	req := createIpRequest("[::1]:5123", map[string]string{"X-Real-Ip": "203.0.113.7", "X-Forwarded-For": "6.6.6.6"})
+
+	if ip := clientIpAddress(req, proxies, realIpHeader); ip != "203.0.113.7" {
+		t.Error("Incorrect X-Real-Ip address:", ip)
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	if _, err := parseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("Accepted invalid CIDR")
+	}
+
+	if _, err := parseTrustedProxies([]string{"localhost"}); err == nil {
+		t.Error("Accepted invalid address")
+	}
+}
+
+func TestParseTrustedProxyHeader(t *testing.T) {
+	for header, expected := range map[string]string{
+		"X-Forwarded-For": forwardedForHeader,
+		"x-forwarded-for": forwardedForHeader,
+		"FORWARDED":       forwardedHeader,
+		"x-real-ip":       realIpHeader,
+	} {
+		actual, err := parseTrustedProxyHeader(header)
+
+		if err != nil {
+			t.Errorf("Rejected %v: %v", header, err)
+		} else if actual != expected {
+			t.Errorf("Expected %v for %v but got %v", expected, header, actual)
+		}
+	}
+
+	for _, header := range []string{"", "X-Client-Ip", "CF-Connecting-IP"} {
+		if _, err := parseTrustedProxyHeader(header); err == nil {
+			t.Errorf("Accepted %q", header)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+type command struct {
+	usage       string
+	description string
+	run         func(args []string) error
+}
+
+var commands = map[string]command{
+	"serve":     {"serve [-disableWatcher]", "run the blog's web server (the default)", serveCommand},
+	"new":       {"new \"Title\" [-tags a,b] [-date \"2006-01-02 15:04:05\"]", "create a new post", newCommand},
+	"list":      {"list [-tag name]", "list posts, newest first", listCommand},
+	"tags":      {"tags", "list tags and the number of posts that use them", tagsCommand},
+	"comments":  {"comments [-spam] [-post url]", "list comments, newest first", commentsCommand},
+	"export":    {"export [-out ./public] [-full]", "export the blog as static HTML", exportCommand},
+	"check":     {"check", "report problems in post and comment files", checkCommand},
+	"import":    {"import wordpress export.xml [-uploads dir] | jekyll|hugo dir [-redirects file]", "import posts from WordPress, Jekyll or Hugo", importCommand},
+	"backup":    {"backup [-out file.tar.gz|file.zip]", "archive the posts, comments, media, config and theme", backupCommand},
+	"restore":   {"restore [-force] file", "verify and unpack an archive made by backup", restoreCommand},
+	"json":      {"json [-out file.json]", "export every post and comment as JSON", jsonExportCommand},
+	"highlight": {"highlight [-style monokai] [-out file] [-list]", "write the stylesheet for a code highlighting style", highlightCommand},
+}
+
+// configFreeCommands run without loading the config file, as they may be the
+// ones creating it.
+var configFreeCommands = map[string]bool{"restore": true}
+
+// commandOutput is where commands write their results.
+var commandOutput io.Writer = os.Stdout
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: gobble [-config ./gobble.conf] [-disableWatcher] <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+
+	names := []string{}
+
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "  %v\t%v\n", commands[name].usage, commands[name].description)
+	}
+
+	w.Flush()
+}
+
+// parseCommandFlags parses a command's flags, which may appear before, after
+// or between its positional arguments, and returns the positional arguments.
+func parseCommandFlags(flags *flag.FlagSet, args []string) []string {
+	positional := []string{}
+
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+
+		if len(args) == 0 {
+			return positional
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func loadCommandBlog() error {
+	var err error
+	blog, err = LoadBlog(SharedConfig.PostPath, SharedConfig.CommentPath, true)
+
+	return err
+}
+
+func serveCommand(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.BoolVar(&disableWatcher, "disableWatcher", disableWatcher, "disable filesystem change watching")
+	parseCommandFlags(flags, args)
+
+	printInfo()
+
+	ipRateLimiter = NewRateLimiter(SharedConfig.CommentBurstPerIP, time.Duration(SharedConfig.CommentRefillPerIP)*time.Second, filepath.Join(SharedConfig.RateLimitPath, "ip.json"))
+	postRateLimiter = NewRateLimiter(SharedConfig.CommentBurstPerPost, time.Duration(SharedConfig.CommentRefillPerPost)*time.Second, filepath.Join(SharedConfig.RateLimitPath, "post.json"))
+
+	startNotifications()
+
+	var err error
+	blog, err = LoadBlog(SharedConfig.PostPath, SharedConfig.CommentPath, disableWatcher)
+
+	if err != nil {
+		return err
+	}
+
+	err = blog.LoadUrlHistory(SharedConfig.URLHistoryFile)
+
+	if err != nil {
+		return err
+	}
+
+	redirects, err = LoadRedirects(SharedConfig)
+
+	if err != nil {
+		return err
+	}
+
+	startEmailRetention()
+	startWebmentions()
+	startActivityPub()
+	saveStateOnExit()
+
+	prepareHandler()
+
+	return nil
+}
+
+// saveStateOnExit writes any state that is saved lazily, such as the rate
+// limits and redirect hits, when the server is interrupted or terminated.
+func saveStateOnExit() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+
+		ipRateLimiter.Flush()
+		postRateLimiter.Flush()
+		redirects.Flush()
+
+		os.Exit(0)
+	}()
+}
+
+// newCommand writes a new post with the given title, ready to be edited.
+func newCommand(args []string) error {
+	flags := flag.NewFlagSet("new", flag.ExitOnError)
+	tagList := flags.String("tags", "", "comma-separated list of tags")
+	dateString := flags.String("date", "", "publish date, in the format \"2006-01-02 15:04:05\" (defaults to now)")
+	positional := parseCommandFlags(flags, args)
+
+	if len(positional) != 1 || len(strings.TrimSpace(positional[0])) == 0 {
+		return errors.New("A single title is required, for example: gobble new \"My Post\" -tags a,b")
+	}
+
+	metadata := BlogPostMetadata{Title: strings.TrimSpace(positional[0]), Date: time.Now()}
+
+	if len(*dateString) > 0 {
+		date, err := time.ParseInLocation("2006-01-02 15:04:05", *dateString, time.Local)
+
+		if err != nil {
+			date, err = time.ParseInLocation("2006-01-02", *dateString, time.Local)
+		}
+
+		if err != nil {
+			return fmt.Errorf("Invalid date %v", *dateString)
+		}
+
+		metadata.Date = date
+	}
+
+	for _, tag := range strings.Split(*tagList, ",") {
+		if tag = strings.TrimSpace(tag); len(tag) > 0 {
+			metadata.Tags = append(metadata.Tags, tag)
+		}
+	}
+
+	err := loadCommandBlog()
+
+	if err != nil {
+		return err
+	}
+
+	metadata.Date = stringToTime(timeToString(metadata.Date))
+
+	post := &BlogPost{Metadata: metadata}
+
+	if existing, err := blog.PostWithUrl(post.urlFromBlogPostProperties()); err == nil {
+		return fmt.Errorf("The post %v already has the URL %v", existing.Filename, existing.Url)
+	}
+
+	filename := blog.NewPostFilename(metadata.Title, metadata.Date)
+	post, err = blog.WritePost(filename, metadata, "\n")
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(commandOutput, filepath.Join(SharedConfig.PostPath, post.Filename))
+
+	return nil
+}
+
+func listCommand(args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	tag := flags.String("tag", "", "only list posts with this tag")
+	parseCommandFlags(flags, args)
+
+	err := loadCommandBlog()
+
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(commandOutput, 0, 4, 2, ' ', 0)
+
+	for _, post := range blog.posts {
+		if len(*tag) > 0 && !post.ContainsTag(*tag) {
+			continue
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", timeToString(post.Metadata.Date), post.Filename, post.Metadata.Title, strings.Join(post.Metadata.Tags, ", "))
+	}
+
+	return w.Flush()
+}
+
+func tagsCommand(args []string) error {
+	flags := flag.NewFlagSet("tags", flag.ExitOnError)
+	parseCommandFlags(flags, args)
+
+	err := loadCommandBlog()
+
+	if err != nil {
+		return err
+	}
+
+	tags := blog.AllTags()
+	w := tabwriter.NewWriter(commandOutput, 0, 4, 2, ' ', 0)
+
+	for _, name := range blog.AllTagNames() {
+		fmt.Fprintf(w, "%v\t%v\n", name, tags[name])
+	}
+
+	return w.Flush()
+}
+
+// commentsCommand lists comments with the post they were left on, so that
+// spam can be reviewed without the admin pages.
+func commentsCommand(args []string) error {
+	flags := flag.NewFlagSet("comments", flag.ExitOnError)
+	spam := flags.Bool("spam", false, "only list comments marked as spam")
+	postUrl := flags.String("post", "", "only list comments on the post with this URL")
+	parseCommandFlags(flags, args)
+
+	err := loadCommandBlog()
+
+	if err != nil {
+		return err
+	}
+
+	type listedComment struct {
+		post    *BlogPost
+		comment *Comment
+	}
+
+	comments := []listedComment{}
+
+	for _, post := range blog.posts {
+		if len(*postUrl) > 0 && post.Url != strings.Trim(strings.TrimPrefix(*postUrl, "/posts/"), "/") {
+			continue
+		}
+
+		for _, comment := range post.Comments {
+			if !*spam || comment.Metadata.IsSpam {
+				comments = append(comments, listedComment{post, comment})
+			}
+		}
+	}
+
+	sort.SliceStable(comments, func(i, j int) bool {
+		return comments[i].comment.Metadata.Date.After(comments[j].comment.Metadata.Date)
+	})
+
+	w := tabwriter.NewWriter(commandOutput, 0, 4, 2, ' ', 0)
+
+	for _, c := range comments {
+		status := ""
+
+		if c.comment.Metadata.IsSpam {
+			status = "spam"
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n",
+			timeToString(c.comment.Metadata.Date),
+			c.post.Url,
+			c.comment.Filename,
+			c.comment.Metadata.Author,
+			status,
+			commentExcerpt(c.comment.Body.Markdown))
+	}
+
+	return w.Flush()
+}
+
+// commentExcerpt returns the start of a comment on a single line.
+func commentExcerpt(body string) string {
+	excerpt := strings.Join(strings.Fields(body), " ")
+
+	if runes := []rune(excerpt); len(runes) > 60 {
+		excerpt = string(runes[:60]) + "…"
+	}
+
+	return excerpt
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createCommandTestBlog(t *testing.T) (string, *bytes.Buffer) {
+	dir := createMicropubTestBlog(t)
+
+	SharedConfig.PostPath = filepath.Join(dir, "posts")
+	SharedConfig.CommentPath = filepath.Join(dir, "comments")
+
+	output := &bytes.Buffer{}
+	commandOutput = output
+
+	return dir, output
+}
+
+func TestParseCommandFlags(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	tags := flags.String("tags", "", "")
+	draft := flags.Bool("draft", false, "")
+
+	positional := parseCommandFlags(flags, []string{"-draft", "My Title", "--tags", "a,b", "extra"})
+
+	if len(positional) != 2 || positional[0] != "My Title" || positional[1] != "extra" {
+		t.Errorf("Unexpected positional arguments %v", positional)
+	}
+
+	if *tags != "a,b" || !*draft {
+		t.Errorf("Flags not parsed: %v %v", *tags, *draft)
+	}
+}
+
+func TestNewCommand(t *testing.T) {
+	dir, output := createCommandTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	err := newCommand([]string{"Hello, World!", "--tags", "go, news", "-date", "2014-01-26 10:30:00"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "posts", "hello-world.md")
+
+	if strings.TrimSpace(output.String()) != path {
+		t.Errorf("Unexpected output %q", output.String())
+	}
+
+	content, _ := ioutil.ReadFile(path)
+	expected := "Title: Hello, World!\nDate: 2014-01-26 10:30:00\nTags: go, news\n\n"
+
+	if string(content) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(content))
+	}
+
+	err = newCommand([]string{"Hello, World!", "-date", "2014-01-26"})
+
+	if err == nil {
+		t.Error("Post with a duplicate URL was created")
+	}
+
+	err = newCommand([]string{"Hello, World!"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "posts", "hello-world-2.md")); err != nil {
+		t.Error("Second post did not get a unique filename")
+	}
+
+	if err := newCommand([]string{}); err == nil {
+		t.Error("Post without a title was created")
+	}
+}
+
+func TestListAndTagsCommands(t *testing.T) {
+	dir, output := createCommandTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	date := time.Date(2014, 1, 26, 10, 0, 0, 0, time.UTC)
+
+	blog.WritePost("first.md", BlogPostMetadata{Title: "First", Date: date, Tags: []string{"news"}}, "Body")
+	blog.WritePost("second.md", BlogPostMetadata{Title: "Second", Date: date.AddDate(0, 0, 1), Tags: []string{"go", "news"}}, "Body")
+
+	err := listCommand([]string{"-tag", "go"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(output.String(), "second.md") || strings.Contains(output.String(), "first.md") {
+		t.Errorf("Unexpected list %q", output.String())
+	}
+
+	output.Reset()
+	tagsCommand([]string{})
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+
+	if len(lines) != 2 || strings.Join(strings.Fields(lines[1]), " ") != "news 2" {
+		t.Errorf("Unexpected tags %q", output.String())
+	}
+}
+
+func TestCommentsCommand(t *testing.T) {
+	dir, output := createCommandTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	post, _ := blog.WritePost("first.md", BlogPostMetadata{Title: "First", Date: time.Now()}, "Body")
+
+	ham := NewComment("Joe", "joe@example.com", "A genuine comment", false)
+	ham.Filename = "ham.md"
+	spam := NewComment("Spammer", "spam@example.com", "Buy   things\nnow", true)
+	spam.Filename = "spam.md"
+
+	post.writeComment(ham)
+	post.writeComment(spam)
+
+	err := commentsCommand([]string{"-spam"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(output.String(), "spam.md") || !strings.Contains(output.String(), "Buy things now") || strings.Contains(output.String(), "ham.md") {
+		t.Errorf("Unexpected comments %q", output.String())
+	}
+}
@@ -3,29 +3,35 @@ package main
 import (
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 )
 
 type CommentMetadata struct {
-	Author string
-	Email  string
-	Date   time.Time
-	IsSpam bool
+	Author    string
+	Email     string
+	Date      time.Time
+	IsSpam    bool
+	Notify    bool
+	Source    string
+	AuthorUrl string
 }
 
 type Comment struct {
 	Metadata     CommentMetadata
 	Body         BlogItemBody
+	Filename     string
 	ModifiedDate time.Time
 }
 
 func LoadComment(path string) (*Comment, error) {
 	c := &Comment{}
+	c.Filename = filepath.Base(path)
 
 	err := loadBlogFile(path, func(fileInfo os.FileInfo) {
 		c.ModifiedDate = fileInfo.ModTime()
-	}, func(key, value string) {
+	}, func(key, value string, raw interface{}) {
 		switch key {
 		case "author":
 			c.Metadata.Author = value
@@ -35,9 +41,15 @@ func LoadComment(path string) (*Comment, error) {
 			c.Metadata.Date = stringToTime(value)
 		case "spam":
 			c.Metadata.IsSpam = value == "true"
+		case "notify":
+			c.Metadata.Notify = value == "true"
+		case "source":
+			c.Metadata.Source = value
+		case "authorurl":
+			c.Metadata.AuthorUrl = value
 		default:
 		}
-	}, func(text string) {
+	}, func(text string, line int) {
 		bytes := []byte(text)
 
 		c.Body.Markdown = text
@@ -60,6 +72,7 @@ func NewComment(author, email, body string, isSpam bool) *Comment {
 	c.Metadata.Email = email
 	c.Metadata.Date = time.Now()
 	c.Metadata.IsSpam = isSpam
+	c.Filename = timeToFilename(c.Metadata.Date)
 	c.Body.Markdown = body
 	c.Body.HTML = convertMarkdownToHtml(&html)
 
@@ -101,5 +114,17 @@ func (m *CommentMetadata) String() string {
 		content += "Spam: true\n"
 	}
 
+	if m.Notify {
+		content += "Notify: true\n"
+	}
+
+	if len(m.Source) > 0 {
+		content += "Source: " + m.Source + "\n"
+	}
+
+	if len(m.AuthorUrl) > 0 {
+		content += "AuthorUrl: " + m.AuthorUrl + "\n"
+	}
+
 	return content
 }
@@ -5,6 +5,8 @@ import (
 	"path/filepath"
 )
 
+const couldNotFindCommentErrorMessage = "Could not find comment"
+
 type Comments []*Comment
 
 func LoadComments(path string) (Comments, error) {
@@ -4,29 +4,77 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/ant512/gobble/mailer"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 type Config struct {
-	Name                string
-	CommentsOpenForDays int
-	PostsPerPage        int
-	Description         string
-	Address             string
-	MediaPath           string
-	Port                int64
-	PostPath            string
-	CommentPath         string
-	Theme               string
-	ThemePath           string
-	HighlightPath       string
-	AkismetAPIKey       string
-	RecaptchaPublicKey  string
-	RecaptchaPrivateKey string
-	StaticFilePath      string
-	StaticFiles         map[string]string
+	Name                 string
+	CommentsOpenForDays  int
+	PostsPerPage         int
+	Description          string
+	Address              string
+	MediaPath            string
+	Port                 int64
+	PostPath             string
+	CommentPath          string
+	Theme                string
+	ThemePath            string
+	HighlightPath        string
+	AkismetAPIKey        string
+	RecaptchaPublicKey   string
+	RecaptchaPrivateKey  string
+	StaticFilePath       string
+	StaticFiles          map[string]string
+	AdminUsername        string
+	AdminPassword        string
+	RateLimitPath        string
+	CommentBurstPerIP    int
+	CommentRefillPerIP   int
+	CommentBurstPerPost  int
+	CommentRefillPerPost int
+	TrustedProxies       []string
+	TrustedProxyHeader   string
+	SMTPHost             string
+	SMTPPort             int
+	SMTPUsername         string
+	SMTPPassword         string
+	SMTPTLS              string
+	NotificationEmail    string
+	NotificationFrom     string
+	SecretKey            string
+	Avatars              string
+	AvatarFormat         string
+	AvatarPath           string
+	EmailRetentionDays   int
+	Webmentions          bool
+	APITokens            []string
+	APIOrigins           []string
+	ActivityPub          bool
+	ActivityPubUsername  string
+	ActivityPubPath      string
+	Permalink            string
+	URLHistoryFile       string
+	RedirectFile         string
+	Redirects            []string
+	LegacyRedirects      bool
+	RedirectHitsFile     string
+	SummaryWords         int
+	ListContent          string
+	FeedContent          string
+	TableOfContents      bool
+	HeadingAnchors       bool
+	Markdown             string
+	MarkdownExtensions   []string
+	Highlighting         string
+	HighlightStyle       string
+	HighlightLineNumbers bool
+	trustedProxies       []*net.IPNet
 }
 
 func LoadConfig(filename string) (*Config, error) {
@@ -60,6 +108,10 @@ func (c *Config) FullThemePath() string {
 	return c.ThemePath + string(filepath.Separator) + c.Theme
 }
 
+func (c *Config) NotificationsEnabled() bool {
+	return len(c.SMTPHost) > 0
+}
+
 func (c *Config) validateConfig() error {
 	_, err := os.Stat(c.FullThemePath())
 
@@ -96,6 +148,84 @@ func (c *Config) validateConfig() error {
 		return errors.New(msg)
 	}
 
+	c.trustedProxies, err = parseTrustedProxies(c.TrustedProxies)
+
+	if err != nil {
+		return err
+	}
+
+	c.TrustedProxyHeader, err = parseTrustedProxyHeader(c.TrustedProxyHeader)
+
+	if err != nil {
+		return err
+	}
+
+	if len(c.Avatars) > 0 && c.Avatars != gravatarAvatars && c.Avatars != identiconAvatars {
+		msg := fmt.Sprintf("Unknown avatar type %v", c.Avatars)
+		return errors.New(msg)
+	}
+
+	// Without a secret key, identicon hashes could be reversed to find
+	// commenters' email addresses.
+	if c.Avatars == identiconAvatars && len(c.SecretKey) == 0 {
+		return errors.New("A secret key is required to generate identicons")
+	}
+
+	if c.AvatarFormat != "png" && c.AvatarFormat != "svg" {
+		msg := fmt.Sprintf("Unknown avatar format %v", c.AvatarFormat)
+		return errors.New(msg)
+	}
+
+	if !strings.Contains(c.Permalink, ":slug") && !strings.Contains(c.Permalink, ":title") && !strings.Contains(c.Permalink, ":filename") && !strings.Contains(c.Permalink, ":id") {
+		return errors.New("The permalink pattern must include :slug, :title, :filename or :id")
+	}
+
+	if c.ListContent != fullContent && c.ListContent != summaryContent {
+		msg := fmt.Sprintf("Unknown list content %v", c.ListContent)
+		return errors.New(msg)
+	}
+
+	if c.FeedContent != fullContent && c.FeedContent != summaryContent {
+		msg := fmt.Sprintf("Unknown feed content %v", c.FeedContent)
+		return errors.New(msg)
+	}
+
+	err = validateMarkdownOptions(markdownOptions{renderer: c.Markdown, extensions: c.MarkdownExtensions})
+
+	if err != nil {
+		msg := fmt.Sprintf("Invalid Markdown settings: %v", err)
+		return errors.New(msg)
+	}
+
+	if c.Highlighting != serverHighlighting && c.Highlighting != clientHighlighting {
+		msg := fmt.Sprintf("Unknown highlighting %v", c.Highlighting)
+		return errors.New(msg)
+	}
+
+	if _, exists := styles.Registry[c.HighlightStyle]; !exists {
+		msg := fmt.Sprintf("Unknown highlight style %v", c.HighlightStyle)
+		return errors.New(msg)
+	}
+
+	if c.NotificationsEnabled() {
+		if c.SMTPTLS != mailer.TLSNone && c.SMTPTLS != mailer.TLSStartTLS && c.SMTPTLS != mailer.TLSImplicit {
+			msg := fmt.Sprintf("Unknown SMTP TLS mode %v", c.SMTPTLS)
+			return errors.New(msg)
+		}
+
+		if len(c.NotificationEmail) == 0 {
+			return errors.New("A notification email address is required to send notifications")
+		}
+
+		if len(c.SecretKey) == 0 {
+			return errors.New("A secret key is required to send notifications")
+		}
+
+		if len(c.NotificationFrom) == 0 {
+			c.NotificationFrom = c.NotificationEmail
+		}
+	}
+
 	return nil
 }
 
@@ -112,4 +242,32 @@ func (c *Config) setDefaults() {
 	c.StaticFilePath = "./files"
 	c.HighlightPath = "./highlight"
 	c.Theme = "grump"
+	c.RateLimitPath = "./ratelimits"
+	c.CommentBurstPerIP = 5
+	c.CommentRefillPerIP = 120
+	c.CommentBurstPerPost = 20
+	c.CommentRefillPerPost = 60
+	c.TrustedProxies = []string{}
+	c.TrustedProxyHeader = "X-Forwarded-For"
+	c.SMTPPort = 587
+	c.SMTPTLS = mailer.TLSStartTLS
+	c.AvatarFormat = "svg"
+	c.AvatarPath = "./avatars"
+	c.APITokens = []string{}
+	c.APIOrigins = []string{}
+	c.ActivityPubUsername = "blog"
+	c.ActivityPubPath = "./activitypub"
+	c.Permalink = defaultPermalinkPattern
+	c.URLHistoryFile = "./urls.json"
+	c.RedirectFile = "./redirects.txt"
+	c.Redirects = []string{}
+	c.LegacyRedirects = true
+	c.RedirectHitsFile = "./redirecthits.json"
+	c.SummaryWords = defaultSummaryWords
+	c.ListContent = fullContent
+	c.FeedContent = fullContent
+	c.Markdown = legacyMarkdown
+	c.MarkdownExtensions = defaultMarkdownExtensions
+	c.Highlighting = clientHighlighting
+	c.HighlightStyle = defaultHighlightStyle
 }
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// exportManifestFilename is written to the root of an export.  It records
+// what was exported so that later exports only render posts that have
+// changed, and can remove pages that no longer exist.
+const exportManifestFilename = ".gobble-export.json"
+
+type exportManifest struct {
+	Posts map[string]string `json:"posts"`
+	Files []string          `json:"files"`
+}
+
+type exporter struct {
+	out      string
+	handler  http.Handler
+	previous exportManifest
+	current  exportManifest
+	written  map[string]bool
+	rendered int
+	skipped  int
+}
+
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	out := flags.String("out", "./public", "directory to export the site to")
+	full := flags.Bool("full", false, "re-export every post, even if it hasn't changed")
+	flags.Parse(args)
+
+	err := loadCommandBlog()
+
+	if err != nil {
+		return err
+	}
+
+	return exportSite(*out, *full)
+}
+
+// exportSite renders the whole blog with the active theme and writes it to a
+// directory as static files.  Pages are written as index.html files in
+// directories matching their URLs, so that the export can be served by any
+// web server.  Unless full is true, posts that haven't changed since the last
+// export into the same directory are left alone.
+func exportSite(out string, full bool) error {
+	e := &exporter{
+		out:     out,
+		handler: newRouter(),
+		current: exportManifest{Posts: make(map[string]string)},
+		written: make(map[string]bool),
+	}
+
+	err := os.MkdirAll(out, 0775)
+
+	if err != nil {
+		return err
+	}
+
+	if !full {
+		e.loadManifest()
+	}
+
+	err = e.exportPages()
+
+	if err != nil {
+		return err
+	}
+
+	err = e.exportPosts()
+
+	if err != nil {
+		return err
+	}
+
+	err = e.exportStaticFiles()
+
+	if err != nil {
+		return err
+	}
+
+	e.removeStaleFiles()
+
+	err = e.saveManifest()
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(commandOutput, "Exported %v posts to \"%v\" (%v unchanged)\n", e.rendered, out, e.skipped)
+
+	return nil
+}
+
+// exportPages renders the home page, tag pages, archive and feed.  These
+// list many posts, so they are always rendered.
+func (e *exporter) exportPages() error {
+	blog.mutex.RLock()
+	homePageCount := pageCount(len(blog.posts))
+	blog.mutex.RUnlock()
+
+	pages := []string{"/", "/archive/", "/tags/"}
+
+	for page := 1; page <= homePageCount; page++ {
+		pages = append(pages, "/page/"+strconv.Itoa(page))
+	}
+
+	for _, tag := range blog.AllTagNames() {
+		_, count := blog.PostsWithTag(tag, 0, 0)
+		pages = append(pages, "/tags/"+url.PathEscape(tag))
+
+		for page := 1; page <= pageCount(count); page++ {
+			pages = append(pages, "/tags/"+url.PathEscape(tag)+"/"+strconv.Itoa(page))
+		}
+	}
+
+	for _, page := range pages {
+		err := e.exportPage(page)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// The feed is linked to as /rss, which is served from rss/index.html, but
+	// is also written with an extension so that servers send the right
+	// content type.
+	content, err := e.render("/rss")
+
+	if err != nil {
+		return err
+	}
+
+	err = e.writeFile("rss/index.html", content)
+
+	if err == nil {
+		err = e.writeFile("rss.xml", content)
+	}
+
+	return err
+}
+
+// exportPosts renders each post that has changed since the last export, along
+// with the avatars of its commenters.
+func (e *exporter) exportPosts() error {
+	blog.mutex.RLock()
+	posts := blog.posts
+	blog.mutex.RUnlock()
+
+	for _, post := range posts {
+		filename := filepath.ToSlash(filepath.Join("posts", filepath.FromSlash(post.Url), "index.html"))
+		fingerprint := exportFingerprint(post)
+
+		e.current.Posts[post.Filename] = fingerprint
+
+		if e.previous.Posts[post.Filename] == fingerprint && e.fileExists(filename) {
+			e.written[filename] = true
+			e.skipped++
+		} else {
+			content, err := e.render("/posts/" + post.Url)
+
+			if err != nil {
+				return err
+			}
+
+			err = e.writeFile(filename, content)
+
+			if err != nil {
+				return err
+			}
+
+			e.rendered++
+		}
+
+		post.mutex.RLock()
+		comments := post.NonSpamComments()
+		post.mutex.RUnlock()
+
+		for _, comment := range comments {
+			avatar := comment.AvatarUrl()
+
+			if !strings.HasPrefix(avatar, "/avatars/") || e.written[avatar[1:]] {
+				continue
+			}
+
+			content, err := e.render(avatar)
+
+			if err != nil {
+				return err
+			}
+
+			err = e.writeFile(avatar[1:], content)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// exportStaticFiles copies the theme, highlight and media directories, the
+// configured static files and the stylesheet for highlighted code.  Files that
+// already exist with the same size and modification time are left alone.
+func (e *exporter) exportStaticFiles() error {
+	directories := map[string]string{
+		"theme":     SharedConfig.FullThemePath(),
+		"highlight": SharedConfig.HighlightPath,
+		"media":     SharedConfig.MediaPath,
+	}
+
+	for name, source := range directories {
+		err := filepath.Walk(source, func(file string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+
+			relative, err := filepath.Rel(source, file)
+
+			if err != nil {
+				return err
+			}
+
+			return e.copyFile(file, filepath.ToSlash(filepath.Join(name, relative)), info)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for address, filename := range SharedConfig.StaticFiles {
+		source := filepath.Join(SharedConfig.StaticFilePath, filename)
+		info, err := os.Stat(source)
+
+		if err != nil {
+			return err
+		}
+
+		err = e.copyFile(source, strings.TrimPrefix(address, "/"), info)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// The stylesheet for highlighted code is generated unless the highlight
+	// directory has its own copy.
+	stylesheet := strings.TrimPrefix(SharedConfig.HighlightStylesheet(), "/")
+
+	if SharedConfig.Highlighting == serverHighlighting && !e.written[stylesheet] {
+		content, err := e.render("/" + stylesheet)
+
+		if err != nil {
+			return err
+		}
+
+		err = e.writeFile(stylesheet, content)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *exporter) exportPage(page string) error {
+	content, err := e.render(page)
+
+	if err != nil {
+		return err
+	}
+
+	unescaped, err := url.PathUnescape(page)
+
+	if err != nil {
+		return err
+	}
+
+	return e.writeFile(strings.TrimPrefix(path.Join(unescaped, "index.html"), "/"), content)
+}
+
+// render requests a page from the blog's own router.
+func (e *exporter) render(page string) ([]byte, error) {
+	req, err := http.NewRequest("GET", page, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	w := httptest.NewRecorder()
+	e.handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		msg := fmt.Sprintf("Could not export %v: status %v", page, w.Code)
+		return nil, errors.New(msg)
+	}
+
+	return w.Body.Bytes(), nil
+}
+
+// writeFile writes a file within the export directory.  Files whose content
+// hasn't changed aren't rewritten, so that their modification times are kept.
+func (e *exporter) writeFile(filename string, content []byte) error {
+	target, err := e.path(filename)
+
+	if err != nil {
+		return err
+	}
+
+	e.written[filepath.ToSlash(filepath.Clean(filename))] = true
+
+	if existing, err := ioutil.ReadFile(target); err == nil && bytes.Equal(existing, content) {
+		return nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(target), 0775)
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(target, content, 0644)
+}
+
+func (e *exporter) copyFile(source, filename string, info os.FileInfo) error {
+	target, err := e.path(filename)
+
+	if err != nil {
+		return err
+	}
+
+	e.written[filepath.ToSlash(filepath.Clean(filename))] = true
+
+	if existing, err := os.Stat(target); err == nil && existing.Size() == info.Size() && existing.ModTime().Equal(info.ModTime()) {
+		return nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(target), 0775)
+
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(source)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := os.Create(target)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	out.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(target, info.ModTime(), info.ModTime())
+}
+
+// path converts a slash-separated filename into a path within the export
+// directory, refusing filenames that would escape it.
+func (e *exporter) path(filename string) (string, error) {
+	target := filepath.Join(e.out, filepath.FromSlash(filename))
+	relative, err := filepath.Rel(e.out, target)
+
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		msg := fmt.Sprintf("Cannot export %v outside of %v", filename, e.out)
+		return "", errors.New(msg)
+	}
+
+	return target, nil
+}
+
+func (e *exporter) fileExists(filename string) bool {
+	target, err := e.path(filename)
+
+	if err != nil {
+		return false
+	}
+
+	_, err = os.Stat(target)
+
+	return err == nil
+}
+
+// removeStaleFiles deletes files written by the last export that weren't
+// written this time, such as the pages of deleted posts, along with any
+// directories left empty.
+func (e *exporter) removeStaleFiles() {
+	for _, filename := range e.previous.Files {
+		if e.written[filename] {
+			continue
+		}
+
+		target, err := e.path(filename)
+
+		if err != nil {
+			continue
+		}
+
+		log.Println("Removing", filename, "from export")
+		os.Remove(target)
+
+		for dir := filepath.Dir(target); dir != filepath.Clean(e.out); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+}
+
+func (e *exporter) loadManifest() {
+	file, err := ioutil.ReadFile(filepath.Join(e.out, exportManifestFilename))
+
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(file, &e.previous)
+
+	if err != nil {
+		log.Println("Ignoring invalid export manifest:", err)
+		e.previous = exportManifest{}
+	}
+}
+
+func (e *exporter) saveManifest() error {
+	for filename := range e.written {
+		e.current.Files = append(e.current.Files, filename)
+	}
+
+	sort.Strings(e.current.Files)
+
+	data, err := json.MarshalIndent(e.current, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(e.out, exportManifestFilename), data, 0644)
+}
+
+// exportFingerprint identifies everything that affects how a post is
+// rendered: its content, its visible comments, the config and the template.
+func exportFingerprint(post *BlogPost) string {
+	hash := sha1.New()
+	config, _ := json.Marshal(SharedConfig)
+
+	hash.Write(config)
+
+	if info, err := os.Stat(SharedConfig.FullThemePath() + "/templates/post.html"); err == nil {
+		fmt.Fprintln(hash, info.ModTime().UnixNano())
+	}
+
+	post.mutex.RLock()
+	io.WriteString(hash, post.String())
+
+	for _, comment := range post.NonSpamComments() {
+		io.WriteString(hash, comment.String())
+	}
+	post.mutex.RUnlock()
+
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+func pageCount(postCount int) int {
+	return (postCount + SharedConfig.PostsPerPage - 1) / SharedConfig.PostsPerPage
+}
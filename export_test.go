@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createExportTestBlog(t *testing.T) string {
+	dir := createMicropubTestBlog(t)
+
+	os.Mkdir(filepath.Join(dir, "files"), 0775)
+	ioutil.WriteFile(filepath.Join(dir, "files", "robots.txt"), []byte("User-agent: *"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "media", "photo.jpg"), []byte("jpeg"), 0644)
+
+	SharedConfig = &Config{
+		Name:           "Test Blog",
+		Address:        "http://blog.example",
+		PostsPerPage:   1,
+		Theme:          "grump",
+		ThemePath:      "./themes",
+		HighlightPath:  "./highlight",
+		Highlighting:   serverHighlighting,
+		HighlightStyle: "monokai",
+		MediaPath:      filepath.Join(dir, "media"),
+		StaticFilePath: filepath.Join(dir, "files"),
+		StaticFiles:    map[string]string{"/robots.txt": "robots.txt"},
+	}
+
+	date := time.Date(2014, 1, 26, 10, 0, 0, 0, time.UTC)
+
+	blog.WritePost("first.md", BlogPostMetadata{Title: "First Post", Date: date, Tags: []string{"news"}}, "First body")
+	blog.WritePost("second.md", BlogPostMetadata{Title: "Second Post", Date: date.AddDate(0, 0, 1), Tags: []string{"news", "go"}}, "Second body")
+
+	return dir
+}
+
+func TestExportSite(t *testing.T) {
+	dir := createExportTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "public")
+
+	err := exportSite(out, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := []string{
+		"index.html",
+		"page/1/index.html",
+		"page/2/index.html",
+		"posts/2014/01/26/first-post/index.html",
+		"posts/2014/01/27/second-post/index.html",
+		"tags/index.html",
+		"tags/news/index.html",
+		"tags/news/2/index.html",
+		"tags/go/1/index.html",
+		"archive/index.html",
+		"rss/index.html",
+		"rss.xml",
+		"theme/templates/home.html",
+		"highlight/highlight.pack.js",
+		"highlight/styles/monokai.css",
+		"media/photo.jpg",
+		"robots.txt",
+		exportManifestFilename,
+	}
+
+	for _, file := range files {
+		if _, err := os.Stat(filepath.Join(out, file)); err != nil {
+			t.Errorf("%v was not exported", file)
+		}
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(out, "posts/2014/01/26/first-post/index.html"))
+
+	if !strings.Contains(string(content), "First body") {
+		t.Errorf("Post page does not contain the post: %v", string(content))
+	}
+
+	content, _ = ioutil.ReadFile(filepath.Join(out, "index.html"))
+
+	if !strings.Contains(string(content), `href="/page/2"`) {
+		t.Errorf("Home page does not link to the next page: %v", string(content))
+	}
+}
+
+func TestExportSiteOnlyRendersChangedPosts(t *testing.T) {
+	dir := createExportTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "public")
+	exportSite(out, false)
+
+	post, _ := blog.PostWithFilename("first.md")
+	blog.WritePost("first.md", post.Metadata, "Changed body")
+
+	second, _ := blog.PostWithFilename("second.md")
+	blog.DeletePost("second.md")
+
+	e := &exporter{out: out, handler: newRouter(), current: exportManifest{Posts: make(map[string]string)}, written: make(map[string]bool)}
+	e.loadManifest()
+
+	err := e.exportPosts()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.rendered != 1 || e.skipped != 0 {
+		t.Errorf("Expected 1 rendered post, got %v rendered and %v skipped", e.rendered, e.skipped)
+	}
+
+	err = exportSite(out, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(out, "posts/2014/01/26/first-post/index.html"))
+
+	if !strings.Contains(string(content), "Changed body") {
+		t.Error("Changed post was not exported")
+	}
+
+	if _, err := os.Stat(filepath.Join(out, "posts", filepath.FromSlash(second.Url))); !os.IsNotExist(err) {
+		t.Error("Deleted post was not removed from the export")
+	}
+
+	if _, err := os.Stat(filepath.Join(out, "page/2/index.html")); !os.IsNotExist(err) {
+		t.Error("Stale page was not removed from the export")
+	}
+
+	e = &exporter{out: out, handler: newRouter(), current: exportManifest{Posts: make(map[string]string)}, written: make(map[string]bool)}
+	e.loadManifest()
+	e.exportPosts()
+
+	if e.rendered != 0 || e.skipped != 1 {
+		t.Errorf("Expected 1 skipped post, got %v rendered and %v skipped", e.rendered, e.skipped)
+	}
+}
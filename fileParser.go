@@ -1,14 +1,22 @@
 package main
 
 import (
-	"github.com/russross/blackfriday"
+	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
+	"sort"
 	"strings"
 )
 
-type metadataParseHandler func(key, value string)
-type bodyParseHandler func(value string)
+// metadataParseHandler receives each metadata key, in lowercase, and its value
+// as a string.  Values from YAML or TOML front matter are also passed as they
+// were decoded in raw, so that lists and other structured values aren't lost.
+// raw is nil for the "Key: value" header format.
+type metadataParseHandler func(key, value string, raw interface{})
+
+// bodyParseHandler receives the body and the 1-based line on which it starts.
+type bodyParseHandler func(value string, line int)
 type fileInfoParseHandler func(fileInfo os.FileInfo)
 
 func loadBlogFile(path string, fileInfoHandler fileInfoParseHandler, metadataHandler metadataParseHandler, bodyHandler bodyParseHandler) error {
@@ -27,25 +35,59 @@ func loadBlogFile(path string, fileInfoHandler fileInfoParseHandler, metadataHan
 		return err
 	}
 
-	parseBlogFile(string(file), metadataHandler, bodyHandler)
+	// A file with broken front matter is still loaded, without its metadata,
+	// so that one bad file can't stop the blog from starting.
+	err = parseBlogFile(string(file), metadataHandler, bodyHandler)
+
+	if err != nil {
+		log.Println(path+":", err)
+	}
 
 	return nil
 }
 
-func parseBlogFile(text string, metadataHandler metadataParseHandler, bodyHandler bodyParseHandler) {
+func parseBlogFile(text string, metadataHandler metadataParseHandler, bodyHandler bodyParseHandler) error {
 	text = strings.Replace(text, "\r", "", -1)
 
-	headerSize := parseBlogFileHeader(text, metadataHandler)
+	headerSize, err := parseBlogFileHeader(text, metadataHandler)
+
+	// A file that is all header may not end with a newline.
+	if headerSize > len(text) {
+		headerSize = len(text)
+	}
+
 	body := text[headerSize:]
-	bodyHandler(body)
+	bodyHandler(body, strings.Count(text[:headerSize], "\n")+1)
+
+	return err
 }
 
-func parseBlogFileHeader(text string, handler metadataParseHandler) int {
+func parseBlogFileHeader(text string, handler metadataParseHandler) (int, error) {
+	headerSize, _, err := scanBlogFileHeader(text, func(line int, key, value string, raw interface{}) {
+		handler(key, value, raw)
+	})
+
+	return headerSize, err
+}
+
+// scanBlogFileHeader passes each metadata key and value to the handler along
+// with its 1-based line number.  The header is either YAML front matter
+// between "---" lines, TOML front matter between "+++" lines, or the original
+// format of "Key: value" lines, which ends at the first line without a colon.
+// It returns the size of the header in bytes and the number of lines it
+// occupies.
+func scanBlogFileHeader(text string, handler func(line int, key, value string, raw interface{})) (int, int, error) {
+	delimiter, matter, body := splitFrontMatter(text)
+
+	if len(delimiter) > 0 {
+		return scanFrontMatter(text, delimiter, matter, body, handler)
+	}
 
 	lines := strings.Split(text, "\n")
 	headerSize := 0
+	headerLines := 0
 
-	for _, line := range lines {
+	for i, line := range lines {
 		if strings.Contains(line, ":") {
 			components := strings.Split(line, ":")
 			key := strings.ToLower(strings.TrimSpace(components[0]))
@@ -53,23 +95,57 @@ func parseBlogFileHeader(text string, handler metadataParseHandler) int {
 			value := strings.TrimSpace(line[separatorIndex:])
 
 			headerSize += len(line) + 1
+			headerLines++
 
-			handler(key, value)
+			handler(i+1, key, value, nil)
 		} else {
 			break
 		}
 	}
 
-	return headerSize
+	return headerSize, headerLines, nil
 }
 
-func convertMarkdownToHtml(markdown *[]byte) string {
-	htmlFlags := blackfriday.HTML_USE_SMARTYPANTS
-	extensions := blackfriday.EXTENSION_AUTOLINK | blackfriday.EXTENSION_FENCED_CODE | blackfriday.EXTENSION_NO_INTRA_EMPHASIS | blackfriday.EXTENSION_STRIKETHROUGH
+func scanFrontMatter(text, delimiter, matter, body string, handler func(line int, key, value string, raw interface{})) (int, int, error) {
+	headerSize := len(text) - len(body)
+	headerLines := strings.Count(text[:headerSize], "\n")
+
+	if !strings.HasSuffix(text[:headerSize], "\n") {
+		headerLines++
+	}
 
-	renderer := blackfriday.HtmlRenderer(htmlFlags, "", "")
+	values, err := parseFrontMatter(delimiter, matter)
 
-	output := blackfriday.Markdown(*markdown, renderer, extensions)
+	if err != nil {
+		return headerSize, headerLines, fmt.Errorf("invalid front matter: %v", err)
+	}
+
+	// Keys are passed in the order they appear in the file.  The delimiter is
+	// on the first line, so the front matter starts on the second.
+	lines := frontMatterKeyLines(matter)
+	keys := []string{}
+
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if lines[keys[i]] != lines[keys[j]] {
+			return lines[keys[i]] < lines[keys[j]]
+		}
+
+		return keys[i] < keys[j]
+	})
+
+	for _, key := range keys {
+		line := 1
+
+		if l, exists := lines[key]; exists {
+			line = l + 2
+		}
+
+		handler(line, key, frontMatterMetadataValue(key, values[key]), values[key])
+	}
 
-	return string(output)
+	return headerSize, headerLines, nil
 }
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const yamlFrontMatterDelimiter = "---"
+const tomlFrontMatterDelimiter = "+++"
+
+// frontMatterDateLayouts are the date formats used by Jekyll and Hugo when a
+// date isn't already parsed as a timestamp.
+var frontMatterDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 -07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// splitFrontMatter separates YAML front matter, between "---" lines, or TOML
+// front matter, between "+++" lines, from the rest of a file.  The returned
+// delimiter is empty if the file has no front matter.
+func splitFrontMatter(text string) (delimiter, matter, body string) {
+	text = strings.TrimPrefix(strings.Replace(text, "\r", "", -1), "\ufeff")
+
+	for _, d := range []string{yamlFrontMatterDelimiter, tomlFrontMatterDelimiter} {
+		if !strings.HasPrefix(text, d+"\n") {
+			continue
+		}
+
+		rest := text[len(d)+1:]
+
+		if strings.HasPrefix(rest, d+"\n") || rest == d {
+			return d, "", strings.TrimPrefix(rest[len(d):], "\n")
+		}
+
+		end := strings.Index(rest, "\n"+d+"\n")
+
+		if end == -1 {
+			if !strings.HasSuffix(rest, "\n"+d) {
+				continue
+			}
+
+			return d, rest[:len(rest)-len(d)-1], ""
+		}
+
+		return d, rest[:end], rest[end+len(d)+2:]
+	}
+
+	return "", "", text
+}
+
+// parseFrontMatter decodes front matter into a map with lowercase keys.
+func parseFrontMatter(delimiter, matter string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	var err error
+
+	switch delimiter {
+	case yamlFrontMatterDelimiter:
+		err = yaml.Unmarshal([]byte(matter), &values)
+	case tomlFrontMatterDelimiter:
+		_, err = toml.Decode(matter, &values)
+	default:
+		err = errors.New("Unknown front matter delimiter " + delimiter)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	lowercase := make(map[string]interface{}, len(values))
+
+	for key, value := range values {
+		lowercase[strings.ToLower(key)] = value
+	}
+
+	return lowercase, nil
+}
+
+func frontMatterString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case time.Time:
+		return timeToString(v)
+	}
+
+	return fmt.Sprint(value)
+}
+
+// frontMatterList reads a list of strings, which may also be written as a
+// single comma or space separated string.
+func frontMatterList(value interface{}) []string {
+	list := []string{}
+
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if s := frontMatterString(item); len(s) > 0 {
+				list = append(list, s)
+			}
+		}
+	case []string:
+		for _, item := range v {
+			if s := strings.TrimSpace(item); len(s) > 0 {
+				list = append(list, s)
+			}
+		}
+	case nil:
+	default:
+		s := frontMatterString(v)
+		separator := func(r rune) bool { return r == ',' }
+
+		if !strings.Contains(s, ",") {
+			separator = func(r rune) bool { return r == ' ' || r == '\t' }
+		}
+
+		for _, item := range strings.FieldsFunc(s, separator) {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				list = append(list, item)
+			}
+		}
+	}
+
+	return list
+}
+
+func frontMatterBool(value interface{}) (bool, bool) {
+	switch v := value.(type) {
+	case bool:
+		return v, true
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		return b, err == nil
+	}
+
+	return false, false
+}
+
+// frontMatterTime reads a date, keeping its wall clock time in the time zone
+// it was written in.
+func frontMatterTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		for _, layout := range frontMatterDateLayouts {
+			if t, err := time.Parse(layout, strings.TrimSpace(v)); err == nil {
+				return t, true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// frontMatterMetadataValue flattens a front matter value into the string form
+// used by the "Key: value" header, so that both formats can be read by the
+// same code.  Lists become comma separated, and dates may be written in any
+// of the front matter date formats.
+func frontMatterMetadataValue(key string, value interface{}) string {
+	switch value.(type) {
+	case []interface{}, []string:
+		return strings.Join(frontMatterList(value), ", ")
+	}
+
+	if key == "date" {
+		if t, ok := frontMatterTime(value); ok {
+			return timeToString(t)
+		}
+	}
+
+	return frontMatterString(value)
+}
+
+// frontMatterKeyLines finds the 0-based line of each top level key in YAML or
+// TOML front matter, so that problems can be reported against the right line.
+// TOML tables, such as "[params]", count as keys.
+func frontMatterKeyLines(matter string) map[string]int {
+	lines := map[string]int{}
+
+	for i, line := range strings.Split(matter, "\n") {
+		if len(line) == 0 || line[0] == ' ' || line[0] == '\t' || line[0] == '#' || line[0] == '-' {
+			continue
+		}
+
+		var key string
+
+		if strings.HasPrefix(line, "[") {
+			names := strings.FieldsFunc(line, func(r rune) bool { return r == '[' || r == ']' || r == '.' })
+
+			if len(names) == 0 {
+				continue
+			}
+
+			key = names[0]
+		} else if end := strings.IndexAny(line, ":="); end > 0 {
+			key = line[:end]
+		} else {
+			continue
+		}
+
+		key = strings.ToLower(strings.Trim(strings.TrimSpace(key), "\"'"))
+
+		if _, exists := lines[key]; !exists {
+			lines[key] = i
+		}
+	}
+
+	return lines
+}
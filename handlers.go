@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"html"
 	"net/http"
@@ -30,19 +31,13 @@ func pageNumberFromRequest(req *http.Request, query string) int64 {
 
 func home(w http.ResponseWriter, req *http.Request) {
 
-	id, err := strconv.Atoi(req.URL.Query().Get("p"))
-
-	if err == nil {
-
-		post, _ := blog.PostWithId(id)
-		showSinglePost(post, w, req)
-
-		return
-	}
-
 	term := req.URL.Query().Get("search")
 	pageNumber := pageNumberFromRequest(req, "page")
 
+	if len(req.URL.Query().Get(":page")) > 0 {
+		pageNumber = pageNumberFromRequest(req, ":page")
+	}
+
 	var previousURL string
 	var nextURL string
 
@@ -52,7 +47,7 @@ func home(w http.ResponseWriter, req *http.Request) {
 		if len(term) > 0 {
 			nextURL = fmt.Sprintf("/?search=%v&page=%v", term, pageNumber)
 		} else {
-			nextURL = fmt.Sprintf("/?page=%v", pageNumber)
+			nextURL = fmt.Sprintf("/page/%v", pageNumber)
 		}
 	}
 
@@ -60,7 +55,7 @@ func home(w http.ResponseWriter, req *http.Request) {
 		if len(term) > 0 {
 			previousURL = fmt.Sprintf("/?search=%v&page=%v", term, pageNumber+2)
 		} else {
-			previousURL = fmt.Sprintf("/?page=%v", pageNumber+2)
+			previousURL = fmt.Sprintf("/page/%v", pageNumber+2)
 		}
 	}
 
@@ -78,12 +73,14 @@ func home(w http.ResponseWriter, req *http.Request) {
 		NextURL           string
 		PreviousURL       string
 		SearchPlaceholder string
+		Summaries         bool
 	}{
 		posts,
 		SharedConfig,
 		nextURL,
 		previousURL,
 		searchPlaceholder,
+		SharedConfig.ListContent == summaryContent,
 	}
 
 	t, _ := template.ParseFiles(SharedConfig.FullThemePath() + "/templates/home.html")
@@ -114,12 +111,14 @@ func taggedPosts(w http.ResponseWriter, req *http.Request) {
 		NextURL           string
 		PreviousURL       string
 		SearchPlaceholder string
+		Summaries         bool
 	}{
 		posts,
 		SharedConfig,
 		nextURL,
 		previousURL,
 		"",
+		SharedConfig.ListContent == summaryContent,
 	}
 
 	t, _ := template.ParseFiles(SharedConfig.FullThemePath() + "/templates/home.html")
@@ -168,15 +167,55 @@ func rss(w http.ResponseWriter, req *http.Request) {
 	}
 
 	page := struct {
-		Posts   BlogPosts
-		Updated time.Time
-		Config  *Config
+		Posts     BlogPosts
+		Updated   time.Time
+		Config    *Config
+		Summaries bool
 	}{
 		posts,
 		updated,
 		SharedConfig,
+		SharedConfig.FeedContent == summaryContent,
 	}
 
 	t, _ := template.ParseFiles(SharedConfig.FullThemePath() + "/templates/rss.html")
 	t.Execute(w, page)
 }
+
+func showError(w http.ResponseWriter, req *http.Request, status int, message string) {
+	showMessage(w, req, status, http.StatusText(status), message)
+}
+
+func showMessage(w http.ResponseWriter, req *http.Request, status int, title, message string) {
+
+	page := struct {
+		Status  int
+		Title   string
+		Message string
+		Config  *Config
+	}{
+		status,
+		title,
+		message,
+		SharedConfig,
+	}
+
+	t, err := template.ParseFiles(SharedConfig.FullThemePath() + "/templates/error.html")
+
+	if err != nil {
+		http.Error(w, message, status)
+		return
+	}
+
+	w.WriteHeader(status)
+	t.Execute(w, page)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
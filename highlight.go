@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/russross/blackfriday"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Values of the highlighting config setting.  Client highlighting, the
+// default, leaves code blocks to highlight.js in the browser, so that existing
+// posts' HTML doesn't change.  Server highlighting colours them when posts are
+// converted to HTML, so that they are coloured in feeds and without
+// JavaScript.
+const serverHighlighting = "server"
+const clientHighlighting = "client"
+
+const defaultHighlightStyle = "monokai"
+
+// clientHighlightStylesheet is the highlight.js style used by the default
+// theme.
+const clientHighlightStylesheet = "monokai_gobble.css"
+
+// codeAttributesPattern finds the attributes at the end of a code block's
+// info string, such as the "{3-5 linenos}" in "go {3-5 linenos}".
+var codeAttributesPattern = regexp.MustCompile(`\{([^}]*)\}\s*$`)
+
+// codeBlockOptions are read from the info string of a fenced code block.
+type codeBlockOptions struct {
+	language    string
+	lineNumbers bool
+	firstLine   int
+	highlighted [][2]int
+}
+
+// parseCodeInfo reads the language of a fenced code block and its attributes.
+// The attributes are line numbers and ranges of lines to highlight, such as
+// "3" or "3-5", "linenos" or "linenos=false" to turn line numbers on or off,
+// and "linenostart=10" to number lines from 10.
+func parseCodeInfo(info string) codeBlockOptions {
+	options := codeBlockOptions{firstLine: 1}
+
+	if SharedConfig != nil {
+		options.lineNumbers = SharedConfig.HighlightLineNumbers
+	}
+
+	if match := codeAttributesPattern.FindStringSubmatchIndex(info); match != nil {
+		attributes := strings.FieldsFunc(info[match[2]:match[3]], func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})
+
+		info = info[:match[0]]
+
+		for _, attribute := range attributes {
+			key, value := attribute, ""
+
+			if i := strings.Index(attribute, "="); i >= 0 {
+				key, value = attribute[:i], attribute[i+1:]
+			}
+
+			switch key {
+			case "linenos":
+				options.lineNumbers = value != "false"
+			case "linenostart":
+				if line, err := strconv.Atoi(value); err == nil && line > 0 {
+					options.firstLine = line
+				}
+			default:
+				if lines, ok := parseLineRange(attribute); ok {
+					options.highlighted = append(options.highlighted, lines)
+				}
+			}
+		}
+	}
+
+	if fields := strings.Fields(info); len(fields) > 0 {
+		options.language = strings.ToLower(fields[0])
+	}
+
+	return options
+}
+
+// parseLineRange reads a line number or a range of lines such as "3-5".
+func parseLineRange(text string) ([2]int, bool) {
+	parts := strings.SplitN(text, "-", 2)
+	first, err := strconv.Atoi(parts[0])
+
+	if err != nil || first < 1 {
+		return [2]int{}, false
+	}
+
+	last := first
+
+	if len(parts) == 2 {
+		last, err = strconv.Atoi(parts[1])
+
+		if err != nil || last < first {
+			return [2]int{}, false
+		}
+	}
+
+	return [2]int{first, last}, true
+}
+
+// highlightCode colours a code block using CSS classes, which are styled by
+// the stylesheet from writeHighlightCSS.  It returns false if the block has
+// no language or its language isn't known, in which case it should be written
+// as plain code.
+func highlightCode(code, info string) (string, bool) {
+	options := parseCodeInfo(info)
+
+	if len(options.language) == 0 {
+		return "", false
+	}
+
+	lexer := lexers.Get(options.language)
+
+	if lexer == nil {
+		return "", false
+	}
+
+	tokens, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+
+	if err != nil {
+		return "", false
+	}
+
+	formatter := chromahtml.New(
+		chromahtml.WithClasses(true),
+		chromahtml.WithLineNumbers(options.lineNumbers),
+		chromahtml.BaseLineNumber(options.firstLine),
+		chromahtml.HighlightLines(options.highlighted),
+	)
+
+	var output bytes.Buffer
+
+	// The colours come from the stylesheet, so the style passed to the
+	// formatter is never used.
+	err = formatter.Format(&output, styles.Fallback, tokens)
+
+	if err != nil {
+		return "", false
+	}
+
+	output.WriteString("\n")
+
+	return output.String(), true
+}
+
+// writeHighlightCSS writes the stylesheet for highlighted code in the colours
+// of one of chroma's styles.
+func writeHighlightCSS(w io.Writer, name string) error {
+	style, exists := styles.Registry[strings.ToLower(name)]
+
+	if !exists {
+		return fmt.Errorf("Unknown highlight style %v", name)
+	}
+
+	return chromahtml.New(chromahtml.WithClasses(true)).WriteCSS(w, style)
+}
+
+// highlightFiles serves the highlight directory.  The stylesheet for any of
+// chroma's styles is generated at /highlight/styles/<style>.css, unless the
+// directory has a file of that name to replace it.
+func highlightFiles() http.Handler {
+	files := http.StripPrefix("/highlight/", http.FileServer(http.Dir(SharedConfig.HighlightPath)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := strings.TrimPrefix(req.URL.Path, "/highlight/styles/")
+
+		if name != req.URL.Path && strings.HasSuffix(name, ".css") {
+			_, err := os.Stat(filepath.Join(SharedConfig.HighlightPath, "styles", name))
+			style := strings.TrimSuffix(name, ".css")
+
+			if _, exists := styles.Registry[style]; exists && os.IsNotExist(err) {
+				w.Header().Set("Content-Type", "text/css; charset=utf-8")
+				writeHighlightCSS(w, style)
+				return
+			}
+		}
+
+		files.ServeHTTP(w, req)
+	})
+}
+
+// highlightRenderer highlights code blocks for the legacy Markdown renderer.
+type highlightRenderer struct {
+	blackfriday.Renderer
+}
+
+func (r *highlightRenderer) BlockCode(out *bytes.Buffer, text []byte, info string) {
+	highlighted, ok := highlightCode(string(text), info)
+
+	if !ok {
+		r.Renderer.BlockCode(out, text, info)
+		return
+	}
+
+	if out.Len() > 0 {
+		out.WriteString("\n")
+	}
+
+	out.WriteString(highlighted)
+}
+
+// commonMarkCodeRenderer highlights fenced code blocks for the CommonMark
+// renderer.
+type commonMarkCodeRenderer struct{}
+
+func (r *commonMarkCodeRenderer) RegisterFuncs(registerer renderer.NodeRendererFuncRegisterer) {
+	registerer.Register(ast.KindFencedCodeBlock, r.renderFencedCodeBlock)
+}
+
+func (r *commonMarkCodeRenderer) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	block := node.(*ast.FencedCodeBlock)
+	info := ""
+
+	if block.Info != nil {
+		info = string(block.Info.Segment.Value(source))
+	}
+
+	var code bytes.Buffer
+
+	for i := 0; i < block.Lines().Len(); i++ {
+		line := block.Lines().At(i)
+		code.Write(line.Value(source))
+	}
+
+	if highlighted, ok := highlightCode(code.String(), info); ok {
+		w.WriteString(highlighted)
+		return ast.WalkSkipChildren, nil
+	}
+
+	// Code that can't be highlighted is written as goldmark writes it.
+	w.WriteString("<pre><code")
+
+	if language := block.Language(source); language != nil {
+		w.WriteString(` class="language-`)
+		w.Write(util.EscapeHTML(language))
+		w.WriteString(`"`)
+	}
+
+	w.WriteString(">")
+	w.Write(util.EscapeHTML(code.Bytes()))
+	w.WriteString("</code></pre>\n")
+
+	return ast.WalkSkipChildren, nil
+}
+
+// HighlightStylesheet returns the address of the stylesheet for code blocks,
+// for themes to link to.
+func (c *Config) HighlightStylesheet() string {
+	if c.ClientHighlighting() {
+		return "/highlight/styles/" + clientHighlightStylesheet
+	}
+
+	return "/highlight/styles/" + c.HighlightStyle + ".css"
+}
+
+// ClientHighlighting returns true if themes should load highlight.js to
+// colour code blocks.
+func (c *Config) ClientHighlighting() bool {
+	return c.Highlighting == clientHighlighting
+}
+
+// highlightCommand writes the stylesheet for one of chroma's styles to the
+// highlight directory, where it can be edited, or lists the styles.
+func highlightCommand(args []string) error {
+	flags := flag.NewFlagSet("highlight", flag.ExitOnError)
+	style := flags.String("style", SharedConfig.HighlightStyle, "the style to write")
+	out := flags.String("out", "", "the file to write (default highlight/styles/<style>.css)")
+	list := flags.Bool("list", false, "list the available styles")
+	parseCommandFlags(flags, args)
+
+	if *list {
+		for _, name := range styles.Names() {
+			fmt.Fprintln(commandOutput, name)
+		}
+
+		return nil
+	}
+
+	if len(*out) == 0 {
+		*out = filepath.Join(SharedConfig.HighlightPath, "styles", strings.ToLower(*style)+".css")
+	}
+
+	var css bytes.Buffer
+
+	err := writeHighlightCSS(&css, *style)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(*out), 0775)
+
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(*out, css.Bytes(), 0644)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(commandOutput, "Wrote %v\n", *out)
+
+	return nil
+}
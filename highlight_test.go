@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseCodeInfo(t *testing.T) {
+	SharedConfig = &Config{}
+
+	tests := map[string]string{
+		"":                          " false 1 []",
+		"go":                        "go false 1 []",
+		"Go {3-5}":                  "go false 1 [[3 5]]",
+		"go {3-5, 8 linenos}":       "go true 1 [[3 5] [8 8]]",
+		"python {linenostart=10 1}": "python false 10 [[1 1]]",
+		"go {5-3 x 0 linenostart=}": "go false 1 []",
+		"{2}":                       " false 1 [[2 2]]",
+	}
+
+	for info, expected := range tests {
+		options := parseCodeInfo(info)
+
+		if text := fmt.Sprint(options.language, " ", options.lineNumbers, " ", options.firstLine, " ", options.highlighted); text != expected {
+			t.Errorf("Parsed %q as %q", info, text)
+		}
+	}
+
+	SharedConfig.HighlightLineNumbers = true
+
+	if options := parseCodeInfo("go {linenos=false}"); options.lineNumbers {
+		t.Error("Line numbers were not turned off")
+	}
+
+	if options := parseCodeInfo("go"); !options.lineNumbers {
+		t.Error("Line numbers were not turned on by the config")
+	}
+}
+
+func TestHighlightCode(t *testing.T) {
+	SharedConfig = &Config{}
+
+	markdown := []byte("Text\n\n```go {2 linenos}\nfunc main() {\n\treturn\n}\n```\n\n```unknown\nx < y\n```\n")
+
+	for _, renderer := range []string{legacyMarkdown, commonMarkMarkdown} {
+		output := renderMarkdown(markdown, markdownOptions{renderer: renderer, highlight: true}, plainHeadings)
+
+		expected := []string{
+			`<pre class="chroma"><code><span class="line"><span class="ln">1</span><span class="cl"><span class="kd">func</span>`,
+			`<span class="line hl"><span class="ln">2</span><span class="cl"><span class="w">	</span><span class="k">return</span>`,
+			`<pre><code class="language-unknown">x &lt; y` + "\n</code></pre>\n",
+		}
+
+		for _, html := range expected {
+			if !strings.Contains(output, html) {
+				t.Errorf("%v: expected %q in %q", renderer, html, output)
+			}
+		}
+
+		// Without server highlighting the code is left for highlight.js.
+		output = renderMarkdown(markdown, markdownOptions{renderer: renderer}, plainHeadings)
+
+		if !strings.Contains(output, `<pre><code class="language-go">func main() {`) {
+			t.Errorf("%v: code was highlighted: %q", renderer, output)
+		}
+	}
+}
+
+func TestHighlightStylesheet(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	SharedConfig.ThemePath = "themes"
+	SharedConfig.Theme = "grump"
+	SharedConfig.HighlightPath = "highlight"
+	SharedConfig.HighlightStyle = "github"
+
+	router := newRouter()
+
+	tests := map[string]string{
+		"/highlight/styles/github.css":         ".chroma .hl",
+		"/highlight/styles/monokai_gobble.css": ".hljs",
+		"/highlight/styles/missing.css":        "404",
+	}
+
+	for path, expected := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("%v: expected %q, got %v %q", path, expected, w.Code, w.Body.String())
+		}
+	}
+
+	for highlighting, expected := range map[string]string{serverHighlighting: "/highlight/styles/github.css", clientHighlighting: "/highlight/styles/monokai_gobble.css"} {
+		SharedConfig.Highlighting = highlighting
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/", nil)
+		router.ServeHTTP(w, req)
+
+		if !strings.Contains(w.Body.String(), `<link rel="stylesheet" href="`+expected+`">`) {
+			t.Errorf("%v: expected a link to %v", highlighting, expected)
+		}
+
+		if scripts := strings.Contains(w.Body.String(), "highlight.pack.js"); scripts != (highlighting == clientHighlighting) {
+			t.Errorf("%v: highlight.js included: %v", highlighting, scripts)
+		}
+	}
+}
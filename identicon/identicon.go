@@ -0,0 +1,111 @@
+package identicon
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+const gridSize = 5
+
+// Identicon is a symmetrical 5x5 grid of coloured cells derived from a hash.
+// The same hash always produces the same identicon.
+type Identicon struct {
+	cells      [gridSize][gridSize]bool
+	foreground color.RGBA
+}
+
+var background = color.RGBA{0xf0, 0xf0, 0xf0, 0xff}
+
+// New creates an identicon from a hash, which must be at least 16 bytes long.
+func New(hash []byte) (*Identicon, error) {
+	if len(hash) < 16 {
+		return nil, fmt.Errorf("Hash must be at least 16 bytes long, got %v", len(hash))
+	}
+
+	i := &Identicon{}
+
+	// The last three bytes choose the colour.  They are halved and offset so
+	// that the colour is never too light to see against the background.
+	i.foreground = color.RGBA{hash[13]/2 + 32, hash[14]/2 + 32, hash[15]/2 + 32, 0xff}
+
+	// Only the left three columns come from the hash; the right two mirror
+	// them.
+	for y := 0; y < gridSize; y++ {
+		for x := 0; x < (gridSize+1)/2; x++ {
+			filled := hash[y*3+x]%2 == 0
+			i.cells[y][x] = filled
+			i.cells[y][gridSize-1-x] = filled
+		}
+	}
+
+	return i, nil
+}
+
+// PNG renders the identicon as a square PNG image of the given size.
+func (i *Identicon) PNG(size int) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	cellSize, margin := i.layout(size)
+
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.SetRGBA(x, y, background)
+		}
+	}
+
+	for y := 0; y < gridSize; y++ {
+		for x := 0; x < gridSize; x++ {
+			if !i.cells[y][x] {
+				continue
+			}
+
+			for py := 0; py < cellSize; py++ {
+				for px := 0; px < cellSize; px++ {
+					img.SetRGBA(margin+x*cellSize+px, margin+y*cellSize+py, i.foreground)
+				}
+			}
+		}
+	}
+
+	var buffer bytes.Buffer
+
+	err := png.Encode(&buffer, img)
+
+	return buffer.Bytes(), err
+}
+
+// SVG renders the identicon as a square SVG image of the given size.
+func (i *Identicon) SVG(size int) []byte {
+	var buffer bytes.Buffer
+	cellSize, margin := i.layout(size)
+
+	fmt.Fprintf(&buffer, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, size, size, size, size)
+	fmt.Fprintf(&buffer, `<rect width="%d" height="%d" fill="%s"/>`, size, size, hexColor(background))
+
+	for y := 0; y < gridSize; y++ {
+		for x := 0; x < gridSize; x++ {
+			if i.cells[y][x] {
+				fmt.Fprintf(&buffer, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`, margin+x*cellSize, margin+y*cellSize, cellSize, cellSize, hexColor(i.foreground))
+			}
+		}
+	}
+
+	buffer.WriteString("</svg>")
+
+	return buffer.Bytes()
+}
+
+// layout returns the size of each cell and the margin around the grid.  The
+// grid takes up the space of five cells plus half a cell of margin each side.
+func (i *Identicon) layout(size int) (int, int) {
+	cellSize := size / (gridSize + 1)
+	margin := (size - cellSize*gridSize) / 2
+
+	return cellSize, margin
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,78 @@
+package identicon
+
+import (
+	"bytes"
+	"crypto/md5"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestDeterministic(t *testing.T) {
+	hash := md5.Sum([]byte("joe@example.com"))
+
+	a, _ := New(hash[:])
+	b, _ := New(hash[:])
+
+	if !bytes.Equal(a.SVG(60), b.SVG(60)) {
+		t.Error("Same hash produced different identicons")
+	}
+
+	other := md5.Sum([]byte("bob@example.com"))
+	c, _ := New(other[:])
+
+	if bytes.Equal(a.SVG(60), c.SVG(60)) {
+		t.Error("Different hashes produced the same identicon")
+	}
+}
+
+func TestSymmetry(t *testing.T) {
+	hash := md5.Sum([]byte("joe@example.com"))
+	i, _ := New(hash[:])
+
+	for y := 0; y < gridSize; y++ {
+		for x := 0; x < gridSize; x++ {
+			if i.cells[y][x] != i.cells[y][gridSize-1-x] {
+				t.Error("Identicon is not symmetrical")
+			}
+		}
+	}
+}
+
+func TestPNG(t *testing.T) {
+	hash := md5.Sum([]byte("joe@example.com"))
+	i, _ := New(hash[:])
+
+	data, err := i.PNG(80)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.Bounds().Dx() != 80 || img.Bounds().Dy() != 80 {
+		t.Error("Incorrect PNG size:", img.Bounds())
+	}
+}
+
+func TestSVG(t *testing.T) {
+	hash := md5.Sum([]byte("joe@example.com"))
+	i, _ := New(hash[:])
+
+	svg := string(i.SVG(80))
+
+	if !strings.HasPrefix(svg, "<svg") || !strings.HasSuffix(svg, "</svg>") {
+		t.Error("Invalid SVG:", svg)
+	}
+}
+
+func TestShortHash(t *testing.T) {
+	if _, err := New([]byte("short")); err == nil {
+		t.Error("Accepted short hash")
+	}
+}
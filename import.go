@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// importers convert posts from other blogging engines.  Each is run with the
+// arguments that follow its name, so "gobble import wordpress export.xml"
+// runs the "wordpress" importer with "export.xml".
+var importers = map[string]func(args []string) error{
+	"wordpress": importWordPress,
+	"jekyll":    importJekyll,
+	"hugo":      importHugo,
+}
+
+func importCommand(args []string) error {
+	if len(args) == 0 || importers[args[0]] == nil {
+		names := []string{}
+
+		for name := range importers {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		return errors.New("An importer is required (" + strings.Join(names, ", ") + "), for example: gobble import wordpress export.xml")
+	}
+
+	return importers[args[0]](args[1:])
+}
+
+// copyImportedFile copies a file into the blog, leaving an existing file
+// alone if it is the same size.  It returns false if the file was skipped.
+func copyImportedFile(source, target string) (bool, error) {
+	info, err := os.Stat(source)
+
+	if err != nil {
+		return false, err
+	}
+
+	if existing, err := os.Stat(target); err == nil && existing.Size() == info.Size() {
+		return false, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(target), 0775)
+
+	if err != nil {
+		return false, err
+	}
+
+	in, err := os.Open(source)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer in.Close()
+
+	out, err := os.Create(target)
+
+	if err != nil {
+		return false, err
+	}
+
+	_, err = io.Copy(out, in)
+	out.Close()
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, os.Chtimes(target, info.ModTime(), info.ModTime())
+}
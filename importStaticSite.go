@@ -0,0 +1,590 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// jekyllPermalinkStyles are the names Jekyll accepts in place of a permalink
+// pattern.
+var jekyllPermalinkStyles = map[string]string{
+	"date":    "/:categories/:year/:month/:day/:title:output_ext",
+	"pretty":  "/:categories/:year/:month/:day/:title/",
+	"ordinal": "/:categories/:year/:y_day/:title:output_ext",
+	"none":    "/:categories/:title:output_ext",
+}
+
+var (
+	jekyllFilenamePattern  = regexp.MustCompile(`^(\d{4}-\d{1,2}-\d{1,2})-(.+)$`)
+	jekyllHighlightPattern = regexp.MustCompile(`(?s)\{%-?\s*highlight\s+([\w+#-]+)[^%]*-?%\}\n?(.*?)\n?\{%-?\s*endhighlight\s*-?%\}`)
+	hugoHighlightPattern   = regexp.MustCompile(`(?s)\{\{[<%]\s*highlight\s+"?([\w+#-]+)"?[^}]*[>%]\}\}\n?(.*?)\n?\{\{[<%]\s*/highlight\s*[>%]\}\}`)
+	markdownExtensions     = []string{".md", ".markdown", ".mkdn", ".mkd", ".html"}
+)
+
+// staticSiteImporter writes the posts read from a Jekyll or Hugo site and
+// records redirects from their old permalinks to their new URLs.
+type staticSiteImporter struct {
+	existing  map[string]string
+	imported  map[string]string
+	redirects [][2]string
+	posts     int
+	drafts    int
+}
+
+type staticSitePost struct {
+	source     string
+	name       string
+	metadata   BlogPostMetadata
+	body       string
+	permalinks []string
+}
+
+// importJekyll imports the posts and drafts from a Jekyll site's _posts and
+// _drafts directories.
+func importJekyll(args []string) error {
+	flags := flag.NewFlagSet("import jekyll", flag.ExitOnError)
+	redirectPath := flags.String("redirects", SharedConfig.RedirectFile, "file to write redirects from the old permalinks to")
+	positional := parseCommandFlags(flags, args)
+
+	if len(positional) != 1 {
+		return errors.New("A single site directory is required, for example: gobble import jekyll ./my-site")
+	}
+
+	dir := positional[0]
+	config, err := readStaticSiteConfig(dir, "_config.yml", "_config.yaml", "_config.toml")
+
+	if err != nil {
+		return err
+	}
+
+	pattern := frontMatterString(config["permalink"])
+
+	if len(pattern) == 0 {
+		pattern = "date"
+	}
+
+	if style, ok := jekyllPermalinkStyles[pattern]; ok {
+		pattern = style
+	}
+
+	baseUrl := strings.TrimSuffix(frontMatterString(config["baseurl"]), "/")
+	posts := []*staticSitePost{}
+
+	for _, directory := range []string{"_posts", "_drafts"} {
+		err = walkMarkdownFiles(filepath.Join(dir, directory), func(file string, info os.FileInfo) error {
+			post, err := readJekyllPost(file, info, directory == "_drafts", pattern, baseUrl)
+
+			if post != nil {
+				posts = append(posts, post)
+			}
+
+			return err
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return importStaticSitePosts(posts, *redirectPath)
+}
+
+func readJekyllPost(file string, info os.FileInfo, isDraft bool, pattern, baseUrl string) (*staticSitePost, error) {
+	post, values, err := readStaticSitePost(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+	date := info.ModTime()
+
+	if match := jekyllFilenamePattern.FindStringSubmatch(name); match != nil {
+		if t, err := time.Parse("2006-1-2", match[1]); err == nil {
+			date = t
+		}
+
+		name = match[2]
+	} else if !isDraft {
+		// Jekyll doesn't publish posts without a date in their filename.
+		fmt.Fprintf(commandOutput, "Skipped %v: its filename doesn't start with a date\n", file)
+		return nil, nil
+	}
+
+	if t, ok := frontMatterTime(values["date"]); ok {
+		date = t
+	}
+
+	post.name = name
+	post.metadata.Date = date
+	post.metadata.Draft = isDraft
+
+	if published, ok := frontMatterBool(values["published"]); ok && !published {
+		post.metadata.Draft = true
+	}
+
+	if len(post.metadata.Title) == 0 {
+		post.metadata.Title = titleFromSlug(name)
+	}
+
+	categories := append(frontMatterList(values["categories"]), frontMatterList(values["category"])...)
+	post.metadata.Tags = mergeTags(frontMatterList(values["tags"]), frontMatterList(values["tag"]), categories)
+
+	if permalink := frontMatterString(values["permalink"]); len(permalink) > 0 {
+		post.permalinks = append(post.permalinks, baseUrl+permalink)
+	} else {
+		slug := frontMatterString(values["slug"])
+
+		if len(slug) == 0 {
+			slug = name
+		}
+
+		for i := range categories {
+			categories[i] = strings.ToLower(categories[i])
+		}
+
+		post.permalinks = append(post.permalinks, baseUrl+expandPermalink(pattern, date, map[string]string{
+			":categories": strings.Join(categories, "/"),
+			":title":      slug,
+			":slug":       slug,
+			":output_ext": ".html",
+		}))
+	}
+
+	post.body = jekyllHighlightPattern.ReplaceAllString(post.body, "```$1\n$2\n```")
+
+	return post, nil
+}
+
+// importHugo imports the pages in a Hugo site's post sections.
+func importHugo(args []string) error {
+	flags := flag.NewFlagSet("import hugo", flag.ExitOnError)
+	redirectPath := flags.String("redirects", SharedConfig.RedirectFile, "file to write redirects from the old permalinks to")
+	sectionList := flags.String("sections", "posts,post,blog", "comma-separated list of the content sections that contain posts")
+	positional := parseCommandFlags(flags, args)
+
+	if len(positional) != 1 {
+		return errors.New("A single site directory is required, for example: gobble import hugo ./my-site")
+	}
+
+	dir := positional[0]
+	config, err := readStaticSiteConfig(dir, "hugo.toml", "hugo.yaml", "hugo.yml", "config.toml", "config.yaml", "config.yml")
+
+	if err != nil {
+		return err
+	}
+
+	baseUrl := ""
+
+	if address, err := url.Parse(frontMatterString(config["baseurl"])); err == nil {
+		baseUrl = strings.TrimSuffix(address.Path, "/")
+	}
+
+	patterns := hugoPermalinks(config)
+	posts := []*staticSitePost{}
+
+	for _, section := range strings.Split(*sectionList, ",") {
+		section = strings.TrimSpace(section)
+		root := filepath.Join(dir, "content", section)
+
+		err = walkMarkdownFiles(root, func(file string, info os.FileInfo) error {
+			if strings.HasPrefix(info.Name(), "_index.") {
+				return nil
+			}
+
+			post, err := readHugoPost(file, info, root, section, patterns[section], baseUrl)
+
+			if err == nil {
+				posts = append(posts, post)
+			}
+
+			return err
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return importStaticSitePosts(posts, *redirectPath)
+}
+
+func readHugoPost(file string, info os.FileInfo, root, section, pattern, baseUrl string) (*staticSitePost, error) {
+	post, values, err := readStaticSitePost(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	relative, _ := filepath.Rel(root, file)
+	relative = strings.TrimSuffix(filepath.ToSlash(relative), path.Ext(relative))
+
+	// Page bundles are named after their directory.
+	if path.Base(relative) == "index" {
+		relative = path.Dir(relative)
+	}
+
+	name := path.Base(relative)
+	date := info.ModTime()
+
+	for _, key := range []string{"date", "publishdate"} {
+		if t, ok := frontMatterTime(values[key]); ok {
+			date = t
+			break
+		}
+	}
+
+	post.name = name
+	post.metadata.Date = date
+
+	if draft, ok := frontMatterBool(values["draft"]); ok {
+		post.metadata.Draft = draft
+	}
+
+	if len(post.metadata.Title) == 0 {
+		post.metadata.Title = titleFromSlug(name)
+	}
+
+	post.metadata.Tags = mergeTags(frontMatterList(values["tags"]), frontMatterList(values["categories"]))
+
+	slug := frontMatterString(values["slug"])
+
+	if len(slug) > 0 {
+		post.name = slug
+	}
+
+	if custom := frontMatterString(values["url"]); len(custom) > 0 {
+		post.permalinks = append(post.permalinks, custom)
+	} else if len(pattern) > 0 {
+		title := urlize(post.metadata.Title)
+		slugOrTitle := title
+		slugOrFilename := name
+
+		if len(slug) > 0 {
+			slugOrTitle = slug
+			slugOrFilename = slug
+		}
+
+		post.permalinks = append(post.permalinks, baseUrl+expandPermalink(pattern, date, map[string]string{
+			":section":               section,
+			":sections":              path.Join(section, path.Dir(relative)),
+			":title":                 title,
+			":slug":                  slugOrTitle,
+			":slugorfilename":        slugOrFilename,
+			":slugorcontentbasename": slugOrFilename,
+			":filename":              name,
+			":contentbasename":       name,
+		}))
+	} else {
+		if len(slug) > 0 {
+			relative = path.Join(path.Dir(relative), slug)
+		}
+
+		post.permalinks = append(post.permalinks, baseUrl+"/"+strings.ToLower(path.Join(section, relative))+"/")
+	}
+
+	for _, alias := range frontMatterList(values["aliases"]) {
+		if !strings.HasPrefix(alias, "/") {
+			alias = baseUrl + "/" + path.Join(section, path.Dir(relative), alias)
+		}
+
+		post.permalinks = append(post.permalinks, alias)
+	}
+
+	post.body = hugoHighlightPattern.ReplaceAllString(post.body, "```$1\n$2\n```")
+
+	return post, nil
+}
+
+// hugoPermalinks reads the permalink pattern for each section from a Hugo
+// config.  Newer versions of Hugo nest the patterns for pages under "page".
+func hugoPermalinks(config map[string]interface{}) map[string]string {
+	patterns := map[string]string{}
+	permalinks, _ := config["permalinks"].(map[string]interface{})
+
+	if page, ok := permalinks["page"].(map[string]interface{}); ok {
+		permalinks = page
+	}
+
+	for section, pattern := range permalinks {
+		if s, ok := pattern.(string); ok {
+			patterns[section] = s
+		}
+	}
+
+	return patterns
+}
+
+func readStaticSiteConfig(dir string, names ...string) (map[string]interface{}, error) {
+	for _, name := range names {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		delimiter := yamlFrontMatterDelimiter
+
+		if filepath.Ext(name) == ".toml" {
+			delimiter = tomlFrontMatterDelimiter
+		}
+
+		config, err := parseFrontMatter(delimiter, string(content))
+
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", name, err)
+		}
+
+		return config, nil
+	}
+
+	return map[string]interface{}{}, nil
+}
+
+// readStaticSitePost reads a post's front matter and body, and the metadata
+// that Jekyll and Hugo have in common.
+func readStaticSitePost(file string) (*staticSitePost, map[string]interface{}, error) {
+	content, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	delimiter, matter, body := splitFrontMatter(string(content))
+	values := map[string]interface{}{}
+
+	if len(delimiter) > 0 {
+		values, err = parseFrontMatter(delimiter, matter)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("%v: %v", file, err)
+		}
+	}
+
+	post := &staticSitePost{source: file, body: body}
+	post.metadata.Title = frontMatterString(values["title"])
+
+	return post, values, nil
+}
+
+func walkMarkdownFiles(root string, walk func(file string, info os.FileInfo) error) error {
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil
+	}
+
+	return filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || !contains(markdownExtensions, strings.ToLower(filepath.Ext(file))) {
+			return nil
+		}
+
+		return walk(file, info)
+	})
+}
+
+func importStaticSitePosts(posts []*staticSitePost, redirectPath string) error {
+	err := loadCommandBlog()
+
+	if err != nil {
+		return err
+	}
+
+	importer := &staticSiteImporter{imported: make(map[string]string)}
+	importer.existing, err = existingPostFilenames()
+
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(posts, func(i, j int) bool {
+		return posts[i].metadata.Date.Before(posts[j].metadata.Date)
+	})
+
+	for _, post := range posts {
+		err = importer.importPost(post)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(commandOutput, "Imported %v posts, %v of which are drafts\n", importer.posts, importer.drafts)
+
+	if len(importer.redirects) == 0 {
+		return nil
+	}
+
+	added, err := writeRedirects(redirectPath, importer.redirects)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(commandOutput, "Added %v redirects from old permalinks to %v\n", added, redirectPath)
+
+	return nil
+}
+
+// importPost writes a post, replacing a previously imported post with the
+// same URL so that a site can be imported again.
+func (i *staticSiteImporter) importPost(post *staticSitePost) error {
+	metadata := post.metadata
+	metadata.Date = stringToTime(timeToString(metadata.Date))
+
+	postUrl := (&BlogPost{Metadata: metadata}).urlFromBlogPostProperties()
+
+	if other, exists := i.imported[postUrl]; exists {
+		fmt.Fprintf(commandOutput, "Skipped %v: it has the same URL as %v\n", post.source, other)
+		return nil
+	}
+
+	i.imported[postUrl] = post.source
+
+	filename, exists := i.existing[postUrl]
+
+	if !exists {
+		filename = blog.NewPostFilename(post.name, metadata.Date)
+	}
+
+	written, err := blog.WritePost(filename, metadata, strings.TrimSpace(post.body)+"\n")
+
+	if err != nil {
+		return fmt.Errorf("Could not import %v: %v", post.source, err)
+	}
+
+	i.posts++
+
+	if metadata.Draft {
+		i.drafts++
+		return nil
+	}
+
+	for _, permalink := range post.permalinks {
+		if target := "/posts/" + written.Url; permalink != target {
+			i.redirects = append(i.redirects, [2]string{permalink, target})
+		}
+	}
+
+	return nil
+}
+
+// existingPostFilenames maps the URLs of every post, including drafts, to
+// their filenames.
+func existingPostFilenames() (map[string]string, error) {
+	posts, err := loadAllPosts()
+
+	if err != nil {
+		return nil, err
+	}
+
+	filenames := map[string]string{}
+
+	for _, post := range posts {
+		filenames[post.Url] = post.Filename
+	}
+
+	return filenames, nil
+}
+
+// writeRedirects adds redirects to a file with one redirect per line, in the
+// form "/old/url /new/url 301".  Redirects from URLs that are already in the
+// file are left alone.  It returns the number of redirects added.
+func writeRedirects(filename string, redirects [][2]string) (int, error) {
+	content, err := ioutil.ReadFile(filename)
+
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	text := string(content)
+	existing := map[string]bool{}
+
+	for _, line := range strings.Split(text, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && !strings.HasPrefix(fields[0], "#") {
+			existing[fields[0]] = true
+		}
+	}
+
+	if len(text) > 0 && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+
+	added := 0
+
+	for _, redirect := range redirects {
+		if existing[redirect[0]] {
+			continue
+		}
+
+		existing[redirect[0]] = true
+		text += redirect[0] + " " + redirect[1] + " 301\n"
+		added++
+	}
+
+	return added, ioutil.WriteFile(filename, []byte(text), 0644)
+}
+
+// mergeTags combines lists of tags and categories, leaving out duplicates.
+func mergeTags(lists ...[]string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+
+	for _, list := range lists {
+		for _, tag := range list {
+			if key := strings.ToLower(tag); !seen[key] {
+				seen[key] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	return tags
+}
+
+// titleFromSlug turns a filename such as "my-first-post" into a title for
+// posts that don't have one.
+func titleFromSlug(slug string) string {
+	words := strings.FieldsFunc(slug, func(r rune) bool {
+		return r == '-' || r == '_'
+	})
+
+	for i, word := range words {
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		words[i] = string(runes)
+	}
+
+	return strings.Join(words, " ")
+}
+
+// urlize converts a title to a URL path segment in the same way as Hugo.
+func urlize(title string) string {
+	var b strings.Builder
+
+	for _, r := range strings.ToLower(strings.TrimSpace(title)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.':
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			b.WriteRune('-')
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(file), 0775)
+
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSplitFrontMatter(t *testing.T) {
+	tests := []struct {
+		text      string
+		delimiter string
+		matter    string
+		body      string
+	}{
+		{"---\ntitle: A\n---\nBody\n", "---", "title: A", "Body\n"},
+		{"+++\ntitle = \"A\"\n+++\n\nBody", "+++", "title = \"A\"", "\nBody"},
+		{"\ufeff---\r\ntitle: A\r\n---\r\nBody", "---", "title: A", "Body"},
+		{"---\n---\nBody", "---", "", "Body"},
+		{"---\ntitle: A\n---", "---", "title: A", ""},
+		{"Title: A\n\nBody", "", "", "Title: A\n\nBody"},
+		{"---\nNo closing delimiter", "", "", "---\nNo closing delimiter"},
+	}
+
+	for _, test := range tests {
+		delimiter, matter, body := splitFrontMatter(test.text)
+
+		if delimiter != test.delimiter || matter != test.matter || body != test.body {
+			t.Errorf("Splitting %q gave %q, %q, %q", test.text, delimiter, matter, body)
+		}
+	}
+}
+
+func TestFrontMatterValues(t *testing.T) {
+	values, err := parseFrontMatter("---", "Title: A\ntags: [a, b]\ncategories: c d\ndate: 2014-01-26 10:30:00 +0100\ndraft: true")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if frontMatterString(values["title"]) != "A" {
+		t.Errorf("Unexpected title %v", values["title"])
+	}
+
+	if tags := strings.Join(frontMatterList(values["tags"]), ","); tags != "a,b" {
+		t.Errorf("Unexpected tags %v", tags)
+	}
+
+	if categories := strings.Join(frontMatterList(values["categories"]), ","); categories != "c,d" {
+		t.Errorf("Unexpected categories %v", categories)
+	}
+
+	if date, ok := frontMatterTime(values["date"]); !ok || timeToString(date) != "2014-01-26 10:30:00" {
+		t.Errorf("Unexpected date %v", values["date"])
+	}
+
+	if draft, ok := frontMatterBool(values["draft"]); !ok || !draft {
+		t.Errorf("Unexpected draft %v", values["draft"])
+	}
+
+	values, err = parseFrontMatter("+++", "title = \"B\"\ndate = 2014-01-26T10:30:00Z\ntags = [\"x\"]")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if date, ok := frontMatterTime(values["date"]); frontMatterString(values["title"]) != "B" || !ok || timeToString(date) != "2014-01-26 10:30:00" {
+		t.Errorf("Unexpected TOML values %v", values)
+	}
+
+	if _, err := parseFrontMatter("---", "title: [unclosed"); err == nil {
+		t.Error("Invalid YAML was accepted")
+	}
+}
+
+func TestExpandPermalink(t *testing.T) {
+	date := time.Date(2014, 1, 6, 10, 0, 0, 0, time.UTC)
+	values := map[string]string{":slug": "my-post", ":slugorfilename": "file", ":categories": ""}
+
+	tests := map[string]string{
+		"/:categories/:year/:month/:day/:slug.html": "/2014/01/06/my-post.html",
+		"/:year/:i_month/:i_day/:slugorfilename/":   "/2014/1/6/file/",
+		":short_year/:y_day/:slug":                  "/14/006/my-post",
+	}
+
+	for pattern, expected := range tests {
+		if permalink := expandPermalink(pattern, date, values); permalink != expected {
+			t.Errorf("Expanding %v: expected %v, got %v", pattern, expected, permalink)
+		}
+	}
+}
+
+func TestImportJekyll(t *testing.T) {
+	dir, output := createCommandTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	site := filepath.Join(dir, "site")
+	redirects := filepath.Join(dir, "redirects.txt")
+
+	writeTestFiles(t, site, map[string]string{
+		"_config.yml": "baseurl: /blog\npermalink: pretty\n",
+		"_posts/2014-01-26-hello-world.md": "---\ntitle: Hello World\ncategories: [News]\ntags: go web\n---\n\n" +
+			"Text\n\n{% highlight go linenos %}\nfunc main() {}\n{% endhighlight %}\n",
+		"_posts/2014/2014-02-01-second.markdown": "---\ndate: 2014-02-01 18:30:00 +0000\npermalink: /custom/second/\n---\nSecond\n",
+		"_posts/2014-03-01-hidden.md":            "---\ntitle: Hidden\npublished: false\n---\nNot yet\n",
+		"_posts/notes.md":                        "---\ntitle: No date\n---\n",
+		"_drafts/idea.md":                        "---\ntitle: Idea\n---\nSomeday\n",
+		"about.md":                               "---\ntitle: About\n---\n",
+	})
+
+	err := importCommand([]string{"jekyll", site, "-redirects", redirects})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(output.String(), "Imported 4 posts, 2 of which are drafts") || !strings.Contains(output.String(), "notes.md") {
+		t.Errorf("Unexpected output %q", output.String())
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "posts", "hello-world.md"))
+	expected := "Title: Hello World\nDate: 2014-01-26 00:00:00\nTags: go, web, News\n\nText\n\n```go\nfunc main() {}\n```\n"
+
+	if string(content) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(content))
+	}
+
+	content, _ = ioutil.ReadFile(filepath.Join(dir, "posts", "second.md"))
+
+	if !strings.HasPrefix(string(content), "Title: Second\nDate: 2014-02-01 18:30:00\n") {
+		t.Errorf("Unexpected post %q", string(content))
+	}
+
+	for _, name := range []string{"hidden.md", "idea.md"} {
+		content, _ = ioutil.ReadFile(filepath.Join(dir, "posts", name))
+
+		if !strings.Contains(string(content), "Draft: true\n") {
+			t.Errorf("%v is not a draft: %q", name, string(content))
+		}
+	}
+
+	if _, err := blog.PostWithFilename("idea.md"); err == nil {
+		t.Error("Draft was published")
+	}
+
+	content, _ = ioutil.ReadFile(redirects)
+	expected = "/blog/news/2014/01/26/hello-world/ /posts/2014/01/26/hello-world 301\n/blog/custom/second/ /posts/2014/02/01/second 301\n"
+
+	if string(content) != expected {
+		t.Errorf("Expected redirects %q, got %q", expected, string(content))
+	}
+
+	// Importing again replaces the posts and doesn't repeat the redirects.
+	output.Reset()
+	err = importCommand([]string{"jekyll", site, "-redirects", redirects})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "posts"))
+
+	if len(files) != 4 {
+		t.Errorf("Expected 4 posts after importing twice, got %v", len(files))
+	}
+
+	if reimported, _ := ioutil.ReadFile(redirects); string(reimported) != string(content) {
+		t.Errorf("Redirects changed: %q", string(reimported))
+	}
+}
+
+func TestImportHugo(t *testing.T) {
+	dir, _ := createCommandTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	site := filepath.Join(dir, "site")
+	redirects := filepath.Join(dir, "redirects.txt")
+
+	writeTestFiles(t, site, map[string]string{
+		"config.toml": "baseURL = \"https://example.com/\"\n[permalinks]\nblog = \"/:year/:month/:slug/\"\n",
+		"content/posts/first-post.md": "+++\ntitle = \"First Post\"\ndate = 2014-01-26T10:00:00Z\ntags = [\"go\"]\ncategories = [\"Code\"]\naliases = [\"/old/first\"]\n+++\n\n" +
+			"{{< highlight python \"linenos=table\" >}}\nprint(1)\n{{< /highlight >}}\n",
+		"content/posts/bundle/index.md": "---\ntitle: In a Bundle\ndate: 2014-01-27\nslug: bundled\n---\nBundle\n",
+		"content/posts/_index.md":       "---\ntitle: Posts\n---\n",
+		"content/posts/draft.md":        "---\ntitle: Draft\ndate: 2014-01-28\ndraft: true\n---\nDraft\n",
+		"content/blog/patterned.md":     "---\ntitle: Patterned Post\ndate: 2014-02-03\n---\nText\n",
+		"content/about.md":              "---\ntitle: About\n---\n",
+	})
+
+	err := importCommand([]string{"hugo", site, "-redirects", redirects})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "posts", "first-post.md"))
+	expected := "Title: First Post\nDate: 2014-01-26 10:00:00\nTags: go, Code\n\n```python\nprint(1)\n```\n"
+
+	if string(content) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(content))
+	}
+
+	if _, err := blog.PostWithFilename("bundled.md"); err != nil {
+		t.Error("Bundle was not imported")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "posts", "about.md")); err == nil {
+		t.Error("Page outside of the post sections was imported")
+	}
+
+	content, _ = ioutil.ReadFile(redirects)
+
+	for _, redirect := range []string{
+		"/posts/first-post/ /posts/2014/01/26/first-post 301",
+		"/old/first /posts/2014/01/26/first-post 301",
+		"/posts/bundled/ /posts/2014/01/27/in-a-bundle 301",
+		"/2014/02/patterned-post/ /posts/2014/02/03/patterned-post 301",
+	} {
+		if !strings.Contains(string(content), redirect+"\n") {
+			t.Errorf("Missing redirect %q in %q", redirect, string(content))
+		}
+	}
+
+	if strings.Contains(string(content), "draft") {
+		t.Errorf("Redirect to a draft was written: %q", string(content))
+	}
+}
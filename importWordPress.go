@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/ant512/gobble/wordpress"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type wordPressImporter struct {
+	export   *wordpress.Export
+	uploads  map[string]bool
+	posts    int
+	comments int
+	skipped  int
+}
+
+// importWordPress imports the published posts and their comments from a
+// WordPress export file.  Posts keep their WordPress IDs, so that links in the
+// "/?p=123" format still work, and importing the same file again updates the
+// posts rather than duplicating them.
+func importWordPress(args []string) error {
+	flags := flag.NewFlagSet("import wordpress", flag.ExitOnError)
+	uploadPath := flags.String("uploads", "", "path to a copy of the blog's wp-content/uploads directory")
+	positional := parseCommandFlags(flags, args)
+
+	if len(positional) != 1 {
+		return errors.New("A single export file is required, for example: gobble import wordpress export.xml -uploads ./wp-content/uploads")
+	}
+
+	file, err := os.Open(positional[0])
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	export, err := wordpress.Parse(file)
+
+	if err != nil {
+		return fmt.Errorf("Could not read %v: %v", positional[0], err)
+	}
+
+	err = loadCommandBlog()
+
+	if err != nil {
+		return err
+	}
+
+	importer := &wordPressImporter{export: export, uploads: make(map[string]bool)}
+
+	for _, item := range export.Posts() {
+		err = importer.importPost(item)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, item := range export.Attachments() {
+		if upload, ok := export.UploadPath(item.AttachmentUrl); ok {
+			importer.uploads[upload] = true
+		}
+	}
+
+	fmt.Fprintf(commandOutput, "Imported %v posts and %v comments\n", importer.posts, importer.comments)
+
+	if importer.skipped > 0 {
+		fmt.Fprintf(commandOutput, "Skipped %v comments in the trash\n", importer.skipped)
+	}
+
+	if len(*uploadPath) == 0 {
+		fmt.Fprintf(commandOutput, "Links to %v uploaded files now point at /media; use -uploads to copy the files\n", len(importer.uploads))
+		return nil
+	}
+
+	return importer.copyUploads(*uploadPath)
+}
+
+func (i *wordPressImporter) importPost(item wordpress.Item) error {
+	body, uploads := i.export.RewriteUploads(wordpress.Markdown(item.Content), "/media")
+	i.addUploads(uploads)
+
+	metadata := BlogPostMetadata{
+		Title:            html.UnescapeString(item.Title),
+		Id:               item.PostId,
+		Date:             item.Date(),
+		Tags:             item.Tags(),
+		DisallowComments: item.CommentStatus == "closed",
+	}
+
+	var filename string
+
+	if existing, err := blog.PostWithId(item.PostId); err == nil && item.PostId != 0 {
+		filename = existing.Filename
+	} else {
+		name := item.Name()
+
+		if len(filenameFromTitle(name)) == 0 {
+			name = metadata.Title
+		}
+
+		filename = blog.NewPostFilename(name, metadata.Date)
+	}
+
+	post, err := blog.WritePost(filename, metadata, body+"\n")
+
+	if err != nil {
+		return fmt.Errorf("Could not import post %v (%v): %v", item.PostId, item.Title, err)
+	}
+
+	i.posts++
+
+	for _, c := range item.Comments {
+		if c.IsTrash() {
+			i.skipped++
+			continue
+		}
+
+		body, uploads := i.export.RewriteUploads(wordpress.Markdown(c.Content), "/media")
+		i.addUploads(uploads)
+
+		// Comments awaiting moderation are marked as spam so that they stay
+		// hidden until they are approved.
+		comment := &Comment{
+			Metadata: CommentMetadata{
+				Author:    html.UnescapeString(c.Author),
+				Email:     c.AuthorEmail,
+				Date:      c.Date(),
+				IsSpam:    c.IsSpam() || c.IsPending(),
+				AuthorUrl: c.AuthorUrl,
+			},
+			Body:     BlogItemBody{Markdown: body + "\n"},
+			Filename: fmt.Sprintf("wordpress-%d.md", c.Id),
+		}
+
+		if c.IsPing() {
+			comment.Metadata.Source = c.AuthorUrl
+		}
+
+		err = post.writeComment(comment)
+
+		if err != nil {
+			return err
+		}
+
+		i.comments++
+	}
+
+	return nil
+}
+
+func (i *wordPressImporter) addUploads(uploads []string) {
+	for _, upload := range uploads {
+		i.uploads[upload] = true
+	}
+}
+
+// copyUploads copies every attachment, and every other upload that a post or
+// comment links to, such as resized images, into the media directory.
+func (i *wordPressImporter) copyUploads(uploadPath string) error {
+	uploads := []string{}
+
+	for upload := range i.uploads {
+		uploads = append(uploads, upload)
+	}
+
+	sort.Strings(uploads)
+
+	copied := 0
+	missing := []string{}
+
+	for _, upload := range uploads {
+		source := filepath.Join(uploadPath, filepath.FromSlash(upload))
+		target := filepath.Join(SharedConfig.MediaPath, filepath.FromSlash(upload))
+
+		if _, err := os.Stat(source); os.IsNotExist(err) {
+			missing = append(missing, upload)
+			continue
+		}
+
+		wasCopied, err := copyImportedFile(source, target)
+
+		if err != nil {
+			return err
+		}
+
+		if wasCopied {
+			copied++
+		}
+	}
+
+	fmt.Fprintf(commandOutput, "Copied %v uploaded files to %v\n", copied, SharedConfig.MediaPath)
+
+	if len(missing) > 0 {
+		fmt.Fprintf(commandOutput, "Could not find %v uploaded files in %v:\n  %v\n", len(missing), uploadPath, strings.Join(missing, "\n  "))
+	}
+
+	return nil
+}
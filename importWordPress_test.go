@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testWordPressExport = `<?xml version="1.0" encoding="UTF-8" ?>
+<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/" xmlns:wp="http://wordpress.org/export/1.2/">
+<channel>
+	<link>http://example.com</link>
+	<wp:base_site_url>http://example.com</wp:base_site_url>
+	<item>
+		<title>Hello &amp;amp; Welcome</title>
+		<content:encoded><![CDATA[<img src="http://example.com/wp-content/uploads/2014/01/photo-300x200.jpg" alt="Photo" />
+
+Some <strong>text</strong>.]]></content:encoded>
+		<wp:post_id>42</wp:post_id>
+		<wp:post_date_gmt>2014-01-26 10:00:00</wp:post_date_gmt>
+		<wp:comment_status>open</wp:comment_status>
+		<wp:post_name>hello-welcome</wp:post_name>
+		<wp:status>publish</wp:status>
+		<wp:post_type>post</wp:post_type>
+		<category domain="post_tag" nicename="go"><![CDATA[Go]]></category>
+		<wp:comment>
+			<wp:comment_id>1</wp:comment_id>
+			<wp:comment_author><![CDATA[Joe]]></wp:comment_author>
+			<wp:comment_author_email>joe@example.com</wp:comment_author_email>
+			<wp:comment_date_gmt>2014-01-27 10:00:00</wp:comment_date_gmt>
+			<wp:comment_content><![CDATA[Great post]]></wp:comment_content>
+			<wp:comment_approved>1</wp:comment_approved>
+		</wp:comment>
+		<wp:comment>
+			<wp:comment_id>2</wp:comment_id>
+			<wp:comment_author><![CDATA[Spammer]]></wp:comment_author>
+			<wp:comment_date_gmt>2014-01-27 11:00:00</wp:comment_date_gmt>
+			<wp:comment_content><![CDATA[Buy things]]></wp:comment_content>
+			<wp:comment_approved>spam</wp:comment_approved>
+		</wp:comment>
+		<wp:comment>
+			<wp:comment_id>3</wp:comment_id>
+			<wp:comment_author><![CDATA[Deleted]]></wp:comment_author>
+			<wp:comment_date_gmt>2014-01-27 12:00:00</wp:comment_date_gmt>
+			<wp:comment_content><![CDATA[Gone]]></wp:comment_content>
+			<wp:comment_approved>trash</wp:comment_approved>
+		</wp:comment>
+	</item>
+	<item>
+		<title>About</title>
+		<wp:post_id>2</wp:post_id>
+		<wp:status>publish</wp:status>
+		<wp:post_type>page</wp:post_type>
+	</item>
+	<item>
+		<title>photo</title>
+		<wp:post_id>44</wp:post_id>
+		<wp:post_type>attachment</wp:post_type>
+		<wp:attachment_url>http://example.com/wp-content/uploads/2014/01/photo.jpg</wp:attachment_url>
+	</item>
+</channel>
+</rss>`
+
+func TestImportWordPress(t *testing.T) {
+	dir, output := createCommandTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	exportPath := filepath.Join(dir, "export.xml")
+	ioutil.WriteFile(exportPath, []byte(testWordPressExport), 0644)
+
+	uploads := filepath.Join(dir, "uploads")
+	os.MkdirAll(filepath.Join(uploads, "2014", "01"), 0775)
+	ioutil.WriteFile(filepath.Join(uploads, "2014", "01", "photo.jpg"), []byte("jpeg"), 0644)
+	ioutil.WriteFile(filepath.Join(uploads, "2014", "01", "photo-300x200.jpg"), []byte("small"), 0644)
+	ioutil.WriteFile(filepath.Join(uploads, "2014", "01", "unused.jpg"), []byte("unused"), 0644)
+
+	err := importCommand([]string{"wordpress", exportPath, "-uploads", uploads})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(output.String(), "Imported 1 posts and 2 comments") || !strings.Contains(output.String(), "Copied 2 uploaded files") {
+		t.Errorf("Unexpected output %q", output.String())
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "posts", "hello-welcome.md"))
+	expected := "Title: Hello & Welcome\nId: 42\nDate: 2014-01-26 10:00:00\nTags: Go\n\n![Photo](/media/2014/01/photo-300x200.jpg)\n\nSome **text**.\n"
+
+	if string(content) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(content))
+	}
+
+	content, _ = ioutil.ReadFile(filepath.Join(dir, "comments", "hello-welcome", "wordpress-2.md"))
+	expected = "Author: Spammer\nEmail: \nDate: 2014-01-27 11:00:00\nSpam: true\n\nBuy things\n"
+
+	if string(content) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(content))
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "comments", "hello-welcome", "wordpress-3.md")); err == nil {
+		t.Error("Comment in the trash was imported")
+	}
+
+	for name, exists := range map[string]bool{"photo.jpg": true, "photo-300x200.jpg": true, "unused.jpg": false} {
+		if _, err := os.Stat(filepath.Join(dir, "media", "2014", "01", name)); (err == nil) != exists {
+			t.Errorf("Expected %v to exist: %v", name, exists)
+		}
+	}
+
+	// Importing again updates the existing post.
+	output.Reset()
+	err = importCommand([]string{"wordpress", exportPath})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "posts"))
+
+	if len(files) != 1 {
+		t.Errorf("Expected 1 post after importing twice, got %v", len(files))
+	}
+
+	post, err := blog.PostWithId(42)
+
+	if err != nil || len(post.Comments) != 2 {
+		t.Errorf("Unexpected post %v: %v", post, err)
+	}
+}
+
+func TestImportCommandRequiresAnImporter(t *testing.T) {
+	if err := importCommand([]string{"blogger"}); err == nil || !strings.Contains(err.Error(), "wordpress") {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
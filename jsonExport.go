@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const jsonExportVersion = 1
+
+// jsonExport is the document written by "gobble json".  Its schema is
+// described in the README, and the version is increased whenever a field is
+// removed or changes meaning.
+type jsonExport struct {
+	Version  int            `json:"version"`
+	Exported time.Time      `json:"exported"`
+	Blog     jsonExportBlog `json:"blog"`
+	Posts    []jsonPost     `json:"posts"`
+}
+
+type jsonExportBlog struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Address     string `json:"address"`
+}
+
+type jsonPost struct {
+	Filename  string           `json:"filename"`
+	Url       string           `json:"url"`
+	Permalink string           `json:"permalink"`
+	Metadata  BlogPostMetadata `json:"metadata"`
+	Body      apiBody          `json:"body"`
+	Comments  []jsonComment    `json:"comments"`
+}
+
+// jsonComment includes everything stored with a comment, unlike the API,
+// because the export is for the blog's owner.
+type jsonComment struct {
+	Filename  string    `json:"filename"`
+	Author    string    `json:"author"`
+	Email     string    `json:"email,omitempty"`
+	AuthorUrl string    `json:"authorUrl,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	Date      time.Time `json:"date"`
+	Spam      bool      `json:"spam"`
+	Notify    bool      `json:"notify"`
+	Body      apiBody   `json:"body"`
+}
+
+func jsonExportCommand(args []string) error {
+	flags := flag.NewFlagSet("json", flag.ExitOnError)
+	out := flags.String("out", "", "file to write the export to (defaults to standard output)")
+	parseCommandFlags(flags, args)
+
+	posts, err := loadAllPosts()
+
+	if err != nil {
+		return err
+	}
+
+	w := commandOutput
+
+	if len(*out) > 0 {
+		file, err := os.Create(*out)
+
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+
+		w = file
+	}
+
+	return writeJSONExport(w, posts)
+}
+
+func writeJSONExport(w io.Writer, posts BlogPosts) error {
+	export := jsonExport{
+		Version:  jsonExportVersion,
+		Exported: time.Now().UTC(),
+		Blog:     jsonExportBlog{SharedConfig.Name, SharedConfig.Description, SharedConfig.Address},
+		Posts:    []jsonPost{},
+	}
+
+	for _, post := range posts {
+		export.Posts = append(export.Posts, newJSONPost(post))
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+
+	return encoder.Encode(export)
+}
+
+func newJSONPost(post *BlogPost) jsonPost {
+	p := jsonPost{
+		Filename:  post.Filename,
+		Url:       post.Url,
+		Permalink: post.Permalink(),
+		Metadata:  post.Metadata,
+		Body:      apiBody{strings.TrimLeft(post.Body.Markdown, "\n"), post.Body.HTML},
+		Comments:  []jsonComment{},
+	}
+
+	if p.Metadata.Tags == nil {
+		p.Metadata.Tags = []string{}
+	}
+
+	comments := append(Comments{}, post.Comments...)
+	sort.Sort(comments)
+
+	for _, comment := range comments {
+		p.Comments = append(p.Comments, jsonComment{
+			Filename:  comment.Filename,
+			Author:    comment.Metadata.Author,
+			Email:     comment.Metadata.Email,
+			AuthorUrl: comment.Metadata.AuthorUrl,
+			Source:    comment.Metadata.Source,
+			Date:      comment.Metadata.Date,
+			Spam:      comment.Metadata.IsSpam,
+			Notify:    comment.Metadata.Notify,
+			Body:      apiBody{strings.TrimLeft(comment.Body.Markdown, "\n"), comment.Body.HTML},
+		})
+	}
+
+	return p
+}
+
+// loadAllPosts reads every post from disk, including drafts, which the blog
+// itself never loads.  Posts are returned newest first.
+func loadAllPosts() (BlogPosts, error) {
+	files, err := ioutil.ReadDir(SharedConfig.PostPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	posts := BlogPosts{}
+
+	for _, file := range files {
+		if !isValidBlogPostFile(file) {
+			continue
+		}
+
+		post, err := LoadPost(file.Name(), SharedConfig.PostPath, SharedConfig.CommentPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		posts = append(posts, post)
+	}
+
+	sort.Sort(posts)
+
+	return posts, nil
+}
@@ -0,0 +1,255 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TLSNone     = "none"
+	TLSStartTLS = "starttls"
+	TLSImplicit = "tls"
+)
+
+const queueSize = 100
+
+type Email struct {
+	To      []string
+	Subject string
+	Body    string
+	Headers map[string]string
+}
+
+type queuedEmail struct {
+	email    *Email
+	attempts int
+}
+
+// Mailer sends plain text emails via an SMTP server.  Emails added with Queue
+// are sent in the background and retried with an increasing delay if the
+// server cannot be reached.  Each attempt is abandoned after Timeout, so that
+// a server that stops responding can't hold up the rest of the queue.
+type Mailer struct {
+	Host       string
+	Port       int
+	Username   string
+	Password   string
+	TLS        string
+	From       string
+	MaxRetries int
+	RetryDelay time.Duration
+	Timeout    time.Duration
+	queue      chan *queuedEmail
+}
+
+func New(host string, port int, username, password, tlsMode, from string) *Mailer {
+	return &Mailer{
+		Host:       host,
+		Port:       port,
+		Username:   username,
+		Password:   password,
+		TLS:        tlsMode,
+		From:       from,
+		MaxRetries: 5,
+		RetryDelay: time.Minute,
+		Timeout:    30 * time.Second,
+		queue:      make(chan *queuedEmail, queueSize),
+	}
+}
+
+func (m *Mailer) Enabled() bool {
+	return m != nil && len(m.Host) > 0
+}
+
+// Start launches the goroutine that sends queued emails.
+func (m *Mailer) Start() {
+	if !m.Enabled() {
+		return
+	}
+
+	go func() {
+		for item := range m.queue {
+			m.deliver(item)
+		}
+	}()
+}
+
+// Queue adds an email to the send queue.  Emails are dropped if the queue is
+// full, as that means the server has been unreachable for some time.
+func (m *Mailer) Queue(email *Email) {
+	if !m.Enabled() {
+		return
+	}
+
+	m.enqueue(&queuedEmail{email: email})
+}
+
+func (m *Mailer) enqueue(item *queuedEmail) {
+	select {
+	case m.queue <- item:
+	default:
+		log.Println("Email queue full; dropping email:", item.email.Subject)
+	}
+}
+
+func (m *Mailer) deliver(item *queuedEmail) {
+	err := m.Send(item.email)
+
+	if err == nil {
+		return
+	}
+
+	item.attempts++
+
+	if item.attempts > m.MaxRetries {
+		log.Println("Giving up sending email:", item.email.Subject, err)
+		return
+	}
+
+	delay := m.RetryDelay * time.Duration(1<<uint(item.attempts-1))
+
+	log.Println("Could not send email:", err, "- retrying in", delay)
+
+	time.AfterFunc(delay, func() {
+		m.enqueue(item)
+	})
+}
+
+// Send delivers an email immediately.
+func (m *Mailer) Send(email *Email) error {
+	if len(email.To) == 0 {
+		return errors.New("Email has no recipients")
+	}
+
+	address := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	tlsConfig := &tls.Config{ServerName: m.Host}
+	dialer := &net.Dialer{Timeout: m.Timeout}
+
+	var conn net.Conn
+	var err error
+
+	if m.TLS == TLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// The deadline covers the whole conversation with the server, including
+	// any STARTTLS handshake.
+	if m.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(m.Timeout))
+	}
+
+	client, err := smtp.NewClient(conn, m.Host)
+
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	defer client.Close()
+
+	if m.TLS == TLSStartTLS {
+		err = client.StartTLS(tlsConfig)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(m.Username) > 0 {
+		err = client.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(m.From)
+
+	if err != nil {
+		return err
+	}
+
+	for _, to := range email.To {
+		err = client.Rcpt(to)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(m.message(email))
+
+	if err != nil {
+		return err
+	}
+
+	err = writer.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func (m *Mailer) message(email *Email) []byte {
+	var buffer bytes.Buffer
+
+	headers := map[string]string{
+		"From":                      m.From,
+		"Subject":                   mime.QEncoding.Encode("utf-8", email.Subject),
+		"Date":                      time.Now().Format(time.RFC1123Z),
+		"MIME-Version":              "1.0",
+		"Content-Type":              "text/plain; charset=utf-8",
+		"Content-Transfer-Encoding": "quoted-printable",
+	}
+
+	for key, value := range email.Headers {
+		headers[key] = value
+	}
+
+	keys := []string{}
+
+	for key := range headers {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	fmt.Fprintf(&buffer, "To: %s\r\n", strings.Join(email.To, ", "))
+
+	for _, key := range keys {
+		fmt.Fprintf(&buffer, "%s: %s\r\n", key, headers[key])
+	}
+
+	buffer.WriteString("\r\n")
+
+	writer := quotedprintable.NewWriter(&buffer)
+	writer.Write([]byte(email.Body))
+	writer.Close()
+
+	return buffer.Bytes()
+}
@@ -0,0 +1,216 @@
+package mailer
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer is a minimal SMTP stand-in that records the messages it
+// receives.  The first few MAIL commands are rejected with a temporary
+// error so that retries can be tested.
+type fakeSMTPServer struct {
+	listener net.Listener
+	failures int
+	messages chan string
+}
+
+func startFakeSMTPServer(t *testing.T, failures int) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeSMTPServer{listener: listener, failures: failures, messages: make(chan string, 10)}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 localhost ESMTP")
+
+	for {
+		line, err := reader.ReadString('\n')
+
+		if err != nil {
+			return
+		}
+
+		command := strings.ToUpper(strings.TrimSpace(line))
+
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL"):
+			if s.failures > 0 {
+				s.failures--
+				reply("451 Try again later")
+			} else {
+				reply("250 OK")
+			}
+		case strings.HasPrefix(command, "RCPT"):
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 Go ahead")
+
+			var message []string
+
+			for {
+				line, err := reader.ReadString('\n')
+
+				if err != nil {
+					return
+				}
+
+				if line == ".\r\n" {
+					break
+				}
+
+				message = append(message, line)
+			}
+
+			s.messages <- strings.Join(message, "")
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (s *fakeSMTPServer) mailer() *Mailer {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+
+	return New(host, portNumber, "", "", TLSNone, "blog@example.com")
+}
+
+func TestSend(t *testing.T) {
+	s := startFakeSMTPServer(t, 0)
+	defer s.listener.Close()
+
+	err := s.mailer().Send(&Email{
+		To:      []string{"owner@example.com"},
+		Subject: "New comment",
+		Body:    "Hello",
+		Headers: map[string]string{"List-Unsubscribe": "<http://example.com/unsubscribe>"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := <-s.messages
+
+	if !strings.Contains(message, "To: owner@example.com\r\n") {
+		t.Error("Message missing recipient:", message)
+	}
+
+	if !strings.Contains(message, "Subject: New comment\r\n") {
+		t.Error("Message missing subject:", message)
+	}
+
+	if !strings.Contains(message, "List-Unsubscribe: <http://example.com/unsubscribe>\r\n") {
+		t.Error("Message missing extra header:", message)
+	}
+
+	if !strings.HasSuffix(message, "\r\n\r\nHello\r\n") {
+		t.Error("Message missing body:", message)
+	}
+}
+
+func TestSendTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	// Accept connections but never reply to them.
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			defer conn.Close()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+
+	m := New(host, portNumber, "", "", TLSNone, "blog@example.com")
+	m.Timeout = 100 * time.Millisecond
+
+	result := make(chan error, 1)
+
+	go func() {
+		result <- m.Send(&Email{To: []string{"owner@example.com"}, Subject: "Hello", Body: "Hello"})
+	}()
+
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("Email was sent to a server that never replied")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Send did not time out")
+	}
+}
+
+func TestQueueRetries(t *testing.T) {
+	s := startFakeSMTPServer(t, 2)
+	defer s.listener.Close()
+
+	m := s.mailer()
+	m.RetryDelay = time.Millisecond
+	m.Start()
+
+	m.Queue(&Email{To: []string{"owner@example.com"}, Subject: "Retry", Body: "Hello"})
+
+	select {
+	case <-s.messages:
+	case <-time.After(5 * time.Second):
+		t.Error("Queued email was not retried")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	m := New("", 25, "", "", TLSNone, "blog@example.com")
+
+	if m.Enabled() {
+		t.Error("Mailer without host is enabled")
+	}
+
+	m.Queue(&Email{To: []string{"owner@example.com"}})
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/bmizerany/pat"
 	"log"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strconv"
 )
@@ -14,6 +15,11 @@ const version = "2.0"
 
 var blog *Blog
 var SharedConfig *Config
+var ipRateLimiter *RateLimiter
+var postRateLimiter *RateLimiter
+var redirects *RedirectTable
+var disableWatcher bool
+var configFilename string
 
 func printInfo() {
 	fmt.Printf("Gobble Blogging Engine (version %v)\n", version)
@@ -23,7 +29,8 @@ func printInfo() {
 	fmt.Println("")
 }
 
-func prepareHandler() {
+// newRouter returns a handler that serves every page of the blog.
+func newRouter() http.Handler {
 
 	m := pat.New()
 	m.Get("/tags/:tag/:page", http.HandlerFunc(taggedPosts))
@@ -31,25 +38,76 @@ func prepareHandler() {
 	m.Get("/tags/", http.HandlerFunc(tags))
 	m.Get("/archive/", http.HandlerFunc(archive))
 	m.Get("/rss", http.HandlerFunc(rss))
-	m.Get("/posts/:year/:month/:day/:title", http.HandlerFunc(post))
+	m.Get("/posts/", http.HandlerFunc(post))
 
 	for key, value := range SharedConfig.StaticFiles {
 		func(url, path string) {
 			m.Get(url, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-				log.Println("Static file request: " + url + " Serving: " + path)
+				log.Println("Static file request from " + getIpAddress(req) + ": " + url + " Serving: " + path)
 				http.ServeFile(w, req, SharedConfig.StaticFilePath+string(filepath.Separator)+path)
 			}))
 		}(key, value)
 	}
 
+	m.Get("/page/:page", http.HandlerFunc(home))
 	m.Get("/", http.HandlerFunc(home))
 
-	m.Post("/posts/:year/:month/:day/:title/comments", http.HandlerFunc(createComment))
+	m.Post("/posts/", http.HandlerFunc(createComment))
+
+	m.Get("/avatars/:hash", http.HandlerFunc(avatar))
+
+	m.Post("/webmention", http.HandlerFunc(receiveWebmention))
+
+	m.Get("/.well-known/webfinger", http.HandlerFunc(webfinger))
+	m.Get("/activitypub/actor", http.HandlerFunc(activityPubActor))
+	m.Get("/activitypub/outbox", http.HandlerFunc(activityPubOutbox))
+	m.Get("/activitypub/followers", http.HandlerFunc(activityPubFollowersCollection))
+	m.Post("/activitypub/inbox", http.HandlerFunc(activityPubInbox))
+
+	m.Get("/micropub", requireToken(micropubQuery))
+	m.Post("/micropub", requireToken(micropub))
+	m.Post("/micropub/media", requireToken(micropubMedia))
+
+	m.Get("/api/v1/posts", http.HandlerFunc(apiPosts))
+	m.Get("/api/v1/posts/", apiPostResource(apiPostDetail, apiPostComments, nil))
+	m.Get("/api/v1/tags", http.HandlerFunc(apiTags))
+	m.Get("/api/v1/archive", http.HandlerFunc(apiArchive))
+	m.Options("/api/v1/", http.HandlerFunc(apiOptions))
+
+	m.Post("/api/v1/posts", requireToken(apiCreatePost))
+	m.Put("/api/v1/posts/", requireToken(apiPostResource(apiReplacePost, nil, apiModerateComment)))
+	m.Del("/api/v1/posts/", requireToken(apiPostResource(apiDeletePost, nil, apiDeleteComment)))
+	m.Get("/api/v1/comments", requireToken(apiModerationComments))
 
-	http.Handle("/", m)
-	http.Handle("/theme/", http.StripPrefix("/theme/", http.FileServer(http.Dir(SharedConfig.FullThemePath()))))
-	http.Handle("/highlight/", http.StripPrefix("/highlight/", http.FileServer(http.Dir(SharedConfig.HighlightPath))))
-	http.Handle("/media/", http.StripPrefix("/media/", http.FileServer(http.Dir(SharedConfig.MediaPath))))
+	m.Post("/xmlrpc", http.HandlerFunc(xmlRpc))
+	m.Get("/rsd.xml", http.HandlerFunc(rsd))
+
+	m.Get("/comments/moderate", http.HandlerFunc(moderateComment))
+	m.Post("/comments/moderate", http.HandlerFunc(moderateComment))
+	m.Get("/comments/unsubscribe", http.HandlerFunc(unsubscribeFromComments))
+	m.Post("/comments/unsubscribe", http.HandlerFunc(unsubscribeFromComments))
+
+	m.Get("/admin/ratelimits", requireAdmin(rateLimits))
+	m.Post("/admin/ratelimits", requireAdminForm(clearRateLimit))
+	m.Get("/admin/redirects", requireAdmin(redirectUses))
+	m.Get("/admin/privacy", requireAdmin(privacy))
+	m.Get("/admin/privacy/export", requireAdmin(exportPrivacy))
+	m.Post("/admin/privacy/erase", requireAdminForm(erasePrivacy))
+
+	m.NotFound = http.HandlerFunc(notFound)
+
+	mux := http.NewServeMux()
+	mux.Handle("/", checkRedirects(m))
+	mux.Handle("/theme/", http.StripPrefix("/theme/", http.FileServer(http.Dir(SharedConfig.FullThemePath()))))
+	mux.Handle("/highlight/", highlightFiles())
+	mux.Handle("/media/", http.StripPrefix("/media/", http.FileServer(http.Dir(SharedConfig.MediaPath))))
+
+	return mux
+}
+
+func prepareHandler() {
+
+	http.Handle("/", newRouter())
 
 	fmt.Printf("Listening on port %v\n", SharedConfig.Port)
 	fmt.Printf("Using theme \"%v\"\n", SharedConfig.Theme)
@@ -86,24 +144,40 @@ func prepareHandler() {
 }
 
 func main() {
-	printInfo()
-
-	configPath := flag.String("config", "./gobble.conf", "config file path")
-	disableWatcher := flag.Bool("disableWatcher", false, "disable filesystem change watching")
+	flag.StringVar(&configFilename, "config", "./gobble.conf", "config file path")
+	flag.BoolVar(&disableWatcher, "disableWatcher", false, "disable filesystem change watching")
+	flag.Usage = printUsage
 	flag.Parse()
 
+	name := "serve"
+	args := []string{}
+
+	if flag.NArg() > 0 {
+		name = flag.Arg(0)
+		args = flag.Args()[1:]
+	}
+
+	command, ok := commands[name]
+
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command \"%v\"\n\n", name)
+		printUsage()
+		os.Exit(2)
+	}
+
 	var err error
-	SharedConfig, err = LoadConfig(*configPath)
 
-	if err != nil {
-		log.Fatal(err)
+	if !configFreeCommands[name] {
+		SharedConfig, err = LoadConfig(configFilename)
+
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	blog, err = LoadBlog(SharedConfig.PostPath, SharedConfig.CommentPath, *disableWatcher)
+	err = command.run(args)
 
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	prepareHandler()
 }
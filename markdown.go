@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/russross/blackfriday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+	"log"
+	"strings"
+)
+
+// Values of the markdown config setting and post metadata key.  The legacy
+// renderer is the one Gobble has always used, so existing posts don't change
+// unless they ask for CommonMark.
+const legacyMarkdown = "legacy"
+const commonMarkMarkdown = "commonmark"
+
+const legacyMarkdownHtmlFlags = blackfriday.HTML_USE_SMARTYPANTS
+const legacyMarkdownExtensions = blackfriday.EXTENSION_AUTOLINK | blackfriday.EXTENSION_FENCED_CODE | blackfriday.EXTENSION_NO_INTRA_EMPHASIS | blackfriday.EXTENSION_STRIKETHROUGH
+
+// commonMarkExtensions are the extensions that can be turned on for the
+// CommonMark renderer.  The first four make up GitHub Flavored Markdown.
+var commonMarkExtensions = map[string]goldmark.Extender{
+	"table":          extension.Table,
+	"strikethrough":  extension.Strikethrough,
+	"linkify":        extension.Linkify,
+	"tasklist":       extension.TaskList,
+	"footnote":       extension.Footnote,
+	"definitionlist": extension.DefinitionList,
+	"typographer":    extension.Typographer,
+}
+
+var defaultMarkdownExtensions = []string{"table", "strikethrough", "linkify", "tasklist", "footnote", "definitionlist", "typographer"}
+
+// markdownOptions chooses the renderer used to convert Markdown to HTML, the
+// extensions it uses for CommonMark and whether code blocks are highlighted.
+type markdownOptions struct {
+	renderer   string
+	extensions []string
+	highlight  bool
+}
+
+// siteMarkdownOptions returns the renderer and extensions from the config.
+func siteMarkdownOptions() markdownOptions {
+	if SharedConfig == nil {
+		return markdownOptions{legacyMarkdown, defaultMarkdownExtensions, false}
+	}
+
+	return markdownOptions{SharedConfig.Markdown, SharedConfig.MarkdownExtensions, SharedConfig.Highlighting == serverHighlighting}
+}
+
+// markdownOptions returns the renderer and extensions for a post, which can
+// override the ones from the config with its Markdown and Extensions keys.
+func (m *BlogPostMetadata) markdownOptions() markdownOptions {
+	options := siteMarkdownOptions()
+
+	if len(m.Markdown) > 0 {
+		options.renderer = m.Markdown
+	}
+
+	if m.Extensions != nil {
+		options.extensions = m.Extensions
+	}
+
+	return options
+}
+
+// parseMarkdownExtensions splits a comma-separated list of extension names.
+func parseMarkdownExtensions(value string) []string {
+	extensions := []string{}
+
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); len(name) > 0 {
+			extensions = append(extensions, name)
+		}
+	}
+
+	return extensions
+}
+
+// validateMarkdownOptions returns an error if the renderer or any of the
+// extensions are unknown.
+func validateMarkdownOptions(options markdownOptions) error {
+	if options.renderer != legacyMarkdown && options.renderer != commonMarkMarkdown {
+		return fmt.Errorf("unknown Markdown renderer %q (expected %v or %v)", options.renderer, legacyMarkdown, commonMarkMarkdown)
+	}
+
+	for _, name := range options.extensions {
+		if _, exists := commonMarkExtensions[name]; !exists {
+			return fmt.Errorf("unknown Markdown extension %q", name)
+		}
+	}
+
+	return nil
+}
+
+// convertMarkdownToHtml converts comments, using the renderer from the config.
+func convertMarkdownToHtml(markdown *[]byte) string {
+	return renderMarkdown(*markdown, siteMarkdownOptions(), plainHeadings)
+}
+
+// renderMarkdown converts Markdown to HTML, adding ids and links to headings
+// as set by headings.  Only the bodies of posts get them, as the ids of
+// comments and summaries could clash with the post's own.
+func renderMarkdown(markdown []byte, options markdownOptions, headings headingStyle) string {
+	if options.renderer == commonMarkMarkdown {
+		return renderCommonMark(markdown, options, headings)
+	}
+
+	var renderer blackfriday.Renderer = blackfriday.HtmlRenderer(legacyMarkdownHtmlFlags, "", "")
+
+	if options.highlight {
+		renderer = &highlightRenderer{renderer}
+	}
+
+	if headings != plainHeadings {
+		renderer = &headingRenderer{renderer, headingIds{}, headings == anchoredHeadings}
+	}
+
+	output := blackfriday.Markdown(markdown, renderer, legacyMarkdownExtensions)
+
+	return string(output)
+}
+
+// renderCommonMark converts Markdown following the CommonMark spec.  Raw HTML
+// is passed through, as it is by the legacy renderer.
+func renderCommonMark(markdown []byte, options markdownOptions, headings headingStyle) string {
+	extenders := []goldmark.Extender{}
+
+	for _, name := range options.extensions {
+		if extender, exists := commonMarkExtensions[name]; exists {
+			extenders = append(extenders, extender)
+		}
+	}
+
+	md := goldmark.New(goldmark.WithExtensions(extenders...), goldmark.WithRendererOptions(html.WithUnsafe()))
+
+	if options.highlight {
+		md.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(&commonMarkCodeRenderer{}, 100)))
+	}
+
+	if headings != plainHeadings {
+		headingRenderer := &commonMarkHeadingRenderer{md, headingIds{}, headings == anchoredHeadings}
+		md.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(headingRenderer, 100)))
+	}
+
+	var output bytes.Buffer
+
+	err := md.Convert(markdown, &output)
+
+	if err != nil {
+		log.Println("Could not convert Markdown:", err)
+	}
+
+	return output.String()
+}
+
+// commonMarkHeadingRenderer gives headings the same ids and links as
+// headingRenderer does for the legacy renderer.
+type commonMarkHeadingRenderer struct {
+	markdown goldmark.Markdown
+	ids      headingIds
+	links    bool
+}
+
+func (r *commonMarkHeadingRenderer) RegisterFuncs(registerer renderer.NodeRendererFuncRegisterer) {
+	registerer.Register(ast.KindHeading, r.renderHeading)
+}
+
+func (r *commonMarkHeadingRenderer) renderHeading(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	var inner bytes.Buffer
+
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		err := r.markdown.Renderer().Render(&inner, source, child)
+
+		if err != nil {
+			return ast.WalkStop, err
+		}
+	}
+
+	w.WriteString(r.ids.anchoredHeading(node.(*ast.Heading).Level, inner.String(), r.links))
+
+	return ast.WalkSkipChildren, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestMarkdownGolden renders every Markdown file in testdata/markdown and
+// compares it with the HTML that was written for it when the file was added,
+// so that changes to the renderers can't silently change existing posts.  The
+// output of the legacy renderer sits beside the Markdown; it was written by
+// the renderer Gobble used before the CommonMark renderer was added, so the
+// legacy renderer must still match it byte for byte.  The output of the
+// CommonMark renderer with every extension is in the commonmark folder.
+// Heading anchors are tested separately.
+func TestMarkdownGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "markdown", "*.md"))
+
+	if err != nil || len(files) == 0 {
+		t.Fatal("No golden files found", err)
+	}
+
+	SharedConfig = &Config{}
+	SharedConfig.setDefaults()
+
+	for _, file := range files {
+		markdown, err := ioutil.ReadFile(file)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		name := strings.TrimSuffix(filepath.Base(file), ".md") + ".html"
+
+		checkGolden(t, file, filepath.Join("testdata", "markdown", name), renderMarkdown(markdown, markdownOptions{renderer: legacyMarkdown}, plainHeadings))
+
+		// Posts on a site with the default config, which leaves heading
+		// anchors off, must get the original HTML too.
+		post := &BlogPost{}
+		output, _ := post.renderBody(string(markdown), 1, post.headingStyle())
+
+		checkGolden(t, file, filepath.Join("testdata", "markdown", name), output)
+
+		checkGolden(t, file, filepath.Join("testdata", "markdown", "commonmark", name), renderMarkdown(markdown, markdownOptions{renderer: commonMarkMarkdown, extensions: defaultMarkdownExtensions}, plainHeadings))
+	}
+}
+
+// checkGolden compares the output for a Markdown file with a golden file, or
+// rewrites the golden file if the tests are run with -update.
+func checkGolden(t *testing.T, file, golden, output string) {
+	if *updateGolden {
+		os.MkdirAll(filepath.Dir(golden), 0775)
+		ioutil.WriteFile(golden, []byte(output), 0644)
+		return
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output != string(expected) {
+		t.Errorf("%v does not match %v:\n%v", file, golden, output)
+	}
+}
+
+func TestPostMarkdownOptions(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	table := "| a | b |\n|---|---|\n| 1 | 2 |\n"
+
+	writeTestFiles(t, dir, map[string]string{
+		"posts/default.md":    "Title: Default\nDate: 2014-01-26 10:30:00\n\n" + table,
+		"posts/commonmark.md": "Title: CommonMark\nDate: 2014-01-27 10:30:00\nMarkdown: CommonMark\n\n" + table,
+		"posts/plain.md":      "---\ntitle: Plain\ndate: 2014-01-28 10:30:00\nmarkdown: commonmark\nextensions: []\n---\n\n" + table,
+		"posts/legacy.md":     "Title: Legacy\nDate: 2014-01-29 10:30:00\nMarkdown: legacy\n\n" + table,
+	})
+
+	for _, siteWide := range []string{legacyMarkdown, commonMarkMarkdown} {
+		SharedConfig.Markdown = siteWide
+		SharedConfig.MarkdownExtensions = []string{"table"}
+
+		blog, _ = LoadBlog(filepath.Join(dir, "posts"), filepath.Join(dir, "comments"), true)
+
+		expected := map[string]bool{"default": siteWide == commonMarkMarkdown, "commonmark": true, "plain": false, "legacy": false}
+
+		for _, post := range blog.AllPosts() {
+			name := strings.TrimSuffix(post.Filename, ".md")
+
+			if hasTable := strings.Contains(post.Body.HTML, "<table>"); hasTable != expected[name] {
+				t.Errorf("%v: expected a table to be rendered with the site-wide renderer %v: %v", name, siteWide, post.Body.HTML)
+			}
+
+			// Summaries use the post's renderer too.
+			if hasTable := strings.Contains(post.Summary, "<table>"); hasTable != expected[name] {
+				t.Errorf("%v: expected a table in the summary with the site-wide renderer %v: %v", name, siteWide, post.Summary)
+			}
+		}
+	}
+
+	// The keys are written back when a post is saved.
+	post, _ := blog.PostWithUrl("2014/01/28/plain")
+
+	if text := post.Metadata.String(); !strings.Contains(text, "Markdown: commonmark\nExtensions: \n") {
+		t.Errorf("Unexpected metadata %q", text)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// mediaExtensions are the types of file that can be uploaded.  Anything else,
+// such as HTML or SVG, could run scripts on the blog's domain when the media
+// directory serves it.
+var mediaExtensions = map[string]bool{
+	".avif": true,
+	".gif":  true,
+	".jpeg": true,
+	".jpg":  true,
+	".png":  true,
+	".webp": true,
+	".m4a":  true,
+	".mp3":  true,
+	".oga":  true,
+	".ogg":  true,
+	".opus": true,
+	".wav":  true,
+	".m4v":  true,
+	".mov":  true,
+	".mp4":  true,
+	".ogv":  true,
+	".webm": true,
+}
+
+var errUnsupportedMediaType = errors.New("Unsupported media type")
+
+// saveMediaFile stores an uploaded file in the media directory, under the
+// current year and month, and returns its absolute URL.  The filename is
+// cleaned up and made unique.  Files that aren't images, audio or video are
+// refused with errUnsupportedMediaType.
+func saveMediaFile(originalFilename, contentType string, content io.Reader) (string, error) {
+	now := time.Now()
+	extension := strings.ToLower(path.Ext(originalFilename))
+	name := filenameFromTitle(strings.TrimSuffix(path.Base(originalFilename), path.Ext(originalFilename)))
+
+	if len(name) == 0 {
+		name = strings.TrimSuffix(timeToFilename(now), validFilenameExtension)
+	}
+
+	if len(extension) == 0 || len(filenameFromTitle(extension)) == 0 {
+		extension = ""
+
+		extensions, _ := mime.ExtensionsByType(contentType)
+
+		for _, e := range extensions {
+			if mediaExtensions[e] {
+				extension = e
+				break
+			}
+		}
+	}
+
+	if !mediaExtensions[extension] {
+		return "", errUnsupportedMediaType
+	}
+
+	directory := fmt.Sprintf("%04d/%02d", now.Year(), now.Month())
+	fullDirectory := filepath.Join(SharedConfig.MediaPath, filepath.FromSlash(directory))
+
+	err := os.MkdirAll(fullDirectory, 0775)
+
+	if err != nil {
+		return "", err
+	}
+
+	filename := name + extension
+
+	for i := 2; ; i++ {
+		output, err := os.OpenFile(filepath.Join(fullDirectory, filename), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+
+		if os.IsExist(err) {
+			filename = fmt.Sprintf("%s-%d%s", name, i, extension)
+			continue
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		_, err = io.Copy(output, content)
+		output.Close()
+
+		if err != nil {
+			os.Remove(output.Name())
+			return "", err
+		}
+
+		break
+	}
+
+	log.Println("Saved media", directory+"/"+filename)
+
+	return SharedConfig.Address + "/media/" + directory + "/" + filename, nil
+}
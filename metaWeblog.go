@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ant512/gobble/xmlrpc"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const metaWeblogBlogId = "1"
+const maxXmlRpcRequestSize = 32 * 1024 * 1024
+
+// Fault codes.  The negative codes are the de facto standard ones for
+// malformed requests; the rest follow WordPress so that clients recognise
+// them.
+const (
+	xmlRpcParseError     = -32700
+	xmlRpcUnknownMethod  = -32601
+	xmlRpcInvalidParams  = -32602
+	xmlRpcNotAuthorised  = 403
+	xmlRpcNotFound       = 404
+	xmlRpcInternalError  = 500
+	xmlRpcInvalidRequest = 400
+)
+
+// xmlRpcMethod describes a MetaWeblog or Blogger API method.  Every method
+// takes a username and password, but not always at the same position.
+type xmlRpcMethod struct {
+	paramCount    int
+	usernameIndex int
+	handler       func(params []interface{}) (interface{}, error)
+}
+
+var xmlRpcMethods = map[string]xmlRpcMethod{
+	"blogger.getUsersBlogs":     {3, 1, getUsersBlogs},
+	"blogger.getRecentPosts":    {5, 2, getRecentBloggerPosts},
+	"blogger.deletePost":        {4, 2, deleteMetaWeblogPost},
+	"metaWeblog.getUsersBlogs":  {3, 1, getUsersBlogs},
+	"metaWeblog.newPost":        {4, 1, newMetaWeblogPost},
+	"metaWeblog.editPost":       {4, 1, editMetaWeblogPost},
+	"metaWeblog.getPost":        {3, 1, getMetaWeblogPost},
+	"metaWeblog.getRecentPosts": {4, 1, getRecentMetaWeblogPosts},
+	"metaWeblog.getCategories":  {3, 1, getMetaWeblogCategories},
+	"metaWeblog.newMediaObject": {4, 1, newMetaWeblogMediaObject},
+	"metaWeblog.deletePost":     {4, 2, deleteMetaWeblogPost},
+}
+
+// xmlRpc handles MetaWeblog and Blogger API requests, which are used by
+// desktop blog editors.  The API uses the admin credentials and does not exist
+// if no admin password has been configured.
+func xmlRpc(w http.ResponseWriter, req *http.Request) {
+
+	if len(SharedConfig.AdminPassword) == 0 {
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+
+	call, err := xmlrpc.ParseMethodCall(io.LimitReader(req.Body, maxXmlRpcRequestSize))
+
+	if err != nil {
+		xmlrpc.WriteFault(w, &xmlrpc.Fault{Code: xmlRpcParseError, Message: err.Error()})
+		return
+	}
+
+	result, err := callXmlRpcMethod(call)
+
+	if err != nil {
+		fault, ok := err.(*xmlrpc.Fault)
+
+		if !ok {
+			log.Println("XML-RPC method", call.Method, "failed:", err)
+			fault = &xmlrpc.Fault{Code: xmlRpcInternalError, Message: err.Error()}
+		}
+
+		xmlrpc.WriteFault(w, fault)
+		return
+	}
+
+	err = xmlrpc.WriteResponse(w, result)
+
+	if err != nil {
+		log.Println("Could not write XML-RPC response:", err)
+	}
+}
+
+func callXmlRpcMethod(call *xmlrpc.MethodCall) (interface{}, error) {
+	if call.Method == "system.listMethods" || call.Method == "mt.supportedMethods" {
+		return supportedXmlRpcMethods(), nil
+	}
+
+	method, ok := xmlRpcMethods[call.Method]
+
+	if !ok {
+		return nil, &xmlrpc.Fault{Code: xmlRpcUnknownMethod, Message: "Unknown method " + call.Method}
+	}
+
+	if len(call.Params) < method.paramCount {
+		return nil, &xmlrpc.Fault{Code: xmlRpcInvalidParams, Message: "Not enough parameters"}
+	}
+
+	username, _ := call.Params[method.usernameIndex].(string)
+	password, _ := call.Params[method.usernameIndex+1].(string)
+
+	if !isAdmin(username, password) {
+		log.Println("Rejected XML-RPC call to", call.Method, "with incorrect credentials")
+		return nil, &xmlrpc.Fault{Code: xmlRpcNotAuthorised, Message: "Incorrect username or password"}
+	}
+
+	return method.handler(call.Params)
+}
+
+func supportedXmlRpcMethods() []string {
+	methods := []string{"mt.supportedMethods", "system.listMethods"}
+
+	for name := range xmlRpcMethods {
+		methods = append(methods, name)
+	}
+
+	sort.Strings(methods)
+
+	return methods
+}
+
+func getUsersBlogs(params []interface{}) (interface{}, error) {
+	return []map[string]interface{}{
+		{
+			"blogid":   metaWeblogBlogId,
+			"blogName": SharedConfig.Name,
+			"url":      SharedConfig.Address + "/",
+			"isAdmin":  true,
+		},
+	}, nil
+}
+
+func newMetaWeblogPost(params []interface{}) (interface{}, error) {
+	content, ok := params[3].(map[string]interface{})
+
+	if !ok {
+		return nil, &xmlrpc.Fault{Code: xmlRpcInvalidParams, Message: "Post content must be a struct"}
+	}
+
+	metadata, body := metaWeblogPostFromStruct(content, BlogPostMetadata{}, "")
+
+	if len(strings.TrimSpace(metadata.Title)) == 0 {
+		return nil, &xmlrpc.Fault{Code: xmlRpcInvalidRequest, Message: "A post needs a title"}
+	}
+
+	if metadata.Date.IsZero() {
+		metadata.Date = time.Now()
+	}
+
+	name := metadata.Title
+
+	if slug, ok := content["wp_slug"].(string); ok && len(slug) > 0 {
+		name = slug
+	} else if slug, ok := content["mt_basename"].(string); ok && len(slug) > 0 {
+		name = slug
+	}
+
+	filename := blog.NewPostFilename(name, metadata.Date)
+	post, err := blog.WritePost(filename, metadata, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("Created post", filename, "via XML-RPC")
+
+	return metaWeblogPostId(post), nil
+}
+
+func editMetaWeblogPost(params []interface{}) (interface{}, error) {
+	post, err := postWithMetaWeblogId(params[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	content, ok := params[3].(map[string]interface{})
+
+	if !ok {
+		return nil, &xmlrpc.Fault{Code: xmlRpcInvalidParams, Message: "Post content must be a struct"}
+	}
+
+	metadata, body := metaWeblogPostFromStruct(content, post.Metadata, post.Body.Markdown)
+
+	if len(strings.TrimSpace(metadata.Title)) == 0 {
+		return nil, &xmlrpc.Fault{Code: xmlRpcInvalidRequest, Message: "A post needs a title"}
+	}
+
+	_, err = blog.WritePost(post.Filename, metadata, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("Updated post", post.Filename, "via XML-RPC")
+
+	return true, nil
+}
+
+func getMetaWeblogPost(params []interface{}) (interface{}, error) {
+	post, err := postWithMetaWeblogId(params[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	return metaWeblogStructFromPost(post), nil
+}
+
+func getRecentMetaWeblogPosts(params []interface{}) (interface{}, error) {
+	count, ok := params[3].(int)
+
+	if !ok || count < 1 {
+		return nil, &xmlrpc.Fault{Code: xmlRpcInvalidParams, Message: "The number of posts must be a positive integer"}
+	}
+
+	posts, _ := blog.SearchPosts("", 0, count)
+	result := []map[string]interface{}{}
+
+	for _, post := range posts {
+		result = append(result, metaWeblogStructFromPost(post))
+	}
+
+	return result, nil
+}
+
+// getRecentBloggerPosts handles the Blogger version of getRecentPosts, which
+// has an extra appkey parameter at the start.
+func getRecentBloggerPosts(params []interface{}) (interface{}, error) {
+	return getRecentMetaWeblogPosts(params[1:])
+}
+
+func getMetaWeblogCategories(params []interface{}) (interface{}, error) {
+	result := []map[string]interface{}{}
+
+	for _, tag := range blog.AllTagNames() {
+		result = append(result, map[string]interface{}{
+			"categoryId":   tag,
+			"categoryName": tag,
+			"title":        tag,
+			"description":  tag,
+			"htmlUrl":      SharedConfig.Address + "/tags/" + tag,
+			"rssUrl":       SharedConfig.Address + "/rss",
+		})
+	}
+
+	return result, nil
+}
+
+func newMetaWeblogMediaObject(params []interface{}) (interface{}, error) {
+	file, ok := params[3].(map[string]interface{})
+
+	if !ok {
+		return nil, &xmlrpc.Fault{Code: xmlRpcInvalidParams, Message: "Media object must be a struct"}
+	}
+
+	name, _ := file["name"].(string)
+	contentType, _ := file["type"].(string)
+	bits, ok := file["bits"].([]byte)
+
+	if !ok {
+		return nil, &xmlrpc.Fault{Code: xmlRpcInvalidParams, Message: "Media object has no base64 bits"}
+	}
+
+	location, err := saveMediaFile(name, contentType, bytes.NewReader(bits))
+
+	if err == errUnsupportedMediaType {
+		return nil, &xmlrpc.Fault{Code: xmlRpcInvalidParams, Message: "Only images, audio and video can be uploaded"}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"file": location[strings.LastIndex(location, "/")+1:],
+		"url":  location,
+		"type": contentType,
+	}, nil
+}
+
+func deleteMetaWeblogPost(params []interface{}) (interface{}, error) {
+	post, err := postWithMetaWeblogId(params[1])
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = blog.DeletePost(post.Filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("Deleted post", post.Filename, "via XML-RPC")
+
+	return true, nil
+}
+
+// metaWeblogPostId identifies a post by its filename without the extension,
+// which, unlike its URL, doesn't change when the post is edited.
+func metaWeblogPostId(post *BlogPost) string {
+	return strings.TrimSuffix(post.Filename, validFilenameExtension)
+}
+
+func postWithMetaWeblogId(id interface{}) (*BlogPost, error) {
+	post, err := blog.PostWithFilename(fmt.Sprint(id) + validFilenameExtension)
+
+	if err != nil {
+		return nil, &xmlrpc.Fault{Code: xmlRpcNotFound, Message: "Could not find post " + fmt.Sprint(id)}
+	}
+
+	return post, nil
+}
+
+// metaWeblogPostFromStruct maps the members of a MetaWeblog post struct onto
+// the metadata and body of a post.  Missing members leave the given values
+// unchanged.
+func metaWeblogPostFromStruct(content map[string]interface{}, metadata BlogPostMetadata, body string) (BlogPostMetadata, string) {
+	if title, ok := content["title"].(string); ok {
+		metadata.Title = singleLine(title)
+	}
+
+	if description, ok := content["description"].(string); ok {
+		body = description
+	}
+
+	if more, ok := content["mt_text_more"].(string); ok && len(strings.TrimSpace(more)) > 0 {
+		body = strings.TrimRight(body, "\n") + "\n\n" + more
+	}
+
+	_, hasCategories := content["categories"]
+	_, hasKeywords := content["mt_keywords"]
+
+	if hasCategories || hasKeywords {
+		metadata.Tags = []string{}
+
+		if categories, ok := content["categories"].([]interface{}); ok {
+			for _, category := range categories {
+				if tag, ok := category.(string); ok {
+					metadata.Tags = append(metadata.Tags, tag)
+				}
+			}
+		}
+
+		if keywords, ok := content["mt_keywords"].(string); ok {
+			for _, keyword := range strings.Split(keywords, ",") {
+				if len(strings.TrimSpace(keyword)) > 0 {
+					metadata.Tags = append(metadata.Tags, strings.TrimSpace(keyword))
+				}
+			}
+		}
+	}
+
+	if date, ok := content["dateCreated"].(time.Time); ok {
+		metadata.Date = date
+	} else if date, ok := content["date_created_gmt"].(time.Time); ok {
+		metadata.Date = date
+	}
+
+	switch allow := content["mt_allow_comments"].(type) {
+	case int:
+		metadata.DisallowComments = allow == 0
+	case bool:
+		metadata.DisallowComments = !allow
+	case string:
+		metadata.DisallowComments = allow == "closed" || allow == "0"
+	}
+
+	return metadata, strings.TrimLeft(body, "\n")
+}
+
+func metaWeblogStructFromPost(post *BlogPost) map[string]interface{} {
+	allowComments := 1
+
+	if post.Metadata.DisallowComments {
+		allowComments = 0
+	}
+
+	return map[string]interface{}{
+		"postid":            metaWeblogPostId(post),
+		"title":             post.Metadata.Title,
+		"description":       strings.TrimLeft(post.Body.Markdown, "\n"),
+		"categories":        post.Metadata.Tags,
+		"mt_keywords":       strings.Join(post.Metadata.Tags, ", "),
+		"dateCreated":       post.Metadata.Date,
+		"date_created_gmt":  post.Metadata.Date,
+		"link":              post.Permalink(),
+		"permaLink":         post.Permalink(),
+		"mt_allow_comments": allowComments,
+		"userid":            SharedConfig.AdminUsername,
+	}
+}
+
+// rsd describes the XML-RPC API so that blog editors can configure themselves
+// from the blog's address alone.
+func rsd(w http.ResponseWriter, req *http.Request) {
+
+	if len(SharedConfig.AdminPassword) == 0 {
+		http.NotFound(w, req)
+		return
+	}
+
+	address := html.EscapeString(SharedConfig.Address)
+	api := address + "/xmlrpc"
+
+	w.Header().Set("Content-Type", "application/rsd+xml; charset=utf-8")
+
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<rsd version="1.0" xmlns="http://archipelago.phrasewise.com/rsd">
+	<service>
+		<engineName>Gobble</engineName>
+		<engineLink>http://simianzombie.com</engineLink>
+		<homePageLink>%v/</homePageLink>
+		<apis>
+			<api name="MetaWeblog" preferred="true" apiLink="%v" blogID="%v" />
+			<api name="Blogger" preferred="false" apiLink="%v" blogID="%v" />
+		</apis>
+	</service>
+</rsd>
+`, address, api, metaWeblogBlogId, api, metaWeblogBlogId)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"github.com/ant512/gobble/xmlrpc"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func callMetaWeblog(t *testing.T, method string, params string) string {
+	body := `<?xml version="1.0"?><methodCall><methodName>` + method + `</methodName><params>` + params + `</params></methodCall>`
+	req, _ := http.NewRequest("POST", "/xmlrpc", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	xmlRpc(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatal("Incorrect status:", w.Code)
+	}
+
+	return w.Body.String()
+}
+
+const metaWeblogCredentials = `<param><value>admin</value></param><param><value>password</value></param>`
+
+func createMetaWeblogTestBlog(t *testing.T) string {
+	dir := createMicropubTestBlog(t)
+	SharedConfig.AdminUsername = "admin"
+	SharedConfig.AdminPassword = "password"
+
+	return dir
+}
+
+func TestMetaWeblogRequiresCredentials(t *testing.T) {
+	dir := createMetaWeblogTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	response := callMetaWeblog(t, "metaWeblog.newPost", `<param><value>1</value></param><param><value>admin</value></param><param><value>wrong</value></param><param><value><struct><member><name>title</name><value>Hi</value></member></struct></value></param>`)
+
+	if !strings.Contains(response, "<fault>") || !strings.Contains(response, "<int>403</int>") {
+		t.Error("Incorrect credentials accepted:", response)
+	}
+
+	if len(blog.AllPosts()) != 0 {
+		t.Error("Post created with incorrect credentials")
+	}
+}
+
+func TestMetaWeblogPosts(t *testing.T) {
+	dir := createMetaWeblogTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	response := callMetaWeblog(t, "metaWeblog.newPost", `<param><value>1</value></param>`+metaWeblogCredentials+`<param><value><struct>
+		<member><name>title</name><value>Desktop Post</value></member>
+		<member><name>description</name><value>&lt;p&gt;Written elsewhere.&lt;/p&gt;</value></member>
+		<member><name>categories</name><value><array><data><value>Go</value></data></array></value></member>
+		<member><name>dateCreated</name><value><dateTime.iso8601>20140126T10:30:00</dateTime.iso8601></value></member>
+		<member><name>mt_allow_comments</name><value><int>0</int></value></member>
+	</struct></value></param><param><value><boolean>1</boolean></value></param>`)
+
+	if !strings.Contains(response, "<string>desktop-post</string>") {
+		t.Fatal("Incorrect post ID:", response)
+	}
+
+	post, err := blog.PostWithFilename("desktop-post.md")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if post.Url != "2014/01/26/desktop-post" || !post.Metadata.DisallowComments || post.Metadata.Tags[0] != "go" {
+		t.Error("Incorrect post:", post.Url, post.Metadata)
+	}
+
+	if !post.Metadata.Date.Equal(time.Date(2014, 1, 26, 10, 30, 0, 0, time.UTC)) {
+		t.Error("Incorrect date:", post.Metadata.Date)
+	}
+
+	response = callMetaWeblog(t, "metaWeblog.editPost", `<param><value>desktop-post</value></param>`+metaWeblogCredentials+`<param><value><struct>
+		<member><name>description</name><value>Changed.</value></member>
+	</struct></value></param><param><value><boolean>1</boolean></value></param>`)
+
+	if !strings.Contains(response, "<boolean>1</boolean>") {
+		t.Fatal("Edit failed:", response)
+	}
+
+	post, _ = blog.PostWithFilename("desktop-post.md")
+
+	if post.Metadata.Title != "Desktop Post" || strings.TrimSpace(post.Body.Markdown) != "Changed." {
+		t.Error("Incorrect edited post:", post.Metadata.Title, post.Body.Markdown)
+	}
+
+	response = callMetaWeblog(t, "metaWeblog.getRecentPosts", `<param><value>1</value></param>`+metaWeblogCredentials+`<param><value><int>10</int></value></param>`)
+
+	if !strings.Contains(response, "<string>http://blog.example/posts/2014/01/26/desktop-post</string>") || !strings.Contains(response, "<string>Changed.") {
+		t.Error("Incorrect recent posts:", response)
+	}
+
+	response = callMetaWeblog(t, "blogger.deletePost", `<param><value>key</value></param><param><value>desktop-post</value></param>`+metaWeblogCredentials+`<param><value><boolean>1</boolean></value></param>`)
+
+	if !strings.Contains(response, "<boolean>1</boolean>") || len(blog.AllPosts()) != 0 {
+		t.Error("Delete failed:", response)
+	}
+
+	response = callMetaWeblog(t, "metaWeblog.getPost", `<param><value>desktop-post</value></param>`+metaWeblogCredentials)
+
+	if !strings.Contains(response, "<int>404</int>") {
+		t.Error("Deleted post found:", response)
+	}
+}
+
+func TestMetaWeblogNewMediaObject(t *testing.T) {
+	dir := createMetaWeblogTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	response := callMetaWeblog(t, "metaWeblog.newMediaObject", `<param><value>1</value></param>`+metaWeblogCredentials+`<param><value><struct>
+		<member><name>name</name><value>Photo.jpg</value></member>
+		<member><name>type</name><value>image/jpeg</value></member>
+		<member><name>bits</name><value><base64>aGVsbG8=</base64></value></member>
+	</struct></value></param>`)
+
+	expected := "http://blog.example/media/" + time.Now().Format("2006/01") + "/photo.jpg"
+
+	if !strings.Contains(response, "<string>"+expected+"</string>") {
+		t.Error("Incorrect media URL:", response)
+	}
+}
+
+func TestUnknownXmlRpcMethod(t *testing.T) {
+	dir := createMetaWeblogTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	response := callMetaWeblog(t, "wp.getOptions", "")
+
+	if !strings.Contains(response, "<int>-32601</int>") {
+		t.Error("Unknown method accepted:", response)
+	}
+
+	call := &xmlrpc.MethodCall{Method: "system.listMethods"}
+
+	if methods, err := callXmlRpcMethod(call); err != nil || len(methods.([]string)) != len(xmlRpcMethods)+2 {
+		t.Error("Incorrect method list:", methods, err)
+	}
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const maxMicropubMediaSize = 32 * 1024 * 1024
+const maxMicropubTitleLength = 60
+
+var htmlTagPattern = regexp.MustCompile("<[^>]*>")
+
+// micropubRequest is a Micropub request in its JSON form.  Form-encoded
+// requests are converted into the same structure.
+type micropubRequest struct {
+	Type       []string                 `json:"type"`
+	Action     string                   `json:"action"`
+	Url        string                   `json:"url"`
+	Properties map[string][]interface{} `json:"properties"`
+	Replace    map[string][]interface{} `json:"replace"`
+	Add        map[string][]interface{} `json:"add"`
+	Delete     interface{}              `json:"delete"`
+}
+
+type micropubError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// micropubPost is a post in the form that Micropub clients expect to receive
+// when they ask for its source.
+type micropubPost struct {
+	Type       []string                 `json:"type"`
+	Properties map[string][]interface{} `json:"properties"`
+}
+
+func micropubQuery(w http.ResponseWriter, req *http.Request) {
+
+	mediaEndpoint := SharedConfig.Address + "/micropub/media"
+
+	switch req.FormValue("q") {
+	case "config":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"media-endpoint": mediaEndpoint,
+			"syndicate-to":   []string{},
+		})
+	case "syndicate-to":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"syndicate-to": []string{},
+		})
+	case "category":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"categories": blog.AllTagNames(),
+		})
+	case "source":
+		post := postWithPermalink(req.FormValue("url"))
+
+		if post == nil {
+			writeMicropubError(w, http.StatusBadRequest, "invalid_request", "The URL is not a post on this site")
+			return
+		}
+
+		source := micropubSource(post)
+		wanted := req.Form["properties[]"]
+
+		if len(wanted) == 0 {
+			wanted = req.Form["properties"]
+		}
+
+		if len(wanted) > 0 {
+			properties := make(map[string][]interface{})
+
+			for _, name := range wanted {
+				if value, ok := source.Properties[name]; ok {
+					properties[name] = value
+				}
+			}
+
+			writeJSON(w, http.StatusOK, map[string]interface{}{"properties": properties})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, source)
+	default:
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", "Unsupported query")
+	}
+}
+
+func micropub(w http.ResponseWriter, req *http.Request) {
+	request, err := parseMicropubRequest(req)
+
+	if err != nil {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	switch request.Action {
+	case "", "create":
+		createMicropubPost(w, req, request)
+	case "update":
+		updateMicropubPost(w, request)
+	case "delete":
+		deleteMicropubPost(w, request)
+	default:
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", "Unsupported action "+request.Action)
+	}
+}
+
+func createMicropubPost(w http.ResponseWriter, req *http.Request, request *micropubRequest) {
+
+	if len(request.Type) > 0 && request.Type[0] != "h-entry" {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", "Only h-entry posts are supported")
+		return
+	}
+
+	// Photos can be uploaded along with the post rather than sent to the media
+	// endpoint first.
+	if req.MultipartForm != nil {
+		for _, header := range req.MultipartForm.File["photo"] {
+			location, err := saveMicropubMedia(header)
+
+			if err != nil {
+				writeMicropubError(w, http.StatusBadRequest, "invalid_request", err.Error())
+				return
+			}
+
+			request.Properties["photo"] = append(request.Properties["photo"], location)
+		}
+	}
+
+	metadata, body, err := micropubPostFromProperties(request.Properties, BlogPostMetadata{}, "")
+
+	if err != nil {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	if len(metadata.Title) == 0 {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", "A post needs a name or content")
+		return
+	}
+
+	if metadata.Date.IsZero() {
+		metadata.Date = time.Now()
+	}
+
+	name := metadata.Title
+
+	if slug := firstMicropubString(request.Properties["mp-slug"]); len(slug) > 0 {
+		name = slug
+	}
+
+	filename := blog.NewPostFilename(name, metadata.Date)
+	post, err := blog.WritePost(filename, metadata, body)
+
+	if err != nil {
+		log.Println("Could not create post:", err)
+		writeMicropubError(w, http.StatusInternalServerError, "server_error", "Could not create post")
+		return
+	}
+
+	log.Println("Created post", filename, "via micropub")
+
+	w.Header().Set("Location", post.Permalink())
+	w.WriteHeader(http.StatusCreated)
+}
+
+func updateMicropubPost(w http.ResponseWriter, request *micropubRequest) {
+
+	post := postWithPermalink(request.Url)
+
+	if post == nil {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", "The URL is not a post on this site")
+		return
+	}
+
+	source := micropubSource(post).Properties
+
+	for name, values := range request.Replace {
+		source[name] = values
+	}
+
+	for name, values := range request.Add {
+		source[name] = append(source[name], values...)
+	}
+
+	switch deleted := request.Delete.(type) {
+	case []interface{}:
+		for _, name := range deleted {
+			if name, ok := name.(string); ok {
+				delete(source, name)
+			}
+		}
+	case map[string]interface{}:
+		for name, values := range deleted {
+			values, ok := values.([]interface{})
+
+			if !ok {
+				continue
+			}
+
+			source[name] = removeMicropubValues(source[name], values)
+		}
+	}
+
+	// Properties that have been removed entirely must be cleared rather than
+	// left at their existing values.
+	metadata := post.Metadata
+	metadata.Title = ""
+	metadata.Tags = []string{}
+
+	metadata, body, err := micropubPostFromProperties(source, metadata, "")
+
+	if err != nil {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	if len(metadata.Title) == 0 {
+		metadata.Title = post.Metadata.Title
+	}
+
+	previousUrl := post.Url
+	post, err = blog.WritePost(post.Filename, metadata, body)
+
+	if err != nil {
+		log.Println("Could not update post:", err)
+		writeMicropubError(w, http.StatusInternalServerError, "server_error", "Could not update post")
+		return
+	}
+
+	log.Println("Updated post", post.Filename, "via micropub")
+
+	if post.Url != previousUrl {
+		w.Header().Set("Location", post.Permalink())
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func deleteMicropubPost(w http.ResponseWriter, request *micropubRequest) {
+
+	post := postWithPermalink(request.Url)
+
+	if post == nil {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", "The URL is not a post on this site")
+		return
+	}
+
+	err := blog.DeletePost(post.Filename)
+
+	if err != nil {
+		log.Println("Could not delete post:", err)
+		writeMicropubError(w, http.StatusInternalServerError, "server_error", "Could not delete post")
+		return
+	}
+
+	log.Println("Deleted post", post.Filename, "via micropub")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// micropubMedia stores a file uploaded to the media endpoint in the media
+// directory, under the current year and month.
+func micropubMedia(w http.ResponseWriter, req *http.Request) {
+	_, header, err := req.FormFile("file")
+
+	if err != nil {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", "No file was uploaded")
+		return
+	}
+
+	location, err := saveMicropubMedia(header)
+
+	if err == errUnsupportedMediaType {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", "Only images, audio and video can be uploaded")
+		return
+	}
+
+	if err != nil {
+		log.Println("Could not save media:", err)
+		writeMicropubError(w, http.StatusInternalServerError, "server_error", "Could not save file")
+		return
+	}
+
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func saveMicropubMedia(header *multipart.FileHeader) (string, error) {
+	file, err := header.Open()
+
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	return saveMediaFile(header.Filename, header.Header.Get("Content-Type"), file)
+}
+
+func parseMicropubRequest(req *http.Request) (*micropubRequest, error) {
+	request := &micropubRequest{}
+	contentType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+
+	if contentType == "application/json" {
+		err := json.NewDecoder(req.Body).Decode(request)
+
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse JSON: %v", err)
+		}
+
+		if request.Properties == nil {
+			request.Properties = make(map[string][]interface{})
+		}
+
+		return request, nil
+	}
+
+	if contentType == "multipart/form-data" {
+		err := req.ParseMultipartForm(maxMicropubMediaSize)
+
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err := req.ParseForm()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	request.Properties = make(map[string][]interface{})
+
+	for key, values := range req.PostForm {
+		switch key {
+		case "h":
+			request.Type = []string{"h-" + values[0]}
+		case "action":
+			request.Action = values[0]
+		case "url":
+			request.Url = values[0]
+		case "access_token":
+		default:
+			key = strings.TrimSuffix(key, "[]")
+
+			for _, value := range values {
+				request.Properties[key] = append(request.Properties[key], value)
+			}
+		}
+	}
+
+	return request, nil
+}
+
+// micropubPostFromProperties maps Micropub properties onto the metadata and
+// body of a post.  Properties that are not present leave the given values
+// unchanged.  It returns an error if a property can't be stored in a post.
+func micropubPostFromProperties(properties map[string][]interface{}, metadata BlogPostMetadata, body string) (BlogPostMetadata, string, error) {
+	if values, ok := properties["content"]; ok {
+		body = firstMicropubString(values)
+	}
+
+	for _, photo := range properties["photo"] {
+		location := ""
+		alt := ""
+
+		switch photo := photo.(type) {
+		case string:
+			location = photo
+		case map[string]interface{}:
+			location, _ = photo["value"].(string)
+			alt, _ = photo["alt"].(string)
+		}
+
+		if len(location) > 0 && !strings.Contains(body, location) {
+			body = strings.TrimRight(body, "\n") + "\n\n![" + alt + "](" + location + ")\n"
+		}
+	}
+
+	body = strings.TrimLeft(body, "\n")
+
+	if values, ok := properties["name"]; ok {
+		metadata.Title = singleLine(firstMicropubString(values))
+	}
+
+	if len(metadata.Title) == 0 {
+		metadata.Title = micropubTitleFromContent(body)
+	}
+
+	if values, ok := properties["summary"]; ok {
+		metadata.Summary = firstMicropubString(values)
+	}
+
+	if values, ok := properties["category"]; ok {
+		metadata.Tags = []string{}
+
+		for _, value := range values {
+			tag, ok := value.(string)
+
+			if !ok {
+				continue
+			}
+
+			// Tags are stored as a comma-separated list, so a comma would
+			// split the category into several tags.
+			if strings.Contains(tag, ",") {
+				return metadata, body, fmt.Errorf("Categories can't contain commas: %v", tag)
+			}
+
+			metadata.Tags = append(metadata.Tags, tag)
+		}
+	}
+
+	if values, ok := properties["published"]; ok {
+		date, err := parseMicropubDate(firstMicropubString(values))
+
+		if err != nil {
+			return metadata, body, err
+		}
+
+		metadata.Date = date
+	}
+
+	return metadata, body, nil
+}
+
+func micropubSource(post *BlogPost) *micropubPost {
+	tags := []interface{}{}
+
+	for _, tag := range post.Metadata.Tags {
+		tags = append(tags, tag)
+	}
+
+	source := &micropubPost{
+		Type: []string{"h-entry"},
+		Properties: map[string][]interface{}{
+			"name":      {post.Metadata.Title},
+			"content":   {strings.TrimLeft(post.Body.Markdown, "\n")},
+			"category":  tags,
+			"published": {post.Metadata.Date.Format(time.RFC3339)},
+			"url":       {post.Permalink()},
+		},
+	}
+
+	if len(post.Metadata.Summary) > 0 {
+		source.Properties["summary"] = []interface{}{post.Metadata.Summary}
+	}
+
+	return source
+}
+
+// firstMicropubString returns the first value of a property as a string.
+// Content may be supplied as an object with html or value fields.
+func firstMicropubString(values []interface{}) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	switch value := values[0].(type) {
+	case string:
+		return value
+	case map[string]interface{}:
+		if html, ok := value["html"].(string); ok {
+			return html
+		}
+
+		if text, ok := value["value"].(string); ok {
+			return text
+		}
+	}
+
+	return ""
+}
+
+func removeMicropubValues(values []interface{}, remove []interface{}) []interface{} {
+	kept := []interface{}{}
+
+	for _, value := range values {
+		found := false
+
+		for _, r := range remove {
+			if s, ok := value.(string); ok && s == r {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			kept = append(kept, value)
+		}
+	}
+
+	return kept
+}
+
+// micropubTitleFromContent creates a title for notes, which don't have names,
+// from the first words of the first line of their content.
+func micropubTitleFromContent(content string) string {
+	firstLine := strings.SplitN(strings.TrimSpace(content), "\n", 2)[0]
+	words := strings.Fields(htmlTagPattern.ReplaceAllString(firstLine, " "))
+	title := ""
+
+	for _, word := range words {
+		if len(title)+len(word)+1 > maxMicropubTitleLength {
+			return title + "…"
+		}
+
+		if len(title) > 0 {
+			title += " "
+		}
+
+		title += word
+	}
+
+	return title
+}
+
+func parseMicropubDate(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Could not parse the published date %q", value)
+}
+
+func writeMicropubError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, micropubError{code, description})
+}
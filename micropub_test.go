@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createMicropubTestBlog(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "gobble")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"posts", "comments", "media"} {
+		os.Mkdir(filepath.Join(dir, name), 0775)
+	}
+
+	SharedConfig = &Config{
+		Address:   "http://blog.example",
+		MediaPath: filepath.Join(dir, "media"),
+		APITokens: []string{"secret"},
+	}
+
+	blog, err = LoadBlog(filepath.Join(dir, "posts"), filepath.Join(dir, "comments"), true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func micropubRequestWithToken(method, target, contentType string, body []byte) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, target, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	w := httptest.NewRecorder()
+
+	switch {
+	case method == "GET":
+		requireToken(micropubQuery)(w, req)
+	case strings.HasSuffix(target, "/media"):
+		requireToken(micropubMedia)(w, req)
+	default:
+		requireToken(micropub)(w, req)
+	}
+
+	return w
+}
+
+func TestMicropubRequiresToken(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	for _, authorization := range []string{"", "Bearer wrong"} {
+		req, _ := http.NewRequest("POST", "/micropub", strings.NewReader("h=entry&content=Hello"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", authorization)
+
+		w := httptest.NewRecorder()
+		requireToken(micropub)(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Error("Request with authorization", authorization, "was not rejected:", w.Code)
+		}
+	}
+
+	if len(blog.AllPosts()) != 0 {
+		t.Error("Unauthorised request created a post")
+	}
+}
+
+func TestMicropubCreateFromForm(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	form := url.Values{
+		"h":          {"entry"},
+		"name":       {"Micropub Post"},
+		"content":    {"Hello *world*."},
+		"category[]": {"go", "Micropub"},
+		"published":  {"2014-01-26T10:30:00Z"},
+	}
+
+	w := micropubRequestWithToken("POST", "/micropub", "application/x-www-form-urlencoded", []byte(form.Encode()))
+
+	if w.Code != http.StatusCreated {
+		t.Fatal("Incorrect status:", w.Code, w.Body.String())
+	}
+
+	if w.Header().Get("Location") != "http://blog.example/posts/2014/01/26/micropub-post" {
+		t.Error("Incorrect location:", w.Header().Get("Location"))
+	}
+
+	post, err := blog.PostWithUrl("2014/01/26/micropub-post")
+
+	if err != nil {
+		t.Fatal("Post was not added to the blog:", err)
+	}
+
+	if post.Filename != "micropub-post.md" {
+		t.Error("Incorrect filename:", post.Filename)
+	}
+
+	// Reloading the file from disk must give the same post.
+	loaded, err := LoadPost(post.Filename, blog.postPath, blog.commentPath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.Metadata.Title != "Micropub Post" || !loaded.Metadata.Date.Equal(time.Date(2014, 1, 26, 10, 30, 0, 0, time.UTC)) {
+		t.Error("Incorrect metadata:", loaded.Metadata)
+	}
+
+	if len(loaded.Metadata.Tags) != 2 || loaded.Metadata.Tags[0] != "go" || loaded.Metadata.Tags[1] != "micropub" {
+		t.Error("Incorrect tags:", loaded.Metadata.Tags)
+	}
+
+	if strings.TrimSpace(loaded.Body.Markdown) != "Hello *world*." {
+		t.Error("Incorrect body:", loaded.Body.Markdown)
+	}
+}
+
+func TestMicropubRejectsInvalidProperties(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	tests := []url.Values{
+		{"h": {"entry"}, "name": {"Bad Date"}, "content": {"Text"}, "published": {"next tuesday"}},
+		{"h": {"entry"}, "name": {"Bad Category"}, "content": {"Text"}, "category": {"a,b"}},
+	}
+
+	for _, form := range tests {
+		w := micropubRequestWithToken("POST", "/micropub", "application/x-www-form-urlencoded", []byte(form.Encode()))
+
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_request") {
+			t.Error("Invalid post was not rejected:", form, w.Code, w.Body.String())
+		}
+	}
+
+	if len(blog.AllPosts()) != 0 {
+		t.Error("Invalid posts were created")
+	}
+}
+
+func TestMicropubCreateNoteFromJSON(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	body := `{"type": ["h-entry"], "properties": {"content": [{"html": "<p>Just a quick note</p>"}], "mp-slug": ["note"], "photo": [{"value": "http://blog.example/media/a.png", "alt": "A"}]}}`
+
+	w := micropubRequestWithToken("POST", "/micropub", "application/json", []byte(body))
+
+	if w.Code != http.StatusCreated {
+		t.Fatal("Incorrect status:", w.Code, w.Body.String())
+	}
+
+	post, err := blog.PostWithFilename("note.md")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if post.Metadata.Title != "Just a quick note" {
+		t.Error("Incorrect title:", post.Metadata.Title)
+	}
+
+	if !strings.Contains(post.Body.Markdown, "![A](http://blog.example/media/a.png)") {
+		t.Error("Photo not added to body:", post.Body.Markdown)
+	}
+}
+
+func TestMicropubUpdateAndDelete(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	form := url.Values{"h": {"entry"}, "name": {"Original"}, "content": {"Text"}, "category": {"a"}, "published": {"2014-01-26T10:30:00Z"}}
+	w := micropubRequestWithToken("POST", "/micropub", "application/x-www-form-urlencoded", []byte(form.Encode()))
+	location := w.Header().Get("Location")
+
+	update := `{"action": "update", "url": "` + location + `", "replace": {"content": ["Changed"]}, "add": {"category": ["b"]}}`
+	w = micropubRequestWithToken("POST", "/micropub", "application/json", []byte(update))
+
+	if w.Code != http.StatusNoContent {
+		t.Fatal("Incorrect status:", w.Code, w.Body.String())
+	}
+
+	post, _ := blog.PostWithFilename("original.md")
+
+	if strings.TrimSpace(post.Body.Markdown) != "Changed" || len(post.Metadata.Tags) != 2 || post.Metadata.Title != "Original" {
+		t.Error("Post not updated:", post.Metadata, post.Body.Markdown)
+	}
+
+	rename := `{"action": "update", "url": "` + location + `", "replace": {"name": ["Renamed"]}, "delete": {"category": ["a"]}}`
+	w = micropubRequestWithToken("POST", "/micropub", "application/json", []byte(rename))
+
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "http://blog.example/posts/2014/01/26/renamed" {
+		t.Error("Renamed post has incorrect location:", w.Code, w.Header().Get("Location"))
+	}
+
+	post, _ = blog.PostWithFilename("original.md")
+
+	if len(post.Metadata.Tags) != 1 || post.Metadata.Tags[0] != "b" {
+		t.Error("Category not deleted:", post.Metadata.Tags)
+	}
+
+	w = micropubRequestWithToken("GET", "/micropub?q=source&url="+url.QueryEscape(post.Permalink()), "", nil)
+
+	if !strings.Contains(w.Body.String(), `"Renamed"`) {
+		t.Error("Incorrect source:", w.Body.String())
+	}
+
+	deletion := url.Values{"action": {"delete"}, "url": {post.Permalink()}}
+	w = micropubRequestWithToken("POST", "/micropub", "application/x-www-form-urlencoded", []byte(deletion.Encode()))
+
+	if w.Code != http.StatusNoContent {
+		t.Fatal("Incorrect status:", w.Code, w.Body.String())
+	}
+
+	if len(blog.AllPosts()) != 0 {
+		t.Error("Post not removed from blog")
+	}
+
+	if _, err := os.Stat(filepath.Join(blog.postPath, "original.md")); !os.IsNotExist(err) {
+		t.Error("Post file not deleted:", err)
+	}
+}
+
+func TestMicropubMedia(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "../My Photo.PNG")
+	part.Write([]byte("image"))
+	writer.Close()
+
+	for i := 0; i < 2; i++ {
+		w := micropubRequestWithToken("POST", "/micropub/media", writer.FormDataContentType(), body.Bytes())
+
+		if w.Code != http.StatusCreated {
+			t.Fatal("Incorrect status:", w.Code, w.Body.String())
+		}
+
+		now := time.Now()
+		expected := "/media/" + now.Format("2006/01") + "/my-photo.png"
+
+		if i > 0 {
+			expected = "/media/" + now.Format("2006/01") + "/my-photo-2.png"
+		}
+
+		if w.Header().Get("Location") != "http://blog.example"+expected {
+			t.Error("Incorrect location:", w.Header().Get("Location"))
+		}
+
+		saved, err := ioutil.ReadFile(filepath.Join(SharedConfig.MediaPath, filepath.FromSlash(strings.TrimPrefix(expected, "/media/"))))
+
+		if err != nil || string(saved) != "image" {
+			t.Error("File not saved:", err)
+		}
+	}
+}
+
+func TestMicropubMediaRejectsUnsupportedTypes(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	for _, filename := range []string{"page.html", "image.svg", "script"} {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", filename)
+		part.Write([]byte("<script>alert(1)</script>"))
+		writer.Close()
+
+		w := micropubRequestWithToken("POST", "/micropub/media", writer.FormDataContentType(), body.Bytes())
+
+		if w.Code != http.StatusBadRequest {
+			t.Error("Upload of", filename, "was not rejected:", w.Code)
+		}
+	}
+
+	if files, _ := ioutil.ReadDir(SharedConfig.MediaPath); len(files) != 0 {
+		t.Error("Rejected uploads were saved")
+	}
+}
+
+func TestRequireTokenLimitsBodySize(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	req, _ := http.NewRequest("POST", "/micropub/media", bytes.NewReader(make([]byte, maxTokenRequestSize+1)))
+	req.Header.Set("Authorization", "Bearer secret")
+
+	var err error
+
+	requireToken(func(w http.ResponseWriter, req *http.Request) {
+		_, err = ioutil.ReadAll(req.Body)
+	})(httptest.NewRecorder(), req)
+
+	if err == nil {
+		t.Error("Oversized request was read in full")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// moderateComment handles the approve and spam links in notification emails.
+// The link shows the comment and a confirmation button, so that email
+// scanners that follow links can't moderate comments by accident.
+func moderateComment(w http.ResponseWriter, req *http.Request) {
+
+	postUrl := req.FormValue("post")
+	filename := req.FormValue("comment")
+	action := req.FormValue("action")
+
+	if !validSignature(req.FormValue("sig"), "moderate", postUrl, filename, action) {
+		showError(w, req, http.StatusForbidden, "This moderation link is not valid.")
+		return
+	}
+
+	if action != moderateApproveAction && action != moderateSpamAction {
+		showError(w, req, http.StatusBadRequest, "Unknown moderation action.")
+		return
+	}
+
+	post, err := blog.PostWithUrl(postUrl)
+
+	if err != nil {
+		showError(w, req, http.StatusNotFound, "The post could not be found.")
+		return
+	}
+
+	comment, err := post.CommentWithFilename(filename)
+
+	if err != nil {
+		showError(w, req, http.StatusNotFound, "The comment could not be found.")
+		return
+	}
+
+	if req.Method != "POST" {
+		page := struct {
+			Post      *BlogPost
+			Comment   *Comment
+			Action    string
+			Signature string
+			Config    *Config
+		}{
+			post,
+			comment,
+			action,
+			req.FormValue("sig"),
+			SharedConfig,
+		}
+
+		t, _ := template.ParseFiles(SharedConfig.FullThemePath() + "/templates/moderate.html")
+		t.Execute(w, page)
+
+		return
+	}
+
+	comment, wasSpam, err := post.SetCommentIsSpam(filename, action == moderateSpamAction)
+
+	if err != nil {
+		log.Println("Could not moderate comment:", err)
+		showError(w, req, http.StatusInternalServerError, "The comment could not be updated.")
+		return
+	}
+
+	log.Println("Comment", filename, "on", postUrl, "moderated:", action)
+
+	if wasSpam && !comment.Metadata.IsSpam {
+		notifyReplySubscribers(post, comment)
+	}
+
+	http.Redirect(w, req, "/posts/"+post.Url+"#comments", http.StatusFound)
+}
+
+func unsubscribeFromComments(w http.ResponseWriter, req *http.Request) {
+
+	postUrl := req.FormValue("post")
+	email := strings.ToLower(req.FormValue("email"))
+
+	if !validSignature(req.FormValue("sig"), "unsubscribe", postUrl, email) {
+		showError(w, req, http.StatusForbidden, "This unsubscribe link is not valid.")
+		return
+	}
+
+	post, err := blog.PostWithUrl(postUrl)
+
+	if err != nil {
+		showError(w, req, http.StatusNotFound, "The post could not be found.")
+		return
+	}
+
+	err = post.Unsubscribe(email)
+
+	if err != nil {
+		log.Println("Could not unsubscribe:", err)
+		showError(w, req, http.StatusInternalServerError, "You could not be unsubscribed.  Please try again later.")
+		return
+	}
+
+	showMessage(w, req, http.StatusOK, "Unsubscribed", "You will no longer receive emails about replies to \""+post.Metadata.Title+"\".")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ant512/gobble/mailer"
+	"html"
+	"log"
+	"net/url"
+	"strings"
+)
+
+const moderateApproveAction = "approve"
+const moderateSpamAction = "spam"
+
+var notificationMailer *mailer.Mailer
+
+func startNotifications() {
+	if !SharedConfig.NotificationsEnabled() {
+		return
+	}
+
+	notificationMailer = mailer.New(SharedConfig.SMTPHost, SharedConfig.SMTPPort, SharedConfig.SMTPUsername, SharedConfig.SMTPPassword, SharedConfig.SMTPTLS, SharedConfig.NotificationFrom)
+	notificationMailer.Start()
+}
+
+// notifyNewComment tells the blog owner about a new comment and, if it isn't
+// spam, tells everyone who asked to be notified of replies to the post.
+func notifyNewComment(post *BlogPost, comment *Comment) {
+	if !notificationMailer.Enabled() {
+		return
+	}
+
+	author := html.UnescapeString(comment.Metadata.Author)
+	email := html.UnescapeString(comment.Metadata.Email)
+
+	var subject string
+
+	if comment.Metadata.IsSpam {
+		subject = fmt.Sprintf("[%v] Comment held for moderation on \"%v\"", SharedConfig.Name, post.Metadata.Title)
+	} else {
+		subject = fmt.Sprintf("[%v] New comment on \"%v\"", SharedConfig.Name, post.Metadata.Title)
+	}
+
+	body := fmt.Sprintf("%v <%v> commented on \"%v\":\n\n", author, email, post.Metadata.Title)
+	body += html.UnescapeString(comment.Body.Markdown) + "\n\n"
+	body += "View: " + postCommentsUrl(post) + "\n"
+
+	if comment.Metadata.IsSpam {
+		body += "Approve: " + moderationUrl(post, comment, moderateApproveAction) + "\n"
+	} else {
+		body += "Mark as spam: " + moderationUrl(post, comment, moderateSpamAction) + "\n"
+	}
+
+	log.Println("Sending comment notification for", post.Url)
+
+	notificationMailer.Queue(&mailer.Email{
+		To:      []string{SharedConfig.NotificationEmail},
+		Subject: subject,
+		Body:    body,
+	})
+
+	if !comment.Metadata.IsSpam {
+		notifyReplySubscribers(post, comment)
+	}
+}
+
+func notifyReplySubscribers(post *BlogPost, comment *Comment) {
+	if !notificationMailer.Enabled() {
+		return
+	}
+
+	author := html.UnescapeString(comment.Metadata.Author)
+	subject := fmt.Sprintf("[%v] New reply on \"%v\"", SharedConfig.Name, post.Metadata.Title)
+
+	for _, email := range post.ReplySubscribers(html.UnescapeString(comment.Metadata.Email)) {
+		if strings.ContainsAny(email, "\r\n") {
+			continue
+		}
+
+		unsubscribe := unsubscribeUrl(post, email)
+
+		body := fmt.Sprintf("%v replied to \"%v\":\n\n", author, post.Metadata.Title)
+		body += html.UnescapeString(comment.Body.Markdown) + "\n\n"
+		body += "View: " + postCommentsUrl(post) + "\n\n"
+		body += "You are receiving this email because you asked to be notified of replies.\n"
+		body += "Unsubscribe: " + unsubscribe + "\n"
+
+		notificationMailer.Queue(&mailer.Email{
+			To:      []string{email},
+			Subject: subject,
+			Body:    body,
+			Headers: map[string]string{"List-Unsubscribe": "<" + unsubscribe + ">"},
+		})
+	}
+}
+
+func postCommentsUrl(post *BlogPost) string {
+	return SharedConfig.Address + "/posts/" + post.Url + "#comments"
+}
+
+func moderationUrl(post *BlogPost, comment *Comment, action string) string {
+	values := url.Values{
+		"post":    {post.Url},
+		"comment": {comment.Filename},
+		"action":  {action},
+		"sig":     {sign("moderate", post.Url, comment.Filename, action)},
+	}
+
+	return SharedConfig.Address + "/comments/moderate?" + values.Encode()
+}
+
+func unsubscribeUrl(post *BlogPost, email string) string {
+	email = strings.ToLower(email)
+
+	values := url.Values{
+		"post":  {post.Url},
+		"email": {email},
+		"sig":   {sign("unsubscribe", post.Url, email)},
+	}
+
+	return SharedConfig.Address + "/comments/unsubscribe?" + values.Encode()
+}
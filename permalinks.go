@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// defaultPermalinkPattern gives posts the URLs they have always had.
+const defaultPermalinkPattern = ":year/:month/:day/:slug"
+
+// urlHistoryBackupName is the name of the URL history file in backups.
+const urlHistoryBackupName = "urls.json"
+
+var repeatedSlashPattern = regexp.MustCompile(`/{2,}`)
+
+// slugify converts text into a URL path segment.  Letters and digits in any
+// script are kept and lowercased, apostrophes are dropped, and every other run
+// of characters becomes a single hyphen.
+func slugify(text string) string {
+	var b strings.Builder
+	separated := false
+
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) || r == '_':
+			if separated && b.Len() > 0 {
+				b.WriteRune('-')
+			}
+
+			separated = false
+			b.WriteRune(r)
+		case r == '\'' || r == '’':
+		default:
+			separated = true
+		}
+	}
+
+	return b.String()
+}
+
+// slug returns the part of the post's URL that identifies it: its Slug
+// metadata if it has any, or else its title.  Posts whose titles have no
+// letters or digits are named after their files.
+func (b *BlogPost) slug() string {
+	slug := slugify(b.Metadata.Slug)
+
+	if len(slug) == 0 {
+		slug = slugify(b.Metadata.Title)
+	}
+
+	if len(slug) == 0 {
+		slug = slugify(strings.TrimSuffix(b.Filename, validFilenameExtension))
+	}
+
+	return slug
+}
+
+// urlFromBlogPostProperties returns the post's URL below "/posts/", made from
+// the permalink pattern in the config.
+func (b *BlogPost) urlFromBlogPostProperties() string {
+	pattern := defaultPermalinkPattern
+
+	if SharedConfig != nil && len(SharedConfig.Permalink) > 0 {
+		pattern = SharedConfig.Permalink
+	}
+
+	values := map[string]string{
+		":slug":     b.slug(),
+		":title":    b.slug(),
+		":filename": slugify(strings.TrimSuffix(b.Filename, validFilenameExtension)),
+		":id":       strconv.Itoa(b.Metadata.Id),
+	}
+
+	return strings.Trim(expandPermalink(pattern, b.Metadata.Date, values), "/")
+}
+
+// legacyUrl returns the URL that Gobble gave the post before it had slugs and
+// permalink patterns, when most punctuation in the title was kept.
+func (b *BlogPost) legacyUrl() string {
+	title := strings.ToLower(b.Metadata.Title)
+	title = strings.Replace(title, " ", "-", -1)
+	title = strings.Replace(title, ",", "", -1)
+	title = strings.Replace(title, "#", "", -1)
+	title = strings.Replace(title, ":", "", -1)
+	title = strings.Replace(title, "\\", "", -1)
+	title = strings.Replace(title, "?", "", -1)
+	title = strings.Replace(title, "/", "", -1)
+
+	return fmt.Sprintf("%04d/%02d/%02d/%s", b.Metadata.Date.Year(), b.Metadata.Date.Month(), b.Metadata.Date.Day(), title)
+}
+
+// redirectPath normalises a request path, an alias or an absolute URL so that
+// they can be compared.  Aliases that don't start with a slash are relative to
+// "/posts/".
+func redirectPath(link string) string {
+	link = strings.TrimSpace(link)
+
+	if parsed, err := url.Parse(link); err == nil && len(parsed.Host) > 0 {
+		link = parsed.Path
+	}
+
+	if !strings.HasPrefix(link, "/") {
+		link = "/posts/" + link
+	}
+
+	if link != "/" {
+		link = strings.TrimSuffix(link, "/")
+	}
+
+	return link
+}
+
+// LoadUrlHistory reads the record of every URL that each post has had from a
+// file, adds the posts' current URLs to it and keeps it up to date from then
+// on.  Requests for old URLs are redirected to the posts' current URLs.
+func (b *Blog) LoadUrlHistory(filename string) error {
+	history := map[string]string{}
+	content, err := ioutil.ReadFile(filename)
+
+	if err == nil {
+		err = json.Unmarshal(content, &history)
+	}
+
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Could not read URL history %v: %v", filename, err)
+	}
+
+	b.mutex.Lock()
+	b.urlHistoryFile = filename
+	b.urlHistory = history
+
+	for _, post := range b.posts {
+		b.recordUrls(post)
+	}
+
+	b.mutex.Unlock()
+
+	return b.saveUrlHistory()
+}
+
+// recordUrls adds a post's current URL and the URL that older versions of
+// Gobble gave it to the URL history.  It must be called with the mutex held,
+// and returns true if the history changed.
+func (b *Blog) recordUrls(post *BlogPost) bool {
+	if b.urlHistory == nil {
+		return false
+	}
+
+	changed := false
+
+	for _, path := range []string{"/posts/" + post.Url, "/posts/" + post.legacyUrl()} {
+		if b.urlHistory[path] != post.Filename {
+			b.urlHistory[path] = post.Filename
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+func (b *Blog) saveUrlHistory() error {
+	b.mutex.RLock()
+
+	if len(b.urlHistoryFile) == 0 {
+		b.mutex.RUnlock()
+		return nil
+	}
+
+	filename := b.urlHistoryFile
+	content, err := json.MarshalIndent(b.urlHistory, "", "  ")
+	b.mutex.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, content, 0644)
+}
+
+// postUrlsChanged records the URLs of a post that has been added or reloaded,
+// and reports any other post that it shares a URL or alias with.
+func (b *Blog) postUrlsChanged(post *BlogPost) {
+	b.mutex.Lock()
+	changed := b.recordUrls(post)
+	b.mutex.Unlock()
+
+	if changed {
+		if err := b.saveUrlHistory(); err != nil {
+			log.Println("Could not save URL history:", err)
+		}
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for _, problem := range duplicateUrls(b.posts) {
+		if strings.Contains(problem, post.Filename) {
+			log.Println(problem)
+		}
+	}
+}
+
+// duplicateUrls describes every URL or alias that is used by more than one
+// post.  Only the newest of the posts can be reached at that URL.
+func duplicateUrls(posts BlogPosts) []string {
+	owners := map[string]string{}
+	problems := []string{}
+
+	for _, post := range posts {
+		paths := []string{"/posts/" + post.Url}
+
+		for _, alias := range post.Metadata.Aliases {
+			paths = append(paths, redirectPath(alias))
+		}
+
+		for _, path := range paths {
+			if owner, exists := owners[path]; exists && owner != post.Filename {
+				problems = append(problems, fmt.Sprintf("%v and %v both use the URL %v", owner, post.Filename, path))
+			} else {
+				owners[path] = post.Filename
+			}
+		}
+	}
+
+	sort.Strings(problems)
+
+	return problems
+}
+
+// PostWithPreviousPath finds the post that a request path used to refer to,
+// either because it is one of the post's aliases, or because the post's URL
+// has changed.
+func (b *Blog) PostWithPreviousPath(path string) (*BlogPost, error) {
+	path = redirectPath(path)
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for _, post := range b.posts {
+		for _, alias := range post.Metadata.Aliases {
+			if redirectPath(alias) == path {
+				return post, nil
+			}
+		}
+	}
+
+	filename := b.urlHistory[path]
+
+	if len(filename) == 0 {
+		return nil, fmt.Errorf("No post has had the URL %v", path)
+	}
+
+	return b.posts.PostWithFilename(filename)
+}
+
+// redirectToPost sends a permanent redirect if the request is for an alias or
+// an old URL of a post.  It returns false if there is no such post.
+func redirectToPost(w http.ResponseWriter, req *http.Request) bool {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return false
+	}
+
+	post, err := blog.PostWithPreviousPath(req.URL.Path)
+
+	if err != nil || redirectPath(req.URL.Path) == "/posts/"+post.Url {
+		return false
+	}
+
+	target := "/posts/" + post.Url
+
+	if len(req.URL.RawQuery) > 0 {
+		target += "?" + req.URL.RawQuery
+	}
+
+	http.Redirect(w, req, target, http.StatusMovedPermanently)
+
+	return true
+}
+
+func notFound(w http.ResponseWriter, req *http.Request) {
+	if !redirectToPost(w, req) {
+		http.NotFound(w, req)
+	}
+}
+
+// expandPermalink replaces the placeholders in a Jekyll or Hugo permalink
+// pattern.  Date placeholders are filled in from the date, and the rest from
+// values.
+func expandPermalink(pattern string, date time.Time, values map[string]string) string {
+	placeholders := map[string]string{
+		":year":        strconv.Itoa(date.Year()),
+		":short_year":  fmt.Sprintf("%02d", date.Year()%100),
+		":month":       fmt.Sprintf("%02d", date.Month()),
+		":i_month":     strconv.Itoa(int(date.Month())),
+		":short_month": date.Format("Jan"),
+		":long_month":  date.Format("January"),
+		":monthname":   strings.ToLower(date.Format("January")),
+		":day":         fmt.Sprintf("%02d", date.Day()),
+		":i_day":       strconv.Itoa(date.Day()),
+		":y_day":       fmt.Sprintf("%03d", date.YearDay()),
+		":yearday":     strconv.Itoa(date.YearDay()),
+		":weekday":     strconv.Itoa(int(date.Weekday())),
+		":weekdayname": strings.ToLower(date.Format("Monday")),
+		":hour":        fmt.Sprintf("%02d", date.Hour()),
+		":minute":      fmt.Sprintf("%02d", date.Minute()),
+		":second":      fmt.Sprintf("%02d", date.Second()),
+	}
+
+	for key, value := range values {
+		placeholders[key] = value
+	}
+
+	// Longer placeholders are replaced first, so that ":slugorfilename" isn't
+	// treated as ":slug" followed by "orfilename".
+	keys := []string{}
+
+	for key := range placeholders {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+
+		return keys[i] < keys[j]
+	})
+
+	for _, key := range keys {
+		pattern = strings.Replace(pattern, key, placeholders[key], -1)
+	}
+
+	return repeatedSlashPattern.ReplaceAllString("/"+pattern, "/")
+}
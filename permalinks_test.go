@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Hello, World!":               "hello-world",
+		"Release 1.0 & \"Quotes\"":    "release-1-0-quotes",
+		"Don't Panic":                 "dont-panic",
+		"Café au lait":                "café-au-lait",
+		"Привет мир":                  "привет-мир",
+		"  --Leading and trailing-- ": "leading-and-trailing",
+		"snake_case":                  "snake_case",
+		"???":                         "",
+	}
+
+	for text, expected := range tests {
+		if slug := slugify(text); slug != expected {
+			t.Errorf("Slugifying %q: expected %q, got %q", text, expected, slug)
+		}
+	}
+}
+
+func TestPermalinkPattern(t *testing.T) {
+	previous := SharedConfig
+	defer func() { SharedConfig = previous }()
+
+	SharedConfig = &Config{}
+
+	post := &BlogPost{Filename: "file.md"}
+	post.Metadata.Title = "Hello, World!"
+	post.Metadata.Date = time.Date(2014, 1, 6, 10, 0, 0, 0, time.UTC)
+	post.Metadata.Id = 12
+
+	tests := map[string]string{
+		"":                           "2014/01/06/hello-world",
+		"/:year/:slug/":              "2014/hello-world",
+		":year/:month/:filename":     "2014/01/file",
+		"archive/:id":                "archive/12",
+		":year/:month/:day/:title/x": "2014/01/06/hello-world/x",
+	}
+
+	for pattern, expected := range tests {
+		SharedConfig.Permalink = pattern
+
+		if url := post.urlFromBlogPostProperties(); url != expected {
+			t.Errorf("Pattern %q: expected %v, got %v", pattern, expected, url)
+		}
+	}
+
+	SharedConfig.Permalink = ""
+	post.Metadata.Slug = "Custom Slug"
+
+	if url := post.urlFromBlogPostProperties(); url != "2014/01/06/custom-slug" {
+		t.Errorf("Slug was not used: %v", url)
+	}
+}
+
+func TestRedirectsToPosts(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	SharedConfig.ThemePath = "themes"
+	SharedConfig.Theme = "grump"
+
+	writeTestFiles(t, dir, map[string]string{
+		"posts/release.md": "Title: Release 1.0\nDate: 2014-01-26 10:30:00\nAliases: /old/release, 2013/release\n\nNotes",
+	})
+
+	blog, _ = LoadBlog(filepath.Join(dir, "posts"), filepath.Join(dir, "comments"), true)
+	err := blog.LoadUrlHistory(filepath.Join(dir, "urls.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing the title changes the URL, and the old one is remembered.
+	metadata := BlogPostMetadata{Title: "Release 1.0 Final", Date: stringToTime("2014-01-26 10:30:00"), Aliases: []string{"/old/release", "2013/release"}}
+
+	if _, err := blog.WritePost("release.md", metadata, "Notes"); err != nil {
+		t.Fatal(err)
+	}
+
+	router := newRouter()
+	target := "/posts/2014/01/26/release-1-0-final"
+
+	for _, path := range []string{
+		"/old/release",
+		"/old/release/",
+		"/posts/2013/release",
+		"/posts/2014/01/26/release-1-0",
+		"/posts/2014/01/26/release-1.0",
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != target {
+			t.Errorf("%v: expected a redirect to %v, got %v %v", path, target, w.Code, w.Header().Get("Location"))
+		}
+	}
+
+	for path, expected := range map[string]int{target: http.StatusOK, "/posts/2014/01/26/missing": http.StatusNotFound, "/missing": http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != expected {
+			t.Errorf("%v: expected %v, got %v", path, expected, w.Code)
+		}
+	}
+
+	// The history is kept between restarts.
+	blog, _ = LoadBlog(filepath.Join(dir, "posts"), filepath.Join(dir, "comments"), true)
+	blog.LoadUrlHistory(filepath.Join(dir, "urls.json"))
+
+	if post, err := blog.PostWithPreviousPath("/posts/2014/01/26/release-1-0"); err != nil || post.Filename != "release.md" {
+		t.Error("URL history was not saved")
+	}
+}
+
+func TestDuplicateUrls(t *testing.T) {
+	posts := BlogPosts{
+		{Filename: "a.md", Url: "2014/01/26/post", Metadata: BlogPostMetadata{Aliases: []string{"/old"}}},
+		{Filename: "b.md", Url: "2014/01/26/post"},
+		{Filename: "c.md", Url: "2014/01/27/other", Metadata: BlogPostMetadata{Aliases: []string{"/old/"}}},
+	}
+
+	problems := duplicateUrls(posts)
+
+	if len(problems) != 2 || !strings.Contains(problems[0], "a.md and b.md both use the URL /posts/2014/01/26/post") || !strings.Contains(problems[1], "a.md and c.md both use the URL /old") {
+		t.Errorf("Unexpected problems %v", problems)
+	}
+}
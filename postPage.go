@@ -6,6 +6,7 @@ type PostPage struct {
 	CommentName           string
 	CommentEmail          string
 	CommentBody           string
+	CommentNotify         bool
 	CommentNameError      string
 	CommentEmailError     string
 	CommentBodyError      string
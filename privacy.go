@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"html"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const anonymousCommentAuthor = "Anonymous"
+
+type PostComment struct {
+	Post    *BlogPost
+	Comment *Comment
+}
+
+// CommentsWithEmail returns every comment, including spam, left with the given
+// email address.
+func (b *Blog) CommentsWithEmail(email string) []PostComment {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	found := []PostComment{}
+
+	for _, post := range b.posts {
+		post.mutex.RLock()
+		for _, comment := range post.Comments {
+			if isSameEmail(comment.Metadata.Email, email) {
+				found = append(found, PostComment{post, comment})
+			}
+		}
+		post.mutex.RUnlock()
+	}
+
+	return found
+}
+
+// EraseCommentsWithEmail removes the personal data from every comment left
+// with the given email address.  If deleteComments is true the comments are
+// deleted entirely; otherwise the author and email address are anonymised but
+// the comment text is kept.  It returns the number of comments erased.
+func (b *Blog) EraseCommentsWithEmail(email string, deleteComments bool) (int, error) {
+	erased := 0
+
+	for _, found := range b.CommentsWithEmail(email) {
+		var err error
+
+		if deleteComments {
+			err = found.Post.DeleteComment(found.Comment.Filename)
+		} else {
+			err = found.Post.AnonymiseComment(found.Comment.Filename, true)
+		}
+
+		if err != nil {
+			return erased, err
+		}
+
+		erased++
+	}
+
+	return erased, nil
+}
+
+// AnonymiseCommentEmails removes the email addresses from all comments posted
+// before the given date.  It returns the number of comments changed.
+func (b *Blog) AnonymiseCommentEmails(before time.Time) (int, error) {
+	b.mutex.RLock()
+	posts := b.posts
+	b.mutex.RUnlock()
+
+	anonymised := 0
+
+	for _, post := range posts {
+		filenames := []string{}
+
+		post.mutex.RLock()
+		for _, comment := range post.Comments {
+			if len(comment.Metadata.Email) > 0 && comment.Metadata.Date.Before(before) {
+				filenames = append(filenames, comment.Filename)
+			}
+		}
+		post.mutex.RUnlock()
+
+		for _, filename := range filenames {
+			err := post.AnonymiseComment(filename, false)
+
+			if err != nil {
+				return anonymised, err
+			}
+
+			anonymised++
+		}
+	}
+
+	return anonymised, nil
+}
+
+// AnonymiseComment removes the email address, and optionally the author's
+// name, from a comment and rewrites its file.
+func (b *BlogPost) AnonymiseComment(filename string, removeAuthor bool) error {
+	comment, err := b.CommentWithFilename(filename)
+
+	if err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	comment.Metadata.Email = ""
+	comment.Metadata.Notify = false
+
+	if removeAuthor {
+		comment.Metadata.Author = anonymousCommentAuthor
+	}
+	b.mutex.Unlock()
+
+	return b.writeComment(comment)
+}
+
+// DeleteComment removes a comment from the post and deletes its file.
+func (b *BlogPost) DeleteComment(filename string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for i, comment := range b.Comments {
+		if comment.Filename == filename {
+			err := os.Remove(filepath.Join(b.commentDirectory(), filename))
+
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+
+			b.Comments = append(b.Comments[:i], b.Comments[i+1:]...)
+
+			return nil
+		}
+	}
+
+	return errors.New(couldNotFindCommentErrorMessage)
+}
+
+// startEmailRetention anonymises comment email addresses once they are older
+// than the configured retention period, checking once a day.
+func startEmailRetention() {
+	if SharedConfig.EmailRetentionDays <= 0 {
+		return
+	}
+
+	retention := time.Hour * 24 * time.Duration(SharedConfig.EmailRetentionDays)
+
+	anonymise := func() {
+		count, err := blog.AnonymiseCommentEmails(time.Now().Add(-retention))
+
+		if err != nil {
+			log.Println("Could not anonymise comment emails:", err)
+		} else if count > 0 {
+			log.Println("Anonymised", count, "comment emails")
+		}
+	}
+
+	go func() {
+		anonymise()
+
+		for range time.Tick(time.Hour * 24) {
+			anonymise()
+		}
+	}()
+}
+
+// isSameEmail compares a stored comment email, which is HTML escaped, with an
+// email address supplied by a user.
+func isSameEmail(stored, email string) bool {
+	email = strings.TrimSpace(email)
+
+	if len(email) == 0 {
+		return false
+	}
+
+	return strings.EqualFold(html.UnescapeString(stored), email)
+}
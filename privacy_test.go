@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createPrivacyTestBlog(t *testing.T) (*Blog, string) {
+	dir, err := ioutil.TempDir("", "gobble")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := createPost()
+	p.Filename = "test-post.md"
+	p.CommentPath = dir
+
+	for i, c := range p.Comments {
+		c.Metadata.Date = time.Date(2014, 1, i+1, 0, 0, 0, 0, time.UTC)
+		c.Filename = timeToFilename(c.Metadata.Date)
+		p.writeComment(c)
+	}
+
+	b := &Blog{posts: BlogPosts{p}, tags: NewTags()}
+
+	return b, dir
+}
+
+func TestCommentsWithEmail(t *testing.T) {
+	b, dir := createPrivacyTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	if found := b.CommentsWithEmail("JOE@example.com"); len(found) != 1 {
+		t.Error("Incorrect number of comments found:", len(found))
+	}
+
+	if found := b.CommentsWithEmail(""); len(found) != 0 {
+		t.Error("Found comments with blank email")
+	}
+}
+
+func TestEraseCommentsWithEmail(t *testing.T) {
+	b, dir := createPrivacyTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	count, err := b.EraseCommentsWithEmail("joe@example.com", false)
+
+	if err != nil || count != 1 {
+		t.Error("Could not anonymise comments:", count, err)
+	}
+
+	comment, _ := LoadComment(filepath.Join(dir, "test-post", timeToFilename(time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC))))
+
+	if comment.Metadata.Email != "" || comment.Metadata.Author != anonymousCommentAuthor {
+		t.Error("Comment file was not anonymised:", comment.Metadata)
+	}
+
+	count, err = b.EraseCommentsWithEmail("bob@example.com", true)
+
+	if err != nil || count != 1 {
+		t.Error("Could not delete comments:", count, err)
+	}
+
+	if len(b.posts[0].Comments) != 1 {
+		t.Error("Deleted comment is still in memory")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "test-post", timeToFilename(time.Date(2014, 1, 2, 0, 0, 0, 0, time.UTC)))); !os.IsNotExist(err) {
+		t.Error("Deleted comment file still exists")
+	}
+}
+
+func TestAnonymiseCommentEmails(t *testing.T) {
+	b, dir := createPrivacyTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	count, err := b.AnonymiseCommentEmails(time.Date(2014, 1, 2, 0, 0, 0, 0, time.UTC))
+
+	if err != nil || count != 1 {
+		t.Error("Incorrect number of comments anonymised:", count, err)
+	}
+
+	if b.posts[0].Comments[0].Metadata.Email != "" || b.posts[0].Comments[0].Metadata.Author != "Joe" {
+		t.Error("Old comment was not anonymised correctly")
+	}
+
+	if b.posts[0].Comments[1].Metadata.Email == "" {
+		t.Error("New comment was anonymised")
+	}
+}
+
+func TestEraseRequiresFormToken(t *testing.T) {
+	b, dir := createPrivacyTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	blog = b
+	SharedConfig = &Config{AdminUsername: "admin", AdminPassword: "password"}
+
+	tests := map[string]int{
+		"":               http.StatusForbidden,
+		"forged":         http.StatusForbidden,
+		adminFormToken(): http.StatusFound,
+	}
+
+	for token, expected := range tests {
+		form := url.Values{"email": {"joe@example.com"}, "mode": {"anonymise"}, "token": {token}}
+		req, _ := http.NewRequest("POST", "/admin/privacy/erase", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("admin", "password")
+
+		w := httptest.NewRecorder()
+		requireAdminForm(erasePrivacy)(w, req)
+
+		if w.Code != expected {
+			t.Errorf("Token %q: expected %v, got %v", token, expected, w.Code)
+		}
+	}
+
+	if found := b.CommentsWithEmail("joe@example.com"); len(found) != 0 {
+		t.Error("Comments were not erased with a valid token")
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rateLimitSaveDelay is how long changes to the buckets are held in memory
+// before they are written to disk, so that a burst of requests only writes the
+// file once.
+const rateLimitSaveDelay = 10 * time.Second
+
+type tokenBucket struct {
+	Tokens  float64
+	Updated time.Time
+}
+
+type RateLimitBlock struct {
+	Key   string
+	Until time.Time
+}
+
+// RateLimiter is a token bucket limiter.  Each key gets a bucket holding up to
+// burst tokens; one token is returned to the bucket every refill interval.
+// Buckets are persisted to a JSON file so that restarting the server does not
+// reset them.
+type RateLimiter struct {
+	burst     float64
+	refill    time.Duration
+	path      string
+	buckets   map[string]*tokenBucket
+	now       func() time.Time
+	saveTimer *time.Timer
+	mutex     sync.Mutex
+}
+
+func NewRateLimiter(burst int, refill time.Duration, path string) *RateLimiter {
+	r := &RateLimiter{
+		burst:   float64(burst),
+		refill:  refill,
+		path:    path,
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+
+	if len(path) > 0 {
+		err := r.load()
+
+		if err != nil && !os.IsNotExist(err) {
+			log.Println("Could not load rate limits:", err)
+		}
+	}
+
+	return r
+}
+
+func (r *RateLimiter) Enabled() bool {
+	return r != nil && r.burst > 0 && r.refill > 0
+}
+
+// Allow consumes a token from the bucket identified by key.  If the bucket is
+// empty the request is refused and the time until the next token is returned.
+func (r *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if !r.Enabled() {
+		return true, 0
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := r.now()
+	bucket := r.bucket(key, now)
+
+	if bucket.Tokens < 1 {
+		return false, r.timeUntilToken(bucket)
+	}
+
+	bucket.Tokens--
+
+	r.scheduleSave()
+
+	return true, 0
+}
+
+// allowBothMutex is held while two limiters are locked together, so that
+// calls that lock them in different orders can't deadlock.
+var allowBothMutex sync.Mutex
+
+// allowBoth consumes a token from keyA's bucket in a and keyB's bucket in b as
+// a single operation.  If either bucket is empty neither is charged, and the
+// longer of the times until a token is available is returned.
+func allowBoth(a *RateLimiter, keyA string, b *RateLimiter, keyB string) (bool, time.Duration) {
+	if !a.Enabled() {
+		return b.Allow(keyB)
+	}
+
+	if !b.Enabled() {
+		return a.Allow(keyA)
+	}
+
+	allowBothMutex.Lock()
+	defer allowBothMutex.Unlock()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if b != a {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+	}
+
+	bucketA := a.bucket(keyA, a.now())
+	bucketB := b.bucket(keyB, b.now())
+
+	needed := 1.0
+
+	if bucketA == bucketB {
+		needed = 2
+	}
+
+	if bucketA.Tokens < needed || bucketB.Tokens < 1 {
+		retryAfter := time.Duration(0)
+
+		if bucketA.Tokens < needed {
+			retryAfter = a.timeUntilToken(bucketA)
+		}
+
+		if wait := b.timeUntilToken(bucketB); bucketB.Tokens < 1 && wait > retryAfter {
+			retryAfter = wait
+		}
+
+		return false, retryAfter
+	}
+
+	bucketA.Tokens--
+	bucketB.Tokens--
+
+	a.scheduleSave()
+	b.scheduleSave()
+
+	return true, 0
+}
+
+// Blocked returns all keys that currently have no tokens left, ordered by key.
+func (r *RateLimiter) Blocked() []RateLimitBlock {
+	blocked := []RateLimitBlock{}
+
+	if !r.Enabled() {
+		return blocked
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := r.now()
+
+	for key := range r.buckets {
+		bucket := r.bucket(key, now)
+
+		if bucket.Tokens < 1 {
+			blocked = append(blocked, RateLimitBlock{key, now.Add(r.timeUntilToken(bucket))})
+		}
+	}
+
+	sort.Slice(blocked, func(i, j int) bool {
+		return blocked[i].Key < blocked[j].Key
+	})
+
+	return blocked
+}
+
+// Clear refills the bucket identified by key.
+func (r *RateLimiter) Clear(key string) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.buckets, key)
+
+	r.scheduleSave()
+}
+
+// Flush writes any changes to the buckets that have not been saved yet.
+func (r *RateLimiter) Flush() {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.saveTimer == nil {
+		return
+	}
+
+	r.saveTimer.Stop()
+	r.saveTimer = nil
+
+	r.save()
+}
+
+// scheduleSave writes the buckets to disk once rateLimitSaveDelay has passed.
+// It must be called with the mutex held.
+func (r *RateLimiter) scheduleSave() {
+	if len(r.path) == 0 || r.saveTimer != nil {
+		return
+	}
+
+	r.saveTimer = time.AfterFunc(rateLimitSaveDelay, r.Flush)
+}
+
+func (r *RateLimiter) bucket(key string, now time.Time) *tokenBucket {
+	bucket, ok := r.buckets[key]
+
+	if !ok {
+		bucket = &tokenBucket{Tokens: r.burst, Updated: now}
+		r.buckets[key] = bucket
+		return bucket
+	}
+
+	elapsed := now.Sub(bucket.Updated)
+
+	if elapsed > 0 {
+		bucket.Tokens = math.Min(r.burst, bucket.Tokens+float64(elapsed)/float64(r.refill))
+		bucket.Updated = now
+	}
+
+	return bucket
+}
+
+func (r *RateLimiter) timeUntilToken(bucket *tokenBucket) time.Duration {
+	return time.Duration((1 - bucket.Tokens) * float64(r.refill))
+}
+
+func (r *RateLimiter) load() error {
+	file, err := ioutil.ReadFile(r.path)
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(file, &r.buckets)
+}
+
+func (r *RateLimiter) save() {
+	if len(r.path) == 0 {
+		return
+	}
+
+	// Full buckets are indistinguishable from missing buckets, so there is no
+	// need to keep them around.
+	now := r.now()
+
+	for key := range r.buckets {
+		if r.bucket(key, now).Tokens >= r.burst {
+			delete(r.buckets, key)
+		}
+	}
+
+	data, err := json.Marshal(r.buckets)
+
+	if err != nil {
+		log.Println("Could not save rate limits:", err)
+		return
+	}
+
+	os.MkdirAll(filepath.Dir(r.path), 0775)
+
+	err = ioutil.WriteFile(r.path, data, 0644)
+
+	if err != nil {
+		log.Println("Could not save rate limits:", err)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func createTestRateLimiter(path string, now *time.Time) *RateLimiter {
+	r := NewRateLimiter(2, time.Minute, path)
+	r.now = func() time.Time {
+		return *now
+	}
+
+	return r
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	now := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := createTestRateLimiter("", &now)
+
+	if allowed, _ := r.Allow("1.2.3.4"); !allowed {
+		t.Error("First request was refused")
+	}
+
+	if allowed, _ := r.Allow("1.2.3.4"); !allowed {
+		t.Error("Request within burst was refused")
+	}
+
+	allowed, retryAfter := r.Allow("1.2.3.4")
+
+	if allowed {
+		t.Error("Request beyond burst was allowed")
+	}
+
+	if retryAfter != time.Minute {
+		t.Error("Incorrect retry time:", retryAfter)
+	}
+
+	if allowed, _ := r.Allow("5.6.7.8"); !allowed {
+		t.Error("Request from another key was refused")
+	}
+
+	now = now.Add(30 * time.Second)
+
+	if _, retryAfter = r.Allow("1.2.3.4"); retryAfter != 30*time.Second {
+		t.Error("Incorrect retry time after partial refill:", retryAfter)
+	}
+
+	now = now.Add(30 * time.Second)
+
+	if allowed, _ := r.Allow("1.2.3.4"); !allowed {
+		t.Error("Request after refill was refused")
+	}
+}
+
+func TestAllowBoth(t *testing.T) {
+	now := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	ip := createTestRateLimiter("", &now)
+	post := NewRateLimiter(1, 2*time.Minute, "")
+	post.now = ip.now
+
+	if allowed, _ := allowBoth(ip, "1.2.3.4", post, "a"); !allowed {
+		t.Error("First comment was refused")
+	}
+
+	// The post is exhausted, so the address must not be charged.
+	allowed, retryAfter := allowBoth(ip, "1.2.3.4", post, "a")
+
+	if allowed || retryAfter != 2*time.Minute {
+		t.Error("Comment on an exhausted post was allowed:", retryAfter)
+	}
+
+	if allowed, _ := allowBoth(ip, "1.2.3.4", post, "b"); !allowed {
+		t.Error("Address was charged for a refused comment")
+	}
+
+	if allowed, _ := allowBoth(ip, "1.2.3.4", post, "c"); allowed {
+		t.Error("Comment beyond the address's burst was allowed")
+	}
+
+	if allowed, _ := allowBoth(ip, "5.6.7.8", NewRateLimiter(0, 0, ""), "a"); !allowed {
+		t.Error("Disabled limiter refused a comment")
+	}
+}
+
+func TestRateLimiterBlockedAndClear(t *testing.T) {
+	now := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := createTestRateLimiter("", &now)
+
+	r.Allow("1.2.3.4")
+	r.Allow("1.2.3.4")
+	r.Allow("5.6.7.8")
+
+	blocked := r.Blocked()
+
+	if len(blocked) != 1 || blocked[0].Key != "1.2.3.4" {
+		t.Error("Incorrect blocked keys:", blocked)
+	}
+
+	r.Clear("1.2.3.4")
+
+	if len(r.Blocked()) != 0 {
+		t.Error("Cleared key is still blocked")
+	}
+}
+
+func TestRateLimiterPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gobble")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "limits", "ip.json")
+	now := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	r := createTestRateLimiter(path, &now)
+	r.Allow("1.2.3.4")
+	r.Allow("1.2.3.4")
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Rate limits were saved before they were flushed")
+	}
+
+	r.Flush()
+
+	r = createTestRateLimiter(path, &now)
+
+	if allowed, _ := r.Allow("1.2.3.4"); allowed {
+		t.Error("Rate limit was not persisted")
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	r := NewRateLimiter(0, time.Minute, "")
+
+	for i := 0; i < 10; i++ {
+		if allowed, _ := r.Allow("1.2.3.4"); !allowed {
+			t.Error("Disabled rate limiter refused a request")
+		}
+	}
+}
@@ -0,0 +1,621 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the redirect file and the redirect hit counts in backups.
+const redirectFileBackupName = "redirects.txt"
+const redirectHitsBackupName = "redirecthits.json"
+
+// redirectToPostTarget is the target of redirects to whichever post the
+// values captured from the old URL refer to.
+const redirectToPostTarget = "post"
+
+// legacyRedirects map the URLs used by WordPress and other blogging engines
+// onto Gobble's pages.  They are checked after the redirects in the redirect
+// file and the config, so they can be overridden.
+var legacyRedirects = []string{
+	"/?p=:id post",
+	"/?feed=:feed /rss",
+	"/feed/* /rss",
+	"/category/:tag/feed/* /rss",
+	"/category/:tag/* /tags/:tag",
+	"/tag/:tag/feed/* /rss",
+	"/tag/:tag/* /tags/:tag",
+	"/:year/:month/:day/:slug post",
+	"/:year/:month/:slug post",
+}
+
+var redirectPlaceholderPattern = regexp.MustCompile(`:[A-Za-z_][A-Za-z0-9_]*`)
+
+// redirectHitsSaveDelay is how long hit counts are held in memory before they
+// are written to disk, so that redirects don't write a file on every request.
+const redirectHitsSaveDelay = time.Minute
+
+// redirectRule is a single redirect.  Requests whose path and query match the
+// rule's pattern are sent to its target with its status, or are told that the
+// page has gone if the status is 410.
+type redirectRule struct {
+	From    string
+	To      string
+	Status  int
+	pattern *regexp.Regexp
+	query   url.Values
+}
+
+// RedirectHits records how often a redirect is used, so that redirects that
+// are no longer needed can be removed.
+type RedirectHits struct {
+	Hits    int
+	LastHit time.Time
+}
+
+// RedirectUse describes a redirect and how often it has been used, for the
+// admin pages.
+type RedirectUse struct {
+	From    string
+	To      string
+	Status  int
+	Hits    int
+	LastHit time.Time
+}
+
+// RedirectTable holds every redirect, in the order in which they are checked.
+// Hit counts are persisted to a JSON file so that restarting the server does
+// not reset them.
+type RedirectTable struct {
+	rules     []*redirectRule
+	path      string
+	hits      map[string]*RedirectHits
+	now       func() time.Time
+	saveTimer *time.Timer
+	mutex     sync.Mutex
+}
+
+// LoadRedirects reads the redirect file named in the config, which need not
+// exist, and adds the config's own redirects and the legacy redirects after
+// it.
+func LoadRedirects(config *Config) (*RedirectTable, error) {
+	lines := []string{}
+	sources := []string{}
+
+	if len(config.RedirectFile) > 0 {
+		content, err := ioutil.ReadFile(config.RedirectFile)
+
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Could not read redirects from %v: %v", config.RedirectFile, err)
+		}
+
+		for i, line := range strings.Split(string(content), "\n") {
+			lines = append(lines, line)
+			sources = append(sources, fmt.Sprintf("%v line %v", config.RedirectFile, i+1))
+		}
+	}
+
+	for i, line := range config.Redirects {
+		lines = append(lines, line)
+		sources = append(sources, fmt.Sprintf("redirect %v in the config", i+1))
+	}
+
+	if config.LegacyRedirects {
+		for _, line := range legacyRedirects {
+			lines = append(lines, line)
+			sources = append(sources, "legacy redirects")
+		}
+	}
+
+	return NewRedirectTable(lines, sources, config.RedirectHitsFile)
+}
+
+// NewRedirectTable parses a list of redirects, one per line.  Each source
+// describes where the matching line came from, for error messages.  Hit counts
+// are loaded from and saved to path, unless it is empty.
+func NewRedirectTable(lines []string, sources []string, path string) (*RedirectTable, error) {
+	t := &RedirectTable{
+		path: path,
+		hits: make(map[string]*RedirectHits),
+		now:  time.Now,
+	}
+
+	seen := map[string]bool{}
+
+	for i, line := range lines {
+		rule, err := parseRedirect(line)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid redirect at %v: %v", sources[i], err)
+		}
+
+		if rule == nil {
+			continue
+		}
+
+		// Only the first redirect from a URL can ever be used.
+		if seen[rule.From] {
+			log.Printf("Ignoring the redirect from %v at %v, which is already redirected", rule.From, sources[i])
+			continue
+		}
+
+		seen[rule.From] = true
+		t.rules = append(t.rules, rule)
+	}
+
+	if len(path) > 0 {
+		err := t.load()
+
+		if err != nil && !os.IsNotExist(err) {
+			log.Println("Could not load redirect hits:", err)
+		}
+
+		// Forget the hits of redirects that have been removed.
+		for from := range t.hits {
+			if !seen[from] {
+				delete(t.hits, from)
+			}
+		}
+	}
+
+	return t, nil
+}
+
+// parseRedirect parses a line in the form "/old/url /new/url 301".  The status
+// is optional and defaults to 301; 302 and 410 are also allowed, and 410
+// redirects have no target.  Blank lines and comments, which start with "#",
+// return nil.
+func parseRedirect(line string) (*redirectRule, error) {
+	fields := strings.Fields(line)
+
+	for i, field := range fields {
+		if strings.HasPrefix(field, "#") {
+			fields = fields[:i]
+			break
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	rule := &redirectRule{From: fields[0], Status: http.StatusMovedPermanently}
+
+	switch {
+	case len(fields) == 2 && fields[1] == strconv.Itoa(http.StatusGone):
+		rule.Status = http.StatusGone
+	case len(fields) == 2:
+		rule.To = fields[1]
+	case len(fields) == 3:
+		rule.To = fields[1]
+		status, err := strconv.Atoi(fields[2])
+
+		if err != nil || (status != http.StatusMovedPermanently && status != http.StatusFound) {
+			if status == http.StatusGone {
+				return nil, errors.New("a 410 redirect can't have a target")
+			}
+
+			return nil, fmt.Errorf("unknown status %v", fields[2])
+		}
+
+		rule.Status = status
+	default:
+		return nil, errors.New("expected a URL, a target and an optional status")
+	}
+
+	if !strings.HasPrefix(rule.From, "/") {
+		return nil, fmt.Errorf("%v does not start with a slash", rule.From)
+	}
+
+	from := rule.From
+	query := ""
+
+	if i := strings.Index(from, "?"); i >= 0 {
+		from, query = from[:i], from[i+1:]
+	}
+
+	if len(query) > 0 {
+		values, err := url.ParseQuery(query)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid query in %v: %v", rule.From, err)
+		}
+
+		rule.query = values
+	}
+
+	pattern, err := compileRedirectPattern(redirectPath(from))
+
+	if err != nil {
+		return nil, err
+	}
+
+	rule.pattern = pattern
+
+	if rule.Status == http.StatusGone {
+		return rule, nil
+	}
+
+	captures := rule.captureNames()
+
+	if rule.To == redirectToPostTarget {
+		if !captures[":id"] && !captures[":slug"] {
+			return nil, fmt.Errorf("%v must capture :id or :slug to redirect to a post", rule.From)
+		}
+
+		return rule, nil
+	}
+
+	if !strings.HasPrefix(rule.To, "/") && !strings.Contains(rule.To, "://") {
+		return nil, fmt.Errorf("%v is not a path, a URL or \"post\"", rule.To)
+	}
+
+	for _, name := range redirectPlaceholderPattern.FindAllString(rule.To, -1) {
+		if !captures[name] {
+			return nil, fmt.Errorf("%v uses %v, which %v does not capture", rule.To, name, rule.From)
+		}
+	}
+
+	if strings.Contains(rule.To, "*") && !captures["*"] {
+		return nil, fmt.Errorf("%v uses *, but %v does not end with *", rule.To, rule.From)
+	}
+
+	return rule, nil
+}
+
+// compileRedirectPattern turns a path such as "/:year/:month/:slug.html" into
+// a regular expression.  Placeholders match one part of the path and become
+// named groups, and a "*" at the end matches the rest of the path, if there is
+// any, with an unnamed group.
+func compileRedirectPattern(from string) (*regexp.Regexp, error) {
+	splat := false
+
+	if from == "/*" || strings.HasSuffix(from, "/*") {
+		from = strings.TrimSuffix(from, "/*")
+		splat = true
+	}
+
+	if strings.Contains(from, "*") {
+		return nil, errors.New("* can only be used at the end of a URL")
+	}
+
+	expression := "^"
+	last := 0
+
+	for _, match := range redirectPlaceholderPattern.FindAllStringIndex(from, -1) {
+		expression += regexp.QuoteMeta(from[last:match[0]])
+		expression += "(?P<" + from[match[0]+1:match[1]] + ">[^/]+)"
+		last = match[1]
+	}
+
+	expression += regexp.QuoteMeta(from[last:])
+
+	if splat {
+		expression += "(?:/(.*))?"
+	}
+
+	return regexp.Compile(expression + "$")
+}
+
+// captureNames returns the placeholders that the rule captures from a request,
+// including "*" for the rest of the path.
+func (r *redirectRule) captureNames() map[string]bool {
+	names := map[string]bool{}
+
+	for _, name := range r.pattern.SubexpNames()[1:] {
+		if len(name) == 0 {
+			names["*"] = true
+		} else {
+			names[":"+name] = true
+		}
+	}
+
+	for _, values := range r.query {
+		if isRedirectPlaceholder(values[0]) {
+			names[values[0]] = true
+		}
+	}
+
+	return names
+}
+
+func isRedirectPlaceholder(value string) bool {
+	return len(value) > 0 && redirectPlaceholderPattern.FindString(value) == value
+}
+
+// match returns the values that the rule captures from a request, or false if
+// the request doesn't match the rule.
+func (r *redirectRule) match(req *http.Request) (map[string]string, bool) {
+	matches := r.pattern.FindStringSubmatch(redirectPath(req.URL.Path))
+
+	if matches == nil {
+		return nil, false
+	}
+
+	values := map[string]string{}
+
+	for i, name := range r.pattern.SubexpNames()[1:] {
+		if len(name) == 0 {
+			values["*"] = matches[i+1]
+		} else {
+			values[":"+name] = matches[i+1]
+		}
+	}
+
+	query := req.URL.Query()
+
+	for key, expected := range r.query {
+		value := query.Get(key)
+
+		if len(value) == 0 {
+			return nil, false
+		}
+
+		if isRedirectPlaceholder(expected[0]) {
+			values[expected[0]] = value
+		} else if value != expected[0] {
+			return nil, false
+		}
+	}
+
+	return values, true
+}
+
+// target returns the URL that a request matching the rule is redirected to,
+// or false if the rule redirects to a post and there is no such post.
+func (r *redirectRule) target(req *http.Request, values map[string]string) (string, bool) {
+	if r.To == redirectToPostTarget {
+		post := redirectPost(values)
+
+		if post == nil {
+			return "", false
+		}
+
+		return "/posts/" + post.Url, true
+	}
+
+	target := redirectPlaceholderPattern.ReplaceAllStringFunc(r.To, func(name string) string {
+		return url.PathEscape(values[name])
+	})
+
+	if splat, ok := values["*"]; ok {
+		segments := strings.Split(splat, "/")
+
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+
+		target = strings.Replace(target, "*", strings.Join(segments, "/"), -1)
+		target = repeatedSlashPattern.ReplaceAllString(target, "/")
+
+		if len(splat) == 0 && len(target) > 1 {
+			target = strings.TrimSuffix(target, "/")
+		}
+	}
+
+	// Pass on the query unless it was used to choose the redirect.
+	if len(r.query) == 0 && len(req.URL.RawQuery) > 0 && !strings.Contains(target, "?") {
+		target += "?" + req.URL.RawQuery
+	}
+
+	return target, true
+}
+
+// redirectPost finds the post that a redirect refers to, either by its ID or
+// by its slug and any parts of its date that the old URL included.
+func redirectPost(values map[string]string) *BlogPost {
+	if len(values[":id"]) > 0 {
+		id, err := strconv.Atoi(values[":id"])
+
+		if err != nil {
+			return nil
+		}
+
+		post, err := blog.PostWithId(id)
+
+		if err != nil {
+			return nil
+		}
+
+		return post
+	}
+
+	date := []int{0, 0, 0}
+
+	for i, name := range []string{":year", ":month", ":day"} {
+		if value, ok := values[name]; ok {
+			number, err := strconv.Atoi(value)
+
+			if err != nil || number <= 0 {
+				return nil
+			}
+
+			date[i] = number
+		}
+	}
+
+	post, err := blog.PostWithSlug(values[":slug"], date[0], date[1], date[2])
+
+	if err != nil {
+		return nil
+	}
+
+	return post
+}
+
+// PostWithSlug finds the newest post with the given slug, or whose URL ends
+// with it, that was published in the given year, month and day.  Parts of the
+// date that are zero match any date.
+func (b *Blog) PostWithSlug(slug string, year, month, day int) (*BlogPost, error) {
+	slug = slugify(slug)
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for _, post := range b.posts {
+		date := post.Metadata.Date
+
+		if (year > 0 && date.Year() != year) || (month > 0 && int(date.Month()) != month) || (day > 0 && date.Day() != day) {
+			continue
+		}
+
+		if len(slug) > 0 && (post.slug() == slug || path.Base(post.Url) == slug) {
+			return post, nil
+		}
+	}
+
+	return nil, fmt.Errorf("No post has the slug %v", slug)
+}
+
+// Redirect sends a redirect, or a "gone" page, if the request matches one of
+// the redirects in the table, and counts the hit.  It returns false if no
+// redirect matches.
+func (t *RedirectTable) Redirect(w http.ResponseWriter, req *http.Request) bool {
+	if t == nil || (req.Method != "GET" && req.Method != "HEAD") {
+		return false
+	}
+
+	for _, rule := range t.rules {
+		values, ok := rule.match(req)
+
+		if !ok {
+			continue
+		}
+
+		if rule.Status == http.StatusGone {
+			t.hit(rule)
+			showError(w, req, http.StatusGone, "This page has been removed.")
+			return true
+		}
+
+		target, ok := rule.target(req, values)
+
+		if !ok || redirectPath(target) == redirectPath(req.URL.Path) {
+			continue
+		}
+
+		t.hit(rule)
+		http.Redirect(w, req, target, rule.Status)
+
+		return true
+	}
+
+	return false
+}
+
+// Uses returns every redirect in the order in which they are checked, along
+// with the number of times each has been used.
+func (t *RedirectTable) Uses() []RedirectUse {
+	uses := []RedirectUse{}
+
+	if t == nil {
+		return uses
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, rule := range t.rules {
+		use := RedirectUse{From: rule.From, To: rule.To, Status: rule.Status}
+
+		if hits, ok := t.hits[rule.From]; ok {
+			use.Hits = hits.Hits
+			use.LastHit = hits.LastHit
+		}
+
+		uses = append(uses, use)
+	}
+
+	return uses
+}
+
+func (t *RedirectTable) hit(rule *redirectRule) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	hits, ok := t.hits[rule.From]
+
+	if !ok {
+		hits = &RedirectHits{}
+		t.hits[rule.From] = hits
+	}
+
+	hits.Hits++
+	hits.LastHit = t.now()
+
+	if len(t.path) > 0 && t.saveTimer == nil {
+		t.saveTimer = time.AfterFunc(redirectHitsSaveDelay, t.Flush)
+	}
+}
+
+// Flush writes any hit counts that have not been saved yet.
+func (t *RedirectTable) Flush() {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.saveTimer == nil {
+		return
+	}
+
+	t.saveTimer.Stop()
+	t.saveTimer = nil
+
+	t.save()
+}
+
+func (t *RedirectTable) load() error {
+	file, err := ioutil.ReadFile(t.path)
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(file, &t.hits)
+}
+
+func (t *RedirectTable) save() {
+	if len(t.path) == 0 {
+		return
+	}
+
+	data, err := json.MarshalIndent(t.hits, "", "  ")
+
+	if err != nil {
+		log.Println("Could not save redirect hits:", err)
+		return
+	}
+
+	os.MkdirAll(filepath.Dir(t.path), 0775)
+
+	err = ioutil.WriteFile(t.path, data, 0644)
+
+	if err != nil {
+		log.Println("Could not save redirect hits:", err)
+	}
+}
+
+// checkRedirects wraps a handler so that requests are checked against the
+// redirect table before they are routed.
+func checkRedirects(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !redirects.Redirect(w, req) {
+			handler.ServeHTTP(w, req)
+		}
+	})
+}
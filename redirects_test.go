@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRedirect(t *testing.T) {
+	valid := map[string]string{
+		"/old /new":                "/old /new 301",
+		"/old /new 302":            "/old /new 302",
+		"/old 410 # removed":       "/old  410",
+		"/:year/:slug.html post":   "/:year/:slug.html post 301",
+		"/docs/* https://x.org/*":  "/docs/* https://x.org/* 301",
+		"/?page_id=:id /pages/:id": "/?page_id=:id /pages/:id 301",
+	}
+
+	for line, expected := range valid {
+		rule, err := parseRedirect(line)
+
+		if err != nil {
+			t.Errorf("Could not parse %q: %v", line, err)
+		} else if text := fmt.Sprint(rule.From, " ", rule.To, " ", rule.Status); text != expected {
+			t.Errorf("Parsed %q as %q", line, text)
+		}
+	}
+
+	if rule, err := parseRedirect("  # Just a comment"); rule != nil || err != nil {
+		t.Errorf("Comment was parsed as %v, %v", rule, err)
+	}
+
+	invalid := map[string]string{
+		"/old":                  "expected a URL",
+		"/old /new 303":         "unknown status 303",
+		"/old /new 410":         "can't have a target",
+		"old /new":              "does not start with a slash",
+		"/old new":              "not a path",
+		"/:year/old /tags/:tag": "does not capture",
+		"/*/old /new":           "at the end",
+		"/:year/old post":       "must capture :id or :slug",
+	}
+
+	for line, expected := range invalid {
+		if _, err := parseRedirect(line); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Parsing %q: expected an error containing %q, got %v", line, expected, err)
+		}
+	}
+}
+
+func TestRedirectTable(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	SharedConfig.ThemePath = "themes"
+	SharedConfig.Theme = "grump"
+	SharedConfig.RedirectFile = filepath.Join(dir, "redirects.txt")
+	SharedConfig.Redirects = []string{"/temporary /archive/ 302"}
+	SharedConfig.LegacyRedirects = true
+	SharedConfig.RedirectHitsFile = filepath.Join(dir, "redirecthits.json")
+
+	writeTestFiles(t, dir, map[string]string{
+		"posts/hello.md":  "Title: Hello, World\nDate: 2014-01-26 10:30:00\nId: 7\n\nHello",
+		"redirects.txt":   "# Moved by hand\n/old-page 410\n/docs/* /files/*\n/feed/special /posts/2014/01/26/hello-world\n",
+		"posts/second.md": "Title: Second\nDate: 2014-02-01 10:30:00\n\nSecond",
+	})
+
+	var err error
+	blog, _ = LoadBlog(filepath.Join(dir, "posts"), filepath.Join(dir, "comments"), true)
+	redirects, err = LoadRedirects(SharedConfig)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { redirects = nil }()
+
+	router := newRouter()
+	post := "/posts/2014/01/26/hello-world"
+
+	tests := map[string]struct {
+		status   int
+		location string
+	}{
+		"/?p=7":                     {http.StatusMovedPermanently, post},
+		"/2014/01/hello-world/":     {http.StatusMovedPermanently, post},
+		"/2014/01/26/hello-world":   {http.StatusMovedPermanently, post},
+		"/2014/02/second":           {http.StatusMovedPermanently, "/posts/2014/02/01/second"},
+		"/category/news/":           {http.StatusMovedPermanently, "/tags/news"},
+		"/category/news/page/2":     {http.StatusMovedPermanently, "/tags/news"},
+		"/category/news/feed/":      {http.StatusMovedPermanently, "/rss"},
+		"/feed/":                    {http.StatusMovedPermanently, "/rss"},
+		"/?feed=rss2":               {http.StatusMovedPermanently, "/rss"},
+		"/feed/special":             {http.StatusMovedPermanently, post},
+		"/docs/a/b.txt?x=1":         {http.StatusMovedPermanently, "/files/a/b.txt?x=1"},
+		"/docs":                     {http.StatusMovedPermanently, "/files"},
+		"/temporary":                {http.StatusFound, "/archive/"},
+		"/old-page":                 {http.StatusGone, ""},
+		"/?p=8":                     {http.StatusOK, ""},
+		"/2014/01/missing":          {http.StatusNotFound, ""},
+		"/2014/02/26/hello-world":   {http.StatusNotFound, ""},
+		"/tags/hello/2":             {http.StatusOK, ""},
+		"/posts/2014/02/01/second/": {http.StatusOK, ""},
+	}
+
+	for path, expected := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != expected.status || w.Header().Get("Location") != expected.location {
+			t.Errorf("%v: expected %v %v, got %v %v", path, expected.status, expected.location, w.Code, w.Header().Get("Location"))
+		}
+	}
+
+	// Hit counts are kept between restarts once they have been flushed.
+	redirects.Flush()
+	redirects, _ = LoadRedirects(SharedConfig)
+	hits := map[string]int{}
+
+	for _, use := range redirects.Uses() {
+		hits[use.From] = use.Hits
+
+		if use.Hits > 0 && time.Since(use.LastHit) > time.Minute {
+			t.Errorf("Unexpected last hit for %v: %v", use.From, use.LastHit)
+		}
+	}
+
+	expected := map[string]int{
+		"/old-page":                1,
+		"/docs/*":                  2,
+		"/temporary":               1,
+		"/?p=:id":                  1,
+		"/:year/:month/:slug":      2,
+		"/:year/:month/:day/:slug": 1,
+		"/category/:tag/*":         2,
+		"/tag/:tag/*":              0,
+		"/feed/special":            1,
+		"/feed/*":                  1,
+		"/category/:tag/feed/*":    1,
+		"/?feed=:feed":             1,
+		"/tag/:tag/feed/*":         0,
+	}
+
+	for from, count := range expected {
+		if hits[from] != count {
+			t.Errorf("Expected %v hits for %v, got %v", count, from, hits[from])
+		}
+	}
+
+	if uses := redirects.Uses(); uses[0].From != "/old-page" || uses[len(uses)-1].From != "/:year/:month/:slug" {
+		t.Errorf("Redirects are in the wrong order: %v", uses)
+	}
+}
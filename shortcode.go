@@ -0,0 +1,600 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// shortcodeTagPattern finds shortcode tags, such as "{{< youtube id >}}" and
+// "{{< /note >}}".  A tag written as "{{</* youtube id */>}}" is shown as it
+// is instead of being expanded.
+var shortcodeTagPattern = regexp.MustCompile(`\{\{<(.*?)>\}\}`)
+
+// inlineCodePattern finds code spans, in which shortcodes aren't expanded.
+var inlineCodePattern = regexp.MustCompile("``[^\n]*?``|`[^`\n]+`")
+
+var shortcodeNamePattern = regexp.MustCompile(`^[\w-]+$`)
+
+// shortcodePlaceholderFormat is written in place of each shortcode before the
+// Markdown is converted, so that Markdown can't change the shortcode's HTML.
+const shortcodePlaceholderFormat = "GOBBLESHORTCODE%vEND"
+
+const shortcodeTemplateDirectory = "shortcodes"
+
+// Shortcode is a shortcode in a post, as given to the templates that themes
+// use to define their own shortcodes.  Inner is the HTML of the content
+// between the opening and closing tags of a shortcode that has them.
+type Shortcode struct {
+	Name   string
+	Args   []string
+	Params map[string]string
+	Inner  string
+	Post   *BlogPost
+}
+
+// Get returns a positional argument if given a number, or a named parameter
+// if given a name.  It returns an empty string if the shortcode doesn't have
+// the argument.
+func (s *Shortcode) Get(key interface{}) string {
+	switch key := key.(type) {
+	case int:
+		if key >= 0 && key < len(s.Args) {
+			return s.Args[key]
+		}
+	case string:
+		return s.Params[key]
+	}
+
+	return ""
+}
+
+// ShortcodeError is a problem with a shortcode in a post, such as a shortcode
+// that doesn't exist.
+type ShortcodeError struct {
+	Line    int
+	Message string
+}
+
+func (e *ShortcodeError) Error() string {
+	return fmt.Sprintf("line %v: %v", e.Line, e.Message)
+}
+
+type shortcodeFunc func(s *Shortcode) (string, error)
+
+// shortcodeTag is an opening or closing shortcode tag found in a post.
+type shortcodeTag struct {
+	start   int
+	end     int
+	line    int
+	name    string
+	closing bool
+	literal string
+	args    []string
+	params  map[string]string
+	err     error
+}
+
+// shortcodeExpander replaces the shortcodes in a post with placeholders,
+// keeping the HTML of each to be put in place once the Markdown has been
+// converted.
+type shortcodeExpander struct {
+	post   *BlogPost
+	html   map[string]string
+	errors []*ShortcodeError
+}
+
+// renderBody converts Markdown containing shortcodes to HTML.  firstLine is
+// the line of the post file on which the Markdown starts, so that problems
+// with shortcodes can be returned with the line they are on.  Shortcodes with
+// problems are left in the text.
+func (b *BlogPost) renderBody(markdown string, firstLine int, headings headingStyle) (string, []*ShortcodeError) {
+	expander := &shortcodeExpander{post: b, html: map[string]string{}}
+	expanded := []byte(expander.expand(markdown, firstLine))
+	output := renderMarkdown(expanded, b.Metadata.markdownOptions(), headings)
+
+	for placeholder, code := range expander.html {
+		output = strings.Replace(output, "<p>"+placeholder+"</p>", code, -1)
+		output = strings.Replace(output, placeholder, code, -1)
+	}
+
+	return output, expander.errors
+}
+
+func (e *shortcodeExpander) expand(text string, firstLine int) string {
+	tags := findShortcodeTags(text, firstLine)
+	expanded := ""
+	position := 0
+
+	for i := 0; i < len(tags); i++ {
+		tag := tags[i]
+		expanded += text[position:tag.start]
+		position = tag.end
+
+		if len(tag.literal) > 0 {
+			expanded += e.placeholder(html.EscapeString(tag.literal))
+			continue
+		}
+
+		if tag.err != nil {
+			e.report(tag.line, "%v", tag.err)
+			expanded += text[tag.start:tag.end]
+			continue
+		}
+
+		if tag.closing {
+			e.report(tag.line, "{{< /%v >}} has no opening tag", tag.name)
+			expanded += text[tag.start:tag.end]
+			continue
+		}
+
+		render, paired, exists := e.find(tag.name)
+
+		if !exists {
+			e.report(tag.line, "unknown shortcode %q", tag.name)
+			expanded += text[tag.start:tag.end]
+			continue
+		}
+
+		shortcode := &Shortcode{Name: tag.name, Args: tag.args, Params: tag.params, Post: e.post}
+
+		if paired {
+			closing := findClosingShortcodeTag(tags, i)
+
+			if closing < 0 {
+				e.report(tag.line, "{{< %v >}} has no closing tag", tag.name)
+				expanded += text[tag.start:tag.end]
+				continue
+			}
+
+			inner, problems := e.post.renderBody(text[tag.end:tags[closing].start], tag.line, plainHeadings)
+			shortcode.Inner = inner
+			e.errors = append(e.errors, problems...)
+
+			i = closing
+			position = tags[closing].end
+		}
+
+		code, err := render(shortcode)
+
+		if err != nil {
+			e.report(tag.line, "%v: %v", tag.name, err)
+			expanded += text[tag.start:position]
+			continue
+		}
+
+		expanded += e.placeholder(code)
+	}
+
+	return expanded + text[position:]
+}
+
+// placeholder keeps the HTML of a shortcode and returns the text to put in
+// its place.
+func (e *shortcodeExpander) placeholder(code string) string {
+	placeholder := fmt.Sprintf(shortcodePlaceholderFormat, len(e.html))
+	e.html[placeholder] = code
+
+	return placeholder
+}
+
+func (e *shortcodeExpander) report(line int, format string, args ...interface{}) {
+	e.errors = append(e.errors, &ShortcodeError{line, fmt.Sprintf(format, args...)})
+}
+
+// find returns the function that expands a shortcode, and whether the
+// shortcode has a closing tag.  Shortcodes defined by the theme replace the
+// built in ones.
+func (e *shortcodeExpander) find(name string) (shortcodeFunc, bool, bool) {
+	if SharedConfig != nil {
+		path := filepath.Join(SharedConfig.FullThemePath(), "templates", shortcodeTemplateDirectory, name+".html")
+
+		if source, err := ioutil.ReadFile(path); err == nil {
+			return func(s *Shortcode) (string, error) {
+				return renderShortcodeTemplate(path, s)
+			}, strings.Contains(string(source), ".Inner"), true
+		}
+	}
+
+	switch name {
+	case "figure":
+		return figureShortcode, false, true
+	case "youtube":
+		return youtubeShortcode, false, true
+	case "gist":
+		return gistShortcode, false, true
+	case "note":
+		return noteShortcode, true, true
+	case "include":
+		return includeShortcode, false, true
+	}
+
+	return nil, false, false
+}
+
+// findShortcodeTags finds the shortcode tags in a post, skipping any in code
+// blocks and code spans.
+func findShortcodeTags(text string, firstLine int) []*shortcodeTag {
+	code := [][]int{}
+	inCode := false
+	offset := 0
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") || strings.HasPrefix(strings.TrimSpace(line), "~~~") {
+			if !inCode {
+				code = append(code, []int{offset, len(text)})
+			} else {
+				code[len(code)-1][1] = offset + len(line)
+			}
+
+			inCode = !inCode
+		} else if !inCode {
+			for _, span := range inlineCodePattern.FindAllStringIndex(line, -1) {
+				code = append(code, []int{offset + span[0], offset + span[1]})
+			}
+		}
+
+		offset += len(line)
+	}
+
+	tags := []*shortcodeTag{}
+
+	for _, match := range shortcodeTagPattern.FindAllStringSubmatchIndex(text, -1) {
+		inSpan := false
+
+		for _, span := range code {
+			if match[0] >= span[0] && match[0] < span[1] {
+				inSpan = true
+				break
+			}
+		}
+
+		if !inSpan {
+			line := firstLine + strings.Count(text[:match[0]], "\n")
+			tags = append(tags, parseShortcodeTag(text[match[2]:match[3]], match[0], match[1], line))
+		}
+	}
+
+	return tags
+}
+
+// parseShortcodeTag reads the name and arguments from the text between a
+// tag's "{{<" and ">}}".  Arguments are either positional or "name=value",
+// and values containing spaces can be quoted.
+func parseShortcodeTag(text string, start, end, line int) *shortcodeTag {
+	tag := &shortcodeTag{start: start, end: end, line: line, params: map[string]string{}}
+	trimmed := strings.TrimSpace(text)
+
+	if strings.HasPrefix(trimmed, "/*") && strings.HasSuffix(trimmed, "*/") {
+		tag.literal = "{{<" + trimmed[2:len(trimmed)-2] + ">}}"
+		return tag
+	}
+
+	if strings.HasPrefix(trimmed, "/") {
+		tag.closing = true
+		trimmed = strings.TrimSpace(trimmed[1:])
+	}
+
+	fields, err := splitShortcodeArgs(trimmed)
+
+	if err != nil {
+		tag.err = err
+		return tag
+	}
+
+	if len(fields) == 0 || !shortcodeNamePattern.MatchString(fields[0]) {
+		tag.err = fmt.Errorf("invalid shortcode %q", "{{<"+text+">}}")
+		return tag
+	}
+
+	tag.name = fields[0]
+
+	for _, field := range fields[1:] {
+		if i := strings.Index(field, "="); i > 0 && shortcodeNamePattern.MatchString(field[:i]) {
+			tag.params[field[:i]] = field[i+1:]
+		} else {
+			tag.args = append(tag.args, field)
+		}
+	}
+
+	if tag.closing && len(fields) > 1 {
+		tag.err = fmt.Errorf("closing tag {{< /%v >}} can't have arguments", tag.name)
+	}
+
+	return tag
+}
+
+// splitShortcodeArgs splits a tag at spaces, except those within quotes.
+// Quotes are removed, and a quote within quotes is written as \".
+func splitShortcodeArgs(text string) ([]string, error) {
+	fields := []string{}
+	field := ""
+	inField := false
+	inQuotes := false
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+
+		switch {
+		case inQuotes && c == '\\' && i+1 < len(text) && (text[i+1] == '"' || text[i+1] == '\\'):
+			i++
+			field += string(text[i])
+		case c == '"':
+			inQuotes = !inQuotes
+			inField = true
+		case !inQuotes && (c == ' ' || c == '\t'):
+			if inField {
+				fields = append(fields, field)
+			}
+
+			field = ""
+			inField = false
+		default:
+			field += string(c)
+			inField = true
+		}
+	}
+
+	if inQuotes {
+		return nil, errors.New("unclosed quote in shortcode")
+	}
+
+	if inField {
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// findClosingShortcodeTag returns the index of the tag that closes the one at
+// the given index, allowing for shortcodes of the same name nested within it,
+// or -1 if it isn't closed.
+func findClosingShortcodeTag(tags []*shortcodeTag, opening int) int {
+	depth := 0
+
+	for i := opening + 1; i < len(tags); i++ {
+		if tags[i].name != tags[opening].name || tags[i].err != nil {
+			continue
+		}
+
+		if !tags[i].closing {
+			depth++
+		} else if depth > 0 {
+			depth--
+		} else {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func renderShortcodeTemplate(path string, s *Shortcode) (string, error) {
+	t, err := template.ParseFiles(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	var output bytes.Buffer
+
+	err = t.Execute(&output, s)
+
+	if err != nil {
+		return "", err
+	}
+
+	return output.String(), nil
+}
+
+// figureShortcode shows an image with an optional caption and link:
+// {{< figure src="/media/a.jpg" alt="..." caption="..." link="..." >}}
+func figureShortcode(s *Shortcode) (string, error) {
+	src := s.Get("src")
+
+	if len(src) == 0 {
+		return "", errors.New("src is required")
+	}
+
+	figure := "<figure"
+
+	if class := s.Get("class"); len(class) > 0 {
+		figure += ` class="` + html.EscapeString(class) + `"`
+	}
+
+	image := `<img src="` + html.EscapeString(src) + `" alt="` + html.EscapeString(s.Get("alt")) + `"`
+
+	for _, attribute := range []string{"title", "width", "height"} {
+		if value := s.Get(attribute); len(value) > 0 {
+			image += " " + attribute + `="` + html.EscapeString(value) + `"`
+		}
+	}
+
+	image += ">"
+
+	if link := s.Get("link"); len(link) > 0 {
+		image = `<a href="` + html.EscapeString(link) + `">` + image + "</a>"
+	}
+
+	figure += ">" + image
+
+	if caption := s.Get("caption"); len(caption) > 0 {
+		figure += "<figcaption>" + html.EscapeString(caption) + "</figcaption>"
+	}
+
+	return figure + "</figure>", nil
+}
+
+// youtubeShortcode embeds a YouTube video: {{< youtube id >}}, with optional
+// title and start parameters.
+func youtubeShortcode(s *Shortcode) (string, error) {
+	id := s.Get("id")
+
+	if len(id) == 0 {
+		id = s.Get(0)
+	}
+
+	if !shortcodeNamePattern.MatchString(id) {
+		return "", fmt.Errorf("invalid video id %q", id)
+	}
+
+	src := "https://www.youtube-nocookie.com/embed/" + id
+
+	if start := s.Get("start"); len(start) > 0 {
+		if _, err := strconv.Atoi(start); err != nil {
+			return "", fmt.Errorf("invalid start %q", start)
+		}
+
+		src += "?start=" + start
+	}
+
+	title := s.Get("title")
+
+	if len(title) == 0 {
+		title = "YouTube video"
+	}
+
+	return `<div class="video"><iframe src="` + src + `" title="` + html.EscapeString(title) + `" allow="accelerometer; autoplay; clipboard-write; encrypted-media; gyroscope; picture-in-picture" allowfullscreen loading="lazy"></iframe></div>`, nil
+}
+
+// gistShortcode embeds a GitHub gist: {{< gist user id >}}, optionally
+// followed by the name of a single file from the gist.  Readers without
+// JavaScript, including feed readers, get a link to the gist.
+func gistShortcode(s *Shortcode) (string, error) {
+	user, id, file := s.Get("user"), s.Get("id"), s.Get("file")
+
+	if len(user) == 0 {
+		user, id, file = s.Get(0), s.Get(1), s.Get(2)
+	}
+
+	if !shortcodeNamePattern.MatchString(user) || !shortcodeNamePattern.MatchString(id) {
+		return "", errors.New("expected a user and a gist id")
+	}
+
+	address := "https://gist.github.com/" + user + "/" + id
+	script := address + ".js"
+
+	if len(file) > 0 {
+		script += "?file=" + url.QueryEscape(file)
+	}
+
+	return `<script src="` + html.EscapeString(script) + `"></script><noscript><a href="` + address + `">View the gist on GitHub</a></noscript>`, nil
+}
+
+// noteShortcode puts Markdown in a callout box:
+// {{< note warning title="Careful" >}}...{{< /note >}}.  The type is added to
+// the box's classes, as "note-warning", so that themes can style each type.
+func noteShortcode(s *Shortcode) (string, error) {
+	kind := s.Get("type")
+
+	if len(kind) == 0 {
+		kind = s.Get(0)
+	}
+
+	if len(kind) == 0 {
+		kind = "note"
+	}
+
+	if !shortcodeNamePattern.MatchString(kind) {
+		return "", fmt.Errorf("invalid type %q", kind)
+	}
+
+	note := `<div class="note note-` + kind + `">` + "\n"
+
+	if title := s.Get("title"); len(title) > 0 {
+		note += `<p class="note-title">` + html.EscapeString(title) + "</p>\n"
+	}
+
+	return note + s.Inner + "</div>\n", nil
+}
+
+// includeShortcode shows the contents of a file as a code block:
+// {{< include file="code/main.go" lines="10-20" >}}.  The file is relative to
+// the posts directory and can't be outside it.  The language is taken from
+// the file's extension unless it is given as lang, and linenos and hl show
+// line numbers and highlight lines as they do for fenced code blocks.
+func includeShortcode(s *Shortcode) (string, error) {
+	file := s.Get("file")
+
+	if len(file) == 0 {
+		file = s.Get(0)
+	}
+
+	if len(file) == 0 {
+		return "", errors.New("file is required")
+	}
+
+	path := filepath.Join(s.Post.PostPath, filepath.FromSlash(file))
+	relative, err := filepath.Rel(s.Post.PostPath, path)
+
+	if err != nil || filepath.IsAbs(file) || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%v is outside the posts directory", file)
+	}
+
+	content, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%v does not exist", file)
+	} else if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(strings.Replace(string(content), "\r", "", -1), "\n"), "\n")
+	first, last := 1, len(lines)
+
+	if text := s.Get("lines"); len(text) > 0 {
+		lineRange, ok := parseLineRange(text)
+
+		if !ok {
+			return "", fmt.Errorf("invalid lines %q", text)
+		}
+
+		if lineRange[1] > len(lines) {
+			return "", fmt.Errorf("%v has only %v lines", file, len(lines))
+		}
+
+		first, last = lineRange[0], lineRange[1]
+	}
+
+	code := strings.Join(lines[first-1:last], "\n")
+	language := s.Get("lang")
+
+	if len(language) == 0 {
+		language = strings.TrimPrefix(filepath.Ext(file), ".")
+	}
+
+	fence := "```"
+
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+
+	info := language
+
+	if len(language) > 0 {
+		attributes := []string{"linenostart=" + strconv.Itoa(first)}
+
+		if linenos := s.Get("linenos"); len(linenos) > 0 {
+			attributes = append(attributes, "linenos="+linenos)
+		}
+
+		if hl := s.Get("hl"); len(hl) > 0 {
+			attributes = append(attributes, hl)
+		}
+
+		info += " {" + strings.Join(attributes, " ") + "}"
+	}
+
+	markdown := []byte(fence + info + "\n" + code + "\n" + fence + "\n")
+
+	return renderMarkdown(markdown, s.Post.Metadata.markdownOptions(), plainHeadings), nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseShortcodeTag(t *testing.T) {
+	tests := map[string]string{
+		` youtube abc `:                          "youtube [abc] map[]",
+		` figure src="/a b.jpg" alt=Photo `:      "figure [] map[alt:Photo src:/a b.jpg]",
+		` note warning title="Say \"hi\"" `:      `note [warning] map[title:Say "hi"]`,
+		` /note `:                                "/note [] map[]",
+		` gist user 123 "file name.go" `:         "gist [user 123 file name.go] map[]",
+		` include file="x.go" lines="10-20" x= `: "include [] map[file:x.go lines:10-20 x:]",
+	}
+
+	for text, expected := range tests {
+		tag := parseShortcodeTag(text, 0, 0, 1)
+		name := tag.name
+
+		if tag.closing {
+			name = "/" + name
+		}
+
+		if tag.err != nil {
+			t.Errorf("Could not parse %q: %v", text, tag.err)
+		} else if description := fmt.Sprint(name, " ", tag.args, " ", tag.params); description != expected {
+			t.Errorf("Parsed %q as %q", text, description)
+		}
+	}
+
+	if tag := parseShortcodeTag(" /* youtube abc */ ", 0, 0, 1); tag.literal != "{{< youtube abc >}}" {
+		t.Errorf("Unexpected literal %q", tag.literal)
+	}
+
+	for _, text := range []string{` figure src="unclosed `, ` `, ` bad!name `, ` /note extra `} {
+		if tag := parseShortcodeTag(text, 0, 0, 1); tag.err == nil {
+			t.Errorf("Expected an error for %q", text)
+		}
+	}
+}
+
+func TestBuiltInShortcodes(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	writeTestFiles(t, dir, map[string]string{
+		"posts/code/main.go": "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"<hi>\")\n}\n",
+	})
+
+	post := &BlogPost{PostPath: filepath.Join(dir, "posts")}
+
+	tests := map[string]string{
+		`{{< figure src="/media/a.jpg" alt="A <b>" caption="Caption & more" link="/posts/" >}}`: `<figure><a href="/posts/"><img src="/media/a.jpg" alt="A &lt;b&gt;"></a><figcaption>Caption &amp; more</figcaption></figure>`,
+		`{{< youtube dQw4w9WgXcQ start=30 >}}`:                                                  `<div class="video"><iframe src="https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ?start=30" title="YouTube video"`,
+		`{{< gist octocat 6cad326836d38bd3a7ae a.go >}}`:                                        `<script src="https://gist.github.com/octocat/6cad326836d38bd3a7ae.js?file=a.go"></script><noscript><a href="https://gist.github.com/octocat/6cad326836d38bd3a7ae">View the gist on GitHub</a></noscript>`,
+		"{{< note warning title=\"Careful\" >}}\nSome *text*.\n{{< /note >}}":                   "<div class=\"note note-warning\">\n<p class=\"note-title\">Careful</p>\n<p>Some <em>text</em>.</p>\n</div>\n",
+		`{{< include file="code/main.go" lines="5-7" >}}`:                                       "<pre><code class=\"language-go\">func main() {\n\tfmt.Println(&quot;&lt;hi&gt;&quot;)\n}\n</code></pre>\n",
+		"Watch {{< youtube abc >}} now":                                                         `<p>Watch <div class="video"><iframe src="https://www.youtube-nocookie.com/embed/abc"`,
+		"`{{< unknown >}}` and {{</* youtube abc */>}}":                                         "<p><code>{{&lt; unknown &gt;}}</code> and {{&lt; youtube abc &gt;}}</p>\n",
+		"```\n{{< unknown >}}\n```":                                                             "<pre><code>{{&lt; unknown &gt;}}\n</code></pre>\n",
+	}
+
+	for markdown, expected := range tests {
+		output, problems := post.renderBody(markdown, 1, plainHeadings)
+
+		if len(problems) > 0 {
+			t.Errorf("%q: unexpected problems %v", markdown, problems)
+		}
+
+		if !strings.HasPrefix(output, expected) {
+			t.Errorf("%q: expected %q, got %q", markdown, expected, output)
+		}
+	}
+}
+
+func TestShortcodeErrors(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	post := &BlogPost{PostPath: filepath.Join(dir, "posts")}
+	markdown := strings.Join([]string{
+		"Text",
+		"{{< tweet 123 >}}",
+		"{{< figure alt=x >}}",
+		"{{< note >}}",
+		"{{< unknown >}} inside a note",
+		"{{< /note >}}",
+		"{{< note >}} never closed",
+		"{{< /gist >}}",
+		`{{< include file="../gobble.conf" >}}`,
+		`{{< include file="missing.go" >}}`,
+	}, "\n")
+
+	output, problems := post.renderBody(markdown, 10, plainHeadings)
+
+	expected := []string{
+		`line 11: unknown shortcode "tweet"`,
+		`line 12: figure: src is required`,
+		`line 14: unknown shortcode "unknown"`,
+		`line 16: {{< note >}} has no closing tag`,
+		`line 17: {{< /gist >}} has no opening tag`,
+		`line 18: include: ../gobble.conf is outside the posts directory`,
+		`line 19: include: missing.go does not exist`,
+	}
+
+	if fmt.Sprint(problems) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected problems:\n%v", problems)
+	}
+
+	// Shortcodes with problems are left in the post.
+	if !strings.Contains(output, "{{&lt; tweet 123 &gt;}}") || !strings.Contains(output, `<div class="note note-note">`) {
+		t.Errorf("Unexpected output %q", output)
+	}
+}
+
+func TestThemeShortcodes(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	SharedConfig.ThemePath = filepath.Join(dir, "themes")
+	SharedConfig.Theme = "test"
+
+	writeTestFiles(t, dir, map[string]string{
+		"themes/test/templates/shortcodes/badge.html":   `<span class="badge">{{html (.Get 0)}}</span>`,
+		"themes/test/templates/shortcodes/details.html": `<details><summary>{{html (.Get "summary")}}</summary>{{.Inner}}</details>`,
+		"themes/test/templates/shortcodes/youtube.html": `<a href="https://youtu.be/{{.Get 0}}">Video</a>`,
+		"posts/shortcodes.md":                           "Title: Shortcodes\nDate: 2014-01-26 10:30:00\n\nNew {{< badge \"<new>\" >}}\n\n{{< details summary=More >}}\n**Hidden**\n{{< /details >}}\n\n{{< youtube abc >}}\n\n{{< missing >}}\n",
+	})
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	post, _ := LoadPost("shortcodes.md", filepath.Join(dir, "posts"), filepath.Join(dir, "comments"))
+
+	expected := []string{
+		`<p>New <span class="badge">&lt;new&gt;</span></p>`,
+		"<details><summary>More</summary><p><strong>Hidden</strong></p>\n</details>",
+		`<a href="https://youtu.be/abc">Video</a>`,
+	}
+
+	for _, html := range expected {
+		if !strings.Contains(post.Body.HTML, html) {
+			t.Errorf("Expected %q in %q", html, post.Body.HTML)
+		}
+	}
+
+	if !strings.Contains(logged.String(), `shortcodes.md:12: unknown shortcode "missing"`) {
+		t.Errorf("The unknown shortcode was not logged with its line: %q", logged.String())
+	}
+}
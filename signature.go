@@ -0,0 +1,26 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// sign returns an HMAC of the values using the secret key from the config
+// file.  It is used to create links, such as those in notification emails,
+// that must not be forgeable.
+func sign(values ...string) string {
+	mac := hmac.New(sha256.New, []byte(SharedConfig.SecretKey))
+	mac.Write([]byte(strings.Join(values, "\x00")))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func validSignature(signature string, values ...string) bool {
+	if len(SharedConfig.SecretKey) == 0 {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(sign(values...)))
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/dpapathanasiou/go-recaptcha"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -32,53 +34,88 @@ func showSinglePost(b *BlogPost, w http.ResponseWriter, req *http.Request) {
 	page.CommentEmailError = ""
 	page.CommentBodyError = ""
 
+	if SharedConfig.Webmentions {
+		w.Header().Set("Link", "<"+SharedConfig.Address+"/webmention>; rel=\"webmention\"")
+	}
+
 	t, _ := template.ParseFiles(SharedConfig.FullThemePath() + "/templates/post.html")
 	t.Execute(w, page)
 }
 
-func postWithQuery(query url.Values) (*BlogPost, error) {
-
-	title := query.Get(":title")
+// postWithPath finds the post that a path below "/posts/" or "/api/v1/posts/"
+// refers to.  Post URLs can have any number of segments, so the longest
+// leading part of the path that is a post's URL is used, and the rest of the
+// path, such as "comments", is returned with the post.
+func postWithPath(path string) (*BlogPost, string, error) {
+	path = strings.TrimPrefix(path, "/api/v1")
+	path = strings.Trim(strings.TrimPrefix(path, "/posts/"), "/")
+	segments := strings.Split(path, "/")
 
-	year, err := strconv.Atoi(query.Get(":year"))
+	for i := len(segments); i > 0; i-- {
+		post, err := blog.PostWithUrl(strings.Join(segments[:i], "/"))
 
-	if err != nil {
-		log.Println("Invalid year supplied")
-		return nil, err
+		if err == nil {
+			return post, strings.Join(segments[i:], "/"), nil
+		}
 	}
 
-	month, err := strconv.Atoi(query.Get(":month"))
+	return nil, "", errors.New(couldNotFindPostErrorMessage)
+}
+
+// postWithPermalink finds the post that an absolute URL on this site refers
+// to, such as the target of a webmention.
+func postWithPermalink(permalink string) *BlogPost {
+	permalinkUrl, err := url.Parse(permalink)
 
 	if err != nil {
-		log.Println("Invalid month supplied")
-		return nil, err
+		return nil
 	}
 
-	day, err := strconv.Atoi(query.Get(":day"))
+	address, err := url.Parse(SharedConfig.Address)
 
-	if err != nil {
-		log.Println("Invalid day supplied")
-		return nil, err
+	if err != nil || !strings.EqualFold(permalinkUrl.Host, address.Host) {
+		return nil
 	}
 
-	url := fmt.Sprintf("%04d/%02d/%02d/%s", year, month, day, title)
+	if !strings.HasPrefix(permalinkUrl.Path, "/posts/") {
+		return nil
+	}
 
-	post, err := blog.PostWithUrl(url)
+	post, rest, err := postWithPath(permalinkUrl.Path)
 
-	return post, err
+	if err != nil || len(rest) > 0 {
+		return nil
+	}
+
+	return post
 }
 
 func post(w http.ResponseWriter, req *http.Request) {
-	post, _ := postWithQuery(req.URL.Query())
+	post, rest, err := postWithPath(req.URL.Path)
+
+	if err != nil || len(rest) > 0 {
+		if redirectToPost(w, req) {
+			return
+		}
+
+		post = nil
+	}
+
+	if wantsActivityJSON(req) {
+		showActivityPubArticle(post, w, req)
+		return
+	}
+
 	showSinglePost(post, w, req)
 }
 
 func createComment(w http.ResponseWriter, req *http.Request) {
 
-	post, err := postWithQuery(req.URL.Query())
+	post, rest, err := postWithPath(req.URL.Path)
 
-	if err != nil {
+	if err != nil || rest != "comments" {
 		log.Println("Could not load post")
+		http.NotFound(w, req)
 		return
 	}
 
@@ -89,6 +126,7 @@ func createComment(w http.ResponseWriter, req *http.Request) {
 	author := strings.TrimSpace(req.FormValue("name"))
 	email := strings.TrimSpace(req.FormValue("email"))
 	body := strings.TrimSpace(req.FormValue("comment"))
+	notify := req.FormValue("notify") == "true" && SharedConfig.NotificationsEnabled()
 
 	hasErrors := false
 	commentNameError := ""
@@ -129,9 +167,7 @@ func createComment(w http.ResponseWriter, req *http.Request) {
 
 	if len(SharedConfig.RecaptchaPrivateKey) > 0 {
 		recaptcha.Init(SharedConfig.RecaptchaPrivateKey)
-		ip := strings.Split(req.RemoteAddr, ":")[0]
-
-		success, _ := recaptcha.Confirm(ip, req.FormValue("g-recaptcha-response"))
+		success, _ := recaptcha.Confirm(getIpAddress(req), req.FormValue("g-recaptcha-response"))
 
 		if (!success) {
 			hasErrors = true
@@ -140,7 +176,15 @@ func createComment(w http.ResponseWriter, req *http.Request) {
 	}
 
 	if !hasErrors {
-		post.SaveComment(SharedConfig.AkismetAPIKey, SharedConfig.Address, getIpAddress(req), req.UserAgent(), req.Referer(), author, email, body)
+
+		// Only comments that would be saved count against the limits.
+		if !allowCommentFrom(w, req, post) {
+			return
+		}
+
+		comment := post.SaveComment(SharedConfig.AkismetAPIKey, SharedConfig.Address, getIpAddress(req), req.UserAgent(), req.Referer(), author, email, body, notify)
+		notifyNewComment(post, comment)
+
 		http.Redirect(w, req, "/posts/"+post.Url+"#comments", http.StatusFound)
 
 		return
@@ -152,6 +196,7 @@ func createComment(w http.ResponseWriter, req *http.Request) {
 		page.CommentName = author
 		page.CommentEmail = email
 		page.CommentBody = body
+		page.CommentNotify = notify
 		page.CommentNameError = commentNameError
 		page.CommentEmailError = commentEmailError
 		page.CommentBodyError = commentBodyError
@@ -162,29 +207,24 @@ func createComment(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func ipAddrFromRemoteAddr(s string) string {
-	idx := strings.LastIndex(s, ":")
-	if idx == -1 {
-		return s
-	}
-	return s[:idx]
-}
+// allowCommentFrom checks the commenter's IP address and the post against
+// the comment rate limiters, sending a 429 response if either is exhausted.
+// Neither limiter is charged unless both allow the comment.
+func allowCommentFrom(w http.ResponseWriter, req *http.Request, post *BlogPost) bool {
+	ip := getIpAddress(req)
 
-func getIpAddress(r *http.Request) string {
-	hdr := r.Header
-	hdrRealIp := hdr.Get("X-Real-Ip")
-	hdrForwardedFor := hdr.Get("X-Forwarded-For")
-	if hdrRealIp == "" && hdrForwardedFor == "" {
-		return ipAddrFromRemoteAddr(r.RemoteAddr)
-	}
-	if hdrForwardedFor != "" {
-		// X-Forwarded-For is potentially a list of addresses separated with ","
-		parts := strings.Split(hdrForwardedFor, ",")
-		for i, p := range parts {
-			parts[i] = strings.TrimSpace(p)
-		}
-		// TODO: should return first non-local address
-		return parts[0]
+	allowed, retryAfter := allowBoth(ipRateLimiter, ip, postRateLimiter, post.Url)
+
+	if allowed {
+		return true
 	}
-	return hdrRealIp
+
+	log.Println("Rate limited comment from", ip, "on", post.Url)
+
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	showError(w, req, http.StatusTooManyRequests, fmt.Sprintf("Too many comments have been posted.  Please try again in %v seconds.", seconds))
+
+	return false
 }
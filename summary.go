@@ -0,0 +1,138 @@
+package main
+
+import (
+	"golang.org/x/net/html"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Values of the listContent and feedContent config settings.
+const fullContent = "full"
+const summaryContent = "summary"
+
+// defaultSummaryWords is the length of automatic summaries if the config
+// doesn't give one.
+const defaultSummaryWords = 50
+
+// moreMarkerPattern finds the marker that ends the summary of a post, as used
+// by WordPress and Hugo.
+var moreMarkerPattern = regexp.MustCompile(`(?i)<!--\s*more\s*-->`)
+
+// voidElements are the HTML elements that have no end tag.
+var voidElements = []string{"area", "base", "br", "col", "embed", "hr", "img", "input", "link", "meta", "param", "source", "track", "wbr"}
+
+// summarise sets the post's summary from its Summary metadata, or from the
+// part of its body before a "<!--more-->" marker, or else from the first few
+// words of its body.
+func (b *BlogPost) summarise() {
+	if len(strings.TrimSpace(b.Metadata.Summary)) > 0 {
+		b.Summary, _ = b.renderBody(b.Metadata.Summary, 1, plainHeadings)
+		b.HasMore = len(strings.TrimSpace(b.Body.Markdown)) > 0
+
+		return
+	}
+
+	if marker := moreMarkerPattern.FindStringIndex(b.Body.Markdown); marker != nil {
+		b.Summary, _ = b.renderBody(b.Body.Markdown[:marker[0]], 1, plainHeadings)
+		b.HasMore = len(strings.TrimSpace(b.Body.Markdown[marker[1]:])) > 0
+
+		return
+	}
+
+	words := defaultSummaryWords
+
+	if SharedConfig != nil {
+		words = SharedConfig.SummaryWords
+	}
+
+	body, _ := b.renderBody(b.Body.Markdown, 1, plainHeadings)
+
+	b.Summary, b.HasMore = truncateHTML(body, words)
+}
+
+// truncateHTML cuts HTML down to the given number of words, closing any
+// elements that are left open, and returns true if anything was removed.  If
+// words is zero the HTML is returned unchanged.
+func truncateHTML(text string, words int) (string, bool) {
+	if words <= 0 {
+		return text, false
+	}
+
+	var b strings.Builder
+	open := []string{}
+	count := 0
+	inWord := false
+	end := 0
+	endOpen := []string{}
+	tokenizer := html.NewTokenizer(strings.NewReader(text))
+
+	for {
+		tokenType := tokenizer.Next()
+
+		if tokenType == html.ErrorToken {
+			if tokenizer.Err() != io.EOF {
+				return text, false
+			}
+
+			return b.String(), false
+		}
+
+		raw := string(tokenizer.Raw())
+
+		if tokenType != html.TextToken {
+			inWord = false
+		}
+
+		switch tokenType {
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+
+			if !contains(voidElements, string(name)) {
+				open = append(open, string(name))
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == string(name) {
+					open = open[:i]
+					break
+				}
+			}
+		case html.TextToken:
+			openAtEnd := append([]string{}, open...)
+
+			for i, r := range raw {
+				if unicode.IsSpace(r) {
+					inWord = false
+					continue
+				}
+
+				if !inWord {
+					inWord = true
+					count++
+				}
+
+				if count > words {
+					// Finish after the last whole word, even if it was in an
+					// element that has since been closed.
+					summary := (b.String() + raw)[:end] + "…"
+
+					for j := len(endOpen) - 1; j >= 0; j-- {
+						summary += "</" + endOpen[j] + ">"
+					}
+
+					return summary, true
+				}
+
+				end = b.Len() + i + utf8.RuneLen(r)
+				endOpen = openAtEnd
+			}
+		}
+
+		b.WriteString(raw)
+	}
+}
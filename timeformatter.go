@@ -16,8 +16,7 @@ func timeToString(t time.Time) string {
 }
 
 func stringToTime(s string) time.Time {
-	const layout = "2006-01-02 15:04:05"
-	t, err := time.Parse(layout, s)
+	t, err := parseTimeString(s)
 
 	if err != nil {
 		log.Println(err)
@@ -25,3 +24,8 @@ func stringToTime(s string) time.Time {
 
 	return t
 }
+
+func parseTimeString(s string) (time.Time, error) {
+	const layout = "2006-01-02 15:04:05"
+	return time.Parse(layout, s)
+}
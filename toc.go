@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/russross/blackfriday"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var headingTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// anchoredHeadingPattern finds the headings written by headingRenderer, with
+// or without links to themselves.
+var anchoredHeadingPattern = regexp.MustCompile(`(?s)<h([1-6]) id="([^"]*)">(.*?)(?: <a class="anchor" href="#[^"]*" aria-label="Link to this section">#</a>)?</h[1-6]>`)
+
+// headingStyle says what is added to the headings of a post.
+type headingStyle int
+
+const (
+	// plainHeadings are left as the renderer writes them.
+	plainHeadings headingStyle = iota
+
+	// headingIdsOnly gives headings ids, so that a table of contents can
+	// link to them.
+	headingIdsOnly
+
+	// anchoredHeadings gives headings ids and links to themselves.
+	anchoredHeadings
+)
+
+// TOCEntry is a heading in a post's table of contents, along with the
+// headings below it.
+type TOCEntry struct {
+	Title    string
+	Id       string
+	Level    int
+	Children []*TOCEntry
+}
+
+// headingRenderer gives every heading an id made from its text and, if links
+// is true, a link to itself that themes can show when the heading is hovered
+// over.  Headings with the same text are numbered so that their ids stay
+// unique.
+type headingRenderer struct {
+	blackfriday.Renderer
+	ids   headingIds
+	links bool
+}
+
+// headingIds counts the ids given to the headings in a post.
+type headingIds map[string]int
+
+func (r *headingRenderer) Header(out *bytes.Buffer, text func() bool, level int, id string) {
+	marker := out.Len()
+	r.Renderer.Header(out, text, level, id)
+
+	rendered := string(out.Bytes()[marker:])
+	start := fmt.Sprintf("<h%d>", level)
+	end := fmt.Sprintf("</h%d>\n", level)
+	i := strings.Index(rendered, start)
+
+	if i < 0 || !strings.HasSuffix(rendered, end) {
+		return
+	}
+
+	inner := rendered[i+len(start) : len(rendered)-len(end)]
+
+	out.Truncate(marker)
+	out.WriteString(rendered[:i])
+	out.WriteString(r.ids.anchoredHeading(level, inner, r.links))
+}
+
+// anchoredHeading writes a heading with the given inner HTML, an id made from
+// its text and, if links is true, a link to itself, as matched by
+// anchoredHeadingPattern.
+func (ids headingIds) anchoredHeading(level int, inner string, links bool) string {
+	id := ids.unique(slugify(headingText(inner)))
+
+	if !links {
+		return fmt.Sprintf(`<h%d id="%s">%s</h%d>`+"\n", level, id, inner, level)
+	}
+
+	return fmt.Sprintf(`<h%d id="%s">%s <a class="anchor" href="#%s" aria-label="Link to this section">#</a></h%d>`+"\n", level, id, inner, id, level)
+}
+
+func (ids headingIds) unique(id string) string {
+	if len(id) == 0 {
+		id = "section"
+	}
+
+	count := ids[id]
+	ids[id] = count + 1
+
+	if count == 0 {
+		return id
+	}
+
+	return id + "-" + strconv.Itoa(count)
+}
+
+// headingText returns the text of a heading without any markup.
+func headingText(heading string) string {
+	return strings.TrimSpace(html.UnescapeString(headingTagPattern.ReplaceAllString(heading, "")))
+}
+
+// tableOfContents lists the headings in a post's HTML, with each heading
+// nested below the nearest heading before it of a higher level.
+func tableOfContents(body string) []*TOCEntry {
+	toc := []*TOCEntry{}
+	parents := []*TOCEntry{}
+
+	for _, match := range anchoredHeadingPattern.FindAllStringSubmatch(body, -1) {
+		level, _ := strconv.Atoi(match[1])
+		entry := &TOCEntry{Title: headingText(match[3]), Id: match[2], Level: level}
+
+		for len(parents) > 0 && parents[len(parents)-1].Level >= level {
+			parents = parents[:len(parents)-1]
+		}
+
+		if len(parents) == 0 {
+			toc = append(toc, entry)
+		} else {
+			parent := parents[len(parents)-1]
+			parent.Children = append(parent.Children, entry)
+		}
+
+		parents = append(parents, entry)
+	}
+
+	return toc
+}
+
+// ShowsTOC returns true if the post has headings and its table of contents
+// should be shown.
+func (b *BlogPost) ShowsTOC() bool {
+	return len(b.TOC) > 0 && b.wantsTOC()
+}
+
+// wantsTOC returns true if the post's TOC metadata turns its table of
+// contents on or, if it has none, the config turns them on for every post.
+func (b *BlogPost) wantsTOC() bool {
+	if b.Metadata.TOC != nil {
+		return *b.Metadata.TOC
+	}
+
+	return SharedConfig != nil && SharedConfig.TableOfContents
+}
+
+// headingStyle returns what should be added to the post's headings.  The
+// headingAnchors config setting gives every post's headings ids and links;
+// without it, only posts with a table of contents get ids, for the table to
+// link to, so that the HTML of other posts doesn't change.
+func (b *BlogPost) headingStyle() headingStyle {
+	if SharedConfig != nil && SharedConfig.HeadingAnchors {
+		return anchoredHeadings
+	}
+
+	if b.wantsTOC() {
+		return headingIdsOnly
+	}
+
+	return plainHeadings
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHeadingAnchors(t *testing.T) {
+	markdown := []byte("# Getting *Started*\n\nText\n\n## Setup\n\n## Setup\n\n### Café & Co.\n\n## ???\n")
+	expected := `<h1 id="getting-started">Getting <em>Started</em> <a class="anchor" href="#getting-started" aria-label="Link to this section">#</a></h1>
+
+<p>Text</p>
+
+<h2 id="setup">Setup <a class="anchor" href="#setup" aria-label="Link to this section">#</a></h2>
+
+<h2 id="setup-1">Setup <a class="anchor" href="#setup-1" aria-label="Link to this section">#</a></h2>
+
+<h3 id="café-co">Café &amp; Co. <a class="anchor" href="#café-co" aria-label="Link to this section">#</a></h3>
+
+<h2 id="section">??? <a class="anchor" href="#section" aria-label="Link to this section">#</a></h2>
+`
+
+	if output := renderMarkdown(markdown, markdownOptions{renderer: legacyMarkdown}, anchoredHeadings); output != expected {
+		t.Errorf("Unexpected HTML %q", output)
+	}
+
+	// The CommonMark renderer gives headings the same ids and links.
+	commonMark := renderMarkdown(markdown, markdownOptions{renderer: commonMarkMarkdown}, anchoredHeadings)
+	headings := anchoredHeadingPattern.FindAllString(commonMark, -1)
+
+	if strings.Join(headings, "\n\n") != strings.Replace(strings.TrimSpace(expected), "\n\n<p>Text</p>", "", 1) {
+		t.Errorf("Unexpected CommonMark headings %q", commonMark)
+	}
+
+	// Comments don't get anchors.
+	if output := convertMarkdownToHtml(&markdown); strings.Contains(output, "id=") {
+		t.Errorf("Comment headings were given ids: %q", output)
+	}
+}
+
+func TestTableOfContents(t *testing.T) {
+	markdown := []byte("## One\n\n#### One A\n\n### One B\n\n# Two & Three\n\n## Two A\n")
+	toc := tableOfContents(renderMarkdown(markdown, markdownOptions{renderer: legacyMarkdown}, anchoredHeadings))
+
+	var describe func(entries []*TOCEntry) string
+
+	describe = func(entries []*TOCEntry) string {
+		text := ""
+
+		for _, entry := range entries {
+			text += entry.Id + ":" + entry.Title
+
+			if len(entry.Children) > 0 {
+				text += "(" + describe(entry.Children) + ")"
+			}
+
+			text += " "
+		}
+
+		return strings.TrimSpace(text)
+	}
+
+	if text := describe(toc); text != "one:One(one-a:One A one-b:One B) two-three:Two & Three(two-a:Two A)" {
+		t.Errorf("Unexpected table of contents %v", text)
+	}
+}
+
+func TestShowsTOC(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	SharedConfig.ThemePath = "themes"
+	SharedConfig.Theme = "grump"
+
+	writeTestFiles(t, dir, map[string]string{
+		"posts/default.md": "Title: Default\nDate: 2014-01-26 10:30:00\n\n## Heading\n",
+		"posts/on.md":      "Title: On\nDate: 2014-01-27 10:30:00\nTOC: true\n\n## Heading\n",
+		"posts/off.md":     "Title: Off\nDate: 2014-01-28 10:30:00\nTOC: false\n\n## Heading\n",
+		"posts/empty.md":   "Title: Empty\nDate: 2014-01-29 10:30:00\nTOC: true\n\nNo headings.\n",
+	})
+
+	router := newRouter()
+
+	for _, siteWide := range []bool{false, true} {
+		SharedConfig.TableOfContents = siteWide
+		SharedConfig.HeadingAnchors = false
+		blog, _ = LoadBlog(filepath.Join(dir, "posts"), filepath.Join(dir, "comments"), true)
+
+		expected := map[string]bool{"default": siteWide, "on": true, "off": false, "empty": false}
+
+		for _, post := range blog.AllPosts() {
+			name := strings.TrimSuffix(post.Filename, ".md")
+
+			if post.ShowsTOC() != expected[name] {
+				t.Errorf("%v: expected ShowsTOC to be %v with the site-wide setting %v", name, expected[name], siteWide)
+			}
+
+			// Without heading anchors, only posts that want a table of
+			// contents give their headings ids, so that other posts' HTML is
+			// unchanged.
+			if ids := strings.Contains(post.Body.HTML, `<h2 id="heading">Heading</h2>`); ids != expected[name] && name != "empty" {
+				t.Errorf("%v: headings given ids: %v", name, ids)
+			}
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/posts/"+post.Url, nil)
+			router.ServeHTTP(w, req)
+
+			if shown := strings.Contains(w.Body.String(), `<li><a href="#heading">Heading</a></li>`); shown != expected[name] {
+				t.Errorf("%v: table of contents shown: %v", name, shown)
+			}
+		}
+	}
+}
+
+func TestHeadingAnchorsSetting(t *testing.T) {
+	dir := createMicropubTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	writeTestFiles(t, dir, map[string]string{
+		"posts/default.md": "Title: Default\nDate: 2014-01-26 10:30:00\n\n## Heading\n",
+		"posts/on.md":      "Title: On\nDate: 2014-01-27 10:30:00\nTOC: true\n\n## Heading\n",
+		"posts/off.md":     "Title: Off\nDate: 2014-01-28 10:30:00\nTOC: false\n\n## Heading\n",
+	})
+
+	link := `<h2 id="heading">Heading <a class="anchor" href="#heading" aria-label="Link to this section">#</a></h2>`
+
+	for _, anchors := range []bool{false, true} {
+		SharedConfig.HeadingAnchors = anchors
+		blog, _ = LoadBlog(filepath.Join(dir, "posts"), filepath.Join(dir, "comments"), true)
+
+		for _, post := range blog.AllPosts() {
+			name := strings.TrimSuffix(post.Filename, ".md")
+
+			// Anchors don't depend on whether the table of contents is shown.
+			if linked := strings.Contains(post.Body.HTML, link); linked != anchors {
+				t.Errorf("%v: headings linked with anchors %v: %q", name, anchors, post.Body.HTML)
+			}
+
+			if shows := post.ShowsTOC(); shows != (name == "on") {
+				t.Errorf("%v: ShowsTOC is %v with anchors %v", name, shows, anchors)
+			}
+
+			if listed := len(post.TOC) > 0; listed != (anchors || name == "on") {
+				t.Errorf("%v: headings listed with anchors %v: %v", name, anchors, post.TOC)
+			}
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package webmention
+
+import (
+	"golang.org/x/net/html"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Entry holds the parts of a microformats2 h-entry that are needed to show a
+// mention as a comment.
+type Entry struct {
+	Source    string
+	Url       string
+	Name      string
+	Content   string
+	Published time.Time
+	Author    Author
+}
+
+type Author struct {
+	Name  string
+	Url   string
+	Photo string
+}
+
+var publishedLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// ParseEntry extracts the first h-entry from a document.  If there is no
+// h-entry an empty Entry is returned, as a plain link is still a mention.
+func ParseEntry(doc *html.Node, base *url.URL) *Entry {
+	entry := &Entry{}
+	root := findClass(doc, "h-entry")
+
+	if root == nil {
+		return entry
+	}
+
+	forEachProperty(root, func(n *html.Node, class string) {
+		switch class {
+		case "p-name":
+			if len(entry.Name) == 0 {
+				entry.Name = text(n)
+			}
+		case "e-content", "p-content":
+			if len(entry.Content) == 0 {
+				entry.Content = text(n)
+			}
+		case "u-url":
+			if len(entry.Url) == 0 {
+				entry.Url = urlProperty(n, base)
+			}
+		case "dt-published":
+			if entry.Published.IsZero() {
+				entry.Published = parsePublished(n)
+			}
+		case "p-author", "u-author":
+			if len(entry.Author.Name) == 0 {
+				entry.Author = parseAuthor(n, base)
+			}
+		}
+	})
+
+	// Notes often mark their content as the name too, which isn't a title.
+	if entry.Name == entry.Content {
+		entry.Name = ""
+	}
+
+	return entry
+}
+
+func parseAuthor(n *html.Node, base *url.URL) Author {
+	author := Author{}
+
+	if !hasToken(attribute(n, "class"), "h-card") {
+		author.Name = text(n)
+
+		if n.Data == "a" {
+			author.Url = urlProperty(n, base)
+		}
+
+		return author
+	}
+
+	forEachProperty(n, func(child *html.Node, class string) {
+		switch class {
+		case "p-name":
+			if len(author.Name) == 0 {
+				author.Name = text(child)
+			}
+		case "u-url":
+			if len(author.Url) == 0 {
+				author.Url = urlProperty(child, base)
+			}
+		case "u-photo":
+			if len(author.Photo) == 0 {
+				author.Photo = urlProperty(child, base)
+			}
+		}
+	})
+
+	// An h-card with no properties is named by its text, and an h-card that
+	// is a link points to its own URL.
+	if len(author.Name) == 0 {
+		author.Name = text(n)
+	}
+
+	if len(author.Url) == 0 && n.Data == "a" {
+		author.Url = urlProperty(n, base)
+	}
+
+	return author
+}
+
+// forEachProperty calls handler for every property class on the descendants
+// of root, without descending into nested microformats, whose properties
+// belong to them rather than to root.
+func forEachProperty(root *html.Node, handler func(n *html.Node, class string)) {
+	for child := root.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+
+		isMicroformat := false
+
+		for _, class := range strings.Fields(attribute(child, "class")) {
+			if strings.HasPrefix(class, "h-") {
+				isMicroformat = true
+			}
+
+			if isPropertyClass(class) {
+				handler(child, class)
+			}
+		}
+
+		if !isMicroformat {
+			forEachProperty(child, handler)
+		}
+	}
+}
+
+func isPropertyClass(class string) bool {
+	for _, prefix := range []string{"p-", "u-", "dt-", "e-"} {
+		if strings.HasPrefix(class, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func findClass(n *html.Node, class string) *html.Node {
+	var found *html.Node
+
+	walk(n, func(child *html.Node) bool {
+		if child.Type == html.ElementNode && hasToken(attribute(child, "class"), class) {
+			found = child
+			return false
+		}
+
+		return true
+	})
+
+	return found
+}
+
+func urlProperty(n *html.Node, base *url.URL) string {
+	var value string
+
+	switch n.Data {
+	case "a", "area", "link":
+		value = attribute(n, "href")
+	case "img", "audio", "video", "source":
+		value = attribute(n, "src")
+	default:
+		value = text(n)
+	}
+
+	resolved, err := resolve(base, value)
+
+	if err != nil || !isWebUrl(resolved) {
+		return ""
+	}
+
+	return resolved
+}
+
+// isWebUrl returns true if s is an http or https URL.  Other schemes, such as
+// javascript:, are dropped, as the URLs are shown to readers as links.
+func isWebUrl(s string) bool {
+	u, err := url.Parse(s)
+
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) > 0
+}
+
+func parsePublished(n *html.Node) time.Time {
+	value := attribute(n, "datetime")
+
+	if len(value) == 0 {
+		value = text(n)
+	}
+
+	for _, layout := range publishedLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// text returns the text content of a node with whitespace collapsed.
+func text(n *html.Node) string {
+	var parts []string
+	var collect func(n *html.Node)
+
+	collect = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			parts = append(parts, n.Data)
+			return
+		}
+
+		if n.Type == html.ElementNode && (n.Data == "script" || n.Data == "style") {
+			return
+		}
+
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			collect(child)
+		}
+	}
+
+	collect(n)
+
+	return strings.Join(strings.Fields(strings.Join(parts, " ")), " ")
+}
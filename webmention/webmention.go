@@ -0,0 +1,380 @@
+package webmention
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxDocumentSize limits how much of a remote page is read.
+const maxDocumentSize = 1024 * 1024
+
+var ErrNoEndpoint = errors.New("Target does not advertise a webmention endpoint")
+var ErrNoLink = errors.New("Source does not link to target")
+var ErrSourceGone = errors.New("Source no longer exists")
+var ErrPrivateAddress = errors.New("Refusing to connect to a private address")
+
+// Fetcher performs HTTP requests.  *http.Client satisfies it; tests can
+// substitute a stand-in that never touches the network.
+type Fetcher interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+type Client struct {
+	Fetcher   Fetcher
+	UserAgent string
+}
+
+func NewClient(fetcher Fetcher) *Client {
+	return &Client{Fetcher: fetcher, UserAgent: "Gobble"}
+}
+
+// NewHTTPClient returns an HTTP client that only connects to public addresses.
+// Anyone can send a webmention, so without it the source URL could be used to
+// make the server fetch pages from itself or its local network.  The address
+// is checked as it is dialled, so redirects and host names that resolve to
+// private addresses are refused too.
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: refusePrivateAddresses}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+		},
+	}
+}
+
+func refusePrivateAddresses(network, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+
+	if ip == nil || !isPublicIP(ip) {
+		return ErrPrivateAddress
+	}
+
+	return nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// Send notifies target that source links to it.  It returns ErrNoEndpoint if
+// the target doesn't accept webmentions.
+func (c *Client) Send(source, target string) error {
+	endpoint, err := c.DiscoverEndpoint(target)
+
+	if err != nil {
+		return err
+	}
+
+	values := url.Values{"source": {source}, "target": {target}}
+
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(values.Encode()))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Webmention endpoint %v returned %v", endpoint, resp.Status)
+	}
+
+	return nil
+}
+
+// DiscoverEndpoint finds the webmention endpoint of a page, looking first at
+// its Link headers and then at <link> and <a> elements.
+func (c *Client) DiscoverEndpoint(target string) (string, error) {
+	base, err := url.Parse(target)
+
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("GET", target, nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.do(req)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.Request != nil && resp.Request.URL != nil {
+		base = resp.Request.URL
+	}
+
+	for _, header := range resp.Header["Link"] {
+		if endpoint, ok := endpointFromLinkHeader(header); ok {
+			return resolve(base, endpoint)
+		}
+	}
+
+	if !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return "", ErrNoEndpoint
+	}
+
+	doc, err := html.Parse(io.LimitReader(resp.Body, maxDocumentSize))
+
+	if err != nil {
+		return "", err
+	}
+
+	var endpoint string
+	found := false
+
+	walk(doc, func(n *html.Node) bool {
+		if n.Type != html.ElementNode || (n.Data != "link" && n.Data != "a") {
+			return true
+		}
+
+		if !hasToken(attribute(n, "rel"), "webmention") {
+			return true
+		}
+
+		if href, ok := attributeValue(n, "href"); ok {
+			endpoint = href
+			found = true
+			return false
+		}
+
+		return true
+	})
+
+	if !found {
+		return "", ErrNoEndpoint
+	}
+
+	return resolve(base, endpoint)
+}
+
+// Verify fetches source and checks that it links to target.  If it does, the
+// first h-entry on the page is returned.  ErrSourceGone means the source has
+// been deleted, so any previous mention should be removed.
+func (c *Client) Verify(source, target string) (*Entry, error) {
+	base, err := url.Parse(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", source, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := c.do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusNotFound {
+		return nil, ErrSourceGone
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("Source %v returned %v", source, resp.Status)
+	}
+
+	doc, err := html.Parse(io.LimitReader(resp.Body, maxDocumentSize))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !linksTo(doc, base, target) {
+		return nil, ErrNoLink
+	}
+
+	entry := ParseEntry(doc, base)
+	entry.Source = source
+
+	return entry, nil
+}
+
+// Links returns the absolute URLs of every link in an HTML fragment, resolved
+// against base and without duplicates.
+func Links(fragment string, base *url.URL) []string {
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+
+	if err != nil {
+		return []string{}
+	}
+
+	found := make(map[string]bool)
+	links := []string{}
+
+	for _, node := range nodes {
+		walk(node, func(n *html.Node) bool {
+			if n.Type != html.ElementNode || n.Data != "a" {
+				return true
+			}
+
+			href, err := resolve(base, attribute(n, "href"))
+
+			if err != nil || found[href] {
+				return true
+			}
+
+			if strings.HasPrefix(href, "http://") || strings.HasPrefix(href, "https://") {
+				found[href] = true
+				links = append(links, href)
+			}
+
+			return true
+		})
+	}
+
+	return links
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("User-Agent", c.UserAgent)
+
+	return c.Fetcher.Do(req)
+}
+
+func linksTo(doc *html.Node, base *url.URL, target string) bool {
+	found := false
+
+	walk(doc, func(n *html.Node) bool {
+		if n.Type != html.ElementNode {
+			return true
+		}
+
+		for _, name := range []string{"href", "src"} {
+			value, ok := attributeValue(n, name)
+
+			if !ok {
+				continue
+			}
+
+			if resolved, err := resolve(base, value); err == nil && resolved == target {
+				found = true
+				return false
+			}
+		}
+
+		return true
+	})
+
+	return found
+}
+
+// endpointFromLinkHeader extracts the URL from a Link header such as
+// `<https://example.com/webmention>; rel="webmention"`.
+func endpointFromLinkHeader(header string) (string, bool) {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		target := strings.TrimSpace(parts[0])
+
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+
+			if !strings.HasPrefix(strings.ToLower(param), "rel=") {
+				continue
+			}
+
+			if hasToken(strings.Trim(param[4:], "\""), "webmention") {
+				return target[1 : len(target)-1], true
+			}
+		}
+	}
+
+	return "", false
+}
+
+func resolve(base *url.URL, reference string) (string, error) {
+	ref, err := url.Parse(strings.TrimSpace(reference))
+
+	if err != nil {
+		return "", err
+	}
+
+	resolved := base.ResolveReference(ref)
+	resolved.Fragment = ""
+
+	return resolved.String(), nil
+}
+
+func hasToken(list, token string) bool {
+	for _, item := range strings.Fields(list) {
+		if strings.EqualFold(item, token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func attribute(n *html.Node, name string) string {
+	value, _ := attributeValue(n, name)
+	return value
+}
+
+func attributeValue(n *html.Node, name string) (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Key == name {
+			return attr.Val, true
+		}
+	}
+
+	return "", false
+}
+
+// walk visits n and its descendants in document order until visit returns
+// false.
+func walk(n *html.Node, visit func(n *html.Node) bool) bool {
+	if !visit(n) {
+		return false
+	}
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if !walk(child, visit) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,203 @@
+package webmention
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakePage struct {
+	status  int
+	headers map[string]string
+	body    string
+}
+
+// fakeFetcher serves canned pages and records the requests made to it, so
+// that tests never touch the network.
+type fakeFetcher struct {
+	pages    map[string]fakePage
+	requests []*http.Request
+	forms    []url.Values
+}
+
+func (f *fakeFetcher) Do(req *http.Request) (*http.Response, error) {
+	f.requests = append(f.requests, req)
+
+	if req.Method == "POST" {
+		req.ParseForm()
+		f.forms = append(f.forms, req.PostForm)
+	}
+
+	page, ok := f.pages[req.URL.String()]
+
+	if !ok {
+		return nil, errors.New("Unknown URL " + req.URL.String())
+	}
+
+	resp := &http.Response{
+		StatusCode: page.status,
+		Status:     http.StatusText(page.status),
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader(page.body)),
+		Request:    req,
+	}
+
+	for key, value := range page.headers {
+		resp.Header.Set(key, value)
+	}
+
+	return resp, nil
+}
+
+var htmlHeaders = map[string]string{"Content-Type": "text/html; charset=utf-8"}
+
+func TestDiscoverEndpoint(t *testing.T) {
+	f := &fakeFetcher{pages: map[string]fakePage{
+		"http://a.example/post": {200, map[string]string{"Link": `<https://a.example/other>; rel="other", </mention>; rel="webmention"`}, ""},
+		"http://b.example/post": {200, htmlHeaders, `<html><head><link rel="stylesheet" href="/s.css"><link rel="webmention" href="endpoint"></head></html>`},
+		"http://c.example/post": {200, htmlHeaders, `<html><body><a rel="nofollow webmention" href="https://c.example/wm">x</a></body></html>`},
+		"http://d.example/post": {200, htmlHeaders, `<html><body>Nothing here</body></html>`},
+	}}
+
+	c := NewClient(f)
+
+	expected := map[string]string{
+		"http://a.example/post": "http://a.example/mention",
+		"http://b.example/post": "http://b.example/endpoint",
+		"http://c.example/post": "https://c.example/wm",
+	}
+
+	for target, endpoint := range expected {
+		found, err := c.DiscoverEndpoint(target)
+
+		if err != nil || found != endpoint {
+			t.Error("Incorrect endpoint for", target, found, err)
+		}
+	}
+
+	if _, err := c.DiscoverEndpoint("http://d.example/post"); err != ErrNoEndpoint {
+		t.Error("Found endpoint on page without one:", err)
+	}
+}
+
+func TestSend(t *testing.T) {
+	f := &fakeFetcher{pages: map[string]fakePage{
+		"http://a.example/post":    {200, map[string]string{"Link": `</mention>; rel="webmention"`}, ""},
+		"http://a.example/mention": {202, nil, ""},
+	}}
+
+	err := NewClient(f).Send("http://blog.example/posts/1", "http://a.example/post")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.forms) != 1 || f.forms[0].Get("source") != "http://blog.example/posts/1" || f.forms[0].Get("target") != "http://a.example/post" {
+		t.Error("Incorrect webmention sent:", f.forms)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	f := &fakeFetcher{pages: map[string]fakePage{
+		"http://a.example/reply": {200, htmlHeaders, `<html><body>
+			<article class="h-entry">
+				<a class="u-url" href="/reply">Permalink</a>
+				<time class="dt-published" datetime="2014-01-26T10:00:00Z">26th January</time>
+				<a class="p-author h-card" href="http://a.example/"><img class="u-photo" src="/me.png" alt="">Alice</a>
+				<div class="e-content">Great <b>post</b>! See <a href="http://blog.example/posts/1#comments">this</a>.</div>
+			</article>
+		</body></html>`},
+		"http://a.example/unrelated": {200, htmlHeaders, `<html><body><a href="http://blog.example/posts/2">x</a></body></html>`},
+		"http://a.example/deleted":   {410, nil, ""},
+	}}
+
+	c := NewClient(f)
+
+	entry, err := c.Verify("http://a.example/reply", "http://blog.example/posts/1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.Url != "http://a.example/reply" || entry.Source != "http://a.example/reply" {
+		t.Error("Incorrect entry URL:", entry.Url, entry.Source)
+	}
+
+	if entry.Content != "Great post ! See this ." {
+		t.Error("Incorrect entry content:", entry.Content)
+	}
+
+	if !entry.Published.Equal(time.Date(2014, 1, 26, 10, 0, 0, 0, time.UTC)) {
+		t.Error("Incorrect entry date:", entry.Published)
+	}
+
+	if entry.Author.Name != "Alice" || entry.Author.Url != "http://a.example/" || entry.Author.Photo != "http://a.example/me.png" {
+		t.Error("Incorrect entry author:", entry.Author)
+	}
+
+	if _, err := c.Verify("http://a.example/unrelated", "http://blog.example/posts/1"); err != ErrNoLink {
+		t.Error("Verified source without link:", err)
+	}
+
+	if _, err := c.Verify("http://a.example/deleted", "http://blog.example/posts/1"); err != ErrSourceGone {
+		t.Error("Verified deleted source:", err)
+	}
+}
+
+func TestVerifyDropsUnsafeUrls(t *testing.T) {
+	f := &fakeFetcher{pages: map[string]fakePage{
+		"http://a.example/reply": {200, htmlHeaders, `<html><body>
+			<article class="h-entry">
+				<a class="u-url" href="javascript:alert(1)">Permalink</a>
+				<a class="p-author h-card" href="javascript:alert(2)">Mallory</a>
+				<a href="http://blog.example/posts/1">this</a>
+			</article>
+		</body></html>`},
+	}}
+
+	entry, err := NewClient(f).Verify("http://a.example/reply", "http://blog.example/posts/1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.Url != "" || entry.Author.Url != "" {
+		t.Error("Unsafe URLs were kept:", entry.Url, entry.Author.Url)
+	}
+}
+
+func TestHTTPClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("private"))
+	}))
+
+	defer server.Close()
+
+	_, err := NewHTTPClient(time.Second).Get(server.URL)
+
+	if err == nil || !strings.Contains(err.Error(), ErrPrivateAddress.Error()) {
+		t.Error("Fetched a loopback address:", err)
+	}
+
+	for address, expected := range map[string]bool{"127.0.0.1": false, "10.1.2.3": false, "192.168.0.1": false, "169.254.169.254": false, "::1": false, "fe80::1": false, "0.0.0.0": false, "93.184.216.34": true, "2606:4700::1": true} {
+		if isPublicIP(net.ParseIP(address)) != expected {
+			t.Errorf("%v: expected public to be %v", address, expected)
+		}
+	}
+}
+
+func TestLinks(t *testing.T) {
+	base, _ := url.Parse("http://blog.example/posts/2014/01/26/test")
+
+	links := Links(`<p><a href="http://a.example/">A</a> <a href="/media/x.png">B</a> <a href="http://a.example/#top">C</a> <a href="mailto:x@example.com">D</a></p>`, base)
+
+	if len(links) != 2 || links[0] != "http://a.example/" || links[1] != "http://blog.example/media/x.png" {
+		t.Error("Incorrect links:", links)
+	}
+}
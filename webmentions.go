@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"github.com/ant512/gobble/akismet"
+	"github.com/ant512/gobble/webmention"
+	"html"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const webmentionQueueSize = 100
+
+type receivedWebmention struct {
+	source        string
+	target        string
+	post          *BlogPost
+	remoteAddress string
+}
+
+var webmentionClient *webmention.Client
+var webmentionQueue chan receivedWebmention
+
+// sentWebmentions maps each source and target pair to a hash of the source
+// post's HTML, so that saving a post repeatedly doesn't resend mentions
+// unless the post has changed.
+var sentWebmentions = make(map[string]string)
+var sentWebmentionsMutex sync.Mutex
+
+func startWebmentions() {
+	if !SharedConfig.Webmentions {
+		return
+	}
+
+	webmentionClient = webmention.NewClient(webmention.NewHTTPClient(30 * time.Second))
+	webmentionClient.UserAgent = "Gobble/" + version
+
+	webmentionQueue = make(chan receivedWebmention, webmentionQueueSize)
+
+	go func() {
+		for mention := range webmentionQueue {
+			processWebmention(mention)
+		}
+	}()
+
+	blog.OnPublish(sendWebmentions)
+}
+
+func receiveWebmention(w http.ResponseWriter, req *http.Request) {
+
+	if !SharedConfig.Webmentions {
+		http.NotFound(w, req)
+		return
+	}
+
+	ip := getIpAddress(req)
+
+	if allowed, retryAfter := ipRateLimiter.Allow(ip); !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(w, "Too many webmentions", http.StatusTooManyRequests)
+		return
+	}
+
+	source := req.FormValue("source")
+	target := req.FormValue("target")
+
+	if !isWebUrl(source) || !isWebUrl(target) {
+		http.Error(w, "Source and target must be http or https URLs", http.StatusBadRequest)
+		return
+	}
+
+	if source == target {
+		http.Error(w, "Source and target must be different", http.StatusBadRequest)
+		return
+	}
+
+	post := postWithPermalink(target)
+
+	if post == nil {
+		http.Error(w, "Target is not a post on this site", http.StatusBadRequest)
+		return
+	}
+
+	if !post.AllowsComments() {
+		http.Error(w, "Target does not accept comments", http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case webmentionQueue <- receivedWebmention{source, target, post, ip}:
+	default:
+		http.Error(w, "Too many webmentions are waiting to be processed", http.StatusServiceUnavailable)
+		return
+	}
+
+	log.Println("Accepted webmention from", source, "to", target)
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("Accepted"))
+}
+
+// processWebmention verifies that a mention's source really links to the post
+// and stores it as a comment.  Mentions whose source no longer links to the
+// post are removed.
+func processWebmention(mention receivedWebmention) {
+	filename := webmentionFilename(mention.source)
+	entry, err := webmentionClient.Verify(mention.source, mention.target)
+
+	if err == webmention.ErrSourceGone || err == webmention.ErrNoLink {
+		log.Println("Webmention source", mention.source, "does not link to", mention.target)
+
+		if _, err := mention.post.CommentWithFilename(filename); err == nil {
+			mention.post.DeleteComment(filename)
+		}
+
+		return
+	}
+
+	if err != nil {
+		log.Println("Could not verify webmention from", mention.source, err)
+		return
+	}
+
+	comment := newWebmentionComment(entry, filename)
+
+	comment.Metadata.IsSpam, _ = akismet.IsSpamComment(comment.Body.Markdown, SharedConfig.Address, mention.remoteAddress, "", "", comment.Metadata.Author, "", SharedConfig.AkismetAPIKey)
+
+	isNew, err := mention.post.SaveWebmention(comment)
+
+	if err != nil {
+		log.Println("Could not save webmention:", err)
+		return
+	}
+
+	if isNew {
+		notifyNewComment(mention.post, comment)
+	}
+}
+
+func newWebmentionComment(entry *webmention.Entry, filename string) *Comment {
+	author := entry.Author.Name
+	source, _ := url.Parse(entry.Source)
+
+	if len(author) == 0 {
+		author = source.Host
+	}
+
+	body := entry.Content
+
+	if len(body) == 0 {
+		body = entry.Name
+	}
+
+	if len(body) == 0 {
+		body = "Mentioned this post."
+	}
+
+	if runes := []rune(body); len(runes) > maxCommentBodyLength {
+		body = string(runes[:maxCommentBodyLength]) + "…"
+	}
+
+	c := NewComment(html.EscapeString(author), "", html.EscapeString(body), false)
+	c.Filename = filename
+	c.Metadata.Source = entry.Source
+
+	if isWebUrl(entry.Author.Url) {
+		c.Metadata.AuthorUrl = entry.Author.Url
+	}
+
+	if isWebUrl(entry.Url) {
+		c.Metadata.Source = entry.Url
+	}
+
+	if !entry.Published.IsZero() {
+		c.Metadata.Date = entry.Published
+	}
+
+	return c
+}
+
+// SaveWebmention adds a webmention to the post, replacing any earlier version
+// of the same mention.  Replacements keep the moderation status of the
+// original.  It returns true if the mention is new.
+func (b *BlogPost) SaveWebmention(comment *Comment) (bool, error) {
+	isNew := true
+
+	b.mutex.Lock()
+	for i, existing := range b.Comments {
+		if existing.Filename == comment.Filename {
+			comment.Metadata.IsSpam = existing.Metadata.IsSpam
+			b.Comments[i] = comment
+			isNew = false
+			break
+		}
+	}
+
+	if isNew {
+		b.Comments = append(b.Comments, comment)
+	}
+	b.mutex.Unlock()
+
+	return isNew, b.writeComment(comment)
+}
+
+// sendWebmentions notifies every site linked to from a post.  It is called
+// whenever a post is added or changed.
+func sendWebmentions(post *BlogPost) {
+	if post.Metadata.Date.After(time.Now()) {
+		return
+	}
+
+	source := SharedConfig.Address + "/posts/" + post.Url
+	base, err := url.Parse(source)
+
+	if err != nil {
+		log.Println("Could not send webmentions:", err)
+		return
+	}
+
+	hash := fmt.Sprintf("%x", sha1.Sum([]byte(post.Body.HTML)))
+	targets := []string{}
+
+	sentWebmentionsMutex.Lock()
+	for _, link := range webmention.Links(post.Body.HTML, base) {
+		target, err := url.Parse(link)
+
+		if err != nil || strings.EqualFold(target.Host, base.Host) {
+			continue
+		}
+
+		key := source + "\x00" + link
+
+		if sentWebmentions[key] != hash {
+			sentWebmentions[key] = hash
+			targets = append(targets, link)
+		}
+	}
+	sentWebmentionsMutex.Unlock()
+
+	go func() {
+		for _, target := range targets {
+			err := webmentionClient.Send(source, target)
+
+			if err == nil {
+				log.Println("Sent webmention from", source, "to", target)
+			} else if err != webmention.ErrNoEndpoint {
+				log.Println("Could not send webmention to", target, err)
+			}
+		}
+	}()
+}
+
+func webmentionFilename(source string) string {
+	return fmt.Sprintf("webmention-%x.md", sha1.Sum([]byte(source)))
+}
+
+func isWebUrl(s string) bool {
+	u, err := url.Parse(s)
+
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) > 0
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"github.com/ant512/gobble/webmention"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// pageFetcher serves canned HTML pages so that webmentions can be tested
+// without touching the network.
+type pageFetcher map[string]string
+
+func (f pageFetcher) Do(req *http.Request) (*http.Response, error) {
+	body, ok := f[req.URL.String()]
+
+	if !ok {
+		return nil, errors.New("Unknown URL")
+	}
+
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/html"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}
+
+	if len(body) == 0 {
+		resp.StatusCode = http.StatusGone
+	}
+
+	return resp, nil
+}
+
+func createWebmentionTestBlog(t *testing.T) (*BlogPost, string) {
+	dir, err := ioutil.TempDir("", "gobble")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	SharedConfig = &Config{Address: "http://blog.example", Webmentions: true}
+	ipRateLimiter = NewRateLimiter(0, 0, "")
+
+	p := createPost()
+	p.Filename = "test-post.md"
+	p.CommentPath = dir
+	p.Url = "2014/01/26/test-post"
+	p.Metadata.DisallowComments = false
+
+	blog = &Blog{posts: BlogPosts{p}, tags: NewTags()}
+
+	return p, dir
+}
+
+func TestReceiveWebmention(t *testing.T) {
+	_, dir := createWebmentionTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	webmentionQueue = make(chan receivedWebmention, 1)
+
+	tests := map[string]int{
+		"http://a.example/reply|http://blog.example/posts/2014/01/26/test-post":  http.StatusAccepted,
+		"http://a.example/reply|http://blog.example/posts/2014/01/26/missing":    http.StatusBadRequest,
+		"http://a.example/reply|http://other.example/posts/2014/01/26/test-post": http.StatusBadRequest,
+		"ftp://a.example/reply|http://blog.example/posts/2014/01/26/test-post":   http.StatusBadRequest,
+	}
+
+	for params, status := range tests {
+		parts := strings.Split(params, "|")
+		form := url.Values{"source": {parts[0]}, "target": {parts[1]}}
+
+		req, _ := http.NewRequest("POST", "/webmention", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = "1.2.3.4:5678"
+
+		w := httptest.NewRecorder()
+		receiveWebmention(w, req)
+
+		if w.Code != status {
+			t.Error("Incorrect status for", params, w.Code)
+		}
+	}
+
+	if len(webmentionQueue) != 1 {
+		t.Error("Webmention was not queued")
+	}
+}
+
+func TestProcessWebmention(t *testing.T) {
+	p, dir := createWebmentionTestBlog(t)
+	defer os.RemoveAll(dir)
+
+	fetcher := pageFetcher{
+		"http://a.example/reply": `<div class="h-entry"><span class="p-author">Alice</span> <p class="e-content">Nice <a href="http://blog.example/posts/2014/01/26/test-post">post</a></p></div>`,
+	}
+
+	webmentionClient = webmention.NewClient(fetcher)
+
+	mention := receivedWebmention{"http://a.example/reply", "http://blog.example/posts/2014/01/26/test-post", p, "1.2.3.4"}
+	processWebmention(mention)
+
+	comment, err := p.CommentWithFilename(webmentionFilename(mention.source))
+
+	if err != nil {
+		t.Fatal("Webmention was not stored")
+	}
+
+	if comment.Metadata.Author != "Alice" || comment.Metadata.Source != "http://a.example/reply" || comment.Body.Markdown != "Nice post" {
+		t.Error("Incorrect webmention comment:", comment.Metadata, comment.Body.Markdown)
+	}
+
+	loaded, err := LoadComments(p.commentDirectory())
+
+	if err != nil || len(loaded) != 1 || loaded[0].Metadata.Source != "http://a.example/reply" {
+		t.Error("Webmention file was not written")
+	}
+
+	commentCount := len(p.Comments)
+	processWebmention(mention)
+
+	if len(p.Comments) != commentCount {
+		t.Error("Repeated webmention was duplicated")
+	}
+
+	fetcher["http://a.example/reply"] = ""
+	processWebmention(mention)
+
+	if _, err := p.CommentWithFilename(webmentionFilename(mention.source)); err == nil {
+		t.Error("Webmention from deleted source was not removed")
+	}
+}
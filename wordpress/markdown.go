@@ -0,0 +1,426 @@
+package wordpress
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/net/html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	spacePattern     = regexp.MustCompile(`[ \t\r\f]+`)
+	paragraphPattern = regexp.MustCompile(`\s*\n\s*\n\s*`)
+	lineBreakPattern = regexp.MustCompile(` ?\n ?`)
+	entityPattern    = regexp.MustCompile(`&(#?[0-9A-Za-z]+;)`)
+	lineStartPattern = regexp.MustCompile(`^(#|>|[-+] |\d+\. )`)
+	captionPattern   = regexp.MustCompile(`\[/?caption[^\]]*\]`)
+	codePattern      = regexp.MustCompile(`(?s)\[(code|sourcecode)([^\]]*)\](.*?)\[/(?:code|sourcecode)\]`)
+	languagePattern  = regexp.MustCompile(`(?:^|\s)(?:language|lang)[-:]([\w+#-]+)|brush:\s*([\w+#-]+)|(?:language|lang)="([\w+#-]+)"`)
+)
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`", "*", `\*`, "[", `\[`, "]", `\]`, "<", "&lt;")
+
+// underscorePattern finds underscores that could start or end emphasis.
+// Underscores within words, such as in variable names, are left alone.
+var underscorePattern = regexp.MustCompile(`(^|[^\pL\pN])_|_([^\pL\pN]|$)`)
+
+// blockElements contain paragraphs of text.
+var blockElements = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "header": true,
+	"footer": true, "aside": true, "nav": true, "main": true, "figure": true,
+	"figcaption": true, "center": true, "address": true,
+}
+
+// rawElements have no Markdown equivalent, so are kept as HTML.
+var rawElements = map[string]bool{
+	"table": true, "iframe": true, "video": true, "audio": true, "object": true,
+	"embed": true, "script": true, "style": true, "form": true, "dl": true,
+	"sup": true, "sub": true,
+}
+
+var inlineElements = map[string]bool{
+	"a": true, "strong": true, "b": true, "em": true, "i": true, "cite": true,
+	"del": true, "s": true, "strike": true, "code": true, "tt": true,
+	"kbd": true, "span": true, "sup": true, "sub": true, "abbr": true,
+	"small": true, "big": true, "u": true, "font": true, "mark": true,
+	"img": true, "q": true,
+}
+
+// Markdown converts the HTML of a WordPress post or comment to Markdown.
+// WordPress stores content with blank lines between paragraphs and single
+// line breaks rather than <p> and <br> tags, and both are preserved.
+// Captions and SyntaxHighlighter code shortcodes are converted; other
+// shortcodes are left as they are.
+func Markdown(content string) string {
+	content = captionPattern.ReplaceAllString(content, "")
+	content = codePattern.ReplaceAllStringFunc(content, func(shortcode string) string {
+		match := codePattern.FindStringSubmatch(shortcode)
+		return "<pre class=\"language-" + html.EscapeString(language(match[2])) + "\">" + html.EscapeString(match[3]) + "</pre>"
+	})
+
+	doc, err := html.Parse(strings.NewReader(content))
+
+	if err != nil {
+		return content
+	}
+
+	return tidy(convertChildren(doc))
+}
+
+func convertChildren(n *html.Node) string {
+	var b strings.Builder
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(convert(child))
+	}
+
+	return b.String()
+}
+
+func convert(n *html.Node) string {
+	switch n.Type {
+	case html.DocumentNode:
+		return convertChildren(n)
+	case html.TextNode:
+		return convertText(n)
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	switch {
+	case blockElements[n.Data]:
+		return block(convertChildren(n))
+	case rawElements[n.Data]:
+		var b bytes.Buffer
+		html.Render(&b, n)
+
+		if inlineElements[n.Data] {
+			return b.String()
+		}
+
+		return block(b.String())
+	}
+
+	switch n.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level, _ := strconv.Atoi(n.Data[1:])
+		return block(strings.Repeat("#", level) + " " + strings.Join(strings.Fields(convertChildren(n)), " "))
+	case "br":
+		return "  \n"
+	case "hr":
+		return block("* * *")
+	case "strong", "b":
+		return wrap("**", convertChildren(n))
+	case "em", "i", "cite":
+		return wrap("*", convertChildren(n))
+	case "del", "s", "strike":
+		return wrap("~~", convertChildren(n))
+	case "code", "tt", "kbd":
+		return inlineCode(textContent(n))
+	case "pre":
+		return convertPre(n)
+	case "blockquote":
+		return block(prefixLines(tidy(convertChildren(n)), "> ", "> "))
+	case "ul", "ol":
+		return convertList(n)
+	case "a":
+		return convertLink(n)
+	case "img":
+		return convertImage(n)
+	}
+
+	return convertChildren(n)
+}
+
+func convertText(n *html.Node) string {
+	text := escape(entityPattern.ReplaceAllString(n.Data, "&amp;$1"))
+	text = spacePattern.ReplaceAllString(text, " ")
+
+	paragraphs := paragraphPattern.Split(text, -1)
+
+	for i := range paragraphs {
+		lines := strings.Split(lineBreakPattern.ReplaceAllString(paragraphs[i], "\n"), "\n")
+
+		for j := range lines {
+			if j > 0 || i > 0 || startsLine(n) {
+				lines[j] = lineStartPattern.ReplaceAllStringFunc(lines[j], func(marker string) string {
+					if strings.HasSuffix(marker, ". ") {
+						return strings.TrimSuffix(marker, ". ") + `\. `
+					}
+
+					return `\` + marker
+				})
+			}
+		}
+
+		paragraphs[i] = strings.Join(lines, "  \n")
+	}
+
+	return strings.Join(paragraphs, "\n\n")
+}
+
+func escape(text string) string {
+	text = markdownEscaper.Replace(text)
+
+	return underscorePattern.ReplaceAllStringFunc(text, func(match string) string {
+		return strings.Replace(match, "_", `\_`, 1)
+	})
+}
+
+// startsLine returns true if a text node will be at the start of a line, so
+// that characters Markdown would treat as a heading or list must be escaped.
+func startsLine(n *html.Node) bool {
+	if n.PrevSibling == nil {
+		return n.Parent == nil || !inlineElements[n.Parent.Data]
+	}
+
+	return n.PrevSibling.Type == html.ElementNode && (n.PrevSibling.Data == "br" || !inlineElements[n.PrevSibling.Data])
+}
+
+func convertPre(n *html.Node) string {
+	code := strings.Trim(textContent(n), "\n")
+	lang := language(attribute(n, "class"))
+
+	if child := n.FirstChild; len(lang) == 0 && child != nil && child.Type == html.ElementNode && child.Data == "code" {
+		lang = language(attribute(child, "class"))
+	}
+
+	fence := "```"
+
+	if strings.Contains(code, fence) {
+		fence = "~~~"
+	}
+
+	return block(fence + lang + "\n" + code + "\n" + fence)
+}
+
+// language finds the language of a code block in the class names used by
+// highlighters and SyntaxHighlighter's shortcode attributes.
+func language(class string) string {
+	match := languagePattern.FindStringSubmatch(class)
+
+	for i := 1; match != nil && i < len(match); i++ {
+		if len(match[i]) > 0 {
+			return strings.ToLower(match[i])
+		}
+	}
+
+	return ""
+}
+
+func convertList(n *html.Node) string {
+	items := []string{}
+	separator := "\n"
+	number := 1
+
+	if start, err := strconv.Atoi(attribute(n, "start")); err == nil {
+		number = start
+	}
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+
+		// Nested lists are sometimes placed outside of an item, so they are
+		// attached to the item before them.
+		if child.Data != "li" {
+			if len(items) > 0 {
+				items[len(items)-1] += "\n" + prefixLines(tidy(convert(child)), "    ", "    ")
+			}
+
+			continue
+		}
+
+		content := tidy(convertChildren(child))
+
+		if hasChild(child, "p") || hasDescendant(child, "pre") {
+			separator = "\n\n"
+		} else {
+			content = strings.Replace(content, "\n\n", "\n", -1)
+		}
+
+		marker := "- "
+
+		if n.Data == "ol" {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+
+		items = append(items, prefixLines(content, marker, "    "))
+	}
+
+	return block(strings.Join(items, separator))
+}
+
+func convertLink(n *html.Node) string {
+	content := convertChildren(n)
+	href := attribute(n, "href")
+
+	if len(href) == 0 || len(strings.TrimSpace(content)) == 0 || strings.Contains(strings.TrimSpace(content), "\n\n") {
+		return content
+	}
+
+	return "[" + strings.TrimSpace(content) + "](" + destination(href, attribute(n, "title")) + ")"
+}
+
+func convertImage(n *html.Node) string {
+	src := attribute(n, "src")
+
+	if len(src) == 0 {
+		return ""
+	}
+
+	return "![" + escape(attribute(n, "alt")) + "](" + destination(src, attribute(n, "title")) + ")"
+}
+
+func destination(link, title string) string {
+	link = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(strings.TrimSpace(link))
+
+	if len(title) > 0 {
+		link += " \"" + strings.Replace(title, "\"", "\\\"", -1) + "\""
+	}
+
+	return link
+}
+
+func inlineCode(code string) string {
+	if len(code) == 0 {
+		return ""
+	}
+
+	if strings.Contains(code, "`") {
+		return "`` " + code + " ``"
+	}
+
+	return "`" + code + "`"
+}
+
+// wrap surrounds text with an emphasis marker, keeping any surrounding spaces
+// outside of the marker.
+func wrap(marker, text string) string {
+	trimmed := strings.TrimSpace(text)
+
+	if len(trimmed) == 0 {
+		return text
+	}
+
+	start := strings.Index(text, trimmed)
+
+	return text[:start] + marker + trimmed + marker + text[start+len(trimmed):]
+}
+
+func block(text string) string {
+	return "\n\n" + strings.TrimSpace(text) + "\n\n"
+}
+
+// prefixLines adds a prefix to each line of text, such as a list marker or
+// blockquote marker.  Blank lines have the prefix's trailing spaces removed.
+func prefixLines(text, first, rest string) string {
+	lines := strings.Split(text, "\n")
+
+	for i := range lines {
+		prefix := rest
+
+		if i == 0 {
+			prefix = first
+		}
+
+		if len(lines[i]) == 0 {
+			lines[i] = strings.TrimRight(prefix, " ")
+		} else {
+			lines[i] = prefix + lines[i]
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// tidy removes leading, trailing and repeated blank lines from Markdown,
+// leaving the contents of fenced code blocks alone.
+func tidy(text string) string {
+	lines := []string{}
+	fence := ""
+
+	for _, line := range strings.Split(text, "\n") {
+		marker := strings.TrimLeft(line, " >")
+
+		if len(fence) > 0 {
+			lines = append(lines, line)
+
+			if strings.HasPrefix(marker, fence) {
+				fence = ""
+			}
+
+			continue
+		}
+
+		if strings.HasPrefix(marker, "```") || strings.HasPrefix(marker, "~~~") {
+			fence = marker[:3]
+		}
+
+		if len(strings.TrimSpace(line)) == 0 {
+			if len(lines) > 0 && len(lines[len(lines)-1]) > 0 {
+				lines[len(lines)-1] = strings.TrimRight(lines[len(lines)-1], " \t")
+				lines = append(lines, "")
+			}
+
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.TrimRight(strings.Join(lines, "\n"), " \t\n")
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+
+	if n.Type == html.ElementNode && n.Data == "br" {
+		return "\n"
+	}
+
+	var b strings.Builder
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(textContent(child))
+	}
+
+	return b.String()
+}
+
+func attribute(n *html.Node, name string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == name {
+			return attr.Val
+		}
+	}
+
+	return ""
+}
+
+func hasChild(n *html.Node, name string) bool {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.Data == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasDescendant(n *html.Node, name string) bool {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if (child.Type == html.ElementNode && child.Data == name) || hasDescendant(child, name) {
+			return true
+		}
+	}
+
+	return false
+}
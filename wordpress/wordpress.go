@@ -0,0 +1,256 @@
+package wordpress
+
+import (
+	"encoding/xml"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const dateLayout = "2006-01-02 15:04:05"
+
+// Export is a WordPress eXtended RSS (WXR) file, as created by WordPress's
+// Tools > Export page.
+type Export struct {
+	Title       string `xml:"channel>title"`
+	Link        string `xml:"channel>link"`
+	BaseSiteUrl string `xml:"channel>base_site_url"`
+	BaseBlogUrl string `xml:"channel>base_blog_url"`
+	Items       []Item `xml:"channel>item"`
+}
+
+// Item is a post, page, attachment or other WordPress content type.
+type Item struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	Content       string     `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	PostId        int        `xml:"post_id"`
+	PostDate      string     `xml:"post_date"`
+	PostDateGmt   string     `xml:"post_date_gmt"`
+	PostName      string     `xml:"post_name"`
+	PostType      string     `xml:"post_type"`
+	Status        string     `xml:"status"`
+	CommentStatus string     `xml:"comment_status"`
+	AttachmentUrl string     `xml:"attachment_url"`
+	Categories    []Category `xml:"category"`
+	Comments      []Comment  `xml:"comment"`
+}
+
+// Category is a category or tag attached to an item.  Its domain is
+// "category" for categories and "post_tag" for tags.
+type Category struct {
+	Domain   string `xml:"domain,attr"`
+	Nicename string `xml:"nicename,attr"`
+	Name     string `xml:",chardata"`
+}
+
+type Comment struct {
+	Id          int    `xml:"comment_id"`
+	Author      string `xml:"comment_author"`
+	AuthorEmail string `xml:"comment_author_email"`
+	AuthorUrl   string `xml:"comment_author_url"`
+	LocalDate   string `xml:"comment_date"`
+	DateGmt     string `xml:"comment_date_gmt"`
+	Content     string `xml:"comment_content"`
+	Approved    string `xml:"comment_approved"`
+	Type        string `xml:"comment_type"`
+}
+
+// Parse reads a WXR file.  Elements are matched without their namespaces so
+// that every version of the format can be read.
+func Parse(r io.Reader) (*Export, error) {
+	export := &Export{}
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+
+	err := decoder.Decode(export)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// Posts returns the published and scheduled posts.  Drafts, pages and other
+// content types are left out.
+func (e *Export) Posts() []Item {
+	posts := []Item{}
+
+	for _, item := range e.Items {
+		if item.PostType == "post" && (item.Status == "publish" || item.Status == "future") {
+			posts = append(posts, item)
+		}
+	}
+
+	return posts
+}
+
+func (e *Export) Attachments() []Item {
+	attachments := []Item{}
+
+	for _, item := range e.Items {
+		if item.PostType == "attachment" && len(item.AttachmentUrl) > 0 {
+			attachments = append(attachments, item)
+		}
+	}
+
+	return attachments
+}
+
+// uploadsPattern matches links to the blog's uploads directory, either
+// relative to the site root or on the blog's own host.  Links to uploads on
+// other WordPress sites are left alone.
+func (e *Export) uploadsPattern() *regexp.Regexp {
+	hosts := []string{}
+
+	for _, address := range []string{e.BaseSiteUrl, e.BaseBlogUrl, e.Link} {
+		u, err := url.Parse(address)
+
+		if err != nil || len(u.Host) == 0 {
+			continue
+		}
+
+		host := regexp.QuoteMeta(strings.TrimPrefix(u.Host, "www."))
+		directory := regexp.QuoteMeta(strings.TrimSuffix(u.Path, "/"))
+		hosts = append(hosts, `(?:https?:)?//(?:www\.)?`+host+directory)
+	}
+
+	// \B only allows relative links, as the slash must not follow a host name.
+	hosts = append(hosts, `\B`)
+
+	return regexp.MustCompile(`(?:` + strings.Join(hosts, "|") + `)/wp-content/uploads/([^\s"'()<>\]]+)`)
+}
+
+// UploadPath returns the path of a link within the uploads directory, such as
+// "2014/01/photo.jpg".  The second return value is false if the link doesn't
+// point at the blog's uploads.
+func (e *Export) UploadPath(link string) (string, bool) {
+	match := e.uploadsPattern().FindStringSubmatch(link)
+
+	if match == nil {
+		return "", false
+	}
+
+	return cleanUploadPath(match[1])
+}
+
+// RewriteUploads replaces links to the blog's uploads directory with links to
+// the same paths under prefix, and returns the uploads that were linked to.
+func (e *Export) RewriteUploads(text, prefix string) (string, []string) {
+	uploads := []string{}
+	pattern := e.uploadsPattern()
+
+	text = pattern.ReplaceAllStringFunc(text, func(link string) string {
+		upload, ok := cleanUploadPath(pattern.FindStringSubmatch(link)[1])
+
+		if !ok {
+			return link
+		}
+
+		uploads = append(uploads, upload)
+
+		return strings.TrimSuffix(prefix, "/") + "/" + upload
+	})
+
+	return text, uploads
+}
+
+func cleanUploadPath(upload string) (string, bool) {
+	if unescaped, err := url.PathUnescape(upload); err == nil {
+		upload = unescaped
+	}
+
+	upload = path.Clean("/" + upload)[1:]
+
+	if len(upload) == 0 {
+		return "", false
+	}
+
+	return upload, true
+}
+
+// Date returns the item's publish date in UTC.  Drafts don't have a GMT date
+// ("0000-00-00 00:00:00"), so their local date is used instead.
+func (i *Item) Date() time.Time {
+	return parseDate(i.PostDateGmt, i.PostDate)
+}
+
+// Tags returns the names of the item's tags and categories, except for the
+// default "Uncategorized" category.
+func (i *Item) Tags() []string {
+	tags := []string{}
+
+	for _, category := range i.Categories {
+		if category.Domain != "post_tag" && category.Domain != "category" {
+			continue
+		}
+
+		if category.Domain == "category" && category.Nicename == "uncategorized" {
+			continue
+		}
+
+		name := strings.TrimSpace(category.Name)
+
+		if len(name) > 0 && !contains(tags, name) {
+			tags = append(tags, name)
+		}
+	}
+
+	return tags
+}
+
+// Name returns the item's slug, decoding any non-ASCII characters.
+func (i *Item) Name() string {
+	name, err := url.PathUnescape(i.PostName)
+
+	if err != nil {
+		return i.PostName
+	}
+
+	return name
+}
+
+func (c *Comment) Date() time.Time {
+	return parseDate(c.DateGmt, c.LocalDate)
+}
+
+func (c *Comment) IsSpam() bool {
+	return c.Approved == "spam"
+}
+
+func (c *Comment) IsPending() bool {
+	return c.Approved == "0"
+}
+
+func (c *Comment) IsTrash() bool {
+	return c.Approved == "trash" || c.Approved == "post-trashed"
+}
+
+// IsPing returns true for pingbacks and trackbacks.
+func (c *Comment) IsPing() bool {
+	return c.Type == "pingback" || c.Type == "trackback"
+}
+
+func parseDate(gmt, local string) time.Time {
+	if t, err := time.Parse(dateLayout, strings.TrimSpace(gmt)); err == nil {
+		return t
+	}
+
+	t, _ := time.Parse(dateLayout, strings.TrimSpace(local))
+
+	return t
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
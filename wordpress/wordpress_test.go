@@ -0,0 +1,183 @@
+package wordpress
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testExport = `<?xml version="1.0" encoding="UTF-8" ?>
+<rss version="2.0"
+	xmlns:excerpt="http://wordpress.org/export/1.2/excerpt/"
+	xmlns:content="http://purl.org/rss/1.0/modules/content/"
+	xmlns:wp="http://wordpress.org/export/1.2/">
+<channel>
+	<title>Example</title>
+	<link>http://example.com</link>
+	<wp:base_site_url>http://example.com</wp:base_site_url>
+	<wp:base_blog_url>http://example.com</wp:base_blog_url>
+	<item>
+		<title>Hello &amp;amp; Welcome</title>
+		<link>http://example.com/2014/01/hello/</link>
+		<content:encoded><![CDATA[First paragraph with <a href="http://example.com/wp-content/uploads/2014/01/photo.jpg">a photo</a>.
+
+Second]]></content:encoded>
+		<excerpt:encoded><![CDATA[Not the content]]></excerpt:encoded>
+		<wp:post_id>42</wp:post_id>
+		<wp:post_date>2014-01-26 12:00:00</wp:post_date>
+		<wp:post_date_gmt>2014-01-26 10:00:00</wp:post_date_gmt>
+		<wp:comment_status>closed</wp:comment_status>
+		<wp:post_name>hello-%e4%b8%96%e7%95%8c</wp:post_name>
+		<wp:status>publish</wp:status>
+		<wp:post_type>post</wp:post_type>
+		<category domain="category" nicename="uncategorized"><![CDATA[Uncategorized]]></category>
+		<category domain="category" nicename="news"><![CDATA[News]]></category>
+		<category domain="post_tag" nicename="go"><![CDATA[Go]]></category>
+		<category domain="post_tag" nicename="news"><![CDATA[News]]></category>
+		<wp:comment>
+			<wp:comment_id>7</wp:comment_id>
+			<wp:comment_author><![CDATA[Joe]]></wp:comment_author>
+			<wp:comment_author_email>joe@example.com</wp:comment_author_email>
+			<wp:comment_author_url>http://joe.example.com</wp:comment_author_url>
+			<wp:comment_date>2014-01-27 12:00:00</wp:comment_date>
+			<wp:comment_date_gmt>2014-01-27 10:00:00</wp:comment_date_gmt>
+			<wp:comment_content><![CDATA[Nice]]></wp:comment_content>
+			<wp:comment_approved>spam</wp:comment_approved>
+			<wp:comment_type></wp:comment_type>
+		</wp:comment>
+	</item>
+	<item>
+		<title>Draft</title>
+		<wp:post_id>43</wp:post_id>
+		<wp:post_date>2014-02-01 09:00:00</wp:post_date>
+		<wp:post_date_gmt>0000-00-00 00:00:00</wp:post_date_gmt>
+		<wp:status>draft</wp:status>
+		<wp:post_type>post</wp:post_type>
+	</item>
+	<item>
+		<title>photo</title>
+		<wp:post_id>44</wp:post_id>
+		<wp:post_type>attachment</wp:post_type>
+		<wp:status>inherit</wp:status>
+		<wp:attachment_url>http://example.com/wp-content/uploads/2014/01/photo.jpg</wp:attachment_url>
+	</item>
+</channel>
+</rss>`
+
+func TestParse(t *testing.T) {
+	export, err := Parse(strings.NewReader(testExport))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(export.Items) != 3 || export.BaseSiteUrl != "http://example.com" {
+		t.Fatalf("Unexpected export %+v", export)
+	}
+
+	posts := export.Posts()
+
+	if len(posts) != 1 {
+		t.Fatalf("Expected 1 post, got %v", len(posts))
+	}
+
+	post := posts[0]
+
+	if post.PostId != 42 || post.Title != "Hello &amp; Welcome" || post.Name() != "hello-世界" || post.CommentStatus != "closed" {
+		t.Errorf("Unexpected post %+v", post)
+	}
+
+	if !strings.HasPrefix(post.Content, "First paragraph") {
+		t.Errorf("Unexpected content %q", post.Content)
+	}
+
+	if !post.Date().Equal(time.Date(2014, 1, 26, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected date %v", post.Date())
+	}
+
+	if tags := strings.Join(post.Tags(), ","); tags != "News,Go" {
+		t.Errorf("Unexpected tags %v", tags)
+	}
+
+	if len(post.Comments) != 1 || post.Comments[0].Author != "Joe" || !post.Comments[0].IsSpam() || post.Comments[0].Id != 7 {
+		t.Errorf("Unexpected comments %+v", post.Comments)
+	}
+
+	if !post.Comments[0].Date().Equal(time.Date(2014, 1, 27, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected comment date %v", post.Comments[0].Date())
+	}
+
+	if draft := export.Items[1]; !draft.Date().Equal(time.Date(2014, 2, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("Draft did not fall back to its local date: %v", draft.Date())
+	}
+
+	if attachments := export.Attachments(); len(attachments) != 1 || attachments[0].PostId != 44 {
+		t.Errorf("Unexpected attachments %+v", attachments)
+	}
+}
+
+func TestRewriteUploads(t *testing.T) {
+	export := &Export{BaseSiteUrl: "http://example.com", BaseBlogUrl: "http://example.com"}
+
+	text := `![A](http://example.com/wp-content/uploads/2014/01/a-300x200.jpg)
+![B](https://www.example.com/wp-content/uploads/2014/01/b%20c.png "B")
+![C](/wp-content/uploads/2014/02/c.gif)
+![D](http://other.example/wp-content/uploads/2014/01/d.jpg)
+[E](//example.com/wp-content/uploads/../../etc/passwd)`
+
+	expected := `![A](/media/2014/01/a-300x200.jpg)
+![B](/media/2014/01/b c.png "B")
+![C](/media/2014/02/c.gif)
+![D](http://other.example/wp-content/uploads/2014/01/d.jpg)
+[E](/media/etc/passwd)`
+
+	rewritten, uploads := export.RewriteUploads(text, "/media")
+
+	if rewritten != expected {
+		t.Errorf("Expected:\n%v\nGot:\n%v", expected, rewritten)
+	}
+
+	if strings.Join(uploads, ",") != "2014/01/a-300x200.jpg,2014/01/b c.png,2014/02/c.gif,etc/passwd" {
+		t.Errorf("Unexpected uploads %v", uploads)
+	}
+
+	if upload, ok := export.UploadPath("http://example.com/wp-content/uploads/2014/01/a.jpg"); !ok || upload != "2014/01/a.jpg" {
+		t.Errorf("Unexpected upload path %v", upload)
+	}
+
+	if _, ok := export.UploadPath("http://other.example/wp-content/uploads/2014/01/a.jpg"); ok {
+		t.Error("Upload on another site was accepted")
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	tests := []struct {
+		html     string
+		markdown string
+	}{
+		{"First line\nsecond line\n\nNew paragraph", "First line  \nsecond line\n\nNew paragraph"},
+		{"<p>One</p>\n<p>Two <strong>bold</strong> and <em>italic </em>text</p>", "One\n\nTwo **bold** and *italic* text"},
+		{"<h2>Title</h2>\n<p>Text</p>", "## Title\n\nText"},
+		{`<a href="http://example.com/a b" title="T">link</a>`, `[link](http://example.com/a%20b "T")`},
+		{`<a href="/x"><img src="/a.jpg" alt="A [1]" /></a>`, `[![A \[1\]](/a.jpg)](/x)`},
+		{"<ul>\n<li>One</li>\n<li>Two\n<ul><li>Nested</li></ul></li>\n</ul>", "- One\n- Two\n    - Nested"},
+		{"<ol start=\"3\"><li>Three</li><li>Four</li></ol>", "3. Three\n4. Four"},
+		{"<blockquote><p>Quote</p><p>More</p></blockquote>", "> Quote\n>\n> More"},
+		{"<pre class=\"brush: go; title: ;\">func main() {\n\n\tx := 1 &lt; 2\n}</pre>", "```go\nfunc main() {\n\n\tx := 1 < 2\n}\n```"},
+		{"<pre><code class=\"language-js\">a = `b`;\n```</code></pre>", "~~~js\na = `b`;\n```\n~~~"},
+		{"[code lang=\"python\"]if a < b:\n    pass[/code]", "```python\nif a < b:\n    pass\n```"},
+		{"Use <code>x_y</code> and *stars* or _under_ snake_case", "Use `x_y` and \\*stars\\* or \\_under\\_ snake_case"},
+		{"1. Not a list\n# Not a heading", "1\\. Not a list  \n\\# Not a heading"},
+		{"Tom &amp; Jerry &amp;copy; &lt;b&gt;", "Tom & Jerry &amp;copy; &lt;b>"},
+		{`[caption id="a" align="alignleft"]<img src="/a.jpg" alt="A" /> The caption[/caption]`, "![A](/a.jpg) The caption"},
+		{"Before<hr />After", "Before\n\n* * *\n\nAfter"},
+		{"<table><tr><td>Cell</td></tr></table>", "<table><tbody><tr><td>Cell</td></tr></tbody></table>"},
+		{"E = mc<sup>2</sup>", "E = mc<sup>2</sup>"},
+	}
+
+	for _, test := range tests {
+		if markdown := Markdown(test.html); markdown != test.markdown {
+			t.Errorf("Converting %q\nExpected: %q\nGot:      %q", test.html, test.markdown, markdown)
+		}
+	}
+}
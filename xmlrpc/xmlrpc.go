@@ -0,0 +1,309 @@
+package xmlrpc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dateTimeLayout = "20060102T15:04:05"
+
+var dateTimeLayouts = []string{
+	dateTimeLayout,
+	"20060102T15:04:05Z",
+	"20060102T15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+}
+
+// MethodCall is a decoded XML-RPC request.  Parameters are decoded into Go
+// values: int, bool, string, float64, time.Time, []byte, []interface{} for
+// arrays and map[string]interface{} for structs.
+type MethodCall struct {
+	Method string
+	Params []interface{}
+}
+
+// Fault is an XML-RPC error response.
+type Fault struct {
+	Code    int
+	Message string
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("XML-RPC fault %v: %v", f.Code, f.Message)
+}
+
+// node is a generic XML element, used because XML-RPC values are easier to
+// interpret by walking the tree than by unmarshalling into structs.
+type node struct {
+	XMLName xml.Name
+	Content string `xml:",chardata"`
+	Nodes   []node `xml:",any"`
+}
+
+// ParseMethodCall decodes an XML-RPC methodCall document.
+func ParseMethodCall(r io.Reader) (*MethodCall, error) {
+	root := node{}
+	err := xml.NewDecoder(r).Decode(&root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if root.XMLName.Local != "methodCall" {
+		return nil, errors.New("Document is not an XML-RPC method call")
+	}
+
+	call := &MethodCall{Params: []interface{}{}}
+
+	for _, child := range root.Nodes {
+		switch child.XMLName.Local {
+		case "methodName":
+			call.Method = strings.TrimSpace(child.Content)
+		case "params":
+			for _, param := range child.Nodes {
+				if param.XMLName.Local != "param" {
+					continue
+				}
+
+				v, ok := firstChild(param, "value")
+
+				if !ok {
+					return nil, errors.New("Parameter has no value")
+				}
+
+				value, err := decodeValue(v)
+
+				if err != nil {
+					return nil, err
+				}
+
+				call.Params = append(call.Params, value)
+			}
+		}
+	}
+
+	if len(call.Method) == 0 {
+		return nil, errors.New("Method call has no method name")
+	}
+
+	return call, nil
+}
+
+// WriteResponse writes a methodResponse document containing a single value.
+func WriteResponse(w io.Writer, value interface{}) error {
+	buffer := &bytes.Buffer{}
+	buffer.WriteString(xml.Header)
+	buffer.WriteString("<methodResponse><params><param>")
+
+	err := encodeValue(buffer, value)
+
+	if err != nil {
+		return err
+	}
+
+	buffer.WriteString("</param></params></methodResponse>\n")
+
+	_, err = buffer.WriteTo(w)
+
+	return err
+}
+
+// WriteFault writes a methodResponse document containing a fault.
+func WriteFault(w io.Writer, fault *Fault) error {
+	buffer := &bytes.Buffer{}
+	buffer.WriteString(xml.Header)
+	buffer.WriteString("<methodResponse><fault>")
+
+	encodeValue(buffer, map[string]interface{}{
+		"faultCode":   fault.Code,
+		"faultString": fault.Message,
+	})
+
+	buffer.WriteString("</fault></methodResponse>\n")
+
+	_, err := buffer.WriteTo(w)
+
+	return err
+}
+
+func decodeValue(v node) (interface{}, error) {
+
+	// A value without a type element is a string.
+	if len(v.Nodes) == 0 {
+		return v.Content, nil
+	}
+
+	typed := v.Nodes[0]
+	content := strings.TrimSpace(typed.Content)
+
+	switch typed.XMLName.Local {
+	case "int", "i4", "i8":
+		return strconv.Atoi(content)
+	case "boolean":
+		return content == "1" || content == "true", nil
+	case "string":
+		return typed.Content, nil
+	case "double":
+		return strconv.ParseFloat(content, 64)
+	case "dateTime.iso8601":
+		for _, layout := range dateTimeLayouts {
+			if t, err := time.Parse(layout, content); err == nil {
+				return t, nil
+			}
+		}
+
+		return nil, errors.New("Invalid date: " + content)
+	case "base64":
+		return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(content), ""))
+	case "nil":
+		return nil, nil
+	case "array":
+		values := []interface{}{}
+		data, _ := firstChild(typed, "data")
+
+		for _, child := range data.Nodes {
+			if child.XMLName.Local != "value" {
+				continue
+			}
+
+			value, err := decodeValue(child)
+
+			if err != nil {
+				return nil, err
+			}
+
+			values = append(values, value)
+		}
+
+		return values, nil
+	case "struct":
+		members := make(map[string]interface{})
+
+		for _, member := range typed.Nodes {
+			if member.XMLName.Local != "member" {
+				continue
+			}
+
+			name, _ := firstChild(member, "name")
+			v, ok := firstChild(member, "value")
+
+			if !ok {
+				continue
+			}
+
+			value, err := decodeValue(v)
+
+			if err != nil {
+				return nil, err
+			}
+
+			members[strings.TrimSpace(name.Content)] = value
+		}
+
+		return members, nil
+	}
+
+	return nil, errors.New("Unknown value type " + typed.XMLName.Local)
+}
+
+func encodeValue(buffer *bytes.Buffer, value interface{}) error {
+	buffer.WriteString("<value>")
+
+	switch value := value.(type) {
+	case nil:
+		buffer.WriteString("<nil/>")
+	case string:
+		buffer.WriteString("<string>")
+		xml.EscapeText(buffer, []byte(value))
+		buffer.WriteString("</string>")
+	case int:
+		buffer.WriteString("<int>" + strconv.Itoa(value) + "</int>")
+	case bool:
+		if value {
+			buffer.WriteString("<boolean>1</boolean>")
+		} else {
+			buffer.WriteString("<boolean>0</boolean>")
+		}
+	case float64:
+		buffer.WriteString("<double>" + strconv.FormatFloat(value, 'f', -1, 64) + "</double>")
+	case time.Time:
+		buffer.WriteString("<dateTime.iso8601>" + value.Format(dateTimeLayout) + "</dateTime.iso8601>")
+	case []byte:
+		buffer.WriteString("<base64>" + base64.StdEncoding.EncodeToString(value) + "</base64>")
+	default:
+		if err := encodeCollection(buffer, reflect.ValueOf(value)); err != nil {
+			return err
+		}
+	}
+
+	buffer.WriteString("</value>")
+
+	return nil
+}
+
+// encodeCollection encodes slices as arrays and maps with string keys as
+// structs.  Struct members are sorted so that the output is predictable.
+func encodeCollection(buffer *bytes.Buffer, value reflect.Value) error {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		buffer.WriteString("<array><data>")
+
+		for i := 0; i < value.Len(); i++ {
+			if err := encodeValue(buffer, value.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+
+		buffer.WriteString("</data></array>")
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("Cannot encode map with %v keys", value.Type().Key())
+		}
+
+		keys := value.MapKeys()
+
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+
+		buffer.WriteString("<struct>")
+
+		for _, key := range keys {
+			buffer.WriteString("<member><name>")
+			xml.EscapeText(buffer, []byte(key.String()))
+			buffer.WriteString("</name>")
+
+			if err := encodeValue(buffer, value.MapIndex(key).Interface()); err != nil {
+				return err
+			}
+
+			buffer.WriteString("</member>")
+		}
+
+		buffer.WriteString("</struct>")
+	default:
+		return fmt.Errorf("Cannot encode value of type %v", value.Type())
+	}
+
+	return nil
+}
+
+func firstChild(n node, name string) (node, bool) {
+	for _, child := range n.Nodes {
+		if child.XMLName.Local == name {
+			return child, true
+		}
+	}
+
+	return node{}, false
+}
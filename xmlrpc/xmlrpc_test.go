@@ -0,0 +1,113 @@
+package xmlrpc
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMethodCall(t *testing.T) {
+	call, err := ParseMethodCall(strings.NewReader(`<?xml version="1.0"?>
+<methodCall>
+	<methodName>metaWeblog.newPost</methodName>
+	<params>
+		<param><value>1</value></param>
+		<param><value><string>admin &amp; co</string></value></param>
+		<param><value><i4>42</i4></value></param>
+		<param><value><struct>
+			<member><name>title</name><value><string>Hello</string></value></member>
+			<member><name>categories</name><value><array><data>
+				<value><string>a</string></value>
+				<value>b</value>
+			</data></array></value></member>
+			<member><name>dateCreated</name><value><dateTime.iso8601>20140126T10:30:00</dateTime.iso8601></value></member>
+			<member><name>bits</name><value><base64>aGVs
+bG8=</base64></value></member>
+		</struct></value></param>
+		<param><value><boolean>1</boolean></value></param>
+	</params>
+</methodCall>`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if call.Method != "metaWeblog.newPost" {
+		t.Error("Incorrect method:", call.Method)
+	}
+
+	if len(call.Params) != 5 || call.Params[0] != "1" || call.Params[1] != "admin & co" || call.Params[2] != 42 || call.Params[4] != true {
+		t.Fatal("Incorrect params:", call.Params)
+	}
+
+	content := call.Params[3].(map[string]interface{})
+
+	if content["title"] != "Hello" || !reflect.DeepEqual(content["categories"], []interface{}{"a", "b"}) {
+		t.Error("Incorrect struct:", content)
+	}
+
+	if !content["dateCreated"].(time.Time).Equal(time.Date(2014, 1, 26, 10, 30, 0, 0, time.UTC)) {
+		t.Error("Incorrect date:", content["dateCreated"])
+	}
+
+	if string(content["bits"].([]byte)) != "hello" {
+		t.Error("Incorrect base64:", content["bits"])
+	}
+}
+
+func TestParseInvalidMethodCall(t *testing.T) {
+	for _, document := range []string{
+		`<methodResponse></methodResponse>`,
+		`<methodCall><params></params></methodCall>`,
+		`<methodCall><methodName>x</methodName><params><param><value><int>x</int></value></param></params></methodCall>`,
+		`<methodCall>`,
+	} {
+		if _, err := ParseMethodCall(strings.NewReader(document)); err == nil {
+			t.Error("Parsed invalid document:", document)
+		}
+	}
+}
+
+func TestWriteResponse(t *testing.T) {
+	buffer := &bytes.Buffer{}
+
+	err := WriteResponse(buffer, map[string]interface{}{
+		"b": []string{"x<y"},
+		"a": 1,
+		"c": time.Date(2014, 1, 26, 10, 30, 0, 0, time.UTC),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<methodResponse><params><param><value><struct>` +
+		`<member><name>a</name><value><int>1</int></value></member>` +
+		`<member><name>b</name><value><array><data><value><string>x&lt;y</string></value></data></array></value></member>` +
+		`<member><name>c</name><value><dateTime.iso8601>20140126T10:30:00</dateTime.iso8601></value></member>` +
+		`</struct></value></param></params></methodResponse>`
+
+	if !strings.Contains(buffer.String(), expected) {
+		t.Error("Incorrect response:", buffer.String())
+	}
+
+	if err := WriteResponse(&bytes.Buffer{}, struct{}{}); err == nil {
+		t.Error("Encoded unsupported type")
+	}
+}
+
+func TestWriteFault(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	WriteFault(buffer, &Fault{403, "Denied"})
+
+	expected := `<methodResponse><fault><value><struct>` +
+		`<member><name>faultCode</name><value><int>403</int></value></member>` +
+		`<member><name>faultString</name><value><string>Denied</string></value></member>` +
+		`</struct></value></fault></methodResponse>`
+
+	if !strings.Contains(buffer.String(), expected) {
+		t.Error("Incorrect fault:", buffer.String())
+	}
+}